@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code says what kind of error a response is, so clients don't have to go by the message (which can change). it's
+// sent next to the message: {"error": "vault not found", "code": "not_found"}.
+type Code string
+
+const (
+	CodeValidation         Code = "validation"          // 400, the request doesn't make sense
+	CodeUnauthorized       Code = "unauthorized"        // 401, no session (or it expired), log in again
+	CodeInvalidCredentials Code = "invalid_credentials" // 401, a wrong master password, code or passkey
+	CodeTwoFactorRequired  Code = "two_factor_required" // 401, send the login again with a two-factor code
+	CodeForbidden          Code = "forbidden"           // 403
+	CodeNotFound           Code = "not_found"           // 404
+	CodeConflict           Code = "conflict"            // 409, it already exists or is already that way
+	CodeTooLarge           Code = "too_large"           // 413
+	CodeLocked             Code = "locked"              // 423, too many wrong codes or passwords
+	CodeRateLimited        Code = "rate_limited"        // 429, wait Retry-After
+	CodeInternal           Code = "internal"            // 500
+)
+
+var codeStatus = map[Code]int{
+	CodeValidation:         http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeInvalidCredentials: http.StatusUnauthorized,
+	CodeTwoFactorRequired:  http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeTooLarge:           http.StatusRequestEntityTooLarge,
+	CodeLocked:             http.StatusLocked,
+	CodeRateLimited:        http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+}
+
+// Status is the http status for c.
+func (c Code) Status() int {
+	if status, ok := codeStatus[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// codeFor is the code of a status, for errors that didn't come with one (fiber's, or an old server's).
+func codeFor(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeValidation
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusRequestEntityTooLarge:
+		return CodeTooLarge
+	case http.StatusLocked:
+		return CodeLocked
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	}
+	return CodeInternal
+}
+
+// the errors to check for with errors.Is, an *Error is its code's.
+var (
+	ErrValidation         = errors.New("invalid request")
+	ErrUnauthorized       = errors.New("unauthorized")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrTwoFactorRequired  = errors.New("two-factor code required")
+	ErrForbidden          = errors.New("forbidden")
+	ErrNotFound           = errors.New("not found")
+	ErrConflict           = errors.New("conflict")
+	ErrTooLarge           = errors.New("too large")
+	ErrLocked             = errors.New("locked")
+	ErrRateLimited        = errors.New("rate limited")
+	ErrInternal           = errors.New("internal error")
+)
+
+var codeErr = map[Code]error{
+	CodeValidation:         ErrValidation,
+	CodeUnauthorized:       ErrUnauthorized,
+	CodeInvalidCredentials: ErrInvalidCredentials,
+	CodeTwoFactorRequired:  ErrTwoFactorRequired,
+	CodeForbidden:          ErrForbidden,
+	CodeNotFound:           ErrNotFound,
+	CodeConflict:           ErrConflict,
+	CodeTooLarge:           ErrTooLarge,
+	CodeLocked:             ErrLocked,
+	CodeRateLimited:        ErrRateLimited,
+	CodeInternal:           ErrInternal,
+}
+
+// Error is an error with a Code. handlers return one to pick the status (see apiErr), and PerformRequest returns
+// one for every response that isn't a 200. Err is what went wrong on the server, it's nil on the client.
+type Error struct {
+	Code       Code
+	Message    string
+	RetryAfter time.Duration // for CodeLocked and CodeRateLimited, 0 if there's no telling
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return codeErr[e.Code] == target
+}
+
+// NewError makes an *Error with code out of err, the message is err's.
+func NewError(code Code, err error) *Error {
+	return &Error{Code: code, Message: err.Error(), Err: err}
+}
+
+func Validation(err error) *Error         { return NewError(CodeValidation, err) }
+func Unauthorized(err error) *Error       { return NewError(CodeUnauthorized, err) }
+func InvalidCredentials(err error) *Error { return NewError(CodeInvalidCredentials, err) }
+func TwoFactorRequired(err error) *Error  { return NewError(CodeTwoFactorRequired, err) }
+func Forbidden(err error) *Error          { return NewError(CodeForbidden, err) }
+func NotFound(err error) *Error           { return NewError(CodeNotFound, err) }
+func Conflict(err error) *Error           { return NewError(CodeConflict, err) }
+func TooLarge(err error) *Error           { return NewError(CodeTooLarge, err) }
+
+func Locked(err error, retryAfter time.Duration) *Error {
+	e := NewError(CodeLocked, err)
+	e.RetryAfter = retryAfter
+	return e
+}
+
+func RateLimited(err error, retryAfter time.Duration) *Error {
+	e := NewError(CodeRateLimited, err)
+	e.RetryAfter = retryAfter
+	return e
+}
+
+// ErrorBody is the body of every error response.
+type ErrorBody struct {
+	Error string `json:"error"`
+	Code  Code   `json:"code"`
+}
+
+// toError makes any error an *Error: a *fiber.Error gets the code of its status, anything else is internal.
+func toError(err error) *Error {
+	var aerr *Error
+	if errors.As(err, &aerr) {
+		return aerr
+	}
+	var ferr *fiber.Error
+	if errors.As(err, &ferr) {
+		return NewError(codeFor(ferr.Code), err)
+	}
+	return NewError(CodeInternal, err)
+}
+
+// fromResponse turns an error response back into an *Error. servers from before codes only sent the message, and
+// whatever's in front of them (a proxy) may not send json at all.
+func fromResponse(resp *http.Response, body []byte) *Error {
+	var eb ErrorBody
+	if err := json.Unmarshal(body, &eb); err != nil || eb.Error == "" {
+		eb.Error = fmt.Sprintf("unexpected status code: %d (body: %s)", resp.StatusCode, body)
+	}
+	if _, ok := codeStatus[eb.Code]; !ok {
+		eb.Code = codeFor(resp.StatusCode)
+	}
+	e := &Error{Code: eb.Code, Message: eb.Error}
+	if secs, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); err == nil {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// retryAfter is d in whole seconds for the Retry-After header, rounded up so a client doesn't come back too early.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// inboxes take secrets from people without an account, see database/inbox.go.
+
+// open inbox request (/api/inbox/open), a new link every time
+type OpenInboxRequest struct {
+	Cookies OpenInboxRequestCookies
+}
+type OpenInboxRequestCookies = SessionCookies
+
+func (r *OpenInboxRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &OpenInboxRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *OpenInboxRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/open"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type OpenInboxResponse struct {
+	Body OpenInboxResponseBody
+}
+
+type OpenInboxResponseBody struct {
+	Token string `json:"token"` // the inbox link is /inbox/<token>
+}
+
+func (r *OpenInboxResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &OpenInboxResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *OpenInboxResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// close inbox request (/api/inbox/close)
+type CloseInboxRequest struct {
+	Cookies CloseInboxRequestCookies
+}
+type CloseInboxRequestCookies = SessionCookies
+
+func (r *CloseInboxRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &CloseInboxRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *CloseInboxRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/close"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type CloseInboxResponse struct{}
+
+func (r *CloseInboxResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &CloseInboxResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *CloseInboxResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// list inbox request (/api/inbox/list)
+type ListInboxRequest struct {
+	Cookies ListInboxRequestCookies
+}
+type ListInboxRequestCookies = SessionCookies
+
+func (r *ListInboxRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListInboxRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListInboxRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/list"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListInboxResponse struct {
+	Body ListInboxResponseBody
+}
+
+type ListInboxResponseBody struct {
+	Items []database.InboxItem `json:"items"`
+}
+
+func (r *ListInboxResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListInboxResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListInboxResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// file inbox item request (/api/inbox/file)
+type FileInboxItemRequest struct {
+	Cookies FileInboxItemRequestCookies
+	Body    FileInboxItemRequestBody
+}
+type FileInboxItemRequestCookies = SessionCookies
+type FileInboxItemRequestBody struct {
+	ID        int64  `json:"id"`
+	Key2      string `json:"key2"`
+	Vault     string `json:"vault,omitempty"` // optional, defaults to the default vault
+	VaultCode string `json:"vault_code,omitempty"`
+	Name      string `json:"name"` // what to save it as
+}
+
+func (r *FileInboxItemRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &FileInboxItemRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if r.Body.ID == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *FileInboxItemRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/file"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type FileInboxItemResponse struct{}
+
+func (r *FileInboxItemResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &FileInboxItemResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *FileInboxItemResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// discard inbox item request (/api/inbox/discard)
+type DiscardInboxItemRequest struct {
+	Cookies DiscardInboxItemRequestCookies
+	Body    DiscardInboxItemRequestBody
+}
+type DiscardInboxItemRequestCookies = SessionCookies
+type DiscardInboxItemRequestBody struct {
+	ID int64 `json:"id"`
+}
+
+func (r *DiscardInboxItemRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DiscardInboxItemRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *DiscardInboxItemRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/discard"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type DiscardInboxItemResponse struct{}
+
+func (r *DiscardInboxItemResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DiscardInboxItemResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DiscardInboxItemResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// get inbox (no session, anyone with the link) request (/api/inbox/info)
+type GetInboxRequest struct {
+	Body GetInboxRequestBody
+}
+type GetInboxRequestBody struct {
+	Token string `json:"token"`
+}
+
+func (r *GetInboxRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &GetInboxRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	return r, nil
+}
+
+func (r *GetInboxRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/info"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type GetInboxResponse struct {
+	Body GetInboxResponseBody
+}
+
+type GetInboxResponseBody struct {
+	Inbox database.Inbox `json:"inbox"`
+}
+
+func (r *GetInboxResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &GetInboxResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *GetInboxResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// drop inbox item (no session, anyone with the link) request (/api/inbox/drop)
+type DropInboxItemRequest struct {
+	Body DropInboxItemRequestBody
+}
+type DropInboxItemRequestBody struct {
+	Token  string `json:"token"`
+	Label  string `json:"label"`  // not encrypted
+	Sealed []byte `json:"sealed"` // sealed to the inbox's public key, see utils/seal.go
+}
+
+func (r *DropInboxItemRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DropInboxItemRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Token == "" || r.Body.Label == "" {
+		return nil, fmt.Errorf("token and label are required")
+	}
+	if len(r.Body.Sealed) == 0 {
+		return nil, fmt.Errorf("sealed is required")
+	}
+	return r, nil
+}
+
+func (r *DropInboxItemRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/inbox/drop"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type DropInboxItemResponse struct{}
+
+func (r *DropInboxItemResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DropInboxItemResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DropInboxItemResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
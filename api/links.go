@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// new one-time link request (/api/links/new)
+type NewLinkRequest struct {
+	Cookies NewLinkRequestCookies
+	Body    NewLinkRequestBody
+}
+type NewLinkRequestCookies = SessionCookies
+type NewLinkRequestBody struct {
+	Ciphertext []byte `json:"ciphertext"` // encrypted by the client, see utils/links.go
+	Nonce      []byte `json:"nonce"`
+	Verifier   []byte `json:"verifier"` // of the key, opening the link has to send it too
+	Passphrase bool   `json:"passphrase"`
+	MaxViews   int    `json:"max_views"`  // optional, defaults to 1
+	ExpiresIn  int64  `json:"expires_in"` // in seconds, optional, defaults to a day
+}
+
+func (r *NewLinkRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewLinkRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if len(r.Body.Ciphertext) == 0 || len(r.Body.Nonce) != 12 || len(r.Body.Verifier) != 32 {
+		return nil, fmt.Errorf("ciphertext, a 12 byte nonce and a 32 byte verifier are required")
+	}
+	if r.Body.MaxViews == 0 {
+		r.Body.MaxViews = 1
+	}
+	if r.Body.ExpiresIn == 0 {
+		r.Body.ExpiresIn = 24 * 60 * 60
+	}
+	if r.Body.MaxViews < 0 || r.Body.ExpiresIn < 0 {
+		return nil, fmt.Errorf("max_views and expires_in can't be negative")
+	}
+	return r, nil
+}
+
+func (r *NewLinkRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/links/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewLinkResponse struct {
+	Body NewLinkResponseBody
+}
+
+type NewLinkResponseBody struct {
+	ID        string `json:"id"` // the link is /s/<id>#<link key>
+	ExpiresAt string `json:"expires_at"`
+}
+
+func (r *NewLinkResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewLinkResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewLinkResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// open one-time link request (/api/links/open), no session: whoever has the link can open it
+type OpenLinkRequest struct {
+	Body OpenLinkRequestBody
+}
+type OpenLinkRequestBody struct {
+	ID       string `json:"id"`
+	Verifier []byte `json:"verifier"` // see utils.LinkVerifier, a wrong one doesn't use up a view
+}
+
+func (r *OpenLinkRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &OpenLinkRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == "" || len(r.Body.Verifier) == 0 {
+		return nil, fmt.Errorf("id and verifier are required")
+	}
+	return r, nil
+}
+
+func (r *OpenLinkRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/links/open"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type OpenLinkResponse struct {
+	Body OpenLinkResponseBody
+}
+
+type OpenLinkResponseBody struct {
+	Link database.SecretLink `json:"link"`
+}
+
+func (r *OpenLinkResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &OpenLinkResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *OpenLinkResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
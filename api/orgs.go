@@ -0,0 +1,721 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// organizations are addressed by name, collections by name within their organization.
+
+// new organization request (/api/orgs/new)
+type NewOrganizationRequest struct {
+	Cookies NewOrganizationRequestCookies
+	Body    NewOrganizationRequestBody
+}
+type NewOrganizationRequestCookies = SessionCookies
+type NewOrganizationRequestBody struct {
+	Name string `json:"name"`
+}
+
+func (r *NewOrganizationRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewOrganizationRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *NewOrganizationRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewOrganizationResponse struct {
+	Body NewOrganizationResponseBody
+}
+
+type NewOrganizationResponseBody struct {
+	Organization database.Organization `json:"organization"`
+}
+
+func (r *NewOrganizationResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewOrganizationResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewOrganizationResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list organizations request (/api/orgs/list)
+type ListOrganizationsRequest struct {
+	Cookies ListOrganizationsRequestCookies
+}
+type ListOrganizationsRequestCookies = SessionCookies
+
+func (r *ListOrganizationsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListOrganizationsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListOrganizationsRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/list"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListOrganizationsResponse struct {
+	Body ListOrganizationsResponseBody
+}
+
+type ListOrganizationsResponseBody struct {
+	Organizations []database.Organization `json:"organizations"`
+}
+
+func (r *ListOrganizationsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListOrganizationsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListOrganizationsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list organization members request (/api/orgs/members)
+type ListOrgMembersRequest struct {
+	Cookies ListOrgMembersRequestCookies
+	Body    ListOrgMembersRequestBody
+}
+type ListOrgMembersRequestCookies = SessionCookies
+type ListOrgMembersRequestBody struct {
+	Org string `json:"org"`
+}
+
+func (r *ListOrgMembersRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListOrgMembersRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" {
+		return nil, fmt.Errorf("org is required")
+	}
+	return r, nil
+}
+
+func (r *ListOrgMembersRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/members"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ListOrgMembersResponse struct {
+	Body ListOrgMembersResponseBody
+}
+
+type ListOrgMembersResponseBody struct {
+	Members []database.OrgMember `json:"members"`
+}
+
+func (r *ListOrgMembersResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListOrgMembersResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListOrgMembersResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// invite organization member request (/api/orgs/invite)
+type InviteOrgMemberRequest struct {
+	Cookies InviteOrgMemberRequestCookies
+	Body    InviteOrgMemberRequestBody
+}
+type InviteOrgMemberRequestCookies = SessionCookies
+type InviteOrgMemberRequestBody struct {
+	Org  string `json:"org"`
+	Name string `json:"name"` // user to add
+	Role string `json:"role"`
+	Key2 string `json:"key2"` // yours, to open the collection keys for them
+}
+
+func (r *InviteOrgMemberRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &InviteOrgMemberRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org and name are required")
+	}
+	if _, err := authz.ParseRole(r.Body.Role); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *InviteOrgMemberRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/invite"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type InviteOrgMemberResponse struct{}
+
+func (r *InviteOrgMemberResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &InviteOrgMemberResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *InviteOrgMemberResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// remove organization member request (/api/orgs/remove)
+type RemoveOrgMemberRequest struct {
+	Cookies RemoveOrgMemberRequestCookies
+	Body    RemoveOrgMemberRequestBody
+}
+type RemoveOrgMemberRequestCookies = SessionCookies
+type RemoveOrgMemberRequestBody struct {
+	Org  string `json:"org"`
+	Name string `json:"name"`
+	Key2 string `json:"key2"` // yours, to re-key the collections
+}
+
+func (r *RemoveOrgMemberRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RemoveOrgMemberRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org and name are required")
+	}
+	return r, nil
+}
+
+func (r *RemoveOrgMemberRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/remove"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type RemoveOrgMemberResponse struct{}
+
+func (r *RemoveOrgMemberResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RemoveOrgMemberResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *RemoveOrgMemberResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// change organization role request (/api/orgs/role)
+type ChangeOrgRoleRequest struct {
+	Cookies ChangeOrgRoleRequestCookies
+	Body    ChangeOrgRoleRequestBody
+}
+type ChangeOrgRoleRequestCookies = SessionCookies
+type ChangeOrgRoleRequestBody struct {
+	Org  string `json:"org"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (r *ChangeOrgRoleRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ChangeOrgRoleRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org and name are required")
+	}
+	if _, err := authz.ParseRole(r.Body.Role); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *ChangeOrgRoleRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/role"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ChangeOrgRoleResponse struct{}
+
+func (r *ChangeOrgRoleResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ChangeOrgRoleResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *ChangeOrgRoleResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// new collection request (/api/orgs/collections/new)
+type NewCollectionRequest struct {
+	Cookies NewCollectionRequestCookies
+	Body    NewCollectionRequestBody
+}
+type NewCollectionRequestCookies = SessionCookies
+type NewCollectionRequestBody struct {
+	Org  string `json:"org"`
+	Name string `json:"name"`
+}
+
+func (r *NewCollectionRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewCollectionRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org and name are required")
+	}
+	return r, nil
+}
+
+func (r *NewCollectionRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/collections/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewCollectionResponse struct {
+	Body NewCollectionResponseBody
+}
+
+type NewCollectionResponseBody struct {
+	Collection database.Collection `json:"collection"`
+}
+
+func (r *NewCollectionResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewCollectionResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewCollectionResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list collections request (/api/orgs/collections/list)
+type ListCollectionsRequest struct {
+	Cookies ListCollectionsRequestCookies
+	Body    ListCollectionsRequestBody
+}
+type ListCollectionsRequestCookies = SessionCookies
+type ListCollectionsRequestBody struct {
+	Org string `json:"org"`
+}
+
+func (r *ListCollectionsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListCollectionsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" {
+		return nil, fmt.Errorf("org is required")
+	}
+	return r, nil
+}
+
+func (r *ListCollectionsRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/collections/list"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ListCollectionsResponse struct {
+	Body ListCollectionsResponseBody
+}
+
+type ListCollectionsResponseBody struct {
+	Collections []database.Collection `json:"collections"`
+}
+
+func (r *ListCollectionsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListCollectionsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListCollectionsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// new organization password request (/api/orgs/passwords/new)
+type NewOrgPasswordRequest struct {
+	Cookies NewOrgPasswordRequestCookies
+	Body    NewOrgPasswordRequestBody
+}
+type NewOrgPasswordRequestCookies = SessionCookies
+type NewOrgPasswordRequestBody struct {
+	Org        string `json:"org"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Key2       string `json:"key2"`
+	Value      string `json:"value"`
+}
+
+func (r *NewOrgPasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewOrgPasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Collection == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org, collection and name are required")
+	}
+	return r, nil
+}
+
+func (r *NewOrgPasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/passwords/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewOrgPasswordResponse struct{}
+
+func (r *NewOrgPasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewOrgPasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *NewOrgPasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// retrieve organization password request (/api/orgs/passwords/retrieve)
+type RetrieveOrgPasswordRequest struct {
+	Cookies RetrieveOrgPasswordRequestCookies
+	Body    RetrieveOrgPasswordRequestBody
+}
+type RetrieveOrgPasswordRequestCookies = SessionCookies
+type RetrieveOrgPasswordRequestBody struct {
+	Org        string `json:"org"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+	Key2       string `json:"key2"`
+}
+
+func (r *RetrieveOrgPasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RetrieveOrgPasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Collection == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org, collection and name are required")
+	}
+	return r, nil
+}
+
+func (r *RetrieveOrgPasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/passwords/retrieve"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type RetrieveOrgPasswordResponse struct {
+	Body RetrieveOrgPasswordResponseBody
+}
+
+type RetrieveOrgPasswordResponseBody struct {
+	Value string `json:"value"`
+}
+
+func (r *RetrieveOrgPasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RetrieveOrgPasswordResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *RetrieveOrgPasswordResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// delete organization password request (/api/orgs/passwords/delete)
+type DeleteOrgPasswordRequest struct {
+	Cookies DeleteOrgPasswordRequestCookies
+	Body    DeleteOrgPasswordRequestBody
+}
+type DeleteOrgPasswordRequestCookies = SessionCookies
+type DeleteOrgPasswordRequestBody struct {
+	Org        string `json:"org"`
+	Collection string `json:"collection"`
+	Name       string `json:"name"`
+}
+
+func (r *DeleteOrgPasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DeleteOrgPasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Collection == "" || r.Body.Name == "" {
+		return nil, fmt.Errorf("org, collection and name are required")
+	}
+	return r, nil
+}
+
+func (r *DeleteOrgPasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/passwords/delete"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type DeleteOrgPasswordResponse struct{}
+
+func (r *DeleteOrgPasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DeleteOrgPasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DeleteOrgPasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// list organization passwords request (/api/orgs/passwords/list)
+type ListOrgPasswordsRequest struct {
+	Cookies ListOrgPasswordsRequestCookies
+	Body    ListOrgPasswordsRequestBody
+}
+type ListOrgPasswordsRequestCookies = SessionCookies
+type ListOrgPasswordsRequestBody struct {
+	Org        string `json:"org"`
+	Collection string `json:"collection"`
+}
+
+func (r *ListOrgPasswordsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListOrgPasswordsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" || r.Body.Collection == "" {
+		return nil, fmt.Errorf("org and collection are required")
+	}
+	return r, nil
+}
+
+func (r *ListOrgPasswordsRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/passwords/list"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ListOrgPasswordsResponse struct {
+	Body ListOrgPasswordsResponseBody
+}
+
+type ListOrgPasswordsResponseBody struct {
+	Passwords []database.Password `json:"passwords"`
+}
+
+func (r *ListOrgPasswordsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListOrgPasswordsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListOrgPasswordsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
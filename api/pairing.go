@@ -0,0 +1,338 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+// device pairing (see server/pairing.go). public keys are uncompressed p-256 points, the sealed session code is
+// aes-256-gcm under a key only the two devices can derive.
+
+// new pairing request (/api/pairing/new), from the logged in device
+type NewPairingRequest struct {
+	Cookies NewPairingRequestCookies
+	Body    NewPairingRequestBody
+}
+type NewPairingRequestCookies = SessionCookies
+type NewPairingRequestBody struct {
+	PublicKey []byte `json:"public_key"`
+}
+
+func (r *NewPairingRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewPairingRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if len(r.Body.PublicKey) == 0 {
+		return nil, fmt.Errorf("public_key is required")
+	}
+	return r, nil
+}
+
+func (r *NewPairingRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/pairing/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewPairingResponse struct {
+	Body NewPairingResponseBody
+}
+
+type NewPairingResponseBody struct {
+	Code      string    `json:"code"`    // what the new device types in
+	QRCode    []byte    `json:"qr_code"` // png of the /pair link with the code in it
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (r *NewPairingResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewPairingResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewPairingResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// pairing status request (/api/pairing/status), the logged in device waiting for the new one to join
+type PairingStatusRequest struct {
+	Cookies PairingStatusRequestCookies
+	Body    PairingStatusRequestBody
+}
+type PairingStatusRequestCookies = SessionCookies
+type PairingStatusRequestBody struct {
+	Code string `json:"code"`
+}
+
+func (r *PairingStatusRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &PairingStatusRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	return r, nil
+}
+
+func (r *PairingStatusRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/pairing/status"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type PairingStatusResponse struct {
+	Body PairingStatusResponseBody
+}
+
+type PairingStatusResponseBody struct {
+	Joined    bool   `json:"joined"`
+	PublicKey []byte `json:"public_key,omitempty"` // the new device's, once it joined
+	Device    string `json:"device,omitempty"`
+}
+
+func (r *PairingStatusResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &PairingStatusResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *PairingStatusResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// approve pairing request (/api/pairing/approve), the logged in device sends the sealed session code once the
+// user checked both devices show the same number
+type ApprovePairingRequest struct {
+	Cookies ApprovePairingRequestCookies
+	Body    ApprovePairingRequestBody
+}
+type ApprovePairingRequestCookies = SessionCookies
+type ApprovePairingRequestBody struct {
+	Code   string `json:"code"`
+	Sealed []byte `json:"sealed"`
+	Nonce  []byte `json:"nonce"`
+}
+
+func (r *ApprovePairingRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ApprovePairingRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Code == "" || len(r.Body.Sealed) == 0 || len(r.Body.Nonce) == 0 {
+		return nil, fmt.Errorf("code, sealed and nonce are required")
+	}
+	return r, nil
+}
+
+func (r *ApprovePairingRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/pairing/approve"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ApprovePairingResponse struct{}
+
+func (r *ApprovePairingResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ApprovePairingResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *ApprovePairingResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// join pairing request (/api/pairing/join), from the new device (no session yet)
+type JoinPairingRequest struct {
+	Body JoinPairingRequestBody
+}
+type JoinPairingRequestBody struct {
+	Code      string `json:"code"`
+	PublicKey []byte `json:"public_key"`
+	Device    string `json:"device,omitempty"` // what the devices page calls the new session
+}
+
+func (r *JoinPairingRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &JoinPairingRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Code == "" || len(r.Body.PublicKey) == 0 {
+		return nil, fmt.Errorf("code and public_key are required")
+	}
+	return r, nil
+}
+
+func (r *JoinPairingRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/pairing/join"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type JoinPairingResponse struct {
+	Body JoinPairingResponseBody
+}
+
+type JoinPairingResponseBody struct {
+	PublicKey []byte `json:"public_key"` // the logged in device's
+	Claim     string `json:"claim"`      // proves it's this device asking for the session, see ClaimPairingRequest
+}
+
+func (r *JoinPairingResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &JoinPairingResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *JoinPairingResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// claim pairing request (/api/pairing/claim), the new device waiting for approval. once approved it gets the
+// session cookies and the sealed session code, and the pairing is gone.
+type ClaimPairingRequest struct {
+	Body ClaimPairingRequestBody
+}
+type ClaimPairingRequestBody struct {
+	Code  string `json:"code"`
+	Claim string `json:"claim"`
+}
+
+func (r *ClaimPairingRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ClaimPairingRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Code == "" || r.Body.Claim == "" {
+		return nil, fmt.Errorf("code and claim are required")
+	}
+	return r, nil
+}
+
+func (r *ClaimPairingRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/pairing/claim"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type ClaimPairingResponse struct {
+	Cookies ClaimPairingResponseCookies // only once approved
+	Body    ClaimPairingResponseBody
+}
+type ClaimPairingResponseCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"` // signed sessions only
+}
+type ClaimPairingResponseBody struct {
+	Approved   bool            `json:"approved"`
+	UserID     int64           `json:"user_id,omitempty"`
+	Sealed     []byte          `json:"sealed,omitempty"`
+	Nonce      []byte          `json:"nonce,omitempty"`
+	CodeFormat passcode.Format `json:"code_format"`
+}
+
+func (r *ClaimPairingResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ClaimPairingResponse{}
+	if err := decodeResponseBody(resp, &r.Body); err != nil {
+		return nil, fmt.Errorf("decode response body: %w", err)
+	}
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "session":
+			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
+		default:
+			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
+		}
+	}
+	if r.Body.Approved && r.Cookies.Session == "" {
+		return nil, fmt.Errorf("missing session cookie")
+	}
+	return r, nil
+}
+
+func (r *ClaimPairingResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	if r.Cookies.Session != "" {
+		SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+// begin passkey registration request (/api/passkeys/register/begin)
+type BeginPasskeyRegistrationRequest struct {
+	Cookies BeginPasskeyRegistrationRequestCookies
+}
+type BeginPasskeyRegistrationRequestCookies = SessionCookies
+
+func (r *BeginPasskeyRegistrationRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &BeginPasskeyRegistrationRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *BeginPasskeyRegistrationRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/register/begin"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type BeginPasskeyRegistrationResponse struct {
+	Body BeginPasskeyRegistrationResponseBody
+}
+
+type BeginPasskeyRegistrationResponseBody struct {
+	Ceremony string          `json:"ceremony"`
+	Options  json.RawMessage `json:"options"`
+}
+
+func (r *BeginPasskeyRegistrationResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &BeginPasskeyRegistrationResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *BeginPasskeyRegistrationResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// finish passkey registration request (/api/passkeys/register/finish)
+type FinishPasskeyRegistrationRequest struct {
+	Cookies FinishPasskeyRegistrationRequestCookies
+	Body    FinishPasskeyRegistrationRequestBody
+}
+type FinishPasskeyRegistrationRequestCookies = SessionCookies
+type FinishPasskeyRegistrationRequestBody struct {
+	Ceremony    string          `json:"ceremony"`
+	Name        string          `json:"name"`                   // what to call the passkey
+	Credential  json.RawMessage `json:"credential"`             // what navigator.credentials.create returned
+	SessionCode []byte          `json:"session_code,omitempty"` // the session code encrypted with the prf output, see database/passkeys.go
+}
+
+func (r *FinishPasskeyRegistrationRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &FinishPasskeyRegistrationRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if r.Body.Ceremony == "" || len(r.Body.Credential) == 0 {
+		return nil, fmt.Errorf("ceremony and credential are required")
+	}
+	return r, nil
+}
+
+func (r *FinishPasskeyRegistrationRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/register/finish"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type FinishPasskeyRegistrationResponse struct {
+	Body FinishPasskeyRegistrationResponseBody
+}
+
+type FinishPasskeyRegistrationResponseBody struct {
+	Passkey database.Passkey `json:"passkey"`
+}
+
+func (r *FinishPasskeyRegistrationResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &FinishPasskeyRegistrationResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *FinishPasskeyRegistrationResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list passkeys request (/api/passkeys/list)
+type ListPasskeysRequest struct {
+	Cookies ListPasskeysRequestCookies
+}
+type ListPasskeysRequestCookies = SessionCookies
+
+func (r *ListPasskeysRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListPasskeysRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListPasskeysRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/list"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListPasskeysResponse struct {
+	Body ListPasskeysResponseBody
+}
+
+type ListPasskeysResponseBody struct {
+	Passkeys []database.Passkey `json:"passkeys"`
+}
+
+func (r *ListPasskeysResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListPasskeysResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListPasskeysResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// delete passkey request (/api/passkeys/delete)
+type DeletePasskeyRequest struct {
+	Cookies DeletePasskeyRequestCookies
+	Body    DeletePasskeyRequestBody
+}
+type DeletePasskeyRequestCookies = SessionCookies
+type DeletePasskeyRequestBody struct {
+	ID int64 `json:"id"`
+}
+
+func (r *DeletePasskeyRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DeletePasskeyRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *DeletePasskeyRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/delete"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type DeletePasskeyResponse struct{}
+
+func (r *DeletePasskeyResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DeletePasskeyResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DeletePasskeyResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// begin passkey login (no session) request (/api/passkeys/login/begin)
+type BeginPasskeyLoginRequest struct {
+	Body BeginPasskeyLoginRequestBody
+}
+type BeginPasskeyLoginRequestBody struct {
+	Name string `json:"name"`
+}
+
+func (r *BeginPasskeyLoginRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &BeginPasskeyLoginRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *BeginPasskeyLoginRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/login/begin"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type BeginPasskeyLoginResponse struct {
+	Body BeginPasskeyLoginResponseBody
+}
+
+type BeginPasskeyLoginResponseBody struct {
+	Ceremony string          `json:"ceremony"`
+	Options  json.RawMessage `json:"options"`
+}
+
+func (r *BeginPasskeyLoginResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &BeginPasskeyLoginResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *BeginPasskeyLoginResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// passkey login request (/api/passkeys/login/finish), no session. it logs in without the master password.
+type PasskeyLoginRequest struct {
+	Body PasskeyLoginRequestBody
+}
+type PasskeyLoginRequestBody = PasskeyAssertion
+
+// PasskeyAssertion is a finished passkey login ceremony, also used as the second factor of a login.
+type PasskeyAssertion struct {
+	Ceremony   string          `json:"ceremony"`
+	Credential json.RawMessage `json:"credential"` // what navigator.credentials.get returned
+}
+
+func (r *PasskeyLoginRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &PasskeyLoginRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Ceremony == "" || len(r.Body.Credential) == 0 {
+		return nil, fmt.Errorf("ceremony and credential are required")
+	}
+	return r, nil
+}
+
+func (r *PasskeyLoginRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passkeys/login/finish"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type PasskeyLoginResponse struct {
+	Cookies PasskeyLoginResponseCookies
+	Body    PasskeyLoginResponseBody
+}
+type PasskeyLoginResponseCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"` // signed sessions only
+}
+type PasskeyLoginResponseBody struct {
+	UserID      int64           `json:"user_id"`
+	SessionCode []byte          `json:"session_code"` // encrypted, the client opens it with the prf output of the passkey
+	CodeFormat  passcode.Format `json:"code_format"`
+	TwoFactor   bool            `json:"two_factor"` // the passkey verified the user (pin, biometrics)
+}
+
+func (r *PasskeyLoginResponse) FromResp(resp *http.Response) (Response, error) {
+	r = new(PasskeyLoginResponse)
+	err := decodeResponseBody(resp, &r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decode response body: %w", err)
+	}
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "session":
+			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
+		default:
+			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
+		}
+	}
+	if r.Cookies.Session == "" {
+		return nil, fmt.Errorf("missing session cookie")
+	}
+	return r, nil
+}
+func (r *PasskeyLoginResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
 )
 
 func PerformRequest[X Response, T Request](host string, req T) (X, error) {
@@ -14,6 +16,11 @@ func PerformRequest[X Response, T Request](host string, req T) (X, error) {
 		return zerov, fmt.Errorf("make http request: %w", err)
 	}
 	httpReq.URL.Host = host
+	// access tokens go in the Authorization header, the session cookie is only for sessions
+	if cookie := httpReq.Header.Get("Cookie"); strings.HasPrefix(cookie, "session="+database.AccessTokenPrefix) {
+		httpReq.Header.Del("Cookie")
+		httpReq.Header.Set("Authorization", "Bearer "+strings.TrimPrefix(cookie, "session="))
+	}
 	httpResp, err := http.DefaultClient.Do(httpReq)
 	if err != nil {
 		return zerov, fmt.Errorf("perform http request: %w", err)
@@ -33,11 +40,12 @@ func Handler[T Request, X Response](handler func(*fiber.Ctx, T) (X, error)) func
 		var zerov T
 		req, err := zerov.FromCtx(c)
 		if err != nil {
-			return apiErr(c, http.StatusBadRequest, fmt.Errorf("parse request: %w", err))
+			return apiErr(c, Validation(fmt.Errorf("parse request: %w", err)))
 		}
 		resp, err := handler(c, req.(T))
 		if err != nil {
-			return apiErr(c, http.StatusInternalServerError, err)
+			// handlers pick a status with the errors in errors.go (or fiber.NewError), everything else is a 500
+			return apiErr(c, err)
 		}
 		return resp.Send(c)
 	}
@@ -0,0 +1,360 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/sessions"
+)
+
+// list sessions request (/api/sessions)
+type ListSessionsRequest struct {
+	Cookies ListSessionsRequestCookies
+}
+type ListSessionsRequestCookies = SessionCookies
+
+func (r *ListSessionsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListSessionsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListSessionsRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sessions"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListSessionsResponse struct {
+	Body ListSessionsResponseBody
+}
+
+type ListSessionsResponseBody struct {
+	Sessions []sessions.Session `json:"sessions"`
+}
+
+func (r *ListSessionsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListSessionsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListSessionsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// revoke session request (/api/sessions/revoke)
+type RevokeSessionRequest struct {
+	Cookies RevokeSessionRequestCookies
+	Body    RevokeSessionRequestBody
+}
+type RevokeSessionRequestCookies = SessionCookies
+type RevokeSessionRequestBody struct {
+	ID string `json:"id"` // from the list
+}
+
+func (r *RevokeSessionRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RevokeSessionRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *RevokeSessionRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sessions/revoke"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type RevokeSessionResponse struct{}
+
+func (r *RevokeSessionResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RevokeSessionResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *RevokeSessionResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// revoke all sessions request (/api/sessions/revoke-all), every session but the one asking
+type RevokeAllSessionsRequest struct {
+	Cookies RevokeAllSessionsRequestCookies
+}
+type RevokeAllSessionsRequestCookies = SessionCookies
+
+func (r *RevokeAllSessionsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RevokeAllSessionsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *RevokeAllSessionsRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sessions/revoke-all"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type RevokeAllSessionsResponse struct {
+	Body RevokeAllSessionsResponseBody
+}
+
+type RevokeAllSessionsResponseBody struct {
+	Revoked int `json:"revoked"`
+}
+
+func (r *RevokeAllSessionsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RevokeAllSessionsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *RevokeAllSessionsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// SessionPolicy is how long sessions last, in seconds. 0 means no idle timeout for the effective policy, and the
+// server's for a user's own.
+type SessionPolicy struct {
+	IdleTimeout int64 `json:"idle_timeout"`
+	MaxLifetime int64 `json:"max_lifetime"`
+}
+
+// session request (/api/session), the session asking and when it really expires
+type SessionRequest struct {
+	Cookies SessionRequestCookies
+}
+type SessionRequestCookies = SessionCookies
+
+func (r *SessionRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &SessionRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *SessionRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/session"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type SessionResponse struct {
+	Body SessionResponseBody
+}
+
+type SessionResponseBody struct {
+	UserID     int64            `json:"user_id"`
+	Session    sessions.Session `json:"session"`
+	Policy     SessionPolicy    `json:"policy"`      // what new sessions get
+	UserPolicy SessionPolicy    `json:"user_policy"` // the user's own, see SetSessionPolicy
+}
+
+func (r *SessionResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &SessionResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *SessionResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// set session policy request (/api/session/policy), the user's own session timeouts. they can only make the
+// server's stricter and apply to sessions made after.
+type SetSessionPolicyRequest struct {
+	Cookies SetSessionPolicyRequestCookies
+	Body    SetSessionPolicyRequestBody
+}
+type SetSessionPolicyRequestCookies = SessionCookies
+type SetSessionPolicyRequestBody = SessionPolicy
+
+func (r *SetSessionPolicyRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &SetSessionPolicyRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	return r, nil
+}
+
+func (r *SetSessionPolicyRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/session/policy"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type SetSessionPolicyResponse struct {
+	Body SetSessionPolicyResponseBody
+}
+
+type SetSessionPolicyResponseBody struct {
+	Policy SessionPolicy `json:"policy"` // what new sessions get now
+}
+
+func (r *SetSessionPolicyResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &SetSessionPolicyResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *SetSessionPolicyResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// SetSessionCookies sets the session cookie, and the refresh cookie when there's a refresh token (signed sessions).
+// both are strict, the browser never sends them with a request another site started.
+func SetSessionCookies(c *fiber.Ctx, session, refresh string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "session",
+		Value:    session,
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+	})
+	if refresh != "" {
+		c.Cookie(&fiber.Cookie{
+			Name:     "refresh",
+			Value:    refresh,
+			HTTPOnly: true,
+			Secure:   true,
+			SameSite: fiber.CookieSameSiteStrictMode,
+		})
+	}
+}
+
+// refresh session request (/api/session/refresh), a new session token and refresh token for a signed session.
+// the session token can have expired, the refresh token can't.
+type RefreshSessionRequest struct {
+	Cookies RefreshSessionRequestCookies
+}
+type RefreshSessionRequestCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"`
+}
+
+func (r *RefreshSessionRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RefreshSessionRequest{}
+	r.Cookies.Session = c.Cookies("session")
+	r.Cookies.Refresh = c.Cookies("refresh")
+	if r.Cookies.Refresh == "" {
+		return nil, fmt.Errorf("missing refresh cookie")
+	}
+	return r, nil
+}
+
+func (r *RefreshSessionRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/session/refresh"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       []string{fmt.Sprintf("session=%s; refresh=%s", r.Cookies.Session, r.Cookies.Refresh)},
+		},
+	}
+	return req, nil
+}
+
+type RefreshSessionResponse struct {
+	Cookies RefreshSessionResponseCookies
+}
+type RefreshSessionResponseCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"`
+}
+
+func (r *RefreshSessionResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RefreshSessionResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "session":
+			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
+		default:
+			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
+		}
+	}
+	if r.Cookies.Session == "" || r.Cookies.Refresh == "" {
+		return nil, fmt.Errorf("missing session or refresh cookie")
+	}
+	return r, nil
+}
+
+func (r *RefreshSessionResponse) Send(c *fiber.Ctx) error {
+	SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
+	c.Status(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,196 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+// single sign-on login request (/api/sso/login), no session. it finishes what /sso/callback started.
+type SSOLoginRequest struct {
+	Body SSOLoginRequestBody
+}
+type SSOLoginRequestBody struct {
+	Token        string `json:"token"`                   // from the /login?sso= redirect
+	TOTP         string `json:"totp,omitempty"`          // if the user has two-factor
+	RecoveryCode string `json:"recovery_code,omitempty"` // instead of totp
+}
+
+func (r *SSOLoginRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &SSOLoginRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Token == "" {
+		return nil, fmt.Errorf("token is required")
+	}
+	return r, nil
+}
+
+func (r *SSOLoginRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sso/login"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type SSOLoginResponse struct {
+	Cookies SSOLoginResponseCookies
+	Body    SSOLoginResponseBody
+}
+type SSOLoginResponseCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"` // signed sessions only
+}
+
+// there's no session code, the client needs the one from a master password login (see database/sso.go)
+type SSOLoginResponseBody struct {
+	UserID     int64           `json:"user_id"`
+	Name       string          `json:"name"`
+	CodeFormat passcode.Format `json:"code_format"`
+	TwoFactor  bool            `json:"two_factor"`
+}
+
+func (r *SSOLoginResponse) FromResp(resp *http.Response) (Response, error) {
+	r = new(SSOLoginResponse)
+	err := decodeResponseBody(resp, &r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decode response body: %w", err)
+	}
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "session":
+			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
+		default:
+			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
+		}
+	}
+	if r.Cookies.Session == "" {
+		return nil, fmt.Errorf("missing session cookie")
+	}
+	return r, nil
+}
+func (r *SSOLoginResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list single sign-on identities request (/api/sso/identities)
+type ListIdentitiesRequest struct {
+	Cookies ListIdentitiesRequestCookies
+}
+type ListIdentitiesRequestCookies = SessionCookies
+
+func (r *ListIdentitiesRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListIdentitiesRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListIdentitiesRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sso/identities"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListIdentitiesResponse struct {
+	Body ListIdentitiesResponseBody
+}
+
+type ListIdentitiesResponseBody struct {
+	Identities []database.Identity `json:"identities"`
+}
+
+func (r *ListIdentitiesResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListIdentitiesResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListIdentitiesResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// unlink single sign-on identity request (/api/sso/unlink)
+type UnlinkIdentityRequest struct {
+	Cookies UnlinkIdentityRequestCookies
+	Body    UnlinkIdentityRequestBody
+}
+type UnlinkIdentityRequestCookies = SessionCookies
+type UnlinkIdentityRequestBody struct {
+	ID int64 `json:"id"`
+}
+
+func (r *UnlinkIdentityRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &UnlinkIdentityRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *UnlinkIdentityRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/sso/unlink"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type UnlinkIdentityResponse struct{}
+
+func (r *UnlinkIdentityResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &UnlinkIdentityResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *UnlinkIdentityResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
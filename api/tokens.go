@@ -0,0 +1,181 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// create access token request (/api/tokens/new)
+type CreateAccessTokenRequest struct {
+	Cookies CreateAccessTokenRequestCookies
+	Body    CreateAccessTokenRequestBody
+}
+type CreateAccessTokenRequestCookies = SessionCookies
+type CreateAccessTokenRequestBody struct {
+	Name      string   `json:"name"`
+	Key2      string   `json:"key2"`                 // to give the token the data keys of the entries in its scopes
+	Scopes    []string `json:"scopes"`               // see authz.Scope
+	ExpiresIn int64    `json:"expires_in,omitempty"` // in seconds, 0 means it doesn't expire
+}
+
+func (r *CreateAccessTokenRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &CreateAccessTokenRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if r.Body.ExpiresIn < 0 {
+		return nil, fmt.Errorf("expires_in can't be negative")
+	}
+	return r, nil
+}
+
+func (r *CreateAccessTokenRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/tokens/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type CreateAccessTokenResponse struct {
+	Body CreateAccessTokenResponseBody
+}
+
+type CreateAccessTokenResponseBody struct {
+	Token       string               `json:"token"` // shown once, we only keep its hash
+	AccessToken database.AccessToken `json:"access_token"`
+}
+
+func (r *CreateAccessTokenResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &CreateAccessTokenResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *CreateAccessTokenResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list access tokens request (/api/tokens)
+type ListAccessTokensRequest struct {
+	Cookies ListAccessTokensRequestCookies
+}
+type ListAccessTokensRequestCookies = SessionCookies
+
+func (r *ListAccessTokensRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListAccessTokensRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListAccessTokensRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/tokens"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListAccessTokensResponse struct {
+	Body ListAccessTokensResponseBody
+}
+
+type ListAccessTokensResponseBody struct {
+	Tokens []database.AccessToken `json:"tokens"`
+}
+
+func (r *ListAccessTokensResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListAccessTokensResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListAccessTokensResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// revoke access token request (/api/tokens/revoke)
+type RevokeAccessTokenRequest struct {
+	Cookies RevokeAccessTokenRequestCookies
+	Body    RevokeAccessTokenRequestBody
+}
+type RevokeAccessTokenRequestCookies = SessionCookies
+type RevokeAccessTokenRequestBody struct {
+	ID int64 `json:"id"`
+}
+
+func (r *RevokeAccessTokenRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &RevokeAccessTokenRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.ID == 0 {
+		return nil, fmt.Errorf("id is required")
+	}
+	return r, nil
+}
+
+func (r *RevokeAccessTokenRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/tokens/revoke"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type RevokeAccessTokenResponse struct{}
+
+func (r *RevokeAccessTokenResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &RevokeAccessTokenResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *RevokeAccessTokenResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// two-factor status request (/api/2fa/status)
+type TwoFactorStatusRequest struct {
+	Cookies TwoFactorStatusRequestCookies
+}
+type TwoFactorStatusRequestCookies = SessionCookies
+
+func (r *TwoFactorStatusRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &TwoFactorStatusRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *TwoFactorStatusRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/2fa/status"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type TwoFactorStatusResponse struct {
+	Body TwoFactorStatusResponseBody
+}
+
+type TwoFactorStatusResponseBody struct {
+	Status    database.TwoFactorStatus `json:"status"`
+	Required  bool                     `json:"required"`
+	Satisfied bool                     `json:"satisfied"`
+}
+
+func (r *TwoFactorStatusResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &TwoFactorStatusResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *TwoFactorStatusResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// enroll two-factor request (/api/2fa/enroll)
+type EnrollTwoFactorRequest struct {
+	Cookies EnrollTwoFactorRequestCookies
+}
+type EnrollTwoFactorRequestCookies = SessionCookies
+
+func (r *EnrollTwoFactorRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &EnrollTwoFactorRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *EnrollTwoFactorRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/2fa/enroll"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type EnrollTwoFactorResponse struct {
+	Body EnrollTwoFactorResponseBody
+}
+
+type EnrollTwoFactorResponseBody struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode []byte `json:"qr_code"`
+}
+
+func (r *EnrollTwoFactorResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &EnrollTwoFactorResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *EnrollTwoFactorResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// confirm two-factor request (/api/2fa/confirm)
+type ConfirmTwoFactorRequest struct {
+	Cookies ConfirmTwoFactorRequestCookies
+	Body    ConfirmTwoFactorRequestBody
+}
+type ConfirmTwoFactorRequestCookies = SessionCookies
+type ConfirmTwoFactorRequestBody struct {
+	Code string `json:"code"` // from the authenticator
+}
+
+func (r *ConfirmTwoFactorRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ConfirmTwoFactorRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	return r, nil
+}
+
+func (r *ConfirmTwoFactorRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/2fa/confirm"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ConfirmTwoFactorResponse struct {
+	Cookies ConfirmTwoFactorResponseCookies
+	Body    ConfirmTwoFactorResponseBody
+}
+
+// a signed session can't be changed, so it gets a new token that passed two-factor (empty otherwise)
+type ConfirmTwoFactorResponseCookies struct {
+	Session string `json:"session"`
+}
+
+type ConfirmTwoFactorResponseBody struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (r *ConfirmTwoFactorResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ConfirmTwoFactorResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "session" {
+			r.Cookies.Session = cookie.Value
+		}
+	}
+	return r, err
+}
+
+func (r *ConfirmTwoFactorResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	if r.Cookies.Session != "" {
+		SetSessionCookies(c, r.Cookies.Session, "")
+	}
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// disable two-factor request (/api/2fa/disable)
+type DisableTwoFactorRequest struct {
+	Cookies DisableTwoFactorRequestCookies
+	Body    DisableTwoFactorRequestBody
+}
+type DisableTwoFactorRequestCookies = SessionCookies
+type DisableTwoFactorRequestBody struct {
+	TOTP         string `json:"totp,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+func (r *DisableTwoFactorRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DisableTwoFactorRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.TOTP == "" && r.Body.RecoveryCode == "" {
+		return nil, fmt.Errorf("totp or recovery_code is required")
+	}
+	return r, nil
+}
+
+func (r *DisableTwoFactorRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/2fa/disable"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type DisableTwoFactorResponse struct{}
+
+func (r *DisableTwoFactorResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DisableTwoFactorResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DisableTwoFactorResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// new recovery codes request (/api/2fa/recovery-codes)
+type NewRecoveryCodesRequest struct {
+	Cookies NewRecoveryCodesRequestCookies
+	Body    NewRecoveryCodesRequestBody
+}
+type NewRecoveryCodesRequestCookies = SessionCookies
+type NewRecoveryCodesRequestBody struct {
+	TOTP         string `json:"totp,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+func (r *NewRecoveryCodesRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewRecoveryCodesRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.TOTP == "" && r.Body.RecoveryCode == "" {
+		return nil, fmt.Errorf("totp or recovery_code is required")
+	}
+	return r, nil
+}
+
+func (r *NewRecoveryCodesRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/2fa/recovery-codes"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewRecoveryCodesResponse struct {
+	Body NewRecoveryCodesResponseBody
+}
+
+type NewRecoveryCodesResponseBody struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func (r *NewRecoveryCodesResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewRecoveryCodesResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewRecoveryCodesResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// require two-factor in an organization request (/api/orgs/two-factor)
+type SetOrgTwoFactorRequest struct {
+	Cookies SetOrgTwoFactorRequestCookies
+	Body    SetOrgTwoFactorRequestBody
+}
+type SetOrgTwoFactorRequestCookies = SessionCookies
+type SetOrgTwoFactorRequestBody struct {
+	Org      string `json:"org"`
+	Required bool   `json:"required"`
+}
+
+func (r *SetOrgTwoFactorRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &SetOrgTwoFactorRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Org == "" {
+		return nil, fmt.Errorf("org is required")
+	}
+	return r, nil
+}
+
+func (r *SetOrgTwoFactorRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/orgs/two-factor"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type SetOrgTwoFactorResponse struct{}
+
+func (r *SetOrgTwoFactorResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &SetOrgTwoFactorResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *SetOrgTwoFactorResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
@@ -7,9 +7,13 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/authz"
 	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/kdf"
+	"github.com/tiredkangaroo/keylock/passcode"
 )
 
 // really redesigned fiber out here ✌️
@@ -29,13 +33,66 @@ type Response interface {
 	Send(*fiber.Ctx) error
 }
 
+// prelogin request (/api/accounts/prelogin), what a client needs to derive its keys from the master password (see
+// kdf/client.go). new accounts use the current kdf params from it too.
+type PreloginRequest struct {
+	Body PreloginRequestBody
+}
+type PreloginRequestBody struct {
+	Name string `json:"name"`
+}
+
+func (r *PreloginRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &PreloginRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *PreloginRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/accounts/prelogin"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+type PreloginResponse struct {
+	Body PreloginResponseBody
+}
+type PreloginResponseBody = kdf.Account
+
+func (r *PreloginResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &PreloginResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *PreloginResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
 // new account request (/api/accounts/new)
 type NewAccountRequest struct {
 	Body NewAccountRequestBody
 }
 type NewAccountRequestBody struct {
-	Name           string `json:"name"`
-	MasterPassword string `json:"master_password"`
+	Name string `json:"name"`
+	kdf.Credentials
+	Device string `json:"device,omitempty"` // what the devices page calls this session, guessed from the user agent if empty
 }
 
 func (r *NewAccountRequest) FromCtx(c *fiber.Ctx) (Request, error) {
@@ -43,8 +100,11 @@ func (r *NewAccountRequest) FromCtx(c *fiber.Ctx) (Request, error) {
 	if err := c.BodyParser(&r.Body); err != nil {
 		return nil, fmt.Errorf("parse request body: %w", err)
 	}
-	if r.Body.Name == "" || r.Body.MasterPassword == "" {
-		return nil, fmt.Errorf("name and master_password are required")
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if err := r.Body.Credentials.Validate(); err != nil {
+		return nil, err
 	}
 	return r, nil
 }
@@ -72,11 +132,11 @@ type NewAccountResponse struct {
 }
 type NewAccountResponseCookies struct {
 	Session string `json:"session"` // json tag dont matter here
+	Refresh string `json:"refresh"` // signed sessions only
 }
 type NewAccountResponseBody struct {
-	UserID      int64  `json:"user_id"`
-	SessionCode string `json:"session_code"`
-	Code        string `json:"code"`
+	UserID     int64           `json:"user_id"`
+	CodeFormat passcode.Format `json:"code_format"`
 }
 
 func (r *NewAccountResponse) FromResp(resp *http.Response) (Response, error) {
@@ -90,6 +150,8 @@ func (r *NewAccountResponse) FromResp(resp *http.Response) (Response, error) {
 		switch cookie.Name {
 		case "session":
 			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
 		default:
 			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
 		}
@@ -101,12 +163,101 @@ func (r *NewAccountResponse) FromResp(resp *http.Response) (Response, error) {
 }
 func (r *NewAccountResponse) Send(c *fiber.Ctx) error {
 	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8) // wow look how specific my mime type is
-	c.Cookie(&fiber.Cookie{
-		Name:     "session",
-		Value:    r.Cookies.Session,
-		HTTPOnly: true,
-		Secure:   true,
-	})
+	SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// login request (/api/accounts/login)
+type LoginRequest struct {
+	Body LoginRequestBody
+}
+type LoginRequestBody struct {
+	Name     string           `json:"name"`
+	LoginKey []byte           `json:"login_key"`
+	Key2     string           `json:"key2,omitempty"`   // hex, only with rekey
+	Rekey    *kdf.Credentials `json:"rekey,omitempty"`  // optional, new kdf params and/or code format
+	Device   string           `json:"device,omitempty"` // what the devices page calls this session, guessed from the user agent if empty
+	// only for accounts that need migrating (see kdf.Account), with rekey
+	MasterPassword string `json:"master_password,omitempty"`
+	// only for users with two-factor enabled, one of them
+	TOTP         string            `json:"totp,omitempty"`
+	RecoveryCode string            `json:"recovery_code,omitempty"`
+	Passkey      *PasskeyAssertion `json:"passkey,omitempty"` // from /api/passkeys/login/begin
+}
+
+func (r *LoginRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &LoginRequest{}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" || len(r.Body.LoginKey) == 0 {
+		return nil, fmt.Errorf("name and login_key are required")
+	}
+	if r.Body.Rekey != nil {
+		if err := r.Body.Rekey.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *LoginRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/accounts/login"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+		},
+	}, nil
+}
+
+// login response (/api/accounts/login)
+
+type LoginResponse struct {
+	Cookies LoginResponseCookies
+	Body    LoginResponseBody
+}
+type LoginResponseCookies struct {
+	Session string `json:"session"`
+	Refresh string `json:"refresh"` // signed sessions only
+}
+type LoginResponseBody struct {
+	UserID      int64           `json:"user_id"`
+	CodeFormat  passcode.Format `json:"code_format"`
+	CodeChanged bool            `json:"code_changed"` // the account was rekeyed (kdf upgrade or new code format), so the session code is different from before (the code only with a new format)
+	TwoFactor   bool            `json:"two_factor"`   // the session passed two-factor
+}
+
+func (r *LoginResponse) FromResp(resp *http.Response) (Response, error) {
+	r = new(LoginResponse)
+	err := decodeResponseBody(resp, &r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decode response body: %w", err)
+	}
+	for _, cookie := range resp.Cookies() {
+		switch cookie.Name {
+		case "session":
+			r.Cookies.Session = cookie.Value
+		case "refresh":
+			r.Cookies.Refresh = cookie.Value
+		default:
+			return nil, fmt.Errorf("unexpected cookie: %s", cookie.Name)
+		}
+	}
+	if r.Cookies.Session == "" {
+		return nil, fmt.Errorf("missing session cookie")
+	}
+	return r, nil
+}
+func (r *LoginResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	SetSessionCookies(c, r.Cookies.Session, r.Cookies.Refresh)
 	c.Status(http.StatusOK)
 	return c.JSON(r.Body)
 }
@@ -118,9 +269,11 @@ type NewPasswordRequest struct {
 }
 type NewPasswordRequestCookies = SessionCookies
 type NewPasswordRequestBody struct {
-	Name  string `json:"name"`
-	Key2  string `json:"key2"`
-	Value string `json:"value"`
+	Vault     string `json:"vault,omitempty"`      // optional, defaults to the default vault. names are unique across all vaults
+	VaultCode string `json:"vault_code,omitempty"` // only for vaults with their own code
+	Name      string `json:"name"`
+	Key2      string `json:"key2"`
+	Value     string `json:"value"`
 }
 
 func (r *NewPasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
@@ -156,7 +309,11 @@ func (r *NewPasswordRequest) HTTPRequest() (*http.Request, error) {
 type NewPasswordResponse struct{}
 
 func (r *NewPasswordResponse) FromResp(resp *http.Response) (Response, error) {
-	// no response body expected
+	r = &NewPasswordResponse{}
+	// no response body expected unless it's an error
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
 	return r, nil
 }
 
@@ -173,18 +330,23 @@ type RetrievePasswordRequest struct {
 }
 type RetrievePasswordRequestCookies = SessionCookies
 type RetrievePasswordRequestBody struct {
-	UserID int64  `json:"user_id"`
-	Name   string `json:"name"`
-	Key2   string `json:"key2"`
+	UserID    int64  `json:"user_id"` // owner of the password, optional (defaults to yourself). access is checked against the session user
+	Name      string `json:"name"`
+	Key2      string `json:"key2"`
+	VaultCode string `json:"vault_code,omitempty"` // only for your own passwords in vaults with their own code
 }
 
 func (r *RetrievePasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
 	r = &RetrievePasswordRequest{}
-	r.Cookies.Fill(c)
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
 	if err := c.BodyParser(&r.Body); err != nil {
 		return nil, fmt.Errorf("parse request body: %w", err)
 	}
-	// validation if needed
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
 	return r, nil
 }
 
@@ -224,10 +386,127 @@ func (r *RetrievePasswordResponse) Send(c *fiber.Ctx) error {
 	return c.JSON(r.Body)
 }
 
+// delete password request (/api/passwords/delete)
+
+type DeletePasswordRequest struct {
+	Cookies DeletePasswordRequestCookies
+	Body    DeletePasswordRequestBody
+}
+type DeletePasswordRequestCookies = SessionCookies
+type DeletePasswordRequestBody struct {
+	Name string `json:"name"`
+}
+
+func (r *DeletePasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &DeletePasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *DeletePasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/delete"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type DeletePasswordResponse struct{}
+
+func (r *DeletePasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &DeletePasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *DeletePasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// update password request (/api/passwords/update)
+
+type UpdatePasswordRequest struct {
+	Cookies UpdatePasswordRequestCookies
+	Body    UpdatePasswordRequestBody
+}
+type UpdatePasswordRequestCookies = SessionCookies
+type UpdatePasswordRequestBody struct {
+	UserID    int64  `json:"user_id"` // owner of the password, optional (defaults to yourself)
+	Name      string `json:"name"`
+	Key2      string `json:"key2"`
+	VaultCode string `json:"vault_code,omitempty"` // only for your own passwords in vaults with their own code
+	Value     string `json:"value"`
+}
+
+func (r *UpdatePasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &UpdatePasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *UpdatePasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/update"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type UpdatePasswordResponse struct{}
+
+func (r *UpdatePasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &UpdatePasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *UpdatePasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
 // list passwords request (/api/passwords/list)
 
 type ListPasswordsRequest struct {
 	Cookies ListPasswordsRequestCookies
+	Vault   string // ?vault=, optional. only your own passwords in that vault, everything you can see without it
 }
 type ListPasswordsRequestCookies = SessionCookies
 
@@ -236,13 +515,18 @@ func (r *ListPasswordsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
 	if err := r.Cookies.Fill(c); err != nil {
 		return nil, fmt.Errorf("cookie fill: %w", err)
 	}
+	r.Vault = c.Query("vault")
 	return r, nil
 }
 
 func (r *ListPasswordsRequest) HTTPRequest() (*http.Request, error) {
+	var query string
+	if r.Vault != "" {
+		query = url.Values{"vault": {r.Vault}}.Encode()
+	}
 	req := &http.Request{
 		Method: http.MethodGet,
-		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/list"},
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/list", RawQuery: query},
 		Header: http.Header{
 			"Content-Type": []string{"application/json"},
 			"Cookie":       r.Cookies.HeaderValue(),
@@ -271,12 +555,195 @@ func (r *ListPasswordsResponse) Send(c *fiber.Ctx) error {
 	return c.JSON(r.Body)
 }
 
+// share password request (/api/passwords/share)
+
+type SharePasswordRequest struct {
+	Cookies SharePasswordRequestCookies
+	Body    SharePasswordRequestBody
+}
+type SharePasswordRequestCookies = SessionCookies
+type SharePasswordRequestBody struct {
+	Name       string `json:"name"`
+	Key2       string `json:"key2"`
+	VaultCode  string `json:"vault_code,omitempty"` // only for passwords in vaults with their own code
+	Recipient  string `json:"recipient"`            // name of the user to share with
+	Permission string `json:"permission"`           // read-only or read-write
+}
+
+func (r *SharePasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &SharePasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" || r.Body.Recipient == "" {
+		return nil, fmt.Errorf("name and recipient are required")
+	}
+	if _, err := authz.ParsePermission(r.Body.Permission); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *SharePasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/share"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type SharePasswordResponse struct{}
+
+func (r *SharePasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &SharePasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *SharePasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// unshare password request (/api/passwords/unshare)
+
+type UnsharePasswordRequest struct {
+	Cookies UnsharePasswordRequestCookies
+	Body    UnsharePasswordRequestBody
+}
+type UnsharePasswordRequestCookies = SessionCookies
+type UnsharePasswordRequestBody struct {
+	Name      string `json:"name"`
+	Recipient string `json:"recipient"`
+}
+
+func (r *UnsharePasswordRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &UnsharePasswordRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" || r.Body.Recipient == "" {
+		return nil, fmt.Errorf("name and recipient are required")
+	}
+	return r, nil
+}
+
+func (r *UnsharePasswordRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/unshare"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type UnsharePasswordResponse struct{}
+
+func (r *UnsharePasswordResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &UnsharePasswordResponse{}
+	if resp.StatusCode != http.StatusOK {
+		return nil, decodeResponseBody(resp, &struct{}{})
+	}
+	return r, nil
+}
+
+func (r *UnsharePasswordResponse) Send(c *fiber.Ctx) error {
+	c.Status(http.StatusOK)
+	return nil
+}
+
+// list shares request (/api/passwords/shares)
+
+type ListSharesRequest struct {
+	Cookies ListSharesRequestCookies
+	Body    ListSharesRequestBody
+}
+type ListSharesRequestCookies = SessionCookies
+type ListSharesRequestBody struct {
+	Name string `json:"name"`
+}
+
+func (r *ListSharesRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListSharesRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *ListSharesRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/passwords/shares"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type ListSharesResponse struct {
+	Body ListSharesResponseBody
+}
+
+type ListSharesResponseBody struct {
+	Shares []database.Share `json:"shares"`
+}
+
+func (r *ListSharesResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListSharesResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListSharesResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
 type SessionCookies struct {
 	Session string `json:"session"`
 }
 
+// Fill takes the session from the cookie or, like SessionMiddleware, the Authorization header (which is how
+// access tokens come).
 func (c *SessionCookies) Fill(ctx *fiber.Ctx) error {
-	c.Session = ctx.Cookies("session", "")
+	c.Session = ctx.Cookies("session", strings.TrimPrefix(ctx.Get("Authorization"), "Bearer "))
 	if c.Session == "" {
 		return fmt.Errorf("missing session cookie")
 	}
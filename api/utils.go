@@ -25,12 +25,24 @@ func shrink(i map[string][]string) map[string]string {
 	}
 	return m
 }
-func apiErr(c *fiber.Ctx, code int, err error) error {
-	return c.Status(code).JSON(fiber.Map{
-		"error": err.Error(),
+
+// apiErr sends err with the status of its code (see errors.go), errors without one are a 500.
+func apiErr(c *fiber.Ctx, err error) error {
+	aerr := toError(err)
+	if aerr.RetryAfter > 0 {
+		c.Set(fiber.HeaderRetryAfter, retryAfter(aerr.RetryAfter))
+	}
+	return c.Status(aerr.Code.Status()).JSON(ErrorBody{
+		Error: aerr.Message,
+		Code:  aerr.Code,
 	})
 }
 
+// SendError is apiErr for middlewares, so their errors look like the handlers'.
+func SendError(c *fiber.Ctx, err error) error {
+	return apiErr(c, err)
+}
+
 func parseBody[T any](c *fiber.Ctx, dst *T) error {
 	if err := c.BodyParser(dst); err != nil {
 		return fmt.Errorf("parse request body: %w", err)
@@ -57,7 +69,7 @@ func decodeResponseBody[T any](resp *http.Response, dst *T) error {
 		return fmt.Errorf("read response body: %w", err)
 	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d (body: %s)", resp.StatusCode, body)
+		return fromResponse(resp, body)
 	}
 	if err := json.Unmarshal(body, dst); err != nil {
 		return fmt.Errorf("decode response body: %w", err)
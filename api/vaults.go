@@ -0,0 +1,123 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+// new vault request (/api/vaults/new)
+type NewVaultRequest struct {
+	Cookies NewVaultRequestCookies
+	Body    NewVaultRequestBody
+}
+type NewVaultRequestCookies = SessionCookies
+type NewVaultRequestBody struct {
+	Name       string           `json:"name"`
+	Key2       string           `json:"key2"`
+	CodeFormat *passcode.Format `json:"code_format,omitempty"` // optional, gives the vault its own code
+}
+
+func (r *NewVaultRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &NewVaultRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	if err := c.BodyParser(&r.Body); err != nil {
+		return nil, fmt.Errorf("parse request body: %w", err)
+	}
+	if r.Body.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	return r, nil
+}
+
+func (r *NewVaultRequest) HTTPRequest() (*http.Request, error) {
+	body, err := json.Marshal(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("marshal request body: %w", err)
+	}
+	return &http.Request{
+		Method: http.MethodPost,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/vaults/new"},
+		Body:   io.NopCloser(bytes.NewBuffer(body)),
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}, nil
+}
+
+type NewVaultResponse struct {
+	Body NewVaultResponseBody
+}
+
+type NewVaultResponseBody struct {
+	Vault database.Vault `json:"vault"`
+	Code  string         `json:"code,omitempty"` // the vault's own code, only shown once
+}
+
+func (r *NewVaultResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &NewVaultResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *NewVaultResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
+
+// list vaults request (/api/vaults/list)
+type ListVaultsRequest struct {
+	Cookies ListVaultsRequestCookies
+}
+type ListVaultsRequestCookies = SessionCookies
+
+func (r *ListVaultsRequest) FromCtx(c *fiber.Ctx) (Request, error) {
+	r = &ListVaultsRequest{}
+	if err := r.Cookies.Fill(c); err != nil {
+		return nil, fmt.Errorf("cookie fill: %w", err)
+	}
+	return r, nil
+}
+
+func (r *ListVaultsRequest) HTTPRequest() (*http.Request, error) {
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Scheme: scheme, Path: "/api/vaults/list"},
+		Header: http.Header{
+			"Content-Type": []string{"application/json"},
+			"Cookie":       r.Cookies.HeaderValue(),
+		},
+	}
+	return req, nil
+}
+
+type ListVaultsResponse struct {
+	Body ListVaultsResponseBody
+}
+
+type ListVaultsResponseBody struct {
+	Vaults []database.Vault `json:"vaults"`
+}
+
+func (r *ListVaultsResponse) FromResp(resp *http.Response) (Response, error) {
+	r = &ListVaultsResponse{}
+	err := decodeResponseBody(resp, &r.Body)
+	return r, err
+}
+
+func (r *ListVaultsResponse) Send(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+	c.Status(http.StatusOK)
+	return c.JSON(r.Body)
+}
@@ -0,0 +1,206 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// every handler that touches a password entry asks the same question: may this principal (whoever the session
+// belongs to) do this action on this entry? the database says what Permission the principal has on the entry
+// (database.PasswordPermission) and Check says whether that's enough.
+
+type Action string
+
+const (
+	Read   Action = "read"
+	Write  Action = "write"
+	Delete Action = "delete"
+	Share  Action = "share" // share the entry with someone else or revoke a share
+)
+
+// Permission is what a principal has on an entry, each level includes the ones below it.
+type Permission uint8
+
+const (
+	None Permission = iota
+	ReadOnly
+	ReadWrite
+	Owner
+)
+
+var (
+	ErrUnauthenticated = errors.New("unauthenticated")
+	ErrForbidden       = errors.New("forbidden")
+	ErrNotFound        = errors.New("not found") // no permission at all, we don't say whether the entry exists
+)
+
+// Principal is who's acting, resolved from the session (or an access token, then Scopes limit it).
+type Principal struct {
+	UserID int64
+	Token  int64   // the access token's id, 0 for a session
+	Scopes []Scope // only for access tokens
+}
+
+func (p Permission) String() string {
+	switch p {
+	case ReadOnly:
+		return "read-only"
+	case ReadWrite:
+		return "read-write"
+	case Owner:
+		return "owner"
+	}
+	return "none"
+}
+
+// ParsePermission is the opposite of String, for permissions an owner can hand out (read-only or read-write).
+func ParsePermission(s string) (Permission, error) {
+	switch s {
+	case "read-only":
+		return ReadOnly, nil
+	case "read-write":
+		return ReadWrite, nil
+	}
+	return None, fmt.Errorf("invalid permission %q (read-only or read-write)", s)
+}
+
+// Allows reports whether the permission is enough for the action. deleting and sharing an entry is only for its owner.
+func (p Permission) Allows(a Action) bool {
+	switch a {
+	case Read:
+		return p >= ReadOnly
+	case Write:
+		return p >= ReadWrite
+	case Delete, Share:
+		return p >= Owner
+	}
+	return false
+}
+
+// Check returns nil if perm allows the action. with no permission at all it's ErrNotFound, so a principal
+// can't find out what other users have stored by guessing.
+func Check(perm Permission, a Action) error {
+	if perm.Allows(a) {
+		return nil
+	}
+	if perm == None {
+		return ErrNotFound
+	}
+	return fmt.Errorf("%w: %s permission can't %s", ErrForbidden, perm, a)
+}
+
+// Role is what a member can do in an organization. every member can use every collection of the organization,
+// the role decides how (see Permission) and who they can manage (see CanManage).
+type Role string
+
+const (
+	RoleOwner    Role = "owner"
+	RoleAdmin    Role = "admin"
+	RoleMember   Role = "member"
+	RoleReadOnly Role = "read-only"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch r := Role(s); r {
+	case RoleOwner, RoleAdmin, RoleMember, RoleReadOnly:
+		return r, nil
+	}
+	return "", fmt.Errorf("invalid role %q (owner, admin, member or read-only)", s)
+}
+
+// Permission is what the role gives on the entries in the organization's collections. owners and admins can
+// delete entries, members can read and write them.
+func (r Role) Permission() Permission {
+	switch r {
+	case RoleOwner, RoleAdmin:
+		return Owner
+	case RoleMember:
+		return ReadWrite
+	case RoleReadOnly:
+		return ReadOnly
+	}
+	return None
+}
+
+// IsAdmin reports whether the role can manage the organization (collections, members).
+func (r Role) IsAdmin() bool {
+	return r == RoleOwner || r == RoleAdmin
+}
+
+// CanManage reports whether r can invite, remove or change the role of a member with role target (or give
+// someone the target role). owners manage everyone, admins manage members and read-only members.
+func (r Role) CanManage(target Role) bool {
+	switch r {
+	case RoleOwner:
+		return true
+	case RoleAdmin:
+		return target == RoleMember || target == RoleReadOnly
+	}
+	return false
+}
+
+// Scope is what an access token (database/tokens.go) may do on top of being its user:
+// "list" lists the user's own entries, "read:<name>" reads one entry and "read:<prefix>*" every entry whose name
+// starts with prefix, "write:..." is the same for changing and deleting them (and includes reading them).
+// tokens only ever see their user's own entries, never what's shared with them.
+type Scope string
+
+const ScopeList Scope = "list"
+
+func ParseScope(s string) (Scope, error) {
+	s = strings.TrimSpace(s)
+	if s == string(ScopeList) {
+		return ScopeList, nil
+	}
+	kind, pattern, ok := strings.Cut(s, ":")
+	if !ok || (kind != "read" && kind != "write") || pattern == "" {
+		return "", fmt.Errorf("invalid scope %q (list, read:<name>, read:<prefix>*, write:<name> or write:<prefix>*)", s)
+	}
+	if strings.Contains(strings.TrimSuffix(pattern, "*"), "*") {
+		return "", fmt.Errorf("invalid scope %q: * only goes at the end", s)
+	}
+	return Scope(s), nil
+}
+
+// Matches reports whether the scope covers the entry called name for the action. read and write scopes cover
+// reading, only write scopes cover writing and deleting. sharing is never for tokens.
+func (s Scope) Matches(a Action, name string) bool {
+	kind, pattern, ok := strings.Cut(string(s), ":")
+	if !ok {
+		return false
+	}
+	switch a {
+	case Read:
+	case Write, Delete:
+		if kind != "write" {
+			return false
+		}
+	default:
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return name == pattern
+}
+
+// CheckScopes returns nil if one of the scopes allows the action on the entry called name.
+func CheckScopes(scopes []Scope, a Action, name string) error {
+	for _, s := range scopes {
+		if s.Matches(a, name) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the access token can't %s %q", ErrForbidden, a, name)
+}
+
+// CanList reports whether the scopes include listing entries.
+func CanList(scopes []Scope) bool {
+	for _, s := range scopes {
+		if s == ScopeList {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		perm   Permission
+		action Action
+		want   error
+	}{
+		{None, Read, ErrNotFound},
+		{None, Write, ErrNotFound},
+		{None, Delete, ErrNotFound},
+		{ReadOnly, Read, nil},
+		{ReadOnly, Write, ErrForbidden},
+		{ReadOnly, Delete, ErrForbidden},
+		{ReadOnly, Share, ErrForbidden},
+		{ReadWrite, Write, nil},
+		{ReadWrite, Delete, ErrForbidden},
+		{ReadWrite, Share, ErrForbidden},
+		{Owner, Delete, nil},
+		{Owner, Share, nil},
+	}
+	for _, tt := range tests {
+		err := Check(tt.perm, tt.action)
+		if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("Check(%s, %s) = %v, want %v", tt.perm, tt.action, err, tt.want)
+		}
+	}
+}
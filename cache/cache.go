@@ -11,25 +11,40 @@ import (
 )
 
 var redisClient *redis.Client
-var ctx = context.Background()
 
 var (
 	ErrCmdNil error = errors.New("redis command returned was nil")
 )
 
+// withTimeout applies the per operation deadline from config on top of ctx.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t := config.DefaultConfig.Redis.Timeout; t > 0 {
+		return context.WithTimeout(ctx, time.Duration(t)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
 func Init() {
 	redisClient = redis.NewClient(&redis.Options{
 		Network:      config.DefaultConfig.Redis.Network,
 		Addr:         config.DefaultConfig.Redis.Hostport,
-		Username:     vault.GetRedisUsername(),
-		Password:     vault.GetRedisPassword(),
+		Username:     vault.GetRedisUsername(context.Background()),
+		Password:     vault.GetRedisPassword(context.Background()),
 		DB:           config.DefaultConfig.Redis.DB,
 		ReadTimeout:  time.Duration(config.DefaultConfig.Redis.Timeout) * time.Second,
 		WriteTimeout: time.Duration(config.DefaultConfig.Redis.Timeout) * time.Second,
 	})
 }
 
-func HGet(key, field string) (string, error) {
+// UseClient makes the cache use client instead of the one Init sets up (tests use it with miniredis).
+func UseClient(client *redis.Client) {
+	redisClient = client
+}
+
+func HGet(ctx context.Context, key, field string) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	cmd := redisClient.HGet(ctx, key, field)
 	if cmd == nil {
 		return "", ErrCmdNil // this shouldn't happen but i don't trust redis
@@ -41,7 +56,10 @@ func HGet(key, field string) (string, error) {
 	return val, nil
 }
 
-func HSetWithExpiration(key, field string, value string, expiration time.Duration) error {
+func HSetWithExpiration(ctx context.Context, key, field string, value string, expiration time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	cmd := redisClient.HSetEXWithArgs(ctx, key, &redis.HSetEXOptions{
 		Condition:      redis.HSetEXFNX, // if none of the fields exist
 		ExpirationType: redis.HSetEXExpirationEX,
@@ -52,3 +70,173 @@ func HSetWithExpiration(key, field string, value string, expiration time.Duratio
 	}
 	return cmd.Err()
 }
+
+// HSetKeepTTL changes a field that already exists without touching its expiration. it does nothing if the field
+// doesn't exist (e.g. it expired).
+func HSetKeepTTL(ctx context.Context, key, field string, value string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.HSetEXWithArgs(ctx, key, &redis.HSetEXOptions{
+		Condition:      redis.HSetEXFXX, // if all of the fields exist
+		ExpirationType: redis.HSetEXExpirationKEEPTTL,
+	}, field, value)
+	if cmd == nil {
+		return ErrCmdNil
+	}
+	return cmd.Err()
+}
+
+// HExpire sets the expiration of fields of the hash key that exist.
+func HExpire(ctx context.Context, key string, expiration time.Duration, fields ...string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.HExpire(ctx, key, expiration, fields...)
+	if cmd == nil {
+		return ErrCmdNil
+	}
+	return cmd.Err()
+}
+
+// HGetAll gets every field of the hash key (none if it doesn't exist).
+func HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.HGetAll(ctx, key)
+	if cmd == nil {
+		return nil, ErrCmdNil
+	}
+	return cmd.Result()
+}
+
+func HDel(ctx context.Context, key string, fields ...string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.HDel(ctx, key, fields...)
+	if cmd == nil {
+		return ErrCmdNil
+	}
+	return cmd.Err()
+}
+
+// Incr increments key and returns the new value. the expiration is only set when the key is created,
+// so a counter is forgotten expiration after its first increment.
+func Incr(ctx context.Context, key string, expiration time.Duration) (int64, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var incr *redis.IntCmd
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.ExpireNX(ctx, key, expiration)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+// SetWithExpiration sets key to value. an expiration of 0 means the key never expires.
+func SetWithExpiration(ctx context.Context, key, value string, expiration time.Duration) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.Set(ctx, key, value, expiration)
+	if cmd == nil {
+		return ErrCmdNil
+	}
+	return cmd.Err()
+}
+
+// Get gets key. redis.Nil if it doesn't exist.
+func Get(ctx context.Context, key string) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.Get(ctx, key)
+	if cmd == nil {
+		return "", ErrCmdNil
+	}
+	return cmd.Result()
+}
+
+// GetDel gets key and deletes it, so only one caller ever gets the value. redis.Nil if it doesn't exist.
+func GetDel(ctx context.Context, key string) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.GetDel(ctx, key)
+	if cmd == nil {
+		return "", ErrCmdNil
+	}
+	return cmd.Result()
+}
+
+// TTL returns the time left before key expires. ok is false if the key doesn't exist, and the
+// duration is negative if the key exists but has no expiration.
+func TTL(ctx context.Context, key string) (ttl time.Duration, ok bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.TTL(ctx, key)
+	if cmd == nil {
+		return 0, false, ErrCmdNil
+	}
+	ttl, err = cmd.Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if ttl == -2 { // key does not exist
+		return 0, false, nil
+	}
+	return ttl, true, nil
+}
+
+// HTTL is TTL for a field of the hash at key.
+func HTTL(ctx context.Context, key, field string) (ttl time.Duration, ok bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.HTTL(ctx, key, field)
+	if cmd == nil {
+		return 0, false, ErrCmdNil
+	}
+	ttls, err := cmd.Result()
+	if err != nil {
+		return 0, false, err
+	}
+	if len(ttls) == 0 || ttls[0] == -2 { // field does not exist
+		return 0, false, nil
+	}
+	if ttls[0] < 0 {
+		return -1, true, nil
+	}
+	return time.Duration(ttls[0]) * time.Second, true, nil
+}
+
+func Del(ctx context.Context, keys ...string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := redisClient.Del(ctx, keys...)
+	if cmd == nil {
+		return ErrCmdNil
+	}
+	return cmd.Err()
+}
+
+// RunScript runs script (EVALSHA, loading it if redis doesn't have it yet) on keys and args.
+func RunScript(ctx context.Context, script *redis.Script, keys []string, args ...any) (any, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	cmd := script.Run(ctx, redisClient, keys, args...)
+	if cmd == nil {
+		return nil, ErrCmdNil
+	}
+	return cmd.Result()
+}
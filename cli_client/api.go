@@ -2,12 +2,13 @@ package main
 
 import (
 	"bufio"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
-	"strconv"
 
 	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/kdf"
 	"github.com/tiredkangaroo/keylock/utils"
 )
 
@@ -25,11 +26,29 @@ func signup() error {
 	if err != nil {
 		return fmt.Errorf("failed to get password: %w", err)
 	}
+	fmt.Println()
+
+	format, err := promptCodeFormat()
+	if err != nil {
+		return fmt.Errorf("failed to get code format: %w", err)
+	}
+
+	// the master password never leaves this machine, only what we derive from it with the server's kdf params
+	account, err := prelogin(username)
+	if err != nil {
+		return err
+	}
+	fmt.Println("deriving keys...")
+	creds, keys, err := account.CurrentKDF.NewCredentials(mp, format)
+	if err != nil {
+		return fmt.Errorf("failed to derive keys: %w", err)
+	}
 
 	resp, err := api.PerformRequest[*api.NewAccountResponse](SERVER, &api.NewAccountRequest{
 		Body: api.NewAccountRequestBody{
-			Name:           username,
-			MasterPassword: mp,
+			Name:        username,
+			Credentials: creds,
+			Device:      deviceName(),
 		},
 	})
 	if err != nil {
@@ -41,18 +60,102 @@ func signup() error {
 	krdata := KeyringData{
 		UserID:       resp.Body.UserID,
 		SessionToken: resp.Cookies.Session,
-		SessionCode:  resp.Body.SessionCode,
+		RefreshToken: resp.Cookies.Refresh,
+		SessionCode:  keys.SessionCode,
+		CodeFormat:   resp.Body.CodeFormat,
+	}
+	err = setKeyringData(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to save session code to keyring: %w", err)
+	}
+
+	fmt.Printf("Please remember this code in order to use this session: %s\n", keys.Code)
+
+	return nil
+}
+
+func login() error {
+	username, err := promptRequiredText("username: ")
+	if err != nil {
+		return fmt.Errorf("failed to get username: %w", err)
+	}
+
+	mp, err := promptRequiredPassword("master password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+
+	account, err := prelogin(username)
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nderiving keys...")
+	l, err := account.Login(mp, nil)
+	if err != nil {
+		return fmt.Errorf("failed to derive keys: %w", err)
+	}
+
+	req := &api.LoginRequest{
+		Body: api.LoginRequestBody{
+			Name:     username,
+			LoginKey: l.LoginKey,
+			Key2:     l.Key2,
+			Rekey:    l.Rekey,
+			Device:   deviceName(),
+			// only for accounts from before login keys, see kdf.Account.Migrate
+			MasterPassword: l.MasterPassword,
+		},
+	}
+	resp, err := api.PerformRequest[*api.LoginResponse](SERVER, req)
+	if errors.Is(err, api.ErrTwoFactorRequired) {
+		fmt.Println()
+		req.Body.TOTP, req.Body.RecoveryCode, err = promptSecondFactor()
+		if err != nil {
+			return err
+		}
+		resp, err = api.PerformRequest[*api.LoginResponse](SERVER, req)
+	}
+	if err != nil {
+		return fmt.Errorf("issue with login: %w", err)
+	}
+
+	fmt.Printf("\nLogged in successfully! Your user ID is %d.\n", resp.Body.UserID)
+
+	keys := l.Keys
+	if resp.Body.CodeChanged {
+		keys = *l.Rekeyed
+	}
+	krdata := KeyringData{
+		UserID:       resp.Body.UserID,
+		SessionToken: resp.Cookies.Session,
+		RefreshToken: resp.Cookies.Refresh,
+		SessionCode:  keys.SessionCode,
+		CodeFormat:   resp.Body.CodeFormat,
 	}
 	err = setKeyringData(krdata)
 	if err != nil {
 		return fmt.Errorf("failed to save session code to keyring: %w", err)
 	}
 
-	fmt.Printf("Please remember this code in order to use this session: %s\n", resp.Body.Code)
+	if resp.Body.CodeChanged {
+		fmt.Println("Your account was upgraded to stronger key derivation, your code stays the same.")
+	}
+	fmt.Printf("Please remember this code in order to use this session: %s\n", keys.Code)
 
 	return nil
 }
 
+// prelogin gets what we need to derive the keys for the account called name (see kdf/client.go).
+func prelogin(name string) (kdf.Account, error) {
+	resp, err := api.PerformRequest[*api.PreloginResponse](SERVER, &api.PreloginRequest{
+		Body: api.PreloginRequestBody{Name: name},
+	})
+	if err != nil {
+		return kdf.Account{}, fmt.Errorf("issue with prelogin: %w", err)
+	}
+	return resp.Body, nil
+}
+
 func me() error {
 	fmt.Printf("Hello, %s.\n", currentUser.Username)
 	krdata, err := getKeyringData()
@@ -71,20 +174,10 @@ func savePassword() error {
 	if krdata.SessionCode == "" {
 		return fmt.Errorf("session code is empty, please sign up or log in again")
 	}
-	code, err := promptRequiredText("5-digit code: ")
-	if err != nil {
-		return fmt.Errorf("failed to get code: %w", err)
-	}
-	if len(code) != 5 {
-		return fmt.Errorf("code must be exactly 5 characters long")
-	}
-	codeuint, err := strconv.ParseUint(code, 10, 16)
+	key2, err := getKey2(krdata)
 	if err != nil {
-		return fmt.Errorf("failed to parse code (hint: the code is a 5-digit NUMBER): %w", err)
+		return fmt.Errorf("failed to get key2: %w", err)
 	}
-	codeBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(codeBytes, uint16(codeuint))
-	key2 := fmt.Sprintf("%s%x", krdata.SessionCode, codeBytes)
 
 	name, err := promptRequiredText("name of password (usually a website or service plus the account name, e.g. 'google-ajinest6': ")
 	if err != nil {
@@ -94,15 +187,22 @@ func savePassword() error {
 	if err != nil {
 		return fmt.Errorf("failed to get password: %w", err)
 	}
+	fmt.Println()
+	vaultCode, err := promptVaultCode(krdata, *vaultFlag)
+	if err != nil {
+		return err
+	}
 
 	_, err = api.PerformRequest[*api.NewPasswordResponse](SERVER, &api.NewPasswordRequest{
 		Cookies: api.NewPasswordRequestCookies{
 			Session: krdata.SessionToken,
 		},
 		Body: api.NewPasswordRequestBody{
-			Name:  name,
-			Key2:  key2,
-			Value: pwd,
+			Vault:     *vaultFlag,
+			VaultCode: vaultCode,
+			Name:      name,
+			Key2:      key2,
+			Value:     pwd,
 		},
 	})
 	if err != nil {
@@ -114,6 +214,9 @@ func savePassword() error {
 }
 
 func retrievePassword() error {
+	if token := envToken(); token != "" {
+		return retrievePasswordWithToken(token)
+	}
 	krdata, err := getKeyringData()
 	if err != nil {
 		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
@@ -130,15 +233,24 @@ func retrievePassword() error {
 	if err != nil {
 		return fmt.Errorf("failed to get name: %w", err)
 	}
+	ownerID, name, vault, err := resolvePassword(krdata, name)
+	if err != nil {
+		return err
+	}
+	vaultCode, err := promptVaultCode(krdata, vault)
+	if err != nil {
+		return err
+	}
 
 	resp, err := api.PerformRequest[*api.RetrievePasswordResponse](SERVER, &api.RetrievePasswordRequest{
 		Cookies: api.RetrievePasswordRequestCookies{
 			Session: krdata.SessionToken,
 		},
 		Body: api.RetrievePasswordRequestBody{
-			UserID: krdata.UserID,
-			Name:   name,
-			Key2:   key2,
+			UserID:    ownerID,
+			Name:      name,
+			Key2:      key2,
+			VaultCode: vaultCode,
 		},
 	})
 	if err != nil {
@@ -149,6 +261,41 @@ func retrievePassword() error {
 	return nil
 }
 
+func deletePassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	name, err := promptRequiredText("name of password to delete: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	confirm, err := promptText(fmt.Sprintf("delete '%s'? this can't be undone (y/N): ", name))
+	if err != nil {
+		return fmt.Errorf("failed to get confirmation: %w", err)
+	}
+	if confirm != "y" && confirm != "Y" {
+		fmt.Println("Not deleted.")
+		return nil
+	}
+
+	_, err = api.PerformRequest[*api.DeletePasswordResponse](SERVER, &api.DeletePasswordRequest{
+		Cookies: api.DeletePasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.DeletePasswordRequestBody{
+			Name: name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete password: %w", err)
+	}
+
+	fmt.Printf("Password '%s' deleted.\n", name)
+	return nil
+}
+
 func listPasswords() error {
 	krdata, err := getKeyringData()
 	if err != nil {
@@ -162,13 +309,33 @@ func listPasswords() error {
 		Cookies: api.ListPasswordsRequestCookies{
 			Session: krdata.SessionToken,
 		},
+		Vault: *vaultFlag,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to list passwords: %w", err)
 	}
-	fmt.Println("Your passwords:")
+	if *vaultFlag != "" {
+		fmt.Printf("Your passwords in '%s':\n", *vaultFlag)
+	} else {
+		fmt.Println("Your passwords:")
+	}
+	var shared []database.Password
 	for _, pwd := range resp.Body.Passwords {
+		if pwd.UserID != krdata.UserID {
+			shared = append(shared, pwd)
+			continue
+		}
+		if *vaultFlag == "" && pwd.Vault != database.DefaultVault {
+			fmt.Printf("- %s [%s] (id: %d, created on: %s)\n", pwd.Name, pwd.Vault, pwd.ID, utils.FormatTime(pwd.CreatedAt))
+			continue
+		}
 		fmt.Printf("- %s (id: %d, created on: %s)\n", pwd.Name, pwd.ID, utils.FormatTime(pwd.CreatedAt))
 	}
+	if len(shared) > 0 {
+		fmt.Println("Shared with you:")
+		for _, pwd := range shared {
+			fmt.Printf("- %s/%s (%s, id: %d, created on: %s)\n", pwd.OwnerName, pwd.Name, pwd.Permission, pwd.ID, utils.FormatTime(pwd.CreatedAt))
+		}
+	}
 	return nil
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os/user"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/passcode"
 )
 
 const service = "keylock-cli"
@@ -13,15 +17,58 @@ type Command uint8
 const (
 	CommandUnknown Command = iota
 	CommandSignup
+	CommandLogin
 	CommandMe
 	CommandSavePassword
 	CommandRetrievePassword
+	CommandDeletePassword
 	CommandListPasswords
+	CommandUpdatePassword
+	CommandSharePassword
+	CommandUnsharePassword
+	CommandListShares
+	CommandCreateOrg
+	CommandListOrgs
+	CommandListOrgMembers
+	CommandInviteOrgMember
+	CommandRemoveOrgMember
+	CommandChangeOrgRole
+	CommandCreateCollection
+	CommandListCollections
+	CommandSaveOrgPassword
+	CommandRetrieveOrgPassword
+	CommandDeleteOrgPassword
+	CommandListOrgPasswords
+	CommandCreateVault
+	CommandListVaults
+	CommandShareLink
+	CommandOpenInbox
+	CommandCloseInbox
+	CommandListInbox
+	CommandFileInboxItem
+	CommandDiscardInboxItem
+	CommandTwoFactorStatus
+	CommandEnrollTwoFactor
+	CommandDisableTwoFactor
+	CommandNewRecoveryCodes
+	CommandSetOrgTwoFactor
+	CommandListPasskeys
+	CommandDeletePasskey
+	CommandListSessions
+	CommandRevokeSession
+	CommandRevokeAllSessions
+	CommandSession
+	CommandSetSessionPolicy
+	CommandCreateAccessToken
+	CommandListAccessTokens
+	CommandRevokeAccessToken
+	CommandPair
 	CommandDebugDump
 )
 
 var cmd Command
 var currentUser, _ = user.Current()
+var vaultFlag = flag.String("vault", "", "vault to use for set-password, list-passwords and inbox-file (default: the default vault, list-passwords shows all)")
 
 func init() {
 	flag.Parse()
@@ -35,14 +82,98 @@ func init() {
 	switch args[0] {
 	case "signup":
 		cmd = CommandSignup
+	case "login":
+		cmd = CommandLogin
 	case "me":
 		cmd = CommandMe
 	case "set-password":
 		cmd = CommandSavePassword
 	case "get-password":
 		cmd = CommandRetrievePassword
+	case "delete-password":
+		cmd = CommandDeletePassword
 	case "list-passwords":
 		cmd = CommandListPasswords
+	case "update-password":
+		cmd = CommandUpdatePassword
+	case "share-password":
+		cmd = CommandSharePassword
+	case "unshare-password":
+		cmd = CommandUnsharePassword
+	case "list-shares":
+		cmd = CommandListShares
+	case "create-org":
+		cmd = CommandCreateOrg
+	case "list-orgs":
+		cmd = CommandListOrgs
+	case "org-members":
+		cmd = CommandListOrgMembers
+	case "org-invite":
+		cmd = CommandInviteOrgMember
+	case "org-remove":
+		cmd = CommandRemoveOrgMember
+	case "org-role":
+		cmd = CommandChangeOrgRole
+	case "create-collection":
+		cmd = CommandCreateCollection
+	case "list-collections":
+		cmd = CommandListCollections
+	case "org-set-password":
+		cmd = CommandSaveOrgPassword
+	case "org-get-password":
+		cmd = CommandRetrieveOrgPassword
+	case "org-delete-password":
+		cmd = CommandDeleteOrgPassword
+	case "org-list-passwords":
+		cmd = CommandListOrgPasswords
+	case "create-vault":
+		cmd = CommandCreateVault
+	case "list-vaults":
+		cmd = CommandListVaults
+	case "share":
+		cmd = CommandShareLink
+	case "inbox-open":
+		cmd = CommandOpenInbox
+	case "inbox-close":
+		cmd = CommandCloseInbox
+	case "inbox":
+		cmd = CommandListInbox
+	case "inbox-file":
+		cmd = CommandFileInboxItem
+	case "inbox-discard":
+		cmd = CommandDiscardInboxItem
+	case "2fa":
+		cmd = CommandTwoFactorStatus
+	case "2fa-enroll":
+		cmd = CommandEnrollTwoFactor
+	case "2fa-disable":
+		cmd = CommandDisableTwoFactor
+	case "2fa-recovery-codes":
+		cmd = CommandNewRecoveryCodes
+	case "org-two-factor":
+		cmd = CommandSetOrgTwoFactor
+	case "passkeys":
+		cmd = CommandListPasskeys
+	case "passkey-remove":
+		cmd = CommandDeletePasskey
+	case "sessions":
+		cmd = CommandListSessions
+	case "session-revoke":
+		cmd = CommandRevokeSession
+	case "sessions-revoke-all":
+		cmd = CommandRevokeAllSessions
+	case "session":
+		cmd = CommandSession
+	case "session-policy":
+		cmd = CommandSetSessionPolicy
+	case "token-create":
+		cmd = CommandCreateAccessToken
+	case "tokens":
+		cmd = CommandListAccessTokens
+	case "token-revoke":
+		cmd = CommandRevokeAccessToken
+	case "pair":
+		cmd = CommandPair
 	case "debug-dump":
 		cmd = CommandDebugDump
 	default:
@@ -51,33 +182,109 @@ func init() {
 }
 
 type KeyringData struct {
-	UserID       int64  `json:"user_id"`
-	SessionToken string `json:"session_token"`
-	SessionCode  string `json:"session_code"`
+	UserID       int64           `json:"user_id"`
+	SessionToken string          `json:"session_token"`
+	RefreshToken string          `json:"refresh_token,omitempty"` // only when the server's sessions are signed tokens
+	SessionCode  string          `json:"session_code"`
+	CodeFormat   passcode.Format `json:"code_format"` // zero value (keyring data from before formats) means passcode.Legacy()
 }
 
 func main() {
 	switch cmd {
 	case CommandSignup:
-		if err := signup(); err != nil {
-			println("\nError:", err.Error())
-		}
+		run(signup)
+	case CommandLogin:
+		run(login)
 	case CommandMe:
-		if err := me(); err != nil {
-			println("\nError:", err.Error())
-		}
+		run(me)
 	case CommandSavePassword:
-		if err := savePassword(); err != nil {
-			println("\nError:", err.Error())
-		}
+		run(savePassword)
 	case CommandRetrievePassword:
-		if err := retrievePassword(); err != nil {
-			println("\nError:", err.Error())
-		}
+		run(retrievePassword)
+	case CommandDeletePassword:
+		run(deletePassword)
 	case CommandListPasswords:
-		if err := listPasswords(); err != nil {
-			println("\nError: ", err.Error())
-		}
+		run(listPasswords)
+	case CommandUpdatePassword:
+		run(updatePassword)
+	case CommandSharePassword:
+		run(sharePassword)
+	case CommandUnsharePassword:
+		run(unsharePassword)
+	case CommandListShares:
+		run(listShares)
+	case CommandCreateOrg:
+		run(createOrg)
+	case CommandListOrgs:
+		run(listOrgs)
+	case CommandListOrgMembers:
+		run(listOrgMembers)
+	case CommandInviteOrgMember:
+		run(inviteOrgMember)
+	case CommandRemoveOrgMember:
+		run(removeOrgMember)
+	case CommandChangeOrgRole:
+		run(changeOrgRole)
+	case CommandCreateCollection:
+		run(createCollection)
+	case CommandListCollections:
+		run(listCollections)
+	case CommandSaveOrgPassword:
+		run(saveOrgPassword)
+	case CommandRetrieveOrgPassword:
+		run(retrieveOrgPassword)
+	case CommandDeleteOrgPassword:
+		run(deleteOrgPassword)
+	case CommandListOrgPasswords:
+		run(listOrgPasswords)
+	case CommandCreateVault:
+		run(createVault)
+	case CommandListVaults:
+		run(listVaults)
+	case CommandShareLink:
+		run(shareLink)
+	case CommandOpenInbox:
+		run(openInbox)
+	case CommandCloseInbox:
+		run(closeInbox)
+	case CommandListInbox:
+		run(listInbox)
+	case CommandFileInboxItem:
+		run(fileInboxItem)
+	case CommandDiscardInboxItem:
+		run(discardInboxItem)
+	case CommandTwoFactorStatus:
+		run(twoFactorStatus)
+	case CommandEnrollTwoFactor:
+		run(enrollTwoFactor)
+	case CommandDisableTwoFactor:
+		run(disableTwoFactor)
+	case CommandNewRecoveryCodes:
+		run(newRecoveryCodes)
+	case CommandSetOrgTwoFactor:
+		run(setOrgTwoFactor)
+	case CommandListPasskeys:
+		run(listPasskeys)
+	case CommandDeletePasskey:
+		run(deletePasskey)
+	case CommandListSessions:
+		run(listSessions)
+	case CommandRevokeSession:
+		run(revokeSession)
+	case CommandRevokeAllSessions:
+		run(revokeAllSessions)
+	case CommandSession:
+		run(showSession)
+	case CommandSetSessionPolicy:
+		run(setSessionPolicy)
+	case CommandCreateAccessToken:
+		run(createAccessToken)
+	case CommandListAccessTokens:
+		run(listAccessTokens)
+	case CommandRevokeAccessToken:
+		run(revokeAccessToken)
+	case CommandPair:
+		run(pair)
 	case CommandDebugDump:
 		// this command just dumps information
 		krdata, err := getKeyringData()
@@ -95,6 +302,27 @@ func main() {
 			return
 		}
 	default:
-		println("Unknown command. Available commands: signup, me, set-password, get-password, list-passwords")
+		println("Unknown command. Available commands: signup, login, me, set-password, get-password, delete-password, list-passwords, update-password, share-password, unshare-password, list-shares, " +
+			"create-org, list-orgs, org-members, org-invite, org-remove, org-role, create-collection, list-collections, " +
+			"org-set-password, org-get-password, org-delete-password, org-list-passwords, create-vault, list-vaults, share, " +
+			"inbox-open, inbox-close, inbox, inbox-file, inbox-discard, 2fa, 2fa-enroll, 2fa-disable, 2fa-recovery-codes, org-two-factor, passkeys, passkey-remove, " +
+			"sessions, session-revoke, sessions-revoke-all, session, session-policy, token-create, tokens, token-revoke, pair " +
+			"(set-password, list-passwords and inbox-file take --vault <name>, get-password uses KEYLOCK_TOKEN if it's set)")
+	}
+}
+
+// run runs a command and prints its error. the server says unauthorized when the session is gone (it expired, was
+// revoked or locked by wrong codes), so that offers a login and runs the command again.
+func run(command func() error) {
+	err := command()
+	relogin := cmd != CommandLogin && cmd != CommandSignup && !(cmd == CommandRetrievePassword && envToken() != "")
+	if relogin && errors.Is(err, api.ErrUnauthorized) {
+		fmt.Println("\nYour session has expired or was revoked, log in again.")
+		if err = login(); err == nil {
+			err = command()
+		}
+	}
+	if err != nil {
+		println("\nError:", err.Error())
 	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+func openInbox() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.OpenInboxResponse](SERVER, &api.OpenInboxRequest{
+		Cookies: api.OpenInboxRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open inbox: %w", err)
+	}
+	fmt.Printf("Your inbox link (any older link no longer works): http://%s/inbox/%s\n", SERVER, resp.Body.Token)
+	return nil
+}
+
+func closeInbox() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.CloseInboxResponse](SERVER, &api.CloseInboxRequest{
+		Cookies: api.CloseInboxRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to close inbox: %w", err)
+	}
+	fmt.Println("Your inbox link no longer works, items already in it are still there.")
+	return nil
+}
+
+func listInbox() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListInboxResponse](SERVER, &api.ListInboxRequest{
+		Cookies: api.ListInboxRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list inbox: %w", err)
+	}
+	if len(resp.Body.Items) == 0 {
+		fmt.Println("Your inbox is empty.")
+		return nil
+	}
+	fmt.Println("Waiting in your inbox (file them with inbox-file):")
+	for _, item := range resp.Body.Items {
+		fmt.Printf("- %d: %q (dropped on: %s)\n", item.ID, item.Label, utils.FormatTime(item.CreatedAt))
+	}
+	return nil
+}
+
+func fileInboxItem() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	id, err := promptInboxItem("id of the item to file: ")
+	if err != nil {
+		return err
+	}
+	name, err := promptRequiredText("name to save it as: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+	vaultCode, err := promptVaultCode(krdata, *vaultFlag)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.PerformRequest[*api.FileInboxItemResponse](SERVER, &api.FileInboxItemRequest{
+		Cookies: api.FileInboxItemRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.FileInboxItemRequestBody{
+			ID:        id,
+			Key2:      key2,
+			Vault:     *vaultFlag,
+			VaultCode: vaultCode,
+			Name:      name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to file inbox item: %w", err)
+	}
+	fmt.Printf("\nSaved as '%s'.\n", name)
+	return nil
+}
+
+func discardInboxItem() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	id, err := promptInboxItem("id of the item to discard: ")
+	if err != nil {
+		return err
+	}
+	_, err = api.PerformRequest[*api.DiscardInboxItemResponse](SERVER, &api.DiscardInboxItemRequest{
+		Cookies: api.DiscardInboxItemRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.DiscardInboxItemRequestBody{
+			ID: id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to discard inbox item: %w", err)
+	}
+	fmt.Println("Discarded.")
+	return nil
+}
+
+func promptInboxItem(prompt string) (int64, error) {
+	s, err := promptRequiredText(prompt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get id: %w", err)
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q isn't an id (see 'keylock inbox')", s)
+	}
+	return id, nil
+}
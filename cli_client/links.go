@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// shareLink makes a one-time link for someone without an account. the secret is encrypted here, the server only
+// gets the ciphertext and the key goes in the link's fragment.
+func shareLink() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	secret, err := promptRequiredPassword("secret to share: ")
+	if err != nil {
+		return fmt.Errorf("failed to get secret: %w", err)
+	}
+	fmt.Println()
+	passphrase, err := promptPassword("passphrase (optional, send it separately): ")
+	if err != nil {
+		return fmt.Errorf("failed to get passphrase: %w", err)
+	}
+	fmt.Println()
+	views, err := promptInt("how many times can it be opened (default: 1): ", 1)
+	if err != nil {
+		return err
+	}
+	hours, err := promptInt("hours until it expires (default: 24): ", 24)
+	if err != nil {
+		return err
+	}
+
+	// encrypt it
+	linkKey, fragment := utils.NewLinkKey()
+	key, err := utils.LinkCipherKey(linkKey, passphrase)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	ciphertext, err := utils.Encrypt(key, nonce, []byte(secret))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	verifier, err := utils.LinkVerifier(key)
+	if err != nil {
+		return err
+	}
+
+	resp, err := api.PerformRequest[*api.NewLinkResponse](SERVER, &api.NewLinkRequest{
+		Cookies: api.NewLinkRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewLinkRequestBody{
+			Ciphertext: ciphertext,
+			Nonce:      nonce,
+			Verifier:   verifier,
+			Passphrase: passphrase != "",
+			MaxViews:   views,
+			ExpiresIn:  int64(hours) * 60 * 60,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create link: %w", err)
+	}
+
+	fmt.Printf("\nLink (expires %s): http://%s/s/%s#%s\n", utils.FormatTime(resp.Body.ExpiresAt), SERVER, resp.Body.ID, fragment)
+	if passphrase != "" {
+		fmt.Println("Send the passphrase some other way than the link.")
+	}
+	return nil
+}
+
+func promptInt(prompt string, def int) (int, error) {
+	s, err := promptText(prompt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get answer: %w", err)
+	}
+	if strings.TrimSpace(s) == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%q isn't a positive number", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+func createOrg() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	name, err := promptRequiredText("name of the organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.NewOrganizationResponse](SERVER, &api.NewOrganizationRequest{
+		Cookies: api.NewOrganizationRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewOrganizationRequestBody{
+			Name: name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create organization: %w", err)
+	}
+	fmt.Printf("Organization '%s' created, you're its owner.\n", resp.Body.Organization.Name)
+	return nil
+}
+
+func listOrgs() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListOrganizationsResponse](SERVER, &api.ListOrganizationsRequest{
+		Cookies: api.ListOrganizationsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list organizations: %w", err)
+	}
+	fmt.Println("Your organizations:")
+	for _, org := range resp.Body.Organizations {
+		fmt.Printf("- %s (%s, created on: %s)\n", org.Name, org.Role, utils.FormatTime(org.CreatedAt))
+	}
+	return nil
+}
+
+func listOrgMembers() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListOrgMembersResponse](SERVER, &api.ListOrgMembersRequest{
+		Cookies: api.ListOrgMembersRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ListOrgMembersRequestBody{
+			Org: org,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list members: %w", err)
+	}
+	fmt.Printf("Members of '%s':\n", org)
+	for _, m := range resp.Body.Members {
+		fmt.Printf("- %s (%s, since %s)\n", m.Name, m.Role, utils.FormatTime(m.CreatedAt))
+	}
+	return nil
+}
+
+func inviteOrgMember() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	name, err := promptRequiredText("username to invite: ")
+	if err != nil {
+		return fmt.Errorf("failed to get username: %w", err)
+	}
+	role, err := promptRequiredText("role (owner, admin, member or read-only): ")
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.InviteOrgMemberResponse](SERVER, &api.InviteOrgMemberRequest{
+		Cookies: api.InviteOrgMemberRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.InviteOrgMemberRequestBody{
+			Org:  org,
+			Name: name,
+			Role: role,
+			Key2: key2,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to invite member: %w", err)
+	}
+	fmt.Printf("\n%s is now a %s of '%s'.\n", name, role, org)
+	return nil
+}
+
+func removeOrgMember() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	name, err := promptRequiredText("username to remove: ")
+	if err != nil {
+		return fmt.Errorf("failed to get username: %w", err)
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.RemoveOrgMemberResponse](SERVER, &api.RemoveOrgMemberRequest{
+		Cookies: api.RemoveOrgMemberRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.RemoveOrgMemberRequestBody{
+			Org:  org,
+			Name: name,
+			Key2: key2,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	fmt.Printf("\n%s was removed from '%s' and its collections were re-keyed.\n", name, org)
+	return nil
+}
+
+func changeOrgRole() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	name, err := promptRequiredText("username: ")
+	if err != nil {
+		return fmt.Errorf("failed to get username: %w", err)
+	}
+	role, err := promptRequiredText("new role (owner, admin, member or read-only): ")
+	if err != nil {
+		return fmt.Errorf("failed to get role: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.ChangeOrgRoleResponse](SERVER, &api.ChangeOrgRoleRequest{
+		Cookies: api.ChangeOrgRoleRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ChangeOrgRoleRequestBody{
+			Org:  org,
+			Name: name,
+			Role: role,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change role: %w", err)
+	}
+	fmt.Printf("%s is now a %s of '%s'.\n", name, role, org)
+	return nil
+}
+
+func createCollection() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	name, err := promptRequiredText("name of the collection: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.NewCollectionResponse](SERVER, &api.NewCollectionRequest{
+		Cookies: api.NewCollectionRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewCollectionRequestBody{
+			Org:  org,
+			Name: name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create collection: %w", err)
+	}
+	fmt.Printf("Collection '%s' created in '%s'.\n", name, org)
+	return nil
+}
+
+func listCollections() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListCollectionsResponse](SERVER, &api.ListCollectionsRequest{
+		Cookies: api.ListCollectionsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ListCollectionsRequestBody{
+			Org: org,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list collections: %w", err)
+	}
+	fmt.Printf("Collections in '%s':\n", org)
+	for _, c := range resp.Body.Collections {
+		fmt.Printf("- %s (created on: %s)\n", c.Name, utils.FormatTime(c.CreatedAt))
+	}
+	return nil
+}
+
+// promptCollection asks for the organization and the collection in it.
+func promptCollection() (org, collection string, err error) {
+	org, err = promptRequiredText("organization: ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get organization: %w", err)
+	}
+	collection, err = promptRequiredText("collection: ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get collection: %w", err)
+	}
+	return
+}
+
+func saveOrgPassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	org, collection, err := promptCollection()
+	if err != nil {
+		return err
+	}
+	name, err := promptRequiredText("name of password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	pwd, err := promptRequiredPassword("password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+	fmt.Println()
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.NewOrgPasswordResponse](SERVER, &api.NewOrgPasswordRequest{
+		Cookies: api.NewOrgPasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewOrgPasswordRequestBody{
+			Org:        org,
+			Collection: collection,
+			Name:       name,
+			Key2:       key2,
+			Value:      pwd,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save password: %w", err)
+	}
+	fmt.Printf("\nPassword for '%s' saved to %s/%s!\n", name, org, collection)
+	return nil
+}
+
+func retrieveOrgPassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	org, collection, err := promptCollection()
+	if err != nil {
+		return err
+	}
+	name, err := promptRequiredText("name of password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.RetrieveOrgPasswordResponse](SERVER, &api.RetrieveOrgPasswordRequest{
+		Cookies: api.RetrieveOrgPasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.RetrieveOrgPasswordRequestBody{
+			Org:        org,
+			Collection: collection,
+			Name:       name,
+			Key2:       key2,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve password: %w", err)
+	}
+	fmt.Printf("Password for '%s': %s\n", name, resp.Body.Value)
+	return nil
+}
+
+func deleteOrgPassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, collection, err := promptCollection()
+	if err != nil {
+		return err
+	}
+	name, err := promptRequiredText("name of password to delete: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	confirm, err := promptText(fmt.Sprintf("delete '%s' from %s/%s? this can't be undone (y/N): ", name, org, collection))
+	if err != nil {
+		return fmt.Errorf("failed to get confirmation: %w", err)
+	}
+	if confirm != "y" && confirm != "Y" {
+		fmt.Println("Not deleted.")
+		return nil
+	}
+
+	_, err = api.PerformRequest[*api.DeleteOrgPasswordResponse](SERVER, &api.DeleteOrgPasswordRequest{
+		Cookies: api.DeleteOrgPasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.DeleteOrgPasswordRequestBody{
+			Org:        org,
+			Collection: collection,
+			Name:       name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete password: %w", err)
+	}
+	fmt.Printf("Password '%s' deleted.\n", name)
+	return nil
+}
+
+func listOrgPasswords() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, collection, err := promptCollection()
+	if err != nil {
+		return err
+	}
+
+	resp, err := api.PerformRequest[*api.ListOrgPasswordsResponse](SERVER, &api.ListOrgPasswordsRequest{
+		Cookies: api.ListOrgPasswordsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ListOrgPasswordsRequestBody{
+			Org:        org,
+			Collection: collection,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list passwords: %w", err)
+	}
+	fmt.Printf("Passwords in %s/%s:\n", org, collection)
+	for _, pwd := range resp.Body.Passwords {
+		fmt.Printf("- %s (id: %d, created on: %s)\n", pwd.Name, pwd.ID, utils.FormatTime(pwd.CreatedAt))
+	}
+	return nil
+}
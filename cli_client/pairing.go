@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/tiredkangaroo/keylock/api"
+)
+
+// pairing secrets, the same as web/assets/js/pairing.js derives
+const (
+	pairingKeyInfo   = "keylock pairing key v1"
+	pairingCheckInfo = "keylock pairing check v1"
+)
+
+// pairingSecrets derives the key the session code is sealed with and the number both devices show from the ecdh
+// secret.
+func pairingSecrets(shared []byte) (key []byte, check string, err error) {
+	key, err = hkdf.Key(sha256.New, shared, nil, pairingKeyInfo, 32)
+	if err != nil {
+		return nil, "", err
+	}
+	raw, err := hkdf.Key(sha256.New, shared, nil, pairingCheckInfo, 4)
+	if err != nil {
+		return nil, "", err
+	}
+	n := binary.BigEndian.Uint32(raw) % 1000000
+	return key, fmt.Sprintf("%03d %03d", n/1000, n%1000), nil
+}
+
+// pair logs this cli in by pairing it with a browser that's logged in (its devices page shows the code).
+func pair() error {
+	code, err := promptRequiredText("code from the other device: ")
+	if err != nil {
+		return fmt.Errorf("failed to get code: %w", err)
+	}
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to make a key: %w", err)
+	}
+	joined, err := api.PerformRequest[*api.JoinPairingResponse](SERVER, &api.JoinPairingRequest{
+		Body: api.JoinPairingRequestBody{
+			Code:      code,
+			PublicKey: priv.PublicKey().Bytes(),
+			Device:    deviceName(),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to join pairing: %w", err)
+	}
+	peer, err := ecdh.P256().NewPublicKey(joined.Body.PublicKey)
+	if err != nil {
+		return fmt.Errorf("the other device's key is invalid: %w", err)
+	}
+	shared, err := priv.ECDH(peer)
+	if err != nil {
+		return fmt.Errorf("failed to derive the pairing key: %w", err)
+	}
+	key, check, err := pairingSecrets(shared)
+	if err != nil {
+		return fmt.Errorf("failed to derive the pairing key: %w", err)
+	}
+	fmt.Printf("Check that your other device shows %s, then approve it there.\n", check)
+
+	var claimed *api.ClaimPairingResponse
+	for {
+		claimed, err = api.PerformRequest[*api.ClaimPairingResponse](SERVER, &api.ClaimPairingRequest{
+			Body: api.ClaimPairingRequestBody{
+				Code:  code,
+				Claim: joined.Body.Claim,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed waiting for approval: %w", err)
+		}
+		if claimed.Body.Approved {
+			break
+		}
+		time.Sleep(2 * time.Second)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	if len(claimed.Body.Nonce) != gcm.NonceSize() {
+		return fmt.Errorf("failed to open the session code: bad nonce")
+	}
+	sessionCode, err := gcm.Open(nil, claimed.Body.Nonce, claimed.Body.Sealed, nil)
+	if err != nil {
+		return fmt.Errorf("failed to open the session code: %w", err)
+	}
+	err = setKeyringData(KeyringData{
+		UserID:       claimed.Body.UserID,
+		SessionToken: claimed.Cookies.Session,
+		RefreshToken: claimed.Cookies.Refresh,
+		SessionCode:  string(sessionCode),
+		CodeFormat:   claimed.Body.CodeFormat,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save session code to keyring: %w", err)
+	}
+	fmt.Printf("\nPaired! Your user ID is %d. Use the same code you use on your other device.\n", claimed.Body.UserID)
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+)
+
+// passkeys are registered from the web client (the cli can't talk to an authenticator), here they can only be
+// listed and removed.
+
+func listPasskeys() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListPasskeysResponse](SERVER, &api.ListPasskeysRequest{
+		Cookies: api.ListPasskeysRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list passkeys: %w", err)
+	}
+	if len(resp.Body.Passkeys) == 0 {
+		fmt.Println("You have no passkeys, add one from the security page of the web client.")
+		return nil
+	}
+	for _, p := range resp.Body.Passkeys {
+		kind := "second factor"
+		if p.Passwordless {
+			kind = "passwordless"
+		}
+		lastUsed := p.LastUsedAt
+		if lastUsed == "" {
+			lastUsed = "never"
+		}
+		fmt.Printf("%d\t%s (%s), added %s, last used %s\n", p.ID, p.Name, kind, p.CreatedAt, lastUsed)
+	}
+	return nil
+}
+
+func deletePasskey() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	s, err := promptRequiredText("passkey id: ")
+	if err != nil {
+		return fmt.Errorf("failed to get id: %w", err)
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q isn't an id (see 'keylock passkeys')", s)
+	}
+	_, err = api.PerformRequest[*api.DeletePasskeyResponse](SERVER, &api.DeletePasskeyRequest{
+		Cookies: api.DeletePasskeyRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.DeletePasskeyRequestBody{
+			ID: id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove passkey: %w", err)
+	}
+	fmt.Println("Removed. Remove it from your authenticator too.")
+	return nil
+}
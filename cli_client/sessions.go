@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tiredkangaroo/keylock/api"
+)
+
+// deviceName is what the devices page calls sessions from this cli.
+func deviceName() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "keylock cli"
+	}
+	return "keylock cli on " + host
+}
+
+func listSessions() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListSessionsResponse](SERVER, &api.ListSessionsRequest{
+		Cookies: api.ListSessionsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, s := range resp.Body.Sessions {
+		current := ""
+		if s.Current {
+			current = " (this one)"
+		}
+		fmt.Printf("%s\t%s%s\n", s.Handle, s.Device, current)
+		fmt.Printf("\t\tfrom %s, last seen %s, logged in %s\n", s.IP, s.LastSeenAt.Local().Format("Jan 2 15:04"), s.CreatedAt.Local().Format("Jan 2 2006"))
+	}
+	return nil
+}
+
+func revokeSession() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	id, err := promptRequiredText("session id (see 'keylock sessions'): ")
+	if err != nil {
+		return fmt.Errorf("failed to get session id: %w", err)
+	}
+	_, err = api.PerformRequest[*api.RevokeSessionResponse](SERVER, &api.RevokeSessionRequest{
+		Cookies: api.RevokeSessionRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.RevokeSessionRequestBody{
+			ID: strings.TrimSpace(id),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	fmt.Println("That device is logged out.")
+	return nil
+}
+
+func revokeAllSessions() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.RevokeAllSessionsResponse](SERVER, &api.RevokeAllSessionsRequest{
+		Cookies: api.RevokeAllSessionsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if resp.Body.Revoked < 0 {
+		fmt.Println("Logged out every other session.")
+	} else {
+		fmt.Printf("Logged out %d other sessions.\n", resp.Body.Revoked)
+	}
+	return nil
+}
+
+func showSession() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.SessionResponse](SERVER, &api.SessionRequest{
+		Cookies: api.SessionRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get session: %w", err)
+	}
+	s := resp.Body.Session
+	fmt.Printf("session %s, two-factor: %t\n", s.Handle, s.TwoFactor)
+	fmt.Printf("expires %s unless it's used, %s at the latest\n", s.ExpiresAt.Local().Format("Jan 2 15:04"), s.EndsAt.Local().Format("Jan 2 15:04"))
+	fmt.Printf("new sessions: %s\n", policyString(resp.Body.Policy))
+	return nil
+}
+
+func setSessionPolicy() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	fmt.Println("Your sessions can time out sooner than the server's. Empty keeps the server's.")
+	idle, err := promptDuration("idle timeout (e.g. 30m): ")
+	if err != nil {
+		return err
+	}
+	max, err := promptDuration("max lifetime (e.g. 12h): ")
+	if err != nil {
+		return err
+	}
+	resp, err := api.PerformRequest[*api.SetSessionPolicyResponse](SERVER, &api.SetSessionPolicyRequest{
+		Cookies: api.SetSessionPolicyRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.SetSessionPolicyRequestBody{
+			IdleTimeout: int64(idle.Seconds()),
+			MaxLifetime: int64(max.Seconds()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set session policy: %w", err)
+	}
+	fmt.Printf("New sessions: %s\n", policyString(resp.Body.Policy))
+	return nil
+}
+
+func promptDuration(prompt string) (time.Duration, error) {
+	s, err := promptText(prompt)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get answer: %w", err)
+	}
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q isn't a duration like 30m or 12h", s)
+	}
+	return d, nil
+}
+
+func policyString(p api.SessionPolicy) string {
+	max := (time.Duration(p.MaxLifetime) * time.Second).String()
+	if p.IdleTimeout == 0 {
+		return "no idle timeout, max lifetime " + max
+	}
+	return fmt.Sprintf("idle timeout %s, max lifetime %s", time.Duration(p.IdleTimeout)*time.Second, max)
+}
+
+// refreshSession gets a signed session token (see sessions/signed.go) that's about to expire a new one and saves
+// it. sessions without a refresh token are left alone.
+func refreshSession(krdata *KeyringData) error {
+	if krdata.RefreshToken == "" || !expiresSoon(krdata.SessionToken) {
+		return nil
+	}
+	resp, err := api.PerformRequest[*api.RefreshSessionResponse](SERVER, &api.RefreshSessionRequest{
+		Cookies: api.RefreshSessionRequestCookies{
+			Session: krdata.SessionToken,
+			Refresh: krdata.RefreshToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh session (hint: log in again): %w", err)
+	}
+	krdata.SessionToken, krdata.RefreshToken = resp.Cookies.Session, resp.Cookies.Refresh
+	return setKeyringData(*krdata)
+}
+
+// expiresSoon reads the expiry of a signed session token, the server checks the signature.
+func expiresSoon(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	var claims struct {
+		Expires int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return false
+	}
+	return time.Until(time.Unix(claims.Expires, 0)) < time.Minute
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// passwords shared with you are named "owner/name" in the cli (just "name" works too if there's no clash).
+
+func sharePassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	name, err := promptRequiredText("name of password to share: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	recipient, err := promptRequiredText("username to share it with: ")
+	if err != nil {
+		return fmt.Errorf("failed to get recipient: %w", err)
+	}
+	perm, err := promptText("permission, 'read-only' or 'read-write' (default: read-only): ")
+	if err != nil {
+		return fmt.Errorf("failed to get permission: %w", err)
+	}
+	if strings.TrimSpace(perm) == "" {
+		perm = "read-only"
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+	_, _, vault, err := resolvePassword(krdata, name)
+	if err != nil {
+		return err
+	}
+	vaultCode, err := promptVaultCode(krdata, vault)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.PerformRequest[*api.SharePasswordResponse](SERVER, &api.SharePasswordRequest{
+		Cookies: api.SharePasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.SharePasswordRequestBody{
+			Name:       name,
+			Key2:       key2,
+			VaultCode:  vaultCode,
+			Recipient:  recipient,
+			Permission: strings.TrimSpace(perm),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to share password: %w", err)
+	}
+
+	fmt.Printf("\nPassword '%s' shared with %s (%s).\n", name, recipient, strings.TrimSpace(perm))
+	return nil
+}
+
+func unsharePassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	name, err := promptRequiredText("name of password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	recipient, err := promptRequiredText("username to stop sharing it with: ")
+	if err != nil {
+		return fmt.Errorf("failed to get recipient: %w", err)
+	}
+
+	_, err = api.PerformRequest[*api.UnsharePasswordResponse](SERVER, &api.UnsharePasswordRequest{
+		Cookies: api.UnsharePasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.UnsharePasswordRequestBody{
+			Name:      name,
+			Recipient: recipient,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unshare password: %w", err)
+	}
+
+	fmt.Printf("%s no longer has access to '%s'.\n", recipient, name)
+	return nil
+}
+
+func listShares() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	name, err := promptRequiredText("name of password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListSharesResponse](SERVER, &api.ListSharesRequest{
+		Cookies: api.ListSharesRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ListSharesRequestBody{
+			Name: name,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list shares: %w", err)
+	}
+	if len(resp.Body.Shares) == 0 {
+		fmt.Printf("'%s' isn't shared with anyone.\n", name)
+		return nil
+	}
+	fmt.Printf("'%s' is shared with:\n", name)
+	for _, s := range resp.Body.Shares {
+		fmt.Printf("- %s (%s, since %s)\n", s.RecipientName, s.Permission, utils.FormatTime(s.CreatedAt))
+	}
+	return nil
+}
+
+func updatePassword() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	name, err := promptRequiredText("name of password to update: ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	ownerID, name, vault, err := resolvePassword(krdata, name)
+	if err != nil {
+		return err
+	}
+	pwd, err := promptRequiredPassword("new password: ")
+	if err != nil {
+		return fmt.Errorf("failed to get password: %w", err)
+	}
+	fmt.Println()
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+	vaultCode, err := promptVaultCode(krdata, vault)
+	if err != nil {
+		return err
+	}
+
+	_, err = api.PerformRequest[*api.UpdatePasswordResponse](SERVER, &api.UpdatePasswordRequest{
+		Cookies: api.UpdatePasswordRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.UpdatePasswordRequestBody{
+			UserID:    ownerID,
+			Name:      name,
+			Key2:      key2,
+			VaultCode: vaultCode,
+			Value:     pwd,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	fmt.Printf("\nPassword for '%s' updated successfully!\n", name)
+	return nil
+}
+
+// resolvePassword finds whose vault the password called name is in: your own first, then the ones shared with
+// you ("owner/name" picks the owner). vault is the vault your own passwords are in ("" for shared ones).
+func resolvePassword(krdata KeyringData, name string) (ownerID int64, entryName, vault string, err error) {
+	resp, err := api.PerformRequest[*api.ListPasswordsResponse](SERVER, &api.ListPasswordsRequest{
+		Cookies: api.ListPasswordsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return 0, "", "", fmt.Errorf("failed to list passwords: %w", err)
+	}
+
+	owner, shortName, hasOwner := strings.Cut(name, "/")
+	var matches []int64
+	for _, pwd := range resp.Body.Passwords {
+		if pwd.UserID == krdata.UserID {
+			if pwd.Name == name {
+				return krdata.UserID, name, pwd.Vault, nil
+			}
+			continue
+		}
+		if hasOwner && pwd.OwnerName == owner && pwd.Name == shortName {
+			return pwd.UserID, shortName, "", nil
+		}
+		if pwd.Name == name {
+			matches = append(matches, pwd.UserID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		// let the server say it doesn't exist
+		return krdata.UserID, name, "", nil
+	case 1:
+		return matches[0], name, "", nil
+	}
+	return 0, "", "", fmt.Errorf("more than one password called '%s' is shared with you, use owner/%s", name, name)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+)
+
+// envToken is an access token from KEYLOCK_TOKEN, for scripts and ci jobs. with one, get-password uses it instead
+// of the keyring and doesn't ask for the code.
+func envToken() string {
+	return strings.TrimSpace(os.Getenv("KEYLOCK_TOKEN"))
+}
+
+func createAccessToken() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	name, err := promptRequiredText("token name (e.g. 'deploy job'): ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	fmt.Println("Scopes: list, read:<name>, read:<prefix>*, write:<name>, write:<prefix>* (write includes read).")
+	rawScopes, err := promptRequiredText("scopes (space separated): ")
+	if err != nil {
+		return fmt.Errorf("failed to get scopes: %w", err)
+	}
+	ttl, err := promptDuration("expires in (e.g. 720h, empty for never): ")
+	if err != nil {
+		return err
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.CreateAccessTokenResponse](SERVER, &api.CreateAccessTokenRequest{
+		Cookies: api.CreateAccessTokenRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.CreateAccessTokenRequestBody{
+			Name:      name,
+			Key2:      key2,
+			Scopes:    strings.Fields(rawScopes),
+			ExpiresIn: int64(ttl.Seconds()),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create access token: %w", err)
+	}
+	fmt.Printf("Token (shown once, use it as KEYLOCK_TOKEN or 'Authorization: Bearer <token>'):\n%s\n", resp.Body.Token)
+	fmt.Printf("It can open %d of your passwords now, and new ones that match its scopes.\n", resp.Body.AccessToken.Entries)
+	return nil
+}
+
+func listAccessTokens() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListAccessTokensResponse](SERVER, &api.ListAccessTokensRequest{
+		Cookies: api.ListAccessTokensRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list access tokens: %w", err)
+	}
+	if len(resp.Body.Tokens) == 0 {
+		fmt.Println("You don't have any access tokens.")
+		return nil
+	}
+	for _, t := range resp.Body.Tokens {
+		expires := "never expires"
+		if t.ExpiresAt != "" {
+			expires = "expires " + t.ExpiresAt
+		}
+		lastUsed := "never used"
+		if t.LastUsedAt != "" {
+			lastUsed = "last used " + t.LastUsedAt
+		}
+		fmt.Printf("%d\t%s (%s, %s)\n", t.ID, t.Name, expires, lastUsed)
+		fmt.Printf("\t\tscopes: %v\n", t.Scopes)
+	}
+	return nil
+}
+
+func revokeAccessToken() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	raw, err := promptRequiredText("token id (see 'keylock tokens'): ")
+	if err != nil {
+		return fmt.Errorf("failed to get token id: %w", err)
+	}
+	id, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q isn't a token id", raw)
+	}
+	_, err = api.PerformRequest[*api.RevokeAccessTokenResponse](SERVER, &api.RevokeAccessTokenRequest{
+		Cookies: api.RevokeAccessTokenRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.RevokeAccessTokenRequestBody{
+			ID: id,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revoke access token: %w", err)
+	}
+	fmt.Println("Token revoked.")
+	return nil
+}
+
+// retrievePasswordWithToken is get-password with KEYLOCK_TOKEN. the name is the argument after the command (or
+// read from stdin) and only the value is printed, so scripts can use it.
+func retrievePasswordWithToken(token string) error {
+	name := flag.Arg(1)
+	if name == "" {
+		var err error
+		if name, err = promptRequiredText(""); err != nil {
+			return fmt.Errorf("failed to get name: %w", err)
+		}
+	}
+	resp, err := api.PerformRequest[*api.RetrievePasswordResponse](SERVER, &api.RetrievePasswordRequest{
+		Cookies: api.RetrievePasswordRequestCookies{
+			Session: token,
+		},
+		Body: api.RetrievePasswordRequestBody{
+			Name: strings.TrimSpace(name),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to retrieve password: %w", err)
+	}
+	fmt.Println(resp.Body.Value)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/skip2/go-qrcode"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/totp"
+)
+
+func twoFactorStatus() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.TwoFactorStatusResponse](SERVER, &api.TwoFactorStatusRequest{
+		Cookies: api.TwoFactorStatusRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get two-factor status: %w", err)
+	}
+	if resp.Body.Status.Enabled {
+		fmt.Printf("Two-factor authentication is enabled, you have %d recovery codes left.\n", resp.Body.Status.RecoveryCodesLeft)
+	} else {
+		fmt.Println("Two-factor authentication is not enabled (enable it with 2fa-enroll).")
+	}
+	if resp.Body.Required && !resp.Body.Satisfied {
+		fmt.Println("This server requires two-factor authentication, enable it and log in again.")
+	}
+	return nil
+}
+
+func enrollTwoFactor() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.EnrollTwoFactorResponse](SERVER, &api.EnrollTwoFactorRequest{
+		Cookies: api.EnrollTwoFactorRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enroll two-factor: %w", err)
+	}
+
+	fmt.Println("Scan this with your authenticator app:")
+	if qr, err := qrcode.New(resp.Body.URI, qrcode.Medium); err == nil {
+		fmt.Println(qr.ToSmallString(false))
+	}
+	fmt.Printf("or enter this secret: %s\n\n", resp.Body.Secret)
+
+	code, err := promptRequiredText(fmt.Sprintf("%d digit code from the app: ", totp.Digits))
+	if err != nil {
+		return fmt.Errorf("failed to get code: %w", err)
+	}
+	confirm, err := api.PerformRequest[*api.ConfirmTwoFactorResponse](SERVER, &api.ConfirmTwoFactorRequest{
+		Cookies: api.ConfirmTwoFactorRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.ConfirmTwoFactorRequestBody{
+			Code: code,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to confirm two-factor: %w", err)
+	}
+	if confirm.Cookies.Session != "" {
+		krdata.SessionToken = confirm.Cookies.Session
+		if err := setKeyringData(krdata); err != nil {
+			return err
+		}
+	}
+	fmt.Println("\nTwo-factor authentication is enabled. You'll need a code from your app to log in.")
+	printRecoveryCodes(confirm.Body.RecoveryCodes)
+	return nil
+}
+
+func disableTwoFactor() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	totpCode, recoveryCode, err := promptSecondFactor()
+	if err != nil {
+		return err
+	}
+	_, err = api.PerformRequest[*api.DisableTwoFactorResponse](SERVER, &api.DisableTwoFactorRequest{
+		Cookies: api.DisableTwoFactorRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.DisableTwoFactorRequestBody{
+			TOTP:         totpCode,
+			RecoveryCode: recoveryCode,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to disable two-factor: %w", err)
+	}
+	fmt.Println("Two-factor authentication is disabled, you can remove keylock from your authenticator app.")
+	return nil
+}
+
+func newRecoveryCodes() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	totpCode, recoveryCode, err := promptSecondFactor()
+	if err != nil {
+		return err
+	}
+	resp, err := api.PerformRequest[*api.NewRecoveryCodesResponse](SERVER, &api.NewRecoveryCodesRequest{
+		Cookies: api.NewRecoveryCodesRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewRecoveryCodesRequestBody{
+			TOTP:         totpCode,
+			RecoveryCode: recoveryCode,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to make new recovery codes: %w", err)
+	}
+	fmt.Println("Your old recovery codes no longer work.")
+	printRecoveryCodes(resp.Body.RecoveryCodes)
+	return nil
+}
+
+func setOrgTwoFactor() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	org, err := promptRequiredText("organization: ")
+	if err != nil {
+		return fmt.Errorf("failed to get organization: %w", err)
+	}
+	answer, err := promptRequiredText("require two-factor for every member? (y/n): ")
+	if err != nil {
+		return fmt.Errorf("failed to get answer: %w", err)
+	}
+	required := strings.HasPrefix(strings.ToLower(answer), "y")
+
+	_, err = api.PerformRequest[*api.SetOrgTwoFactorResponse](SERVER, &api.SetOrgTwoFactorRequest{
+		Cookies: api.SetOrgTwoFactorRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.SetOrgTwoFactorRequestBody{
+			Org:      org,
+			Required: required,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change two-factor policy: %w", err)
+	}
+	if required {
+		fmt.Printf("Members of '%s' now need to log in with two-factor to use it.\n", org)
+	} else {
+		fmt.Printf("'%s' no longer requires two-factor.\n", org)
+	}
+	return nil
+}
+
+// promptSecondFactor asks for a code from the authenticator app or a recovery code, and tells them apart by length.
+func promptSecondFactor() (totpCode, recoveryCode string, err error) {
+	code, err := promptRequiredText("two-factor code (or a recovery code): ")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get two-factor code: %w", err)
+	}
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		return code, "", nil
+	}
+	return "", code, nil
+}
+
+func printRecoveryCodes(codes []string) {
+	fmt.Println("Keep these recovery codes somewhere safe, each one logs you in once without your app:")
+	for _, code := range codes {
+		fmt.Printf("  %s\n", code)
+	}
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/tiredkangaroo/keylock/passcode"
 	"github.com/zalando/go-keyring"
 	"golang.org/x/term"
 )
@@ -62,6 +62,9 @@ func getKeyringData() (KeyringData, error) {
 	if err := json.Unmarshal([]byte(dataStr), &data); err != nil {
 		return KeyringData{}, fmt.Errorf("failed to unmarshal keyring data: %w", err)
 	}
+	if err := refreshSession(&data); err != nil {
+		return KeyringData{}, err
+	}
 	return data, nil
 }
 func setKeyringData(krdata KeyringData) error {
@@ -77,19 +80,45 @@ func setKeyringData(krdata KeyringData) error {
 }
 
 func getKey2(krdata KeyringData) (string, error) {
-	code, err := promptRequiredText("5-digit code: ")
+	format := krdata.CodeFormat
+	if format == (passcode.Format{}) {
+		format = passcode.Legacy()
+	}
+	code, err := promptRequiredText(format.String() + ": ")
 	if err != nil {
 		return "", fmt.Errorf("failed to get code: %w", err)
 	}
-	if len(code) != 5 {
-		return "", fmt.Errorf("code must be exactly 5 characters long")
-	}
-	codeuint, err := strconv.ParseUint(code, 10, 16)
+	key2, err := passcode.Key2(krdata.SessionCode, code, format)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse code (hint: the code is a 5-digit NUMBER): %w", err)
+		return "", fmt.Errorf("failed to parse code (hint: it's a %s): %w", format, err)
 	}
-	codeBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(codeBytes, uint16(codeuint))
-	key2 := fmt.Sprintf("%s%x", krdata.SessionCode, codeBytes)
 	return key2, nil
 }
+
+// promptCodeFormat asks what kind of code the user wants, enter picks the default (a 5-digit code).
+func promptCodeFormat() (passcode.Format, error) {
+	format := passcode.Legacy()
+	kind, err := promptText("code type, 'digits' or 'alphanumeric' (default: digits): ")
+	if err != nil {
+		return format, fmt.Errorf("failed to get code type: %w", err)
+	}
+	switch strings.TrimSpace(kind) {
+	case "", string(passcode.Digits):
+	case string(passcode.Alphanumeric):
+		format = passcode.Format{Kind: passcode.Alphanumeric, Length: 8}
+	default:
+		return format, fmt.Errorf("unknown code type %q", kind)
+	}
+
+	length, err := promptText(fmt.Sprintf("code length (default: %d): ", format.Length))
+	if err != nil {
+		return format, fmt.Errorf("failed to get code length: %w", err)
+	}
+	if strings.TrimSpace(length) != "" {
+		format.Length, err = strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return format, fmt.Errorf("code length must be a number: %w", err)
+		}
+	}
+	return format, format.Validate()
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/passcode"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+func createVault() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+	if krdata.SessionCode == "" {
+		return fmt.Errorf("session code is empty, please sign up or log in again")
+	}
+
+	name, err := promptRequiredText("name of the vault (e.g. 'work'): ")
+	if err != nil {
+		return fmt.Errorf("failed to get name: %w", err)
+	}
+	ownCode, err := promptText("give it its own code? (y/N): ")
+	if err != nil {
+		return fmt.Errorf("failed to get answer: %w", err)
+	}
+	var format *passcode.Format
+	if strings.TrimSpace(ownCode) == "y" || strings.TrimSpace(ownCode) == "Y" {
+		f, err := promptCodeFormat()
+		if err != nil {
+			return fmt.Errorf("failed to get code format: %w", err)
+		}
+		format = &f
+	}
+	key2, err := getKey2(krdata)
+	if err != nil {
+		return fmt.Errorf("failed to get key2: %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.NewVaultResponse](SERVER, &api.NewVaultRequest{
+		Cookies: api.NewVaultRequestCookies{
+			Session: krdata.SessionToken,
+		},
+		Body: api.NewVaultRequestBody{
+			Name:       name,
+			Key2:       key2,
+			CodeFormat: format,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create vault: %w", err)
+	}
+
+	fmt.Printf("\nVault '%s' created, use it with --vault %s.\n", resp.Body.Vault.Name, resp.Body.Vault.Name)
+	if resp.Body.Code != "" {
+		fmt.Printf("Please remember this code, it's needed for everything in this vault: %s\n", resp.Body.Code)
+	}
+	return nil
+}
+
+func listVaults() error {
+	krdata, err := getKeyringData()
+	if err != nil {
+		return fmt.Errorf("failed to get keyring data (hint: make sure you're signed in): %w", err)
+	}
+
+	resp, err := api.PerformRequest[*api.ListVaultsResponse](SERVER, &api.ListVaultsRequest{
+		Cookies: api.ListVaultsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list vaults: %w", err)
+	}
+	fmt.Println("Your vaults:")
+	for _, v := range resp.Body.Vaults {
+		if v.CodeFormat != nil {
+			fmt.Printf("- %s (own %s, created on: %s)\n", v.Name, v.CodeFormat, utils.FormatTime(v.CreatedAt))
+			continue
+		}
+		fmt.Printf("- %s (created on: %s)\n", v.Name, utils.FormatTime(v.CreatedAt))
+	}
+	return nil
+}
+
+// promptVaultCode asks for the code of the vault if it has its own, "" otherwise (and for the default vault).
+func promptVaultCode(krdata KeyringData, vault string) (string, error) {
+	if vault == "" || vault == database.DefaultVault {
+		return "", nil
+	}
+	resp, err := api.PerformRequest[*api.ListVaultsResponse](SERVER, &api.ListVaultsRequest{
+		Cookies: api.ListVaultsRequestCookies{
+			Session: krdata.SessionToken,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list vaults: %w", err)
+	}
+	for _, v := range resp.Body.Vaults {
+		if v.Name != vault {
+			continue
+		}
+		if v.CodeFormat == nil {
+			return "", nil
+		}
+		code, err := promptRequiredPassword(fmt.Sprintf("%s for vault '%s': ", v.CodeFormat, vault))
+		if err != nil {
+			return "", fmt.Errorf("failed to get vault code: %w", err)
+		}
+		fmt.Println()
+		return code, nil
+	}
+	// let the server say it doesn't exist
+	return "", nil
+}
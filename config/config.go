@@ -20,6 +20,8 @@ type Config struct {
 	Addr  string `toml:"addr"`
 	Debug bool   `toml:"debug"`
 
+	RequestTimeout int64 `toml:"request_timeout"` // in seconds, max time a request's database/cache/vault work can take
+
 	Redis struct {
 		Network  string `toml:"network"`
 		Hostport string `toml:"hostport"`
@@ -32,6 +34,7 @@ type Config struct {
 		Port     int    `toml:"port"`
 		SSL      bool   `toml:"ssl"`
 		Database string `toml:"database"`
+		Timeout  int64  `toml:"timeout"` // in seconds, per query
 	} `toml:"postgres"`
 
 	Vault struct {
@@ -42,17 +45,147 @@ type Config struct {
 		Token        string `toml:"token"`          // vault token
 	} `toml:"vault"`
 
+	KDF struct {
+		Algorithm string `toml:"algorithm"` // "argon2id" (default) or "pbkdf2-sha256", used for new accounts and upgrades
+
+		Argon2id struct {
+			Memory      uint32 `toml:"memory"`      // in KiB
+			Time        uint32 `toml:"time"`        // number of passes
+			Parallelism uint8  `toml:"parallelism"` // number of lanes
+		} `toml:"argon2id"`
+
+		PBKDF2 struct {
+			Iterations int `toml:"iterations"`
+		} `toml:"pbkdf2"`
+	} `toml:"kdf"`
+
+	Lockout struct {
+		CodeMaxFailures   int   `toml:"code_max_failures"`   // wrong codes (per user and per session) before the master password is required again
+		LoginMaxFailures  int   `toml:"login_max_failures"`  // wrong master passwords (per account and per ip) before login is locked
+		LoginLockDuration int64 `toml:"login_lock_duration"` // in seconds
+		Window            int64 `toml:"window"`              // in seconds, failures older than this are forgotten
+		BackoffBase       int64 `toml:"backoff_base"`        // in ms, doubled after every failure
+		BackoffMax        int64 `toml:"backoff_max"`         // in ms
+	} `toml:"lockout"`
+
+	Links struct {
+		MaxViews int   `toml:"max_views"` // most times a one-time link can be opened
+		MaxTTL   int64 `toml:"max_ttl"`   // in seconds, longest a one-time link can live
+		MaxSize  int   `toml:"max_size"`  // in bytes, biggest ciphertext a one-time link can hold
+	} `toml:"links"`
+
+	Inbox struct {
+		MaxSize    int `toml:"max_size"`    // in bytes, biggest sealed secret that can be dropped
+		MaxPending int `toml:"max_pending"` // items an inbox can hold before drops are refused
+	} `toml:"inbox"`
+
+	TwoFactor struct {
+		Required bool   `toml:"required"` // every account needs two-factor, sessions without it can only enroll
+		Issuer   string `toml:"issuer"`   // the name authenticator apps show for the account
+	} `toml:"two_factor"`
+
+	WebAuthn struct {
+		RPID    string   `toml:"rp_id"`   // the domain the web client is served on, passkeys are bound to it
+		RPName  string   `toml:"rp_name"` // what authenticators show
+		Origins []string `toml:"origins"` // every origin (scheme://host[:port]) the web client is served from
+		Timeout int64    `toml:"timeout"` // in seconds, how long a registration or login ceremony can take
+	} `toml:"webauthn"`
+
+	Sessions struct {
+		Mode          string `toml:"mode"`           // "redis" (default) keeps sessions in redis, "signed" makes them signed tokens (see sessions/signed.go), redis is still required for the lockout
+		IdleTimeout   int64  `toml:"idle_timeout"`   // in seconds, a session not used for this long ends, 0 for no idle timeout
+		MaxLifetime   int64  `toml:"max_lifetime"`   // in seconds, a session ends this long after it was made however much it's used
+		TokenLifetime int64  `toml:"token_lifetime"` // in seconds, only for signed sessions: how long a token lasts before it has to be refreshed
+	} `toml:"sessions"`
+
+	RateLimit struct {
+		Store  string                `toml:"store"`  // "redis" (default) shares the counts between servers, "memory" keeps them in this one (a single node)
+		Routes map[string]RouteLimit `toml:"routes"` // by path (e.g. "/api/accounts/new"), routes that aren't here aren't limited
+	} `toml:"rate_limit"`
+
+	OIDC struct {
+		Issuer         string   `toml:"issuer"`          // the openid connect provider, empty disables single sign-on
+		ClientID       string   `toml:"client_id"`       // as registered with the provider
+		ClientSecret   string   `toml:"client_secret"`   // empty for a public client (pkce is always used)
+		RedirectURL    string   `toml:"redirect_url"`    // https://<web client>/sso/callback, registered with the provider
+		AllowedDomains []string `toml:"allowed_domains"` // email domains that can sign in, empty allows any verified email
+		Name           string   `toml:"name"`            // what the login button calls the provider
+	} `toml:"oidc"`
+
 	dirname string // lowercase to avoid toml, is working directory ("./.keylock") or "/home/.keylock" or similar
 }
 
+// RouteLimit is how many requests one ip and one user can make to a route in a sliding window, 0 doesn't limit.
+type RouteLimit struct {
+	IP     int   `toml:"ip"`
+	User   int   `toml:"user"`   // only counts on routes that need a session or access token, and per inbox on /api/inbox/drop
+	Window int64 `toml:"window"` // in seconds
+}
+
 func (c *Config) Dirname() string {
 	return c.dirname
 }
 
-var DefaultConfig *Config = &Config{
-	Addr:    ":0",
-	Debug:   false,
-	dirname: ".",
+var DefaultConfig *Config = newDefaultConfig()
+
+func newDefaultConfig() *Config {
+	c := &Config{
+		Addr:           ":0",
+		Debug:          false,
+		RequestTimeout: 30,
+		dirname:        ".",
+	}
+	c.Postgres.Timeout = 10
+
+	// rfc 9106 second recommended option (64 MiB, 3 passes, 4 lanes)
+	c.KDF.Algorithm = "argon2id"
+	c.KDF.Argon2id.Memory = 64 * 1024
+	c.KDF.Argon2id.Time = 3
+	c.KDF.Argon2id.Parallelism = 4
+	c.KDF.PBKDF2.Iterations = 1e6
+
+	c.Lockout.CodeMaxFailures = 5
+	c.Lockout.LoginMaxFailures = 10
+	c.Lockout.LoginLockDuration = 15 * 60
+	c.Lockout.Window = 60 * 60
+	c.Lockout.BackoffBase = 1000
+	c.Lockout.BackoffMax = 5 * 60 * 1000
+
+	c.Links.MaxViews = 10
+	c.Links.MaxTTL = 7 * 24 * 60 * 60
+	c.Links.MaxSize = 64 * 1024
+
+	c.Inbox.MaxSize = 16 * 1024
+	c.Inbox.MaxPending = 50
+
+	c.TwoFactor.Issuer = "keylock"
+
+	c.WebAuthn.RPID = "localhost"
+	c.WebAuthn.RPName = "keylock"
+	c.WebAuthn.Origins = []string{"http://localhost:8755"}
+	c.WebAuthn.Timeout = 5 * 60
+
+	c.Sessions.Mode = "redis"
+	c.Sessions.IdleTimeout = 24 * 60 * 60
+	c.Sessions.MaxLifetime = 7 * 24 * 60 * 60
+	c.Sessions.TokenLifetime = 15 * 60
+
+	c.RateLimit.Store = "redis"
+	c.RateLimit.Routes = map[string]RouteLimit{
+		"/api/accounts/new":            {IP: 5, Window: 60 * 60},
+		"/api/accounts/prelogin":       {IP: 30, Window: 60},
+		"/api/accounts/login":          {IP: 20, Window: 60},
+		"/api/passkeys/login/begin":    {IP: 20, Window: 60},
+		"/api/sso/login":               {IP: 20, Window: 60},
+		"/api/pairing/join":            {IP: 10, Window: 60},
+		"/api/links/open":              {IP: 30, Window: 60},
+		"/api/inbox/drop":              {IP: 10, User: 30, Window: 60 * 60},
+		"/api/passwords/retrieve":      {IP: 60, User: 30, Window: 60},
+		"/api/orgs/passwords/retrieve": {IP: 60, User: 30, Window: 60},
+	}
+
+	c.OIDC.Name = "single sign-on"
+	return c
 }
 
 func Init() {
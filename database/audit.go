@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+)
+
+type AuditEvent string
+
+const (
+	AuditLogin        AuditEvent = "login"
+	AuditLoginFailed  AuditEvent = "login_failed"
+	AuditLoginLocked  AuditEvent = "login_locked"
+	AuditCodeFailed   AuditEvent = "code_failed"
+	AuditCodeLocked   AuditEvent = "code_locked"
+	AuditCodeUnlocked AuditEvent = "code_unlocked"
+	AuditAccessDenied AuditEvent = "access_denied"
+	AuditOrgInvite    AuditEvent = "org_invite"
+	AuditOrgRemove    AuditEvent = "org_remove"
+	AuditOrgRole      AuditEvent = "org_role"
+
+	AuditTwoFactorFailed   AuditEvent = "two_factor_failed"
+	AuditTwoFactorEnabled  AuditEvent = "two_factor_enabled"
+	AuditTwoFactorDisabled AuditEvent = "two_factor_disabled"
+	AuditRecoveryCodeUsed  AuditEvent = "recovery_code_used"
+	AuditRecoveryCodesNew  AuditEvent = "recovery_codes_new"
+	AuditOrgTwoFactor      AuditEvent = "org_two_factor"
+
+	AuditPasskeyAdded   AuditEvent = "passkey_added"
+	AuditPasskeyRemoved AuditEvent = "passkey_removed"
+	AuditPasskeyLogin   AuditEvent = "passkey_login"
+	AuditPasskeyFailed  AuditEvent = "passkey_failed"
+
+	AuditSSOLinked   AuditEvent = "sso_linked"
+	AuditSSOUnlinked AuditEvent = "sso_unlinked"
+	AuditSSOLogin    AuditEvent = "sso_login"
+	AuditSSOFailed   AuditEvent = "sso_failed"
+
+	AuditSessionRevoked       AuditEvent = "session_revoked"
+	AuditSessionsRevokedAll   AuditEvent = "sessions_revoked_all"
+	AuditSessionPolicyChanged AuditEvent = "session_policy_changed"
+
+	AuditAccessTokenCreated AuditEvent = "access_token_created"
+	AuditAccessTokenRevoked AuditEvent = "access_token_revoked"
+
+	AuditDevicePaired AuditEvent = "device_paired"
+)
+
+// Audit records a security relevant event. userID may be 0 if the user isn't known (e.g. a login with a name that
+// doesn't exist), put whatever identifies them in detail instead. failing to write an audit event is logged
+// but never fails the request.
+func (db *DB) Audit(ctx context.Context, userID int64, event AuditEvent, ip, detail string) {
+	// the event should be written even if the request that caused it is gone
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx))
+	defer cancel()
+
+	uid := sql.NullInt64{Int64: userID, Valid: userID != 0}
+	stmt := `INSERT INTO audit_events (user_id, event, ip, detail) VALUES ($1, $2, $3, $4);`
+	if _, err := db.sql.ExecContext(ctx, stmt, uid, string(event), ip, detail); err != nil {
+		slog.Error("writing audit event", "event", event, "user_id", userID, "err", err)
+		return
+	}
+	slog.Info("audit", "event", event, "user_id", userID, "ip", ip, "detail", detail)
+}
@@ -1,18 +1,24 @@
 package database
 
 import (
-	"bytes"
+	"context"
 	"crypto/hkdf"
-	"crypto/pbkdf2"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
-	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"log/slog"
+	"strings"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/kdf"
+	"github.com/tiredkangaroo/keylock/passcode"
 	"github.com/tiredkangaroo/keylock/utils"
 	"github.com/tiredkangaroo/keylock/vault"
 )
@@ -22,19 +28,45 @@ import (
 // but that's super inefficient and its 3am rn.
 //
 // note for ui:
-// - session code + code -> key2 should be done in this manner
-// - decode code (uint16) -> bytes -> hex string
-// - session code hex (30 bytes or 60 chars) + code hex (4 chars) = key2 hex (64 chars)
+// - session code + code -> key2 should be done in this manner (see passcode.Key2, the web runs it as wasm, see web/wasm)
+// - decode code (digits or alphanumeric, see the user's passcode.Format) -> big endian bytes -> hex string
+// - session code hex + code hex = key2 hex (64 chars). the default format is 30 bytes + 2 bytes (uint16)
 
 var enc_key = make([]byte, 32) // 32 bytes for aes-256-gcm
 
+var (
+	ErrInvalidCredentials = errors.New("invalid name or master password")
+	ErrInvalidCode        = errors.New("incorrect code")
+	ErrPasswordNotFound   = errors.New("password not found")
+	ErrUserExists         = errors.New("a user with that name already exists")
+	ErrPasswordExists     = errors.New("a password with that name already exists, names are unique across all your vaults")
+
+	ErrInvalidSessionPolicy = errors.New("session timeouts can't be negative")
+	ErrOutdatedKDF          = errors.New("keys must be derived with the server's current kdf params (see /api/accounts/prelogin)")
+	ErrMigrationRekey       = errors.New("this account is from before login keys and has to be rekeyed with the master password to log in, update your client")
+)
+
 func Init() {
-	enc_key_str := vault.GetEncryptionKey()
+	enc_key_str := vault.GetEncryptionKey(context.Background())
 	if _, err := hex.Decode(enc_key, []byte(enc_key_str)); err != nil {
 		panic(fmt.Errorf("decoding ENCRYPTION_KEY: %w", err))
 	}
 }
 
+// withTimeout applies the per query deadline from config on top of ctx.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t := config.DefaultConfig.Postgres.Timeout; t > 0 {
+		return context.WithTimeout(ctx, time.Duration(t)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+// isUniqueViolation reports whether err is postgres complaining about a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
 type DB struct {
 	sql *sql.DB
 }
@@ -50,7 +82,7 @@ func (db *DB) Close() error {
 // user signs in how ever the hell they want (sms code, email code, password, 2fa, yubikey, biometrics, etc.): this has nothing to do with the secrets
 // user provides a code to encrypt/decrypt the secrets
 //
-// a master password is pbkdfd + key2_salt'd into 32 bytes -> 30 bytes will become a session code (e.g session storage), 2 bytes will become a uint16 number
+// a master password is kdf'd on the client (see kdf/client.go, argon2id by default) + key2_salt'd into 32 bytes -> 30 bytes will become a session code (e.g session storage), 2 bytes will become a uint16 number
 // the user must remember the uint16 number (when they're in a session)
 // combining the 32 bytes will give us key2
 // if not in a session (or the code is forgotten), the user must provide the master password
@@ -67,39 +99,31 @@ func (db *DB) Close() error {
 // this allows for unilateral key1 rotation.
 
 type User struct {
-	ID        int64  `json:"id"`
-	Name      string `json:"name"`
-	CreatedAt string `json:"created_at"`
+	ID         int64           `json:"id"`
+	Name       string          `json:"name"`
+	CodeFormat passcode.Format `json:"code_format"`
+	TwoFactor  bool            `json:"two_factor"` // whether they have a totp authenticator enabled, see twofactor.go
+	CreatedAt  string          `json:"created_at"`
+	// the user's own session policy in seconds, 0 for the server's. they can only make it stricter (see sessions.PolicyFor)
+	SessionIdleTimeout int64 `json:"session_idle_timeout,omitempty"`
+	SessionMaxLifetime int64 `json:"session_max_lifetime,omitempty"`
 }
 
 type Password struct {
 	ID        int64  `json:"id"`
-	UserID    int64  `json:"user_id"`
+	UserID    int64  `json:"user_id"` // the owner
 	Name      string `json:"name"`
 	CreatedAt string `json:"created_at"`
+	Vault     string `json:"vault,omitempty"` // only set for the user's own passwords
+	// only set for passwords shared with the user
+	OwnerName  string `json:"owner_name,omitempty"`
+	Permission string `json:"permission,omitempty"`
 }
 
-func Database() (*DB, error) {
-	db := &DB{}
-
-	sslmode := "disable"
-	if config.DefaultConfig.Postgres.SSL {
-		sslmode = "require"
-	}
-	sql, err := sql.Open("postgres", fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		vault.GetPostgresUsername(),
-		vault.GetPostgresPassword(),
-		config.DefaultConfig.Postgres.Host,
-		config.DefaultConfig.Postgres.Port,
-		config.DefaultConfig.Postgres.Database,
-		sslmode,
-	))
-	if err != nil {
-		return nil, err
-	}
-	db.sql = sql
-
-	userTableCreate := `CREATE TABLE IF NOT EXISTS users (
+// schema is run in order every time the database is opened, so every statement must be idempotent.
+// new columns on existing tables go at the end as ALTER TABLE ... ADD COLUMN IF NOT EXISTS.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS users (
 		id BIGSERIAL PRIMARY KEY,
 		name TEXT NOT NULL UNIQUE,
 		key1 BYTEA NOT NULL,
@@ -107,8 +131,8 @@ func Database() (*DB, error) {
 		key2_salt BYTEA NOT NULL,
 		key2_verifier BYTEA NOT NULL,
 		created_at timestamp DEFAULT CURRENT_TIMESTAMP
-	)`
-	passwordTableCreate := `CREATE TABLE IF NOT EXISTS passwords (
+	)`,
+	`CREATE TABLE IF NOT EXISTS passwords (
 		id BIGSERIAL PRIMARY KEY,
 		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
 		name TEXT NOT NULL,
@@ -117,53 +141,307 @@ func Database() (*DB, error) {
 		value_layer2_nonce BYTEA NOT NULL,
 		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE(user_id, name)
-	)`
-	_, err = db.sql.Exec(userTableCreate)
-	if err != nil {
-		return nil, fmt.Errorf("stmt 1: %w", err)
-	}
-	_, err = db.sql.Exec(passwordTableCreate)
-	if err != nil {
-		return nil, fmt.Errorf("stmt 2: %w", err)
-	}
+	)`,
 	// create index for faster lookups
-	_, err = db.sql.Exec("CREATE INDEX IF NOT EXISTS idx_user_name ON users(name)")
-	if err != nil {
-		return nil, fmt.Errorf("stmt 3: %w", err)
+	"CREATE INDEX IF NOT EXISTS idx_user_name ON users(name)",
+	"CREATE INDEX IF NOT EXISTS idx_password_user_id ON passwords(user_id)",
+	"CREATE INDEX IF NOT EXISTS idx_password_name ON passwords(name)",
+	// kdf used to derive key2 from the master password (see kdf.Params). users created before this column
+	// existed were all derived with the legacy pbkdf2 params.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS key2_kdf TEXT NOT NULL DEFAULT '{"algorithm":"pbkdf2-sha256","iterations":1000000}'`,
+	// security events (failed codes, lockouts, logins...), see audit.go
+	`CREATE TABLE IF NOT EXISTS audit_events (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		event TEXT NOT NULL,
+		ip TEXT NOT NULL,
+		detail TEXT NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events(user_id)",
+	// how the code is split off of key2 (see passcode.Format). users created before this column all have 5 digit codes.
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS code_format TEXT NOT NULL DEFAULT '{"kind":"digits","length":5}'`,
+	// x25519 key pair, the private key is encrypted with key2 (see keys.go). NULL for users from before key pairs.
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS public_key BYTEA",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS private_key BYTEA",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS private_key_nonce BYTEA",
+	// per password data key encrypted with the owner's key2. NULL for passwords from before data keys.
+	"ALTER TABLE passwords ADD COLUMN IF NOT EXISTS data_key BYTEA",
+	"ALTER TABLE passwords ADD COLUMN IF NOT EXISTS data_key_nonce BYTEA",
+	// data keys sealed to the public key of whoever a password is shared with (see shares.go)
+	`CREATE TABLE IF NOT EXISTS password_shares (
+		password_id BIGINT NOT NULL REFERENCES passwords(id) ON DELETE CASCADE,
+		recipient_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		data_key BYTEA NOT NULL,
+		permission TEXT NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(password_id, recipient_id)
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_password_shares_recipient_id ON password_shares(recipient_id)",
+	// organizations, see orgs.go. key1 is the organization's layer 2 key, like a user's.
+	`CREATE TABLE IF NOT EXISTS organizations (
+		id BIGSERIAL PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		key1 BYTEA NOT NULL,
+		key1_nonce BYTEA NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE TABLE IF NOT EXISTS org_members (
+		org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		role TEXT NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(org_id, user_id)
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id)",
+	`CREATE TABLE IF NOT EXISTS collections (
+		id BIGSERIAL PRIMARY KEY,
+		org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(org_id, name)
+	)`,
+	// a collection's data key sealed to the public key of every member
+	`CREATE TABLE IF NOT EXISTS collection_keys (
+		collection_id BIGINT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		data_key BYTEA NOT NULL,
+		UNIQUE(collection_id, user_id)
+	)`,
+	`CREATE TABLE IF NOT EXISTS collection_passwords (
+		id BIGSERIAL PRIMARY KEY,
+		collection_id BIGINT NOT NULL REFERENCES collections(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		value BYTEA NOT NULL,
+		value_layer1_nonce BYTEA NOT NULL,
+		value_layer2_nonce BYTEA NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(collection_id, name)
+	)`,
+	// vaults other than the default one, see vaults.go. code_format is NULL if the vault doesn't have its own code.
+	`CREATE TABLE IF NOT EXISTS vaults (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		vault_key BYTEA NOT NULL,
+		vault_key_nonce BYTEA NOT NULL,
+		salt BYTEA NOT NULL,
+		code_format TEXT,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(user_id, name)
+	)`,
+	// NULL is the default vault
+	"ALTER TABLE passwords ADD COLUMN IF NOT EXISTS vault_id BIGINT REFERENCES vaults(id) ON DELETE CASCADE",
+	// inboxes, see inbox.go. NULL means the user doesn't have one open.
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS inbox_token TEXT UNIQUE",
+	`CREATE TABLE IF NOT EXISTS inbox_items (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		label TEXT NOT NULL,
+		sealed BYTEA NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_inbox_items_user_id ON inbox_items(user_id)",
+	// one-time links, see links.go. only ciphertext, the key is in the link.
+	`CREATE TABLE IF NOT EXISTS secret_links (
+		id TEXT PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		ciphertext BYTEA NOT NULL,
+		nonce BYTEA NOT NULL,
+		passphrase BOOLEAN NOT NULL DEFAULT FALSE,
+		views_left INTEGER NOT NULL,
+		expires_at timestamp NOT NULL,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// checks the key before a view is used up (see utils/links.go). NULL for links from before, they open without it.
+	"ALTER TABLE secret_links ADD COLUMN IF NOT EXISTS verifier BYTEA",
+	// two-factor, see twofactor.go. the totp secret is encrypted with enc_key and NULL if the user never enrolled.
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret BYTEA",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret_nonce BYTEA",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter BIGINT NOT NULL DEFAULT 0",
+	`CREATE TABLE IF NOT EXISTS recovery_codes (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash BYTEA NOT NULL,
+		used_at timestamp,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)",
+	// organizations can require their members to use two-factor
+	"ALTER TABLE organizations ADD COLUMN IF NOT EXISTS require_two_factor BOOLEAN NOT NULL DEFAULT FALSE",
+	// webauthn credentials, see passkeys.go. credential is the json of the webauthn library's credential record,
+	// session_code is only set for passkeys that can log in without the master password.
+	`CREATE TABLE IF NOT EXISTS passkeys (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		credential_id BYTEA NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		credential BYTEA NOT NULL,
+		sign_count BIGINT NOT NULL DEFAULT 0,
+		session_code BYTEA,
+		last_used_at timestamp,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id)",
+	// single sign-on identities linked to users, see sso.go
+	`CREATE TABLE IF NOT EXISTS sso_identities (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		issuer TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		email TEXT NOT NULL DEFAULT '',
+		last_used_at timestamp,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (issuer, subject)
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_sso_identities_user_id ON sso_identities(user_id)",
+	// per-user session policy, see sessions.PolicyFor
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS session_idle_timeout BIGINT NOT NULL DEFAULT 0",
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS session_max_lifetime BIGINT NOT NULL DEFAULT 0",
+	// personal access tokens, see tokens.go. scopes are space separated, expires_at is NULL if it doesn't expire.
+	`CREATE TABLE IF NOT EXISTS access_tokens (
+		id BIGSERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		token_hash BYTEA NOT NULL UNIQUE,
+		scopes TEXT NOT NULL,
+		public_key BYTEA NOT NULL,
+		private_key BYTEA NOT NULL,
+		private_key_nonce BYTEA NOT NULL,
+		expires_at timestamp,
+		last_used_at timestamp,
+		created_at timestamp DEFAULT CURRENT_TIMESTAMP
+	)`,
+	"CREATE INDEX IF NOT EXISTS idx_access_tokens_user_id ON access_tokens(user_id)",
+	// data keys sealed to the public key of the access tokens whose scopes cover them
+	`CREATE TABLE IF NOT EXISTS access_token_keys (
+		token_id BIGINT NOT NULL REFERENCES access_tokens(id) ON DELETE CASCADE,
+		password_id BIGINT NOT NULL REFERENCES passwords(id) ON DELETE CASCADE,
+		data_key BYTEA NOT NULL,
+		UNIQUE(token_id, password_id)
+	)`,
+	// revoked signed sessions, see revocations.go
+	`CREATE TABLE IF NOT EXISTS revoked_sessions (
+		key TEXT PRIMARY KEY,
+		revoked_before timestamp,
+		except_session TEXT NOT NULL DEFAULT '',
+		expires_at timestamp NOT NULL
+	)`,
+	// checks the login key clients derive along with key2 (see kdf/client.go). only used for accounts on the current
+	// kdf.KeysVersion, the others migrate with their master password on their next login (see LoginUser).
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS login_verifier BYTEA",
+	// keeps the code the same through a rekey (see passcode.Offset), NULL if it's the one the kdf gives
+	"ALTER TABLE users ADD COLUMN IF NOT EXISTS code_offset BYTEA",
+}
+
+func Database(ctx context.Context) (*DB, error) {
+	sslmode := "disable"
+	if config.DefaultConfig.Postgres.SSL {
+		sslmode = "require"
 	}
-	_, err = db.sql.Exec("CREATE INDEX IF NOT EXISTS idx_password_user_id ON passwords(user_id)")
+	return Open(ctx, fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		vault.GetPostgresUsername(ctx),
+		vault.GetPostgresPassword(ctx),
+		config.DefaultConfig.Postgres.Host,
+		config.DefaultConfig.Postgres.Port,
+		config.DefaultConfig.Postgres.Database,
+		sslmode,
+	))
+}
+
+// Open connects to the postgres database at dsn and creates the tables. Database is Open with the configured
+// database, tests use it with their own.
+func Open(ctx context.Context, dsn string) (*DB, error) {
+	sql, err := sql.Open("postgres", dsn)
 	if err != nil {
-		return nil, fmt.Errorf("stmt 4: %w", err)
+		return nil, err
 	}
-	_, err = db.sql.Exec("CREATE INDEX IF NOT EXISTS idx_password_name ON passwords(name)")
-	if err != nil {
-		return nil, fmt.Errorf("stmt 5: %w", err)
+	db := &DB{sql: sql}
+
+	for i, stmt := range schema {
+		if _, err := db.sql.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("stmt %d: %w", i+1, err)
+		}
 	}
 
 	return db, nil
 }
 
-func (db *DB) GetUserByID(id int64) (*User, error) {
-	stmt := `SELECT id, name, created_at FROM users WHERE id = $1;`
+func (db *DB) GetUserByID(ctx context.Context, id int64) (*User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, name, code_format, totp_enabled, created_at, session_idle_timeout, session_max_lifetime FROM users WHERE id = $1;`
 	var user User
-	err := db.sql.QueryRow(stmt, id).Scan(&user.ID, &user.Name, &user.CreatedAt)
+	var codeFormat string
+	err := db.sql.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Name, &codeFormat, &user.TwoFactor, &user.CreatedAt, &user.SessionIdleTimeout, &user.SessionMaxLifetime)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user with id %d not found", id)
 		}
 		return nil, fmt.Errorf("querying user: %w", err)
 	}
+	user.CodeFormat, err = passcode.Parse(codeFormat)
+	if err != nil {
+		return nil, fmt.Errorf("user code format: %w", err)
+	}
 	return &user, nil
 }
 
+// GetUserByName is GetUserByID for a name, ErrUserNotFound if nobody has it.
+func (db *DB) GetUserByName(ctx context.Context, name string) (*User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var id int64
+	if err := db.sql.QueryRowContext(ctx, `SELECT id FROM users WHERE name = $1;`, name).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrUserNotFound, name)
+		}
+		return nil, fmt.Errorf("querying user: %w", err)
+	}
+	return db.GetUserByID(ctx, id)
+}
+
+// SetSessionPolicy changes the user's session policy (seconds, 0 for the server's). it applies to new sessions.
+func (db *DB) SetSessionPolicy(ctx context.Context, userid, idleTimeout, maxLifetime int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if idleTimeout < 0 || maxLifetime < 0 {
+		return ErrInvalidSessionPolicy
+	}
+	stmt := `UPDATE users SET session_idle_timeout = $2, session_max_lifetime = $3 WHERE id = $1;`
+	if _, err := db.sql.ExecContext(ctx, stmt, userid, idleTimeout, maxLifetime); err != nil {
+		return fmt.Errorf("updating session policy: %w", err)
+	}
+	return nil
+}
+
 // SaveUser saves a user to the database (oh great explanation, i know).
 // Expected fields:
 // - Name
-// - Master Password (not stored, used to provide session code and code)
-func (db *DB) SaveUser(name, masterPassword string) (id int64, sessionCode string, code string, err error) {
-	// name is literally the only thing we're taking from the passed in struct
-	// we'll generate the key1, key1_nonce, and key2_salt here
-	randoms := make([]byte, 16+12+16) // 16 for key1, 12 for key1_nonce, 16 for key2_salt
+// - Credentials (derived from the master password by the client, see kdf.Credentials. key2 isn't stored)
+func (db *DB) SaveUser(ctx context.Context, name string, creds kdf.Credentials) (id int64, err error) {
+	if err = checkCredentials(creds); err != nil {
+		return
+	}
+	key2, err := decodeKey2(creds.Key2)
+	if err != nil {
+		return
+	}
+	codeFormat, err := creds.CodeFormat.Marshal()
+	if err != nil {
+		return
+	}
+	kdfParams, err := creds.KDF.Marshal()
+	if err != nil {
+		return
+	}
+
+	// we'll generate the key1 and key1_nonce here, the salt comes from the client
+	randoms := make([]byte, 16+12) // 16 for key1, 12 for key1_nonce
 
 	_, err = rand.Read(randoms) // err is never returned, program "crashes irrecoverably" on error ?? 💔
 	if err != nil {
@@ -172,8 +450,7 @@ func (db *DB) SaveUser(name, masterPassword string) (id int64, sessionCode strin
 	}
 
 	key1_raw := randoms[:16]
-	key1_nonce := randoms[16:28]
-	key2_salt := randoms[28:]
+	key1_nonce := randoms[16:]
 
 	key_1, err := utils.Encrypt(enc_key, key1_nonce, key1_raw)
 	if err != nil {
@@ -181,164 +458,586 @@ func (db *DB) SaveUser(name, masterPassword string) (id int64, sessionCode strin
 		return
 	}
 
-	key2, err := pbkdf2.Key(sha256.New, masterPassword, key2_salt, 1e6, 32)
+	key2_verifier, err := key2Verifier(key2)
+	if err != nil {
+		return
+	}
+	login_verifier, err := loginVerifier(creds.LoginKey)
 	if err != nil {
-		err = fmt.Errorf("pbkdf2 key: %w", err)
 		return
 	}
-	key2_verifier, err := hkdf.Key(sha256.New, append(key2, enc_key...), nil, "key2-verifier", 32)
+	public_key, private_key, private_key_nonce, err := newKeyPair(key2)
 	if err != nil {
-		err = fmt.Errorf("hkdf key2 verifier: %w", err)
 		return
 	}
 
 	// id and created_at are defaulted by the database, so we don't need to set them
-	stmt := `INSERT INTO users (name, key1, key1_nonce, key2_salt, key2_verifier) VALUES ($1, $2, $3, $4, $5) RETURNING id;`
-	err = db.sql.QueryRow(stmt, name, key_1, key1_nonce, key2_salt, key2_verifier).Scan(&id)
+	stmt := `INSERT INTO users (name, key1, key1_nonce, key2_salt, key2_verifier, key2_kdf, code_format, code_offset, public_key, private_key, private_key_nonce, login_verifier) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id;`
+	qctx, cancel := withTimeout(ctx)
+	defer cancel()
+	err = db.sql.QueryRowContext(qctx, stmt, name, key_1, key1_nonce, creds.Salt, key2_verifier, kdfParams, codeFormat, creds.CodeOffset, public_key, private_key, private_key_nonce, login_verifier).Scan(&id)
 	if err != nil {
+		if isUniqueViolation(err) {
+			err = fmt.Errorf("%w: %s", ErrUserExists, name)
+			return
+		}
 		err = fmt.Errorf("inserting user: %w", err)
 		return
 	}
-
-	sessionCode = hex.EncodeToString(key2[:30])     // 30 bytes for session code
-	rawCode := binary.BigEndian.Uint16(key2[30:32]) // 2 bytes for code, uint16
-	code = fmt.Sprintf("%05d", rawCode)             // 5 digits
 	return
 }
 
-// SavePassword saves a password to the database.
-// expected fields:
-// - UserID
-// - Value
-// - Name
-func (db *DB) SavePassword(userid int64, name, key2, value string) error {
-	key2_decoded, err := hex.DecodeString(key2)
+// checkCredentials checks credentials a client made for a new account or a rekey. they have to use the current
+// kdf params, otherwise a client could pick weak ones.
+func checkCredentials(creds kdf.Credentials) error {
+	if err := creds.Validate(); err != nil {
+		return err
+	}
+	if creds.KDF != kdf.Current() {
+		return ErrOutdatedKDF
+	}
+	return nil
+}
+
+// Prelogin returns what a client needs to derive the keys of the user with the given name (see kdf.Account).
+// names nobody has get the current params and a made up (but always the same) salt, so it doesn't say who has an
+// account. accounts that still need migrating or upgrading do stand out, and so do rekeyed ones (by their code offset).
+func (db *DB) Prelogin(ctx context.Context, name string) (kdf.Account, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	a := kdf.Account{CurrentKDF: kdf.Current()}
+	var kdfParams, codeFormat string
+	stmt := `SELECT key2_salt, key2_kdf, code_format, code_offset FROM users WHERE name = $1;`
+	err := db.sql.QueryRowContext(ctx, stmt, name).Scan(&a.Salt, &kdfParams, &codeFormat, &a.CodeOffset)
+	if err == sql.ErrNoRows {
+		mac := hmac.New(sha256.New, enc_key)
+		mac.Write([]byte("prelogin:" + name))
+		a.KDF, a.Salt, a.CodeFormat = a.CurrentKDF, mac.Sum(nil)[:16], passcode.Legacy()
+		return a, nil
+	}
 	if err != nil {
-		return fmt.Errorf("decoding key2 with hex: %w", err)
+		return kdf.Account{}, fmt.Errorf("querying user: %w", err)
+	}
+	if a.KDF, err = kdf.Parse(kdfParams); err != nil {
+		return kdf.Account{}, fmt.Errorf("user kdf params: %w", err)
+	}
+	if a.CodeFormat, err = passcode.Parse(codeFormat); err != nil {
+		return kdf.Account{}, fmt.Errorf("user code format: %w", err)
+	}
+	a.Migrate = a.KDF.Version < kdf.KeysVersion
+	return a, nil
+}
+
+// LoginUser checks the login key of the user with the given name (see kdf.Login).
+// users with two-factor enabled also need factor (see twofactor.go), it's checked after the login key so a wrong
+// master password never uses up a code.
+// key2 (hex) is needed with rekey (optional, new kdf params and/or code format), then every password the user owns
+// is re-encrypted with the new key2.
+// accounts from before login keys (see kdf.Account.Migrate) have no login key key2 doesn't give away, and key2 is
+// only the session code and the code, so it doesn't prove the master password. they log in with masterPassword
+// instead: we derive key2 from it with their params like we used to, check it against key2_verifier and rekey them,
+// which is what gives them a login key. that's the one time the kdf runs here for an account.
+// upgraded reports whether a rekey happened, which means the session code changed (the code only does if the format
+// did, see passcode.Offset).
+func (db *DB) LoginUser(ctx context.Context, name string, loginKey []byte, key2Hex, masterPassword string, rekey *kdf.Credentials, factor SecondFactor) (id int64, upgraded bool, err error) {
+	if rekey != nil {
+		if err = checkCredentials(*rekey); err != nil {
+			return
+		}
 	}
 
-	stmt := `SELECT key1, key1_nonce, key2_verifier FROM users WHERE id = $1;` // step 1: get the user to retrieve key1
-	var key1_raw, key1_nonce, key2_verifier []byte
-	err = db.sql.QueryRow(stmt, userid).Scan(&key1_raw, &key1_nonce, &key2_verifier)
+	// step 1: get the user
+	stmt := `SELECT id, key1, key1_nonce, key2_salt, key2_verifier, key2_kdf, login_verifier, code_format, code_offset, public_key IS NOT NULL FROM users WHERE name = $1;`
+	var key1_raw, key1_nonce, key2_salt, key2_verifier, login_verifier, codeOffset []byte
+	var kdfParams, codeFormat string
+	var hasKeyPair bool
+	qctx, cancel := withTimeout(ctx)
+	err = db.sql.QueryRowContext(qctx, stmt, name).Scan(&id, &key1_raw, &key1_nonce, &key2_salt, &key2_verifier, &kdfParams, &login_verifier, &codeFormat, &codeOffset, &hasKeyPair)
+	cancel()
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("user with id %d not found", userid)
+			err = ErrInvalidCredentials
+		} else {
+			err = fmt.Errorf("querying user: %w", err)
+		}
+		return
+	}
+	params, err := kdf.Parse(kdfParams)
+	if err != nil {
+		err = fmt.Errorf("user kdf params: %w", err)
+		return
+	}
+	currentFormat, err := passcode.Parse(codeFormat)
+	if err != nil {
+		err = fmt.Errorf("user code format: %w", err)
+		return
+	}
+
+	// step 2: check the login key, or the master password of migrating users, and key2 if we got it
+	migrating := params.Version < kdf.KeysVersion
+	var key2 []byte
+	if migrating {
+		if masterPassword == "" || rekey == nil {
+			err = ErrMigrationRekey
+			return
+		}
+		keys, kerr := params.Keys(masterPassword, key2_salt, currentFormat, codeOffset)
+		if kerr != nil {
+			err = kerr
+			return
+		}
+		key2 = keys.Key2
+	} else {
+		verifier, verr := loginVerifier(loginKey)
+		if verr != nil {
+			err = verr
+			return
+		}
+		if subtle.ConstantTimeCompare(verifier, login_verifier) != 1 {
+			err = ErrInvalidCredentials
+			return
+		}
+		if key2Hex != "" {
+			if key2, err = decodeKey2(key2Hex); err != nil {
+				err = ErrInvalidCredentials
+				return
+			}
+		} else if rekey != nil {
+			err = fmt.Errorf("%w: key2 is required to rekey", kdf.ErrMalformedCredentials)
+			return
+		}
+	}
+	if key2 != nil {
+		verifier, verr := key2Verifier(key2)
+		if verr != nil {
+			err = verr
+			return
+		}
+		if subtle.ConstantTimeCompare(verifier, key2_verifier) != 1 {
+			err = ErrInvalidCredentials
+			return
+		}
+	}
+
+	// step 3: the second factor, before anything below changes the code
+	if _, err = db.VerifySecondFactor(ctx, id, factor); err != nil {
+		return
+	}
+
+	// step 4: rekey (upgrade the kdf and/or change the code format)
+	if rekey != nil {
+		key1, derr := utils.Decrypt(enc_key, key1_nonce, key1_raw)
+		if derr != nil {
+			err = fmt.Errorf("decrypting key1: %w", derr)
+			return
+		}
+		uerr := db.rekeyUser(ctx, id, key1, key2, *rekey)
+		if uerr == nil {
+			slog.Info("rekeyed user", "user_id", id, "kdf", rekey.KDF.Algorithm, "code_format", rekey.CodeFormat.String())
+			upgraded = true
+			return
+		}
+		if rekey.CodeFormat != currentFormat {
+			err = fmt.Errorf("changing code format: %w", uerr)
+			return
+		}
+		if migrating {
+			// the rekey is what gives them a login key
+			err = fmt.Errorf("migrating: %w", uerr)
+			return
+		}
+		// the old key2 still works so we don't fail the login over a kdf upgrade, the client tries again next time
+		slog.Error("upgrading user kdf", "user_id", id, "err", uerr)
+	}
+
+	// this isn't worth failing the login over either
+	qctx, cancel = withTimeout(ctx)
+	defer cancel()
+	if key2 != nil && !hasKeyPair {
+		// users from before key pairs get one now (rekeyUser does it otherwise)
+		if kerr := ensureKeyPair(qctx, db.sql, &userKeys{id: id}, key2); kerr != nil {
+			slog.Error("giving user a key pair", "user_id", id, "err", kerr)
+		}
+	}
+	return
+}
+
+// rekeyUser moves everything the user has under the old key2 (data keys, private key) to the key2 in creds (which
+// has a new salt, kdf params and/or code format) and stores the new salt, verifiers, params, format and offset. it all
+// happens in one transaction so a failure leaves the user on their old key2.
+func (db *DB) rekeyUser(ctx context.Context, userid int64, key1, oldKey2 []byte, creds kdf.Credentials) (err error) {
+	kdfParams, err := creds.KDF.Marshal()
+	if err != nil {
+		return
+	}
+	codeFormat, err := creds.CodeFormat.Marshal()
+	if err != nil {
+		return
+	}
+	key2, err := decodeKey2(creds.Key2)
+	if err != nil {
+		return
+	}
+	verifier, err := key2Verifier(key2)
+	if err != nil {
+		return
+	}
+	login_verifier, err := loginVerifier(creds.LoginKey)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		err = fmt.Errorf("begin tx: %w", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err = reencryptPasswords(ctx, tx, userid, key1, oldKey2, key2); err != nil {
+		return
+	}
+	// the private key moves to the new key2 too (users from before key pairs get one here)
+	k, err := getUserKeys(ctx, tx, userid)
+	if err != nil {
+		return
+	}
+	var publicKey, privateKey, privateKeyNonce []byte
+	if k.privateKey == nil {
+		if publicKey, privateKey, privateKeyNonce, err = newKeyPair(key2); err != nil {
+			return
+		}
+	} else {
+		priv, perr := k.openPrivateKey(oldKey2)
+		if perr != nil {
+			err = perr
+			return
+		}
+		publicKey, privateKeyNonce = k.publicKey, make([]byte, 12)
+		rand.Read(privateKeyNonce)
+		if privateKey, err = utils.Encrypt(key2, privateKeyNonce, priv); err != nil {
+			err = fmt.Errorf("encrypting private key: %w", err)
+			return
+		}
+	}
+
+	stmt := `UPDATE users SET key2_salt = $1, key2_verifier = $2, key2_kdf = $3, code_format = $4, public_key = $5, private_key = $6, private_key_nonce = $7, login_verifier = $8, code_offset = $9 WHERE id = $10;`
+	if _, err = tx.ExecContext(ctx, stmt, creds.Salt, verifier, kdfParams, codeFormat, publicKey, privateKey, privateKeyNonce, login_verifier, creds.CodeOffset, userid); err != nil {
+		err = fmt.Errorf("updating user: %w", err)
+		return
+	}
+	// the session code changed, so passkeys can't hand out the old one anymore (see passkeys.go)
+	if err = clearPasskeySessionCodes(ctx, tx, userid); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		err = fmt.Errorf("commit tx: %w", err)
+		return
+	}
+	return
+}
+
+// reencryptPasswords moves the data key of every password in the user's default vault from oldKey2 to newKey2
+// (the other vaults don't use key2 directly, see vaults.go). passwords from before data keys get one (see
+// keys.go), so their layer 1 no longer depends on key2 at all.
+func reencryptPasswords(ctx context.Context, tx *sql.Tx, userid int64, key1, oldKey2, newKey2 []byte) error {
+	stmt := `SELECT id, value, value_layer1_nonce, value_layer2_nonce, data_key, data_key_nonce FROM passwords WHERE user_id = $1 AND vault_id IS NULL FOR UPDATE;`
+	rows, err := tx.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return fmt.Errorf("querying passwords: %w", err)
+	}
+	var entries []*entry
+	for rows.Next() {
+		e := &entry{ownerID: userid}
+		if err := rows.Scan(&e.id, &e.value, &e.layer1_nonce, &e.layer2_nonce, &e.dataKey, &e.dataKeyNonce); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning password row: %w", err)
 		}
-		return fmt.Errorf("querying user: %w", err)
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating passwords: %w", err)
+	}
+
+	for _, e := range entries {
+		dataKey, err := e.giveDataKey(key1, oldKey2)
+		if err != nil {
+			return err
+		}
+		if err := e.wrapDataKey(dataKey, newKey2); err != nil {
+			return err
+		}
+		if err := e.update(ctx, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key2Verifier is what we store to check a key2 without storing key2 itself.
+func key2Verifier(key2 []byte) ([]byte, error) {
+	v, err := hkdf.Key(sha256.New, append(key2[:len(key2):len(key2)], enc_key...), nil, "key2-verifier", 32)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf key2 verifier: %w", err)
 	}
+	return v, nil
+}
 
-	// step 2: verify key2
-	hkdf_key2, err := hkdf.Key(sha256.New, append(key2_decoded, enc_key...), nil, "key2-verifier", 32)
+// loginVerifier is what we store to check a login key (see kdf.Keys).
+func loginVerifier(loginKey []byte) ([]byte, error) {
+	v, err := hkdf.Key(sha256.New, append(loginKey[:len(loginKey):len(loginKey)], enc_key...), nil, "login-verifier", 32)
 	if err != nil {
-		return fmt.Errorf("hkdf key2 verifier: %w", err)
+		return nil, fmt.Errorf("hkdf login verifier: %w", err)
 	}
-	if !bytes.Equal(hkdf_key2, key2_verifier) {
-		return fmt.Errorf("key2 verification failed")
+	return v, nil
+}
+
+// decodeKey2 decodes key2 from hex (see passcode.Key2).
+func decodeKey2(key2 string) ([]byte, error) {
+	b, err := hex.DecodeString(key2)
+	if err != nil {
+		return nil, fmt.Errorf("decoding key2 with hex: %w", err)
 	}
+	return b, nil
+}
 
-	key1, err := utils.Decrypt(enc_key, key1_nonce, key1_raw) // step 3: decrypt key1
+// SavePassword saves a password to the database.
+// expected fields:
+// - UserID
+// - Vault (name, "" for the default vault) and its code if it has its own
+// - Value
+// - Name
+func (db *DB) SavePassword(ctx context.Context, userid int64, vault, name, key2, vaultCode, value string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	key2_decoded, err := decodeKey2(key2)
 	if err != nil {
-		return fmt.Errorf("decrypting key1: %w", err)
+		return err
+	}
+
+	// step 1: get the user's keys (key1 is decrypted) and verify key2
+	k, err := getUserKeys(ctx, db.sql, userid)
+	if err != nil {
+		return err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return err
+	}
+	// step 2: users from before key pairs get one now, so their passwords can be shared
+	if err := ensureKeyPair(ctx, db.sql, k, key2_decoded); err != nil {
+		return err
 	}
 
-	// step 4: generate nonces for layer1 and layer2 encryption
-	nonces := make([]byte, 24)  // 12 bytes for layer1 nonce, 12 bytes for layer2 nonce
-	rand.Read(nonces)           // generate random nonces
-	layer1_nonce := nonces[:12] // first 12 bytes for layer1 nonce
-	layer2_nonce := nonces[12:] // last 12 bytes for layer2 nonce
+	// step 3: encrypt and insert it
+	return insertPassword(ctx, db.sql, k, key2_decoded, vault, vaultCode, name, []byte(value))
+}
 
-	// step 5: encrypt the value with key2 to make layer 1
-	layer1, err := utils.Encrypt(key2_decoded, layer1_nonce, []byte(value))
+// insertPassword encrypts value and inserts it as the password called name in k's vault. key2 must be verified already.
+func insertPassword(ctx context.Context, q querier, k *userKeys, key2 []byte, vault, vaultCode, name string, value []byte) error {
+	// step 1: open the vault (key2 itself for the default vault)
+	v, err := getVault(ctx, q, k.id, vault)
 	if err != nil {
-		return fmt.Errorf("encrypting layer 1: %w", err)
+		return err
 	}
-	// step 6: encrypt the layer1 with key1 to make layer 2 (final value)
-	layer2, err := utils.Encrypt(key1, layer2_nonce, layer1)
+	wrapKey, err := v.wrapKey(k, key2, vaultCode)
 	if err != nil {
-		return fmt.Errorf("encrypting layer 2: %w", err)
+		return err
+	}
+	e := &entry{ownerID: k.id}
+	if v != nil {
+		e.vaultID = sql.NullInt64{Int64: v.ID, Valid: true}
+	}
+
+	// step 2: encrypt the value with a new data key (layer 1) and key1 (layer 2), the data key is kept under the vault's key
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	if err := e.seal(k.key1, dataKey, value); err != nil {
+		return err
+	}
+	if err := e.wrapDataKey(dataKey, wrapKey); err != nil {
+		return err
 	}
 
-	// step 7: insert the password into the database
-	stmt = `INSERT INTO passwords (user_id, name, value, value_layer1_nonce, value_layer2_nonce) VALUES ($1, $2, $3, $4, $5);`
-	_, err = db.sql.Exec(stmt, userid, name, layer2, layer1_nonce, layer2_nonce)
+	// step 3: insert the password into the database
+	stmt := `INSERT INTO passwords (user_id, name, value, value_layer1_nonce, value_layer2_nonce, data_key, data_key_nonce, vault_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id;`
+	err = q.QueryRowContext(ctx, stmt, k.id, name, e.value, e.layer1_nonce, e.layer2_nonce, e.dataKey, e.dataKeyNonce, e.vaultID).Scan(&e.id)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("%w: %s", ErrPasswordExists, name)
+		}
 		return fmt.Errorf("inserting password: %w", err)
 	}
+
+	// step 4: access tokens whose scopes cover it get its data key (not in vaults with their own code, see tokens.go)
+	if v == nil || v.CodeFormat == nil {
+		return sealToTokens(ctx, q, k.id, e, name, dataKey)
+	}
 	return nil
 }
 
 // password will be set into the value field
 // expected fields:
-// - user id
+// - user id (who's asking, key2 is theirs)
+// - owner id (whose vault the password is in, the same as user id unless it's shared with them)
 // - name
-func (db *DB) RetrievePassword(userid int64, name, key2 string) (pwd []byte, err error) {
-	// i dont think we need to verify key2 here since if u try to decrypt it with the wrong key2, it will just return an error (gcm)
+// - vault code, if the password is in one of the owner's vaults with its own code (not needed for shares)
+func (db *DB) RetrievePassword(ctx context.Context, userid, ownerID int64, name, key2, vaultCode string) (pwd []byte, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// a wrong key2 would fail gcm anyway, but we verify it so a wrong code is ErrInvalidCode (and counts towards the lockout)
 	// steps:
 	// - decode key2 from hex to bytes
-	// - get password by name and user id (value, value_layer1_nonce and value_layer2_nonce)
-	// - get the user by id (key1, key1_nonce)
-	// - decrypt key1 with enc_key + key1_nonce
-	// - decrypt layer 2 with key1 + value_layer2_nonce
-	// - decrypt layer 1 with key2 + value_layer1_nonce (secret)
+	// - get the user's keys by id (key1, key2_verifier, private key) and verify key2
+	// - get password by name and owner id
+	// - get the data key: with key2 (or the vault key) for the owner, with the user's private key for a share
+	// - decrypt layer 2 with the owner's key1 + value_layer2_nonce
+	// - decrypt layer 1 with the data key + value_layer1_nonce (secret)
 
 	// step 0: decode key2 from hex to bytes
-	key2_decoded, err := hex.DecodeString(key2)
+	key2_decoded, err := decodeKey2(key2)
 	if err != nil {
-		err = fmt.Errorf("decoding key2 with hex: %w", err)
 		return
 	}
 
-	// step 1: get password and extract value, value_layer1_nonce, value_layer2_nonce
-	stmt := `SELECT value, value_layer1_nonce, value_layer2_nonce FROM passwords WHERE user_id = $1 AND name = $2;`
-	var value, value_layer1_nonce, value_layer2_nonce []byte
-	err = db.sql.QueryRow(stmt, userid, name).Scan(&value, &value_layer1_nonce, &value_layer2_nonce)
+	// step 1: get the user's keys and verify key2
+	k, err := getUserKeys(ctx, db.sql, userid)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			err = fmt.Errorf("password with name %s for user id %d not found", name, userid)
-		} else {
-			err = fmt.Errorf("querying password: %w", err)
-		}
+		return
+	}
+	if err = k.verify(key2_decoded); err != nil {
 		return
 	}
 
-	// step 2: get the user to retrieve key1 and key1_nonce
-	stmt = `SELECT key1, key1_nonce FROM users WHERE id = $1;`
-	var key1_raw, key1_nonce []byte
-	err = db.sql.QueryRow(stmt, userid).Scan(&key1_raw, &key1_nonce)
+	// step 2: get the password
+	e, err := getEntry(ctx, db.sql, ownerID, name, false)
 	if err != nil {
-		if err == sql.ErrNoRows { // unlikely but handle it
-			err = fmt.Errorf("user with id %d not found", userid)
-		} else {
-			err = fmt.Errorf("querying user: %w", err)
-		}
 		return
 	}
 
-	// step 3: decrypt key1 with enc_key and key1_nonce
-	key1, err := utils.Decrypt(enc_key, key1_nonce, key1_raw) // decrypt key1
+	// step 3: get the data key
+	dataKey, err := dataKeyFor(ctx, db.sql, e, k, key2_decoded, vaultCode)
 	if err != nil {
-		err = fmt.Errorf("decrypting key1: %w", err)
 		return
 	}
 
-	// step 4: decrypt layer 2 with key1 and value_layer2_nonce
-	layer1, err := utils.Decrypt(key1, value_layer2_nonce, value) // decrypt the layer 2 to get layer 1
+	// step 4: layer 2 is always under the owner's key1
+	key1 := k.key1
+	if ownerID != userid {
+		owner, oerr := getUserKeys(ctx, db.sql, ownerID)
+		if oerr != nil {
+			err = oerr
+			return
+		}
+		key1 = owner.key1
+	}
+
+	// step 5: decrypt both layers
+	return e.open(key1, dataKey)
+}
+
+// UpdatePassword changes the value of the password called name in ownerID's vault. userid is who's asking (the
+// owner or someone it's shared with read-write) and key2 is theirs.
+func (db *DB) UpdatePassword(ctx context.Context, userid, ownerID int64, name, key2, vaultCode, value string) error {
+	key2_decoded, err := decodeKey2(key2)
 	if err != nil {
-		err = fmt.Errorf("decrypting layer 2: %w", err)
-		return
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
 	}
-	// step 5: decrypt layer 1 with key2 and value_layer1_nonce
-	pwd, err = utils.Decrypt(key2_decoded, value_layer1_nonce, layer1) // decrypt the layer 1 to get the password
+	defer tx.Rollback()
+
+	// step 1: get the user's keys and verify key2
+	k, err := getUserKeys(ctx, tx, userid)
 	if err != nil {
-		err = fmt.Errorf("decrypting layer 1: %w", err)
-		return
+		return err
 	}
-	return
+	if err := k.verify(key2_decoded); err != nil {
+		return err
+	}
+
+	// step 2: get the password and its data key (the data key doesn't change, so shares stay valid)
+	e, err := getEntry(ctx, tx, ownerID, name, true)
+	if err != nil {
+		return err
+	}
+	dataKey, err := dataKeyFor(ctx, tx, e, k, key2_decoded, vaultCode)
+	if err != nil {
+		return err
+	}
+	key1 := k.key1
+	if ownerID != userid {
+		owner, err := getUserKeys(ctx, tx, ownerID)
+		if err != nil {
+			return err
+		}
+		key1 = owner.key1
+	}
+
+	// step 3: encrypt the new value
+	if err := e.seal(key1, dataKey, []byte(value)); err != nil {
+		return err
+	}
+	if err := e.update(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
 }
 
-func (db *DB) ListPasswords(userID int64) ([]Password, error) {
-	stmt := `SELECT id, name, created_at FROM passwords WHERE user_id = $1;`
-	rows, err := db.sql.Query(stmt, userID)
+// DeletePassword deletes the password called name from the user's vault.
+func (db *DB) DeletePassword(ctx context.Context, userid int64, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM passwords WHERE user_id = $1 AND name = $2;`
+	res, err := db.sql.ExecContext(ctx, stmt, userid, name)
+	if err != nil {
+		return fmt.Errorf("deleting password: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting password: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: password with name %s for user id %d", ErrPasswordNotFound, name, userid)
+	}
+	return nil
+}
+
+// ListPasswords lists the passwords the user owns (in every vault) and the ones shared with them. with a vault
+// (DefaultVault for the default one) it only lists the user's own passwords in that vault.
+func (db *DB) ListPasswords(ctx context.Context, userID int64, vault string) ([]Password, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT p.id, p.user_id, p.name, p.created_at, u.name, COALESCE(s.permission, ''), COALESCE(v.name, '') FROM passwords p
+		JOIN users u ON u.id = p.user_id
+		LEFT JOIN password_shares s ON s.password_id = p.id AND s.recipient_id = $1
+		LEFT JOIN vaults v ON v.id = p.vault_id
+		WHERE p.user_id = $1 OR s.recipient_id = $1;`
+	args := []any{userID}
+	switch vault {
+	case "":
+	case DefaultVault:
+		stmt = strings.Replace(stmt, "WHERE p.user_id = $1 OR s.recipient_id = $1", "WHERE p.user_id = $1 AND p.vault_id IS NULL", 1)
+	default:
+		stmt = strings.Replace(stmt, "WHERE p.user_id = $1 OR s.recipient_id = $1", "WHERE p.user_id = $1 AND v.name = $2", 1)
+		args = append(args, vault)
+	}
+	rows, err := db.sql.QueryContext(ctx, stmt, args...)
 	if err != nil {
 		return nil, fmt.Errorf("querying passwords: %w", err)
 	}
@@ -354,13 +1053,22 @@ func (db *DB) ListPasswords(userID int64) ([]Password, error) {
 	return passwords, nil
 }
 
-// expects id, name, created_at
+// expects id, user_id, name, created_at, owner name, share permission (” if not shared), vault name (” for the
+// default vault)
 func scanPassword(rows *sql.Rows, userID int64) (Password, error) {
-	pwd := Password{
-		UserID: userID,
-	}
-	if err := rows.Scan(&pwd.ID, &pwd.Name, &pwd.CreatedAt); err != nil {
+	var pwd Password
+	var ownerName, vault string
+	if err := rows.Scan(&pwd.ID, &pwd.UserID, &pwd.Name, &pwd.CreatedAt, &ownerName, &pwd.Permission, &vault); err != nil {
 		return Password{}, fmt.Errorf("scanning password row: %w", err)
 	}
+	if pwd.UserID != userID {
+		pwd.OwnerName = ownerName
+		return pwd, nil
+	}
+	// the owner's vaults are none of a recipient's business
+	pwd.Vault = vault
+	if vault == "" {
+		pwd.Vault = DefaultVault
+	}
 	return pwd, nil
 }
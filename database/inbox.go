@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// inboxes let someone without an account (a vendor, a contractor) drop a secret into a user's vault. the inbox
+// link has a random token that leads to the user's public key, the sender seals the secret to it (see
+// utils/seal.go) and we keep the sealed box until the owner files it into a vault (which needs their private key,
+// so their code) or throws it away. the label is what the sender says it is and isn't encrypted.
+
+var (
+	ErrInboxNotFound     = errors.New("this inbox doesn't exist or was closed")
+	ErrInboxItemNotFound = errors.New("inbox item not found")
+	ErrInboxFull         = errors.New("this inbox is full, ask its owner to empty it")
+)
+
+// Inbox is what a sender needs to drop a secret.
+type Inbox struct {
+	OwnerName string `json:"owner_name"`
+	PublicKey []byte `json:"public_key"`
+}
+
+type InboxItem struct {
+	ID        int64  `json:"id"`
+	Label     string `json:"label"`
+	CreatedAt string `json:"created_at"`
+}
+
+// OpenInbox gives the user a new inbox token (the old link stops working). they need a key pair.
+func (db *DB) OpenInbox(ctx context.Context, userid int64) (string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	stmt := `UPDATE users SET inbox_token = $1 WHERE id = $2 AND public_key IS NOT NULL;`
+	res, err := db.sql.ExecContext(ctx, stmt, token, userid)
+	if err != nil {
+		return "", fmt.Errorf("updating inbox token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", ErrNoKeyPair
+	}
+	return token, nil
+}
+
+// CloseInbox stops the user's inbox link from working. items already in it stay.
+func (db *DB) CloseInbox(ctx context.Context, userid int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	if _, err := db.sql.ExecContext(ctx, `UPDATE users SET inbox_token = NULL WHERE id = $1;`, userid); err != nil {
+		return fmt.Errorf("closing inbox: %w", err)
+	}
+	return nil
+}
+
+// GetInbox gets the inbox with the token.
+func (db *DB) GetInbox(ctx context.Context, token string) (*Inbox, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	inbox := &Inbox{}
+	stmt := `SELECT name, public_key FROM users WHERE inbox_token = $1 AND public_key IS NOT NULL;`
+	if err := db.sql.QueryRowContext(ctx, stmt, token).Scan(&inbox.OwnerName, &inbox.PublicKey); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInboxNotFound
+		}
+		return nil, fmt.Errorf("querying inbox: %w", err)
+	}
+	return inbox, nil
+}
+
+// DropInboxItem puts a sealed secret in the inbox with the token, unless it already has maxPending items.
+func (db *DB) DropInboxItem(ctx context.Context, token, label string, sealed []byte, maxPending int) error {
+	if len(sealed) < 32+12 {
+		return utils.ErrSealedTooShort
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// step 1: find the inbox, locking the user so two drops can't both squeeze into the last spot
+	var userid int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE inbox_token = $1 FOR UPDATE;`, token).Scan(&userid); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInboxNotFound
+		}
+		return fmt.Errorf("querying inbox: %w", err)
+	}
+
+	// step 2: check it isn't full
+	var pending int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM inbox_items WHERE user_id = $1;`, userid).Scan(&pending); err != nil {
+		return fmt.Errorf("counting inbox items: %w", err)
+	}
+	if pending >= maxPending {
+		return ErrInboxFull
+	}
+
+	// step 3: insert it
+	stmt := `INSERT INTO inbox_items (user_id, label, sealed) VALUES ($1, $2, $3);`
+	if _, err := tx.ExecContext(ctx, stmt, userid, label, sealed); err != nil {
+		return fmt.Errorf("inserting inbox item: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// ListInbox lists the items waiting in the user's inbox, oldest first.
+func (db *DB) ListInbox(ctx context.Context, userid int64) ([]InboxItem, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, label, created_at FROM inbox_items WHERE user_id = $1 ORDER BY created_at, id;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying inbox items: %w", err)
+	}
+	defer rows.Close()
+	var items []InboxItem
+	for rows.Next() {
+		var item InboxItem
+		if err := rows.Scan(&item.ID, &item.Label, &item.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning inbox item row: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating inbox items: %w", err)
+	}
+	return items, nil
+}
+
+// FileInboxItem opens the item and saves it as the password called name in the user's vault (see SavePassword),
+// then deletes it from the inbox.
+func (db *DB) FileInboxItem(ctx context.Context, userid, itemID int64, key2, vault, vaultCode, name string) error {
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// step 1: verify key2 and open the private key
+	k, err := getUserKeys(ctx, tx, userid)
+	if err != nil {
+		return err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return err
+	}
+	priv, err := k.openPrivateKey(key2_decoded)
+	if err != nil {
+		return err
+	}
+
+	// step 2: get and open the item
+	var sealed []byte
+	stmt := `SELECT sealed FROM inbox_items WHERE id = $1 AND user_id = $2 FOR UPDATE;`
+	if err := tx.QueryRowContext(ctx, stmt, itemID, userid).Scan(&sealed); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %d", ErrInboxItemNotFound, itemID)
+		}
+		return fmt.Errorf("querying inbox item: %w", err)
+	}
+	value, err := utils.Unseal(priv, sealed)
+	if err != nil {
+		return fmt.Errorf("opening inbox item %d (it may not have been sealed to you): %w", itemID, err)
+	}
+
+	// step 3: save it and take it out of the inbox
+	if err := insertPassword(ctx, tx, k, key2_decoded, vault, vaultCode, name, value); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM inbox_items WHERE id = $1;`, itemID); err != nil {
+		return fmt.Errorf("deleting inbox item: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// DiscardInboxItem deletes an item from the user's inbox without opening it.
+func (db *DB) DiscardInboxItem(ctx context.Context, userid, itemID int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.sql.ExecContext(ctx, `DELETE FROM inbox_items WHERE id = $1 AND user_id = $2;`, itemID, userid)
+	if err != nil {
+		return fmt.Errorf("deleting inbox item: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %d", ErrInboxItemNotFound, itemID)
+	}
+	return nil
+}
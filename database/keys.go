@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// key pairs: every user has an x25519 key pair so other users can hand them data keys (see shares.go). the public
+// key is stored as is, the private key is encrypted with key2 so it needs the code like everything else.
+// users created before key pairs existed get one the next time we see their key2 (login, saving a password).
+//
+// data keys: every password has its own random data key. layer 1 is encrypted with the data key and the data key
+// is stored encrypted with the owner's key2 (and sealed to the public key of everyone it's shared with), so sharing
+// an entry never needs the owner's key2 to leave the server. passwords from before data keys have no data key and
+// layer 1 is encrypted with key2 itself, they get one the first time they're shared or the owner is rekeyed.
+
+// querier is what *sql.DB and *sql.Tx have in common.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// userKeys is the key material of a user, key1 is already decrypted.
+type userKeys struct {
+	id       int64
+	key1     []byte
+	verifier []byte
+	// nil if the user doesn't have a key pair yet. privateKey is encrypted with key2.
+	publicKey, privateKey, privateKeyNonce []byte
+}
+
+func getUserKeys(ctx context.Context, q querier, userid int64) (*userKeys, error) {
+	stmt := `SELECT key1, key1_nonce, key2_verifier, public_key, private_key, private_key_nonce FROM users WHERE id = $1;`
+	k := &userKeys{id: userid}
+	var key1_raw, key1_nonce []byte
+	err := q.QueryRowContext(ctx, stmt, userid).Scan(&key1_raw, &key1_nonce, &k.verifier, &k.publicKey, &k.privateKey, &k.privateKeyNonce)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user with id %d not found", userid)
+		}
+		return nil, fmt.Errorf("querying user: %w", err)
+	}
+	k.key1, err = utils.Decrypt(enc_key, key1_nonce, key1_raw)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting key1: %w", err)
+	}
+	return k, nil
+}
+
+// verify returns ErrInvalidCode if key2 isn't the user's key2.
+func (k *userKeys) verify(key2 []byte) error {
+	verifier, err := key2Verifier(key2)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(verifier, k.verifier) != 1 {
+		return ErrInvalidCode
+	}
+	return nil
+}
+
+// openPrivateKey decrypts the user's private key with key2 (which should be verified already).
+func (k *userKeys) openPrivateKey(key2 []byte) ([]byte, error) {
+	if k.privateKey == nil {
+		return nil, fmt.Errorf("user %d has no key pair", k.id)
+	}
+	priv, err := utils.Decrypt(key2, k.privateKeyNonce, k.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting private key: %w", err)
+	}
+	return priv, nil
+}
+
+// ensureKeyPair gives the user a key pair if they don't have one yet. key2 must be verified already.
+func ensureKeyPair(ctx context.Context, q querier, k *userKeys, key2 []byte) error {
+	if k.publicKey != nil {
+		return nil
+	}
+	pub, priv, nonce, err := newKeyPair(key2)
+	if err != nil {
+		return err
+	}
+	// another request may have beaten us to it, theirs wins
+	stmt := `UPDATE users SET public_key = $1, private_key = $2, private_key_nonce = $3 WHERE id = $4 AND public_key IS NULL;`
+	res, err := q.ExecContext(ctx, stmt, pub, priv, nonce, k.id)
+	if err != nil {
+		return fmt.Errorf("storing key pair: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		stmt = `SELECT public_key, private_key, private_key_nonce FROM users WHERE id = $1;`
+		if err := q.QueryRowContext(ctx, stmt, k.id).Scan(&k.publicKey, &k.privateKey, &k.privateKeyNonce); err != nil {
+			return fmt.Errorf("querying key pair: %w", err)
+		}
+		return nil
+	}
+	k.publicKey, k.privateKey, k.privateKeyNonce = pub, priv, nonce
+	return nil
+}
+
+// newKeyPair generates a key pair and encrypts the private key with key2.
+func newKeyPair(key2 []byte) (publicKey, privateKey, privateKeyNonce []byte, err error) {
+	publicKey, priv, err := utils.NewKeyPair()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("generating key pair: %w", err)
+	}
+	privateKeyNonce = make([]byte, 12)
+	rand.Read(privateKeyNonce)
+	privateKey, err = utils.Encrypt(key2, privateKeyNonce, priv)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("encrypting private key: %w", err)
+	}
+	return
+}
+
+// entry is a row of the passwords table.
+type entry struct {
+	id, ownerID                       int64
+	value, layer1_nonce, layer2_nonce []byte
+	dataKey, dataKeyNonce             []byte        // nil for passwords from before data keys
+	vaultID                           sql.NullInt64 // NULL for the default vault, see vaults.go
+}
+
+// getEntry gets the password called name in ownerID's vault, lock makes it SELECT ... FOR UPDATE (in a tx).
+func getEntry(ctx context.Context, q querier, ownerID int64, name string, lock bool) (*entry, error) {
+	stmt := `SELECT id, value, value_layer1_nonce, value_layer2_nonce, data_key, data_key_nonce, vault_id FROM passwords WHERE user_id = $1 AND name = $2`
+	if lock {
+		stmt += " FOR UPDATE"
+	}
+	e := &entry{ownerID: ownerID}
+	err := q.QueryRowContext(ctx, stmt, ownerID, name).Scan(&e.id, &e.value, &e.layer1_nonce, &e.layer2_nonce, &e.dataKey, &e.dataKeyNonce, &e.vaultID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: password with name %s for user id %d", ErrPasswordNotFound, name, ownerID)
+		}
+		return nil, fmt.Errorf("querying password: %w", err)
+	}
+	return e, nil
+}
+
+// ownerDataKey is the key layer 1 is encrypted with, using what the owner's copy is encrypted with (their verified
+// key2, or the vault key for passwords that aren't in the default vault, see entryWrapKey).
+func (e *entry) ownerDataKey(wrapKey []byte) ([]byte, error) {
+	if e.dataKey == nil {
+		return wrapKey, nil
+	}
+	dataKey, err := utils.Decrypt(wrapKey, e.dataKeyNonce, e.dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting data key of password %d: %w", e.id, err)
+	}
+	return dataKey, nil
+}
+
+// open decrypts both layers.
+func (e *entry) open(key1, dataKey []byte) ([]byte, error) {
+	layer1, err := utils.Decrypt(key1, e.layer2_nonce, e.value)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting layer 2 of password %d: %w", e.id, err)
+	}
+	secret, err := utils.Decrypt(dataKey, e.layer1_nonce, layer1)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting layer 1 of password %d: %w", e.id, err)
+	}
+	return secret, nil
+}
+
+// seal encrypts secret into both layers with fresh nonces.
+func (e *entry) seal(key1, dataKey, secret []byte) error {
+	nonces := make([]byte, 24) // 12 bytes for layer1 nonce, 12 bytes for layer2 nonce
+	rand.Read(nonces)
+	layer1, err := utils.Encrypt(dataKey, nonces[:12], secret)
+	if err != nil {
+		return fmt.Errorf("encrypting layer 1: %w", err)
+	}
+	layer2, err := utils.Encrypt(key1, nonces[12:], layer1)
+	if err != nil {
+		return fmt.Errorf("encrypting layer 2: %w", err)
+	}
+	e.value, e.layer1_nonce, e.layer2_nonce = layer2, nonces[:12], nonces[12:]
+	return nil
+}
+
+// wrapDataKey stores dataKey encrypted with the owner's key2 (or the vault key).
+func (e *entry) wrapDataKey(dataKey, wrapKey []byte) error {
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	wrapped, err := utils.Encrypt(wrapKey, nonce, dataKey)
+	if err != nil {
+		return fmt.Errorf("encrypting data key: %w", err)
+	}
+	e.dataKey, e.dataKeyNonce = wrapped, nonce
+	return nil
+}
+
+// giveDataKey makes sure the entry has its own data key (see the top of the file) and returns it. legacy
+// entries (always in the default vault, so wrapKey is key2) are re-encrypted with a new one.
+func (e *entry) giveDataKey(key1, wrapKey []byte) ([]byte, error) {
+	if e.dataKey != nil {
+		return e.ownerDataKey(wrapKey)
+	}
+	secret, err := e.open(key1, wrapKey)
+	if err != nil {
+		return nil, err
+	}
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	if err := e.seal(key1, dataKey, secret); err != nil {
+		return nil, err
+	}
+	if err := e.wrapDataKey(dataKey, wrapKey); err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// update writes the value, nonces and data key back.
+func (e *entry) update(ctx context.Context, q querier) error {
+	stmt := `UPDATE passwords SET value = $1, value_layer1_nonce = $2, value_layer2_nonce = $3, data_key = $4, data_key_nonce = $5 WHERE id = $6;`
+	if _, err := q.ExecContext(ctx, stmt, e.value, e.layer1_nonce, e.layer2_nonce, e.dataKey, e.dataKeyNonce, e.id); err != nil {
+		return fmt.Errorf("updating password %d: %w", e.id, err)
+	}
+	return nil
+}
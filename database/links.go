@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// one-time links ("burn after reading") are for sending a secret to someone without an account. the client
+// encrypts it (see utils/links.go) so all we ever have is ciphertext, with an expiry and how many more times it
+// can be opened. opening it the last time deletes it, expired ones are deleted whenever a new one is made.
+// opening takes a verifier of the key (see utils.LinkVerifier), a wrong one doesn't use up a view.
+
+var (
+	ErrLinkNotFound = errors.New("this link doesn't exist, has expired or was already opened")
+	ErrLinkKey      = errors.New("wrong passphrase, or the link is missing part of its key")
+)
+
+type SecretLink struct {
+	ID         string `json:"id"`
+	Ciphertext []byte `json:"ciphertext"`
+	Nonce      []byte `json:"nonce"`
+	Passphrase bool   `json:"passphrase"` // whether the key needs a passphrase too
+	ViewsLeft  int    `json:"views_left"`
+	ExpiresAt  string `json:"expires_at"`
+}
+
+// CreateLink stores an encrypted secret that can be opened views times within ttl (with verifier), it returns the
+// link's id.
+func (db *DB) CreateLink(ctx context.Context, userid int64, ciphertext, nonce, verifier []byte, passphrase bool, views int, ttl time.Duration) (*SecretLink, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// step 1: clean up expired links
+	if _, err := db.sql.ExecContext(ctx, `DELETE FROM secret_links WHERE expires_at <= CURRENT_TIMESTAMP;`); err != nil {
+		return nil, fmt.Errorf("deleting expired links: %w", err)
+	}
+
+	// step 2: insert the link with a random id (it's in the url, so it has to be unguessable)
+	id := make([]byte, 16)
+	rand.Read(id)
+	link := &SecretLink{
+		ID:         base64.RawURLEncoding.EncodeToString(id),
+		Passphrase: passphrase,
+		ViewsLeft:  views,
+	}
+	stmt := `INSERT INTO secret_links (id, user_id, ciphertext, nonce, verifier, passphrase, views_left, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP + $8 * INTERVAL '1 second') RETURNING expires_at;`
+	err := db.sql.QueryRowContext(ctx, stmt, link.ID, userid, ciphertext, nonce, verifier, passphrase, views, int64(ttl/time.Second)).Scan(&link.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("inserting link: %w", err)
+	}
+	return link, nil
+}
+
+// OpenLink checks verifier, then uses up one view of the link and returns it (the ciphertext, we can't decrypt it).
+// the last view deletes it.
+func (db *DB) OpenLink(ctx context.Context, id string, verifier []byte) (*SecretLink, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// step 1: check the verifier, the row stays locked until the view is counted
+	var stored []byte
+	stmt := `SELECT verifier FROM secret_links WHERE id = $1 AND views_left > 0 AND expires_at > CURRENT_TIMESTAMP FOR UPDATE;`
+	if err := tx.QueryRowContext(ctx, stmt, id).Scan(&stored); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLinkNotFound
+		}
+		return nil, fmt.Errorf("querying link: %w", err)
+	}
+	if stored != nil && subtle.ConstantTimeCompare(stored, verifier) != 1 {
+		return nil, ErrLinkKey
+	}
+
+	// step 2: use up a view
+	link := &SecretLink{ID: id}
+	stmt = `UPDATE secret_links SET views_left = views_left - 1
+		WHERE id = $1
+		RETURNING ciphertext, nonce, passphrase, views_left, expires_at;`
+	err = tx.QueryRowContext(ctx, stmt, id).Scan(&link.Ciphertext, &link.Nonce, &link.Passphrase, &link.ViewsLeft, &link.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrLinkNotFound
+		}
+		return nil, fmt.Errorf("opening link: %w", err)
+	}
+	if link.ViewsLeft == 0 {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM secret_links WHERE id = $1;`, id); err != nil {
+			return nil, fmt.Errorf("deleting link: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return link, nil
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+func TestOpenLinkWrongPassphrase(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := &DB{sql: sqldb}
+	ctx := context.Background()
+	verifierOf := func(linkKey []byte, passphrase string) []byte {
+		t.Helper()
+		key, err := utils.LinkCipherKey(linkKey, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := utils.LinkVerifier(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	linkKey, _ := utils.NewLinkKey()
+	stored := verifierOf(linkKey, "correct horse")
+	check := regexp.QuoteMeta("SELECT verifier FROM secret_links")
+	view := regexp.QuoteMeta("UPDATE secret_links SET views_left = views_left - 1")
+
+	// a max_views=1 link opened with a typo: no view is used up
+	mock.ExpectBegin()
+	mock.ExpectQuery(check).WithArgs("link").WillReturnRows(sqlmock.NewRows([]string{"verifier"}).AddRow(stored))
+	mock.ExpectRollback()
+	if _, err := db.OpenLink(ctx, "link", verifierOf(linkKey, "correct hrose")); !errors.Is(err, ErrLinkKey) {
+		t.Fatalf("wrong passphrase: got %v, want ErrLinkKey", err)
+	}
+	// or without the passphrase
+	mock.ExpectBegin()
+	mock.ExpectQuery(check).WithArgs("link").WillReturnRows(sqlmock.NewRows([]string{"verifier"}).AddRow(stored))
+	mock.ExpectRollback()
+	if _, err := db.OpenLink(ctx, "link", verifierOf(linkKey, "")); !errors.Is(err, ErrLinkKey) {
+		t.Fatalf("no passphrase: got %v, want ErrLinkKey", err)
+	}
+
+	// the right one still opens it, and the last view deletes it
+	mock.ExpectBegin()
+	mock.ExpectQuery(check).WithArgs("link").WillReturnRows(sqlmock.NewRows([]string{"verifier"}).AddRow(stored))
+	mock.ExpectQuery(view).WithArgs("link").WillReturnRows(sqlmock.NewRows([]string{"ciphertext", "nonce", "passphrase", "views_left", "expires_at"}).
+		AddRow([]byte("ciphertext"), []byte("nonce"), true, 0, "tomorrow"))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM secret_links")).WithArgs("link").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	link, err := db.OpenLink(ctx, "link", verifierOf(linkKey, "correct horse"))
+	if err != nil {
+		t.Fatalf("right passphrase: %v", err)
+	}
+	if string(link.Ciphertext) != "ciphertext" || link.ViewsLeft != 0 {
+		t.Fatalf("right passphrase: got %+v", link)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,772 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// organizations have members with roles (see authz.Role) and collections of passwords. every collection has its own
+// random data key for layer 1, sealed to the public key of every member like a shared password (see shares.go).
+// layer 2 is under the organization's key1, which works like a user's key1.
+//
+// adding a member seals the data key of every collection to them, so whoever adds them has to open the keys first
+// (with their key2). removing a member gives every collection a new data key, re-encrypts its passwords and seals
+// the new key to everyone left, so the old data key is useless from then on.
+
+var (
+	ErrOrgNotFound        = errors.New("organization not found")
+	ErrOrgExists          = errors.New("an organization with that name already exists")
+	ErrMemberNotFound     = errors.New("member not found")
+	ErrAlreadyMember      = errors.New("user is already a member")
+	ErrLastOwner          = errors.New("an organization needs at least one owner")
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrCollectionExists   = errors.New("a collection with that name already exists")
+)
+
+type Organization struct {
+	ID               int64      `json:"id"`
+	Name             string     `json:"name"`
+	Role             authz.Role `json:"role"`               // of the user who asked
+	RequireTwoFactor bool       `json:"require_two_factor"` // members need a session that passed two-factor
+	CreatedAt        string     `json:"created_at"`
+}
+
+type OrgMember struct {
+	UserID    int64      `json:"user_id"`
+	Name      string     `json:"name"`
+	Role      authz.Role `json:"role"`
+	CreatedAt string     `json:"created_at"`
+}
+
+type Collection struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// CreateOrganization creates an organization with the user as its owner.
+func (db *DB) CreateOrganization(ctx context.Context, userID int64, name string) (*Organization, error) {
+	randoms := make([]byte, 16+12) // 16 for key1, 12 for key1_nonce
+	rand.Read(randoms)
+	key1, err := utils.Encrypt(enc_key, randoms[16:], randoms[:16])
+	if err != nil {
+		return nil, fmt.Errorf("encrypting key1: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	org := &Organization{Name: name, Role: authz.RoleOwner}
+	stmt := `INSERT INTO organizations (name, key1, key1_nonce) VALUES ($1, $2, $3) RETURNING id, created_at;`
+	if err := tx.QueryRowContext(ctx, stmt, name, key1, randoms[16:]).Scan(&org.ID, &org.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: %s", ErrOrgExists, name)
+		}
+		return nil, fmt.Errorf("inserting organization: %w", err)
+	}
+	stmt = `INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3);`
+	if _, err := tx.ExecContext(ctx, stmt, org.ID, userID, string(authz.RoleOwner)); err != nil {
+		return nil, fmt.Errorf("inserting owner: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return org, nil
+}
+
+// GetOrganization gets the organization called name with the user's role in it. organizations the user isn't a
+// member of are ErrOrgNotFound.
+func (db *DB) GetOrganization(ctx context.Context, userID int64, name string) (*Organization, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT o.id, o.name, m.role, o.require_two_factor, o.created_at FROM organizations o JOIN org_members m ON m.org_id = o.id
+		WHERE o.name = $1 AND m.user_id = $2;`
+	var org Organization
+	err := db.sql.QueryRowContext(ctx, stmt, name, userID).Scan(&org.ID, &org.Name, &org.Role, &org.RequireTwoFactor, &org.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrOrgNotFound, name)
+		}
+		return nil, fmt.Errorf("querying organization: %w", err)
+	}
+	return &org, nil
+}
+
+// ListOrganizations lists the organizations the user is a member of.
+func (db *DB) ListOrganizations(ctx context.Context, userID int64) ([]Organization, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT o.id, o.name, m.role, o.require_two_factor, o.created_at FROM organizations o JOIN org_members m ON m.org_id = o.id
+		WHERE m.user_id = $1 ORDER BY o.name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userID)
+	if err != nil {
+		return nil, fmt.Errorf("querying organizations: %w", err)
+	}
+	defer rows.Close()
+	var orgs []Organization
+	for rows.Next() {
+		var org Organization
+		if err := rows.Scan(&org.ID, &org.Name, &org.Role, &org.RequireTwoFactor, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning organization row: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating organizations: %w", err)
+	}
+	return orgs, nil
+}
+
+// OrgMembers lists the members of the organization.
+func (db *DB) OrgMembers(ctx context.Context, orgID int64) ([]OrgMember, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT u.id, u.name, m.role, m.created_at FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 ORDER BY u.name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("querying members: %w", err)
+	}
+	defer rows.Close()
+	var members []OrgMember
+	for rows.Next() {
+		var m OrgMember
+		if err := rows.Scan(&m.UserID, &m.Name, &m.Role, &m.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning member row: %w", err)
+		}
+		members = append(members, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating members: %w", err)
+	}
+	return members, nil
+}
+
+// GetOrgMember gets the member of the organization with the user name.
+func (db *DB) GetOrgMember(ctx context.Context, orgID int64, name string) (*OrgMember, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT u.id, u.name, m.role, m.created_at FROM org_members m JOIN users u ON u.id = m.user_id
+		WHERE m.org_id = $1 AND u.name = $2;`
+	var m OrgMember
+	if err := db.sql.QueryRowContext(ctx, stmt, orgID, name).Scan(&m.UserID, &m.Name, &m.Role, &m.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: %s", ErrMemberNotFound, name)
+		}
+		return nil, fmt.Errorf("querying member: %w", err)
+	}
+	return &m, nil
+}
+
+// AddOrgMember adds the user called memberName to the organization with role. actorID is who's adding them and
+// key2 is theirs, it opens the collection keys so they can be sealed to the new member.
+func (db *DB) AddOrgMember(ctx context.Context, orgID, actorID int64, key2, memberName string, role authz.Role) error {
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := lockOrg(ctx, tx, orgID); err != nil {
+		return err
+	}
+
+	// step 1: verify the actor's key2
+	actor, err := getUserKeys(ctx, tx, actorID)
+	if err != nil {
+		return err
+	}
+	if err := actor.verify(key2_decoded); err != nil {
+		return err
+	}
+
+	// step 2: get the new member's public key
+	stmt := `SELECT id, public_key FROM users WHERE name = $1;`
+	var memberID int64
+	var publicKey []byte
+	if err := tx.QueryRowContext(ctx, stmt, memberName).Scan(&memberID, &publicKey); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, memberName)
+		}
+		return fmt.Errorf("querying user: %w", err)
+	}
+	if publicKey == nil {
+		return fmt.Errorf("%w: %s", ErrNoKeyPair, memberName)
+	}
+
+	// step 3: add them
+	stmt = `INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT (org_id, user_id) DO NOTHING;`
+	res, err := tx.ExecContext(ctx, stmt, orgID, memberID, string(role))
+	if err != nil {
+		return fmt.Errorf("inserting member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: %s", ErrAlreadyMember, memberName)
+	}
+
+	// step 4: seal every collection key to them
+	keys, err := collectionKeys(ctx, tx, orgID, actor, key2_decoded)
+	if err != nil {
+		return err
+	}
+	for collectionID, key := range keys {
+		if err := sealCollectionKey(ctx, tx, collectionID, memberID, publicKey, key); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// SetOrgTwoFactor sets whether the organization requires its members to use two-factor.
+func (db *DB) SetOrgTwoFactor(ctx context.Context, orgID int64, required bool) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE organizations SET require_two_factor = $1 WHERE id = $2;`
+	if _, err := db.sql.ExecContext(ctx, stmt, required, orgID); err != nil {
+		return fmt.Errorf("updating organization: %w", err)
+	}
+	return nil
+}
+
+// SetOrgRole changes the role of a member. the last owner can't stop being one.
+func (db *DB) SetOrgRole(ctx context.Context, orgID, memberID int64, role authz.Role) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := lockOrg(ctx, tx, orgID); err != nil {
+		return err
+	}
+
+	if role != authz.RoleOwner {
+		if err := ensureOwnerLeft(ctx, tx, orgID, memberID); err != nil {
+			return err
+		}
+	}
+	stmt := `UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3;`
+	res, err := tx.ExecContext(ctx, stmt, string(role), orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("updating member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// RemoveOrgMember removes a member from the organization and re-keys every collection (see the top of the file).
+// actorID is who's removing them and key2 is theirs, it opens the old collection keys.
+func (db *DB) RemoveOrgMember(ctx context.Context, orgID, actorID int64, key2 string, memberID int64) error {
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := lockOrg(ctx, tx, orgID); err != nil {
+		return err
+	}
+
+	// step 1: verify the actor's key2 and open the current collection keys (before the actor's own could be gone)
+	actor, err := getUserKeys(ctx, tx, actorID)
+	if err != nil {
+		return err
+	}
+	if err := actor.verify(key2_decoded); err != nil {
+		return err
+	}
+	oldKeys, err := collectionKeys(ctx, tx, orgID, actor, key2_decoded)
+	if err != nil {
+		return err
+	}
+
+	// step 2: remove them
+	if err := ensureOwnerLeft(ctx, tx, orgID, memberID); err != nil {
+		return err
+	}
+	stmt := `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2;`
+	res, err := tx.ExecContext(ctx, stmt, orgID, memberID)
+	if err != nil {
+		return fmt.Errorf("deleting member: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+
+	// step 3: re-key every collection for whoever is left
+	key1, err := orgKey1(ctx, tx, orgID)
+	if err != nil {
+		return err
+	}
+	stmt = `SELECT u.id, u.public_key FROM org_members m JOIN users u ON u.id = m.user_id WHERE m.org_id = $1;`
+	rows, err := tx.QueryContext(ctx, stmt, orgID)
+	if err != nil {
+		return fmt.Errorf("querying members: %w", err)
+	}
+	publicKeys := map[int64][]byte{}
+	for rows.Next() {
+		var id int64
+		var pub []byte
+		if err := rows.Scan(&id, &pub); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning member row: %w", err)
+		}
+		publicKeys[id] = pub
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating members: %w", err)
+	}
+
+	for collectionID, oldKey := range oldKeys {
+		if err := rekeyCollection(ctx, tx, collectionID, key1, oldKey, publicKeys); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// rekeyCollection re-encrypts every password in the collection from oldKey to a new data key and seals the new
+// key to publicKeys (every member, by user id). keys sealed to anyone else are deleted.
+func rekeyCollection(ctx context.Context, tx *sql.Tx, collectionID int64, key1, oldKey []byte, publicKeys map[int64][]byte) error {
+	newKey := make([]byte, 32)
+	rand.Read(newKey)
+
+	stmt := `SELECT id, value, value_layer1_nonce, value_layer2_nonce FROM collection_passwords WHERE collection_id = $1 FOR UPDATE;`
+	rows, err := tx.QueryContext(ctx, stmt, collectionID)
+	if err != nil {
+		return fmt.Errorf("querying collection passwords: %w", err)
+	}
+	var entries []*entry
+	for rows.Next() {
+		e := &entry{}
+		if err := rows.Scan(&e.id, &e.value, &e.layer1_nonce, &e.layer2_nonce); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning collection password row: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating collection passwords: %w", err)
+	}
+
+	for _, e := range entries {
+		secret, err := e.open(key1, oldKey)
+		if err != nil {
+			return err
+		}
+		if err := e.seal(key1, newKey, secret); err != nil {
+			return err
+		}
+		if err := e.updateCollection(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	stmt = `DELETE FROM collection_keys WHERE collection_id = $1;`
+	if _, err := tx.ExecContext(ctx, stmt, collectionID); err != nil {
+		return fmt.Errorf("deleting collection keys: %w", err)
+	}
+	for userID, pub := range publicKeys {
+		if err := sealCollectionKey(ctx, tx, collectionID, userID, pub, newKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateCollection creates an empty collection in the organization with a new data key sealed to every member.
+func (db *DB) CreateCollection(ctx context.Context, orgID int64, name string) (*Collection, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := lockOrg(ctx, tx, orgID); err != nil {
+		return nil, err
+	}
+
+	c := &Collection{Name: name}
+	stmt := `INSERT INTO collections (org_id, name) VALUES ($1, $2) RETURNING id, created_at;`
+	if err := tx.QueryRowContext(ctx, stmt, orgID, name).Scan(&c.ID, &c.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, fmt.Errorf("%w: %s", ErrCollectionExists, name)
+		}
+		return nil, fmt.Errorf("inserting collection: %w", err)
+	}
+
+	key := make([]byte, 32)
+	rand.Read(key)
+	stmt = `SELECT u.id, u.name, u.public_key FROM org_members m JOIN users u ON u.id = m.user_id WHERE m.org_id = $1;`
+	rows, err := tx.QueryContext(ctx, stmt, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("querying members: %w", err)
+	}
+	type member struct {
+		id  int64
+		pub []byte
+	}
+	var members []member
+	for rows.Next() {
+		var m member
+		var name string
+		if err := rows.Scan(&m.id, &name, &m.pub); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning member row: %w", err)
+		}
+		if m.pub == nil {
+			rows.Close()
+			return nil, fmt.Errorf("%w: %s", ErrNoKeyPair, name)
+		}
+		members = append(members, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating members: %w", err)
+	}
+	for _, m := range members {
+		if err := sealCollectionKey(ctx, tx, c.ID, m.id, m.pub, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return c, nil
+}
+
+// ListCollections lists the collections of the organization.
+func (db *DB) ListCollections(ctx context.Context, orgID int64) ([]Collection, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, name, created_at FROM collections WHERE org_id = $1 ORDER BY name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("querying collections: %w", err)
+	}
+	defer rows.Close()
+	var collections []Collection
+	for rows.Next() {
+		var c Collection
+		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning collection row: %w", err)
+		}
+		collections = append(collections, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating collections: %w", err)
+	}
+	return collections, nil
+}
+
+// SaveCollectionPassword saves a password to a collection. userid is who's saving it and key2 is theirs.
+func (db *DB) SaveCollectionPassword(ctx context.Context, orgID int64, collection string, userid int64, name, key2, value string) error {
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// step 1: verify key2 and open the collection key
+	k, err := getUserKeys(ctx, db.sql, userid)
+	if err != nil {
+		return err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return err
+	}
+	collectionID, err := getCollectionID(ctx, db.sql, orgID, collection)
+	if err != nil {
+		return err
+	}
+	dataKey, err := collectionKey(ctx, db.sql, collectionID, k, key2_decoded)
+	if err != nil {
+		return err
+	}
+	key1, err := orgKey1(ctx, db.sql, orgID)
+	if err != nil {
+		return err
+	}
+
+	// step 2: encrypt and insert
+	e := &entry{}
+	if err := e.seal(key1, dataKey, []byte(value)); err != nil {
+		return err
+	}
+	stmt := `INSERT INTO collection_passwords (collection_id, name, value, value_layer1_nonce, value_layer2_nonce) VALUES ($1, $2, $3, $4, $5);`
+	if _, err := db.sql.ExecContext(ctx, stmt, collectionID, name, e.value, e.layer1_nonce, e.layer2_nonce); err != nil {
+		return fmt.Errorf("inserting password: %w", err)
+	}
+	return nil
+}
+
+// RetrieveCollectionPassword decrypts a password in a collection. userid is who's asking and key2 is theirs.
+func (db *DB) RetrieveCollectionPassword(ctx context.Context, orgID int64, collection string, userid int64, name, key2 string) ([]byte, error) {
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// step 1: verify key2
+	k, err := getUserKeys(ctx, db.sql, userid)
+	if err != nil {
+		return nil, err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return nil, err
+	}
+
+	// step 2: get the password
+	collectionID, err := getCollectionID(ctx, db.sql, orgID, collection)
+	if err != nil {
+		return nil, err
+	}
+	stmt := `SELECT id, value, value_layer1_nonce, value_layer2_nonce FROM collection_passwords WHERE collection_id = $1 AND name = $2;`
+	e := &entry{}
+	if err := db.sql.QueryRowContext(ctx, stmt, collectionID, name).Scan(&e.id, &e.value, &e.layer1_nonce, &e.layer2_nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: password with name %s in collection %s", ErrPasswordNotFound, name, collection)
+		}
+		return nil, fmt.Errorf("querying password: %w", err)
+	}
+
+	// step 3: open the collection key and decrypt
+	dataKey, err := collectionKey(ctx, db.sql, collectionID, k, key2_decoded)
+	if err != nil {
+		return nil, err
+	}
+	key1, err := orgKey1(ctx, db.sql, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(key1, dataKey)
+}
+
+// DeleteCollectionPassword deletes the password called name from a collection.
+func (db *DB) DeleteCollectionPassword(ctx context.Context, orgID int64, collection, name string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM collection_passwords p USING collections c
+		WHERE p.collection_id = c.id AND c.org_id = $1 AND c.name = $2 AND p.name = $3;`
+	res, err := db.sql.ExecContext(ctx, stmt, orgID, collection, name)
+	if err != nil {
+		return fmt.Errorf("deleting password: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting password: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: password with name %s in collection %s", ErrPasswordNotFound, name, collection)
+	}
+	return nil
+}
+
+// ListCollectionPasswords lists the passwords in a collection (UserID isn't set, they belong to the organization).
+func (db *DB) ListCollectionPasswords(ctx context.Context, orgID int64, collection string) ([]Password, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	collectionID, err := getCollectionID(ctx, db.sql, orgID, collection)
+	if err != nil {
+		return nil, err
+	}
+	stmt := `SELECT id, name, created_at FROM collection_passwords WHERE collection_id = $1 ORDER BY name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("querying passwords: %w", err)
+	}
+	defer rows.Close()
+	var passwords []Password
+	for rows.Next() {
+		var pwd Password
+		if err := rows.Scan(&pwd.ID, &pwd.Name, &pwd.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning password row: %w", err)
+		}
+		passwords = append(passwords, pwd)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating passwords: %w", err)
+	}
+	return passwords, nil
+}
+
+// lockOrg serializes changes to an organization's members and collections (in a tx).
+func lockOrg(ctx context.Context, tx *sql.Tx, orgID int64) error {
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM organizations WHERE id = $1 FOR UPDATE;`, orgID).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrOrgNotFound
+		}
+		return fmt.Errorf("locking organization: %w", err)
+	}
+	return nil
+}
+
+// ensureOwnerLeft returns ErrLastOwner if memberID is the only owner of the organization.
+func ensureOwnerLeft(ctx context.Context, tx *sql.Tx, orgID, memberID int64) error {
+	stmt := `SELECT count(*) FILTER (WHERE role = 'owner'), bool_or(user_id = $2 AND role = 'owner') FROM org_members WHERE org_id = $1;`
+	var owners int
+	var isOwner sql.NullBool
+	if err := tx.QueryRowContext(ctx, stmt, orgID, memberID).Scan(&owners, &isOwner); err != nil {
+		return fmt.Errorf("counting owners: %w", err)
+	}
+	if isOwner.Bool && owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// orgKey1 gets the organization's key1, decrypted.
+func orgKey1(ctx context.Context, q querier, orgID int64) ([]byte, error) {
+	var key1_raw, key1_nonce []byte
+	if err := q.QueryRowContext(ctx, `SELECT key1, key1_nonce FROM organizations WHERE id = $1;`, orgID).Scan(&key1_raw, &key1_nonce); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrOrgNotFound
+		}
+		return nil, fmt.Errorf("querying organization: %w", err)
+	}
+	key1, err := utils.Decrypt(enc_key, key1_nonce, key1_raw)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting key1: %w", err)
+	}
+	return key1, nil
+}
+
+func getCollectionID(ctx context.Context, q querier, orgID int64, name string) (int64, error) {
+	var id int64
+	if err := q.QueryRowContext(ctx, `SELECT id FROM collections WHERE org_id = $1 AND name = $2;`, orgID, name).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+		}
+		return 0, fmt.Errorf("querying collection: %w", err)
+	}
+	return id, nil
+}
+
+// collectionKey opens the data key of the collection sealed to the user (whose key2 must be verified already).
+func collectionKey(ctx context.Context, q querier, collectionID int64, k *userKeys, key2 []byte) ([]byte, error) {
+	var sealed []byte
+	stmt := `SELECT data_key FROM collection_keys WHERE collection_id = $1 AND user_id = $2;`
+	if err := q.QueryRowContext(ctx, stmt, collectionID, k.id).Scan(&sealed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("collection %d has no key for user id %d", collectionID, k.id)
+		}
+		return nil, fmt.Errorf("querying collection key: %w", err)
+	}
+	priv, err := k.openPrivateKey(key2)
+	if err != nil {
+		return nil, err
+	}
+	key, err := utils.Unseal(priv, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("opening collection key: %w", err)
+	}
+	return key, nil
+}
+
+// collectionKeys opens the data key of every collection in the organization, by collection id.
+func collectionKeys(ctx context.Context, tx *sql.Tx, orgID int64, k *userKeys, key2 []byte) (map[int64][]byte, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM collections WHERE org_id = $1;`, orgID)
+	if err != nil {
+		return nil, fmt.Errorf("querying collections: %w", err)
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scanning collection row: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating collections: %w", err)
+	}
+
+	keys := make(map[int64][]byte, len(ids))
+	for _, id := range ids {
+		key, err := collectionKey(ctx, tx, id, k, key2)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+func sealCollectionKey(ctx context.Context, q querier, collectionID, userID int64, publicKey, key []byte) error {
+	if publicKey == nil {
+		return fmt.Errorf("%w: user id %d", ErrNoKeyPair, userID)
+	}
+	sealed, err := utils.Seal(publicKey, key)
+	if err != nil {
+		return fmt.Errorf("sealing collection key: %w", err)
+	}
+	stmt := `INSERT INTO collection_keys (collection_id, user_id, data_key) VALUES ($1, $2, $3)
+		ON CONFLICT (collection_id, user_id) DO UPDATE SET data_key = EXCLUDED.data_key;`
+	if _, err := q.ExecContext(ctx, stmt, collectionID, userID, sealed); err != nil {
+		return fmt.Errorf("inserting collection key: %w", err)
+	}
+	return nil
+}
+
+// updateCollection writes the value and nonces of a collection password back.
+func (e *entry) updateCollection(ctx context.Context, q querier) error {
+	stmt := `UPDATE collection_passwords SET value = $1, value_layer1_nonce = $2, value_layer2_nonce = $3 WHERE id = $4;`
+	if _, err := q.ExecContext(ctx, stmt, e.value, e.layer1_nonce, e.layer2_nonce, e.id); err != nil {
+		return fmt.Errorf("updating collection password %d: %w", e.id, err)
+	}
+	return nil
+}
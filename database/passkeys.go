@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// passkeys: webauthn credentials a user registered from the web client (the ceremonies are in server/passkeys.go,
+// we only keep the credential records). a passkey can be used as the second factor of a login, or instead of the
+// master password. logging in without the master password doesn't give us key2, so a passkey can only do that if
+// it can hand the client back its session code: when it's registered the client encrypts the session code with a
+// key from the authenticator (the webauthn prf extension) and we keep that blob. we can't open it, the client
+// needs the authenticator again, and the user still needs their code on top of it like after any login.
+// rekeying the user changes the session code, so it drops every blob (those passkeys go back to being a second
+// factor until they're registered again).
+
+var (
+	ErrPasskeyNotFound      = errors.New("passkey not found")
+	ErrPasskeyExists        = errors.New("that passkey is already registered")
+	ErrPasskeyCloned        = errors.New("passkey signature counter went backwards, it may have been cloned")
+	ErrPasskeyNoSessionCode = errors.New("this passkey can't unlock your passwords, log in with your master password")
+)
+
+type Passkey struct {
+	ID           int64  `json:"id"`
+	Name         string `json:"name"`
+	Passwordless bool   `json:"passwordless"` // it can log in without the master password
+	LastUsedAt   string `json:"last_used_at,omitempty"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// PasskeyCredential is a stored credential record, SignCount is the latest one (the one in Credential is from
+// when it was registered).
+type PasskeyCredential struct {
+	Credential []byte
+	SignCount  uint32
+}
+
+// AddPasskey stores a credential the user just registered. sessionCode is the encrypted session code (nil if the
+// authenticator can't do prf).
+func (db *DB) AddPasskey(ctx context.Context, userid int64, credentialID []byte, name string, credential []byte, signCount uint32, sessionCode []byte) (*Passkey, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	p := &Passkey{Name: name, Passwordless: sessionCode != nil}
+	stmt := `INSERT INTO passkeys (user_id, credential_id, name, credential, sign_count, session_code) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at;`
+	if err := db.sql.QueryRowContext(ctx, stmt, userid, credentialID, name, credential, int64(signCount), sessionCode).Scan(&p.ID, &p.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrPasskeyExists
+		}
+		return nil, fmt.Errorf("inserting passkey: %w", err)
+	}
+	return p, nil
+}
+
+// PasskeyCredentials gets the credential records of the user's passkeys.
+func (db *DB) PasskeyCredentials(ctx context.Context, userid int64) ([]PasskeyCredential, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	rows, err := db.sql.QueryContext(ctx, `SELECT credential, sign_count FROM passkeys WHERE user_id = $1;`, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying passkeys: %w", err)
+	}
+	defer rows.Close()
+	var creds []PasskeyCredential
+	for rows.Next() {
+		var c PasskeyCredential
+		var signCount int64
+		if err := rows.Scan(&c.Credential, &signCount); err != nil {
+			return nil, fmt.Errorf("scanning passkey row: %w", err)
+		}
+		c.SignCount = uint32(signCount)
+		creds = append(creds, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating passkeys: %w", err)
+	}
+	return creds, nil
+}
+
+// ListPasskeys lists the user's passkeys.
+func (db *DB) ListPasskeys(ctx context.Context, userid int64) ([]Passkey, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, name, session_code IS NOT NULL, last_used_at, created_at FROM passkeys WHERE user_id = $1 ORDER BY created_at;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying passkeys: %w", err)
+	}
+	defer rows.Close()
+	var passkeys []Passkey
+	for rows.Next() {
+		var p Passkey
+		var lastUsed sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.Passwordless, &lastUsed, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning passkey row: %w", err)
+		}
+		p.LastUsedAt = lastUsed.String
+		passkeys = append(passkeys, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating passkeys: %w", err)
+	}
+	return passkeys, nil
+}
+
+// DeletePasskey removes one of the user's passkeys.
+func (db *DB) DeletePasskey(ctx context.Context, userid, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.sql.ExecContext(ctx, `DELETE FROM passkeys WHERE id = $1 AND user_id = $2;`, id, userid)
+	if err != nil {
+		return fmt.Errorf("deleting passkey: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: id %d", ErrPasskeyNotFound, id)
+	}
+	return nil
+}
+
+// UsePasskey records a verified assertion with signCount and returns the passkey's encrypted session code (nil if
+// it doesn't have one). the counter has to go up unless the authenticator doesn't keep one (always 0), otherwise
+// it's ErrPasskeyCloned. checking it in the update means two logins with the same assertion can't both pass.
+func (db *DB) UsePasskey(ctx context.Context, userid int64, credentialID []byte, signCount uint32) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `UPDATE passkeys SET sign_count = $3, last_used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND credential_id = $2 AND (sign_count < $3 OR (sign_count = 0 AND $3 = 0))
+		RETURNING session_code;`
+	var sessionCode []byte
+	err := db.sql.QueryRowContext(ctx, stmt, userid, credentialID, int64(signCount)).Scan(&sessionCode)
+	if err == nil {
+		return sessionCode, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("updating passkey: %w", err)
+	}
+
+	var exists bool
+	if err := db.sql.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM passkeys WHERE user_id = $1 AND credential_id = $2);`, userid, credentialID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("querying passkey: %w", err)
+	}
+	if !exists {
+		return nil, ErrPasskeyNotFound
+	}
+	return nil, ErrPasskeyCloned
+}
+
+func clearPasskeySessionCodes(ctx context.Context, q querier, userid int64) error {
+	if _, err := q.ExecContext(ctx, `UPDATE passkeys SET session_code = NULL WHERE user_id = $1;`, userid); err != nil {
+		return fmt.Errorf("clearing passkey session codes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUsePasskeySignCount(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := &DB{sql: sqldb}
+	ctx := context.Background()
+	id := []byte("credential")
+	update := regexp.QuoteMeta("UPDATE passkeys SET sign_count = $3")
+	exists := regexp.QuoteMeta("SELECT EXISTS")
+
+	// the counter went up
+	mock.ExpectQuery(update).WithArgs(int64(7), id, int64(11)).WillReturnRows(sqlmock.NewRows([]string{"session_code"}).AddRow([]byte("code")))
+	if code, err := db.UsePasskey(ctx, 7, id, 11); err != nil || string(code) != "code" {
+		t.Fatalf("counter up: %q, %v", code, err)
+	}
+
+	// it didn't (the update only matches a lower stored counter), but the passkey is there
+	mock.ExpectQuery(update).WithArgs(int64(7), id, int64(5)).WillReturnRows(sqlmock.NewRows([]string{"session_code"}))
+	mock.ExpectQuery(exists).WithArgs(int64(7), id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	if _, err := db.UsePasskey(ctx, 7, id, 5); !errors.Is(err, ErrPasskeyCloned) {
+		t.Fatalf("counter back: got %v, want ErrPasskeyCloned", err)
+	}
+
+	mock.ExpectQuery(update).WithArgs(int64(7), id, int64(5)).WillReturnRows(sqlmock.NewRows([]string{"session_code"}))
+	mock.ExpectQuery(exists).WithArgs(int64(7), id).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	if _, err := db.UsePasskey(ctx, 7, id, 5); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Fatalf("deleted passkey: got %v, want ErrPasskeyNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
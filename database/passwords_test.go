@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestListPasswordsAcrossVaults(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := &DB{sql: sqldb}
+
+	rows := sqlmock.NewRows([]string{"id", "user_id", "name", "created_at", "owner", "permission", "vault"}).
+		AddRow(1, 7, "github", "2025-01-01T00:00:00Z", "alice", "", "").
+		AddRow(2, 7, "aws", "2025-01-02T00:00:00Z", "alice", "", "work").
+		AddRow(3, 8, "netflix", "2025-01-03T00:00:00Z", "bob", "read", "family")
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT p.id, p.user_id, p.name")).WithArgs(int64(7)).WillReturnRows(rows)
+
+	pwds, err := db.ListPasswords(context.Background(), 7, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Password{
+		{ID: 1, UserID: 7, Name: "github", CreatedAt: "2025-01-01T00:00:00Z", Vault: DefaultVault},
+		{ID: 2, UserID: 7, Name: "aws", CreatedAt: "2025-01-02T00:00:00Z", Vault: "work"},
+		// shared with user 7, bob's vault isn't theirs to see
+		{ID: 3, UserID: 8, Name: "netflix", CreatedAt: "2025-01-03T00:00:00Z", OwnerName: "bob", Permission: "read"},
+	}
+	if len(pwds) != len(want) {
+		t.Fatalf("got %d passwords, want %d", len(pwds), len(want))
+	}
+	for i := range want {
+		if pwds[i] != want[i] {
+			t.Errorf("password %d: got %+v, want %+v", i, pwds[i], want[i])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
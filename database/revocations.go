@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// signed sessions (see sessions/signed.go) aren't stored anywhere, so revoking one means remembering that it was
+// revoked until it would have expired anyway. this is where every server finds that list. the keys and what they
+// mean belong to the sessions package, a row is just kept until expires_at.
+
+type RevokedSession struct {
+	Key       string
+	Before    time.Time // zero if the key doesn't need one
+	Except    string
+	ExpiresAt time.Time
+}
+
+// SaveRevokedSession adds r to the list, replacing what was there for its key.
+func (db *DB) SaveRevokedSession(ctx context.Context, r RevokedSession) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var before sql.NullTime
+	if !r.Before.IsZero() {
+		before = sql.NullTime{Time: r.Before.UTC(), Valid: true}
+	}
+	stmt := `INSERT INTO revoked_sessions (key, revoked_before, except_session, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO UPDATE SET revoked_before = EXCLUDED.revoked_before, except_session = EXCLUDED.except_session,
+		expires_at = GREATEST(revoked_sessions.expires_at, EXCLUDED.expires_at);`
+	if _, err := db.sql.ExecContext(ctx, stmt, r.Key, before, r.Except, r.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("saving revoked session: %w", err)
+	}
+	return nil
+}
+
+// RevokedSessions deletes the rows that have expired and returns the rest.
+func (db *DB) RevokedSessions(ctx context.Context) ([]RevokedSession, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if _, err := db.sql.ExecContext(ctx, `DELETE FROM revoked_sessions WHERE expires_at <= $1;`, now); err != nil {
+		return nil, fmt.Errorf("deleting expired revoked sessions: %w", err)
+	}
+	rows, err := db.sql.QueryContext(ctx, `SELECT key, revoked_before, except_session, expires_at FROM revoked_sessions;`)
+	if err != nil {
+		return nil, fmt.Errorf("querying revoked sessions: %w", err)
+	}
+	defer rows.Close()
+	var list []RevokedSession
+	for rows.Next() {
+		var r RevokedSession
+		var before sql.NullTime
+		if err := rows.Scan(&r.Key, &before, &r.Except, &r.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scanning revoked session row: %w", err)
+		}
+		r.Before = before.Time
+		list = append(list, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating revoked sessions: %w", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// sharing: the owner seals the entry's data key (see keys.go) to the recipient's public key and stores it in
+// password_shares with a permission. the recipient opens it with their private key, which needs their own key2,
+// so they use their own code for shared entries. layer 2 stays under the owner's key1.
+// revoking deletes the row. the data key only ever leaves the database inside the server, so there's nothing
+// for the recipient to keep.
+
+var (
+	ErrUserNotFound  = errors.New("user not found")
+	ErrNoKeyPair     = errors.New("user has no key pair yet (they need to log in once)")
+	ErrShareWithSelf = errors.New("can't share a password with yourself")
+	ErrShareNotFound = errors.New("share not found")
+)
+
+type Share struct {
+	RecipientID   int64  `json:"recipient_id"`
+	RecipientName string `json:"recipient_name"`
+	Permission    string `json:"permission"`
+	CreatedAt     string `json:"created_at"`
+}
+
+// PasswordPermission returns what the user may do with the password called name in ownerID's vault.
+// the owner can do anything in their own vault, including creating entries that don't exist yet. anyone else
+// only has what the owner shared with them.
+func (db *DB) PasswordPermission(ctx context.Context, userID, ownerID int64, name string) (authz.Permission, error) {
+	if userID == ownerID {
+		return authz.Owner, nil
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT s.permission FROM password_shares s JOIN passwords p ON p.id = s.password_id
+		WHERE p.user_id = $1 AND p.name = $2 AND s.recipient_id = $3;`
+	var perm string
+	err := db.sql.QueryRowContext(ctx, stmt, ownerID, name, userID).Scan(&perm)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return authz.None, nil
+		}
+		return authz.None, fmt.Errorf("querying share: %w", err)
+	}
+	return authz.ParsePermission(perm)
+}
+
+// SharePassword shares the password called name in ownerID's vault with the user called recipientName. sharing
+// it again with the same user changes the permission. vaultCode is needed if the password is in a vault with its
+// own code.
+func (db *DB) SharePassword(ctx context.Context, ownerID int64, name, key2, vaultCode, recipientName string, perm authz.Permission) error {
+	if perm != authz.ReadOnly && perm != authz.ReadWrite {
+		return fmt.Errorf("can't share with %s permission", perm)
+	}
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// step 1: verify the owner's key2
+	owner, err := getUserKeys(ctx, tx, ownerID)
+	if err != nil {
+		return err
+	}
+	if err := owner.verify(key2_decoded); err != nil {
+		return err
+	}
+
+	// step 2: get the recipient's public key
+	stmt := `SELECT id, public_key FROM users WHERE name = $1;`
+	var recipientID int64
+	var publicKey []byte
+	if err := tx.QueryRowContext(ctx, stmt, recipientName).Scan(&recipientID, &publicKey); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: %s", ErrUserNotFound, recipientName)
+		}
+		return fmt.Errorf("querying recipient: %w", err)
+	}
+	if recipientID == ownerID {
+		return ErrShareWithSelf
+	}
+	if publicKey == nil {
+		return fmt.Errorf("%w: %s", ErrNoKeyPair, recipientName)
+	}
+
+	// step 3: get the data key (legacy entries get one now)
+	e, err := getEntry(ctx, tx, ownerID, name, true)
+	if err != nil {
+		return err
+	}
+	legacy := e.dataKey == nil
+	wrapKey, err := entryWrapKey(ctx, tx, owner, e, key2_decoded, vaultCode)
+	if err != nil {
+		return err
+	}
+	dataKey, err := e.giveDataKey(owner.key1, wrapKey)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if err := e.update(ctx, tx); err != nil {
+			return err
+		}
+	}
+
+	// step 4: seal it to the recipient
+	sealed, err := utils.Seal(publicKey, dataKey)
+	if err != nil {
+		return fmt.Errorf("sealing data key: %w", err)
+	}
+	stmt = `INSERT INTO password_shares (password_id, recipient_id, data_key, permission) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (password_id, recipient_id) DO UPDATE SET data_key = EXCLUDED.data_key, permission = EXCLUDED.permission;`
+	if _, err := tx.ExecContext(ctx, stmt, e.id, recipientID, sealed, perm.String()); err != nil {
+		return fmt.Errorf("inserting share: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// UnsharePassword revokes the share of the password called name in ownerID's vault with the user called recipientName.
+func (db *DB) UnsharePassword(ctx context.Context, ownerID int64, name, recipientName string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `DELETE FROM password_shares s USING passwords p, users u
+		WHERE s.password_id = p.id AND s.recipient_id = u.id AND p.user_id = $1 AND p.name = $2 AND u.name = $3;`
+	res, err := db.sql.ExecContext(ctx, stmt, ownerID, name, recipientName)
+	if err != nil {
+		return fmt.Errorf("deleting share: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("deleting share: %w", err)
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: password %s with %s", ErrShareNotFound, name, recipientName)
+	}
+	return nil
+}
+
+// PasswordShares lists who the password called name in ownerID's vault is shared with.
+func (db *DB) PasswordShares(ctx context.Context, ownerID int64, name string) ([]Share, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT u.id, u.name, s.permission, s.created_at FROM password_shares s
+		JOIN passwords p ON p.id = s.password_id JOIN users u ON u.id = s.recipient_id
+		WHERE p.user_id = $1 AND p.name = $2 ORDER BY u.name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, ownerID, name)
+	if err != nil {
+		return nil, fmt.Errorf("querying shares: %w", err)
+	}
+	defer rows.Close()
+	var shares []Share
+	for rows.Next() {
+		var s Share
+		if err := rows.Scan(&s.RecipientID, &s.RecipientName, &s.Permission, &s.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning share row: %w", err)
+		}
+		shares = append(shares, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating shares: %w", err)
+	}
+	return shares, nil
+}
+
+// dataKeyFor returns the key layer 1 of e is encrypted with, for the principal (whose key2 must be verified
+// already). the owner gets it with key2 (and the vault code, see vaults.go), a recipient opens their share with
+// their private key.
+func dataKeyFor(ctx context.Context, q querier, e *entry, principal *userKeys, key2 []byte, vaultCode string) ([]byte, error) {
+	if principal.id == e.ownerID {
+		wrapKey, err := entryWrapKey(ctx, q, principal, e, key2, vaultCode)
+		if err != nil {
+			return nil, err
+		}
+		return e.ownerDataKey(wrapKey)
+	}
+
+	stmt := `SELECT data_key FROM password_shares WHERE password_id = $1 AND recipient_id = $2;`
+	var sealed []byte
+	if err := q.QueryRowContext(ctx, stmt, e.id, principal.id).Scan(&sealed); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: password %d isn't shared with user id %d", ErrPasswordNotFound, e.id, principal.id)
+		}
+		return nil, fmt.Errorf("querying share: %w", err)
+	}
+	priv, err := principal.openPrivateKey(key2)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := utils.Unseal(priv, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("opening shared data key: %w", err)
+	}
+	return dataKey, nil
+}
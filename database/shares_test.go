@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/tiredkangaroo/keylock/authz"
+)
+
+func TestPasswordPermission(t *testing.T) {
+	sqldb, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqldb.Close()
+	db := &DB{sql: sqldb}
+	ctx := context.Background()
+	query := regexp.QuoteMeta("SELECT s.permission FROM password_shares")
+
+	// your own entries don't need a share
+	perm, err := db.PasswordPermission(ctx, 7, 7, "bank")
+	if err != nil || perm != authz.Owner {
+		t.Fatalf("own entry: %s, %v", perm, err)
+	}
+
+	// someone else's: the share has to be with the principal (the recipient is the last argument)
+	mock.ExpectQuery(query).WithArgs(int64(8), "bank", int64(7)).WillReturnRows(sqlmock.NewRows([]string{"permission"}))
+	perm, err = db.PasswordPermission(ctx, 7, 8, "bank")
+	if err != nil || perm != authz.None {
+		t.Fatalf("another user's entry: %s, %v", perm, err)
+	}
+
+	mock.ExpectQuery(query).WithArgs(int64(8), "bank", int64(7)).WillReturnRows(sqlmock.NewRows([]string{"permission"}).AddRow("read-only"))
+	perm, err = db.PasswordPermission(ctx, 7, 8, "bank")
+	if err != nil || perm != authz.ReadOnly {
+		t.Fatalf("read-only share: %s, %v", perm, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
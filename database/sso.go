@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// single sign-on identities: an account at an openid connect provider (issuer + subject) linked to a keylock
+// user (the flow is in server/sso.go). signing in with the provider only proves who the user is. it gets them a
+// session but not key2, so their browser still needs the session code from a master password login and they
+// still need their code, same as always.
+
+var (
+	ErrIdentityNotLinked = errors.New("no keylock account is linked to that single sign-on account, log in with your master password and link it from the security page")
+	ErrIdentityLinked    = errors.New("that single sign-on account is already linked to a keylock account")
+	ErrIdentityNotFound  = errors.New("single sign-on identity not found")
+)
+
+type Identity struct {
+	ID         int64  `json:"id"`
+	Issuer     string `json:"issuer"`
+	Email      string `json:"email,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// LinkIdentity links the provider account (issuer, subject) to the user.
+func (db *DB) LinkIdentity(ctx context.Context, userid int64, issuer, subject, email string) (*Identity, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	i := &Identity{Issuer: issuer, Email: email}
+	stmt := `INSERT INTO sso_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING id, created_at;`
+	if err := db.sql.QueryRowContext(ctx, stmt, userid, issuer, subject, email).Scan(&i.ID, &i.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrIdentityLinked
+		}
+		return nil, fmt.Errorf("inserting identity: %w", err)
+	}
+	return i, nil
+}
+
+// UseIdentity gets the user the provider account (issuer, subject) is linked to and records the sign in.
+// email is updated since the provider may have changed it.
+func (db *DB) UseIdentity(ctx context.Context, issuer, subject, email string) (*User, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var userid int64
+	stmt := `UPDATE sso_identities SET last_used_at = CURRENT_TIMESTAMP, email = $3 WHERE issuer = $1 AND subject = $2 RETURNING user_id;`
+	if err := db.sql.QueryRowContext(ctx, stmt, issuer, subject, email).Scan(&userid); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrIdentityNotLinked
+		}
+		return nil, fmt.Errorf("updating identity: %w", err)
+	}
+	return db.GetUserByID(ctx, userid)
+}
+
+// ListIdentities lists the provider accounts linked to the user.
+func (db *DB) ListIdentities(ctx context.Context, userid int64) ([]Identity, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, issuer, email, last_used_at, created_at FROM sso_identities WHERE user_id = $1 ORDER BY created_at;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying identities: %w", err)
+	}
+	defer rows.Close()
+	var identities []Identity
+	for rows.Next() {
+		var i Identity
+		var lastUsed sql.NullString
+		if err := rows.Scan(&i.ID, &i.Issuer, &i.Email, &lastUsed, &i.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning identity row: %w", err)
+		}
+		i.LastUsedAt = lastUsed.String
+		identities = append(identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating identities: %w", err)
+	}
+	return identities, nil
+}
+
+// UnlinkIdentity unlinks one of the user's provider accounts.
+func (db *DB) UnlinkIdentity(ctx context.Context, userid, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.sql.ExecContext(ctx, `DELETE FROM sso_identities WHERE id = $1 AND user_id = $2;`, id, userid)
+	if err != nil {
+		return fmt.Errorf("deleting identity: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: id %d", ErrIdentityNotFound, id)
+	}
+	return nil
+}
@@ -0,0 +1,394 @@
+package database
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// personal access tokens are for scripts and ci jobs that can't do a session and type a code. a token is
+// AccessTokenPrefix + 32 random bytes, we only keep its sha256 (it's random enough that a plain hash is fine).
+// every token has its own x25519 key pair, the private key is encrypted with a key derived from the token, so
+// only whoever has the token can open it. the data keys (see keys.go) of the entries in the token's scopes are
+// sealed to the token's public key in access_token_keys, like a share. that's the only key material a token
+// has: it can read and change the entries in its scopes but can't open anything else, even though it acts as
+// its user. making a token needs key2 to get at the data keys, entries saved later that match a scope are sealed
+// to the token when they're saved (saving needs key2 anyway).
+// entries in vaults with their own code are left out, a token shouldn't get around a code.
+
+const AccessTokenPrefix = "klpat_"
+
+var (
+	ErrAccessTokenInvalid  = errors.New("invalid or expired access token")
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrAccessTokenScopes   = errors.New("an access token needs at least one scope")
+)
+
+type AccessToken struct {
+	ID         int64         `json:"id"`
+	UserID     int64         `json:"user_id"`
+	Name       string        `json:"name"`
+	Scopes     []authz.Scope `json:"scopes"`
+	ExpiresAt  string        `json:"expires_at,omitempty"` // empty if it doesn't expire
+	LastUsedAt string        `json:"last_used_at,omitempty"`
+	CreatedAt  string        `json:"created_at"`
+	Entries    int           `json:"entries,omitempty"` // only when it's made, how many entries it can open
+
+	token                       string // only set by UseAccessToken
+	privateKey, privateKeyNonce []byte
+}
+
+func hashAccessToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// accessTokenKey is what the token's private key is encrypted with.
+func accessTokenKey(token string) ([]byte, error) {
+	key, err := hkdf.Key(sha256.New, []byte(token), nil, "keylock access token", 32)
+	if err != nil {
+		return nil, fmt.Errorf("deriving access token key: %w", err)
+	}
+	return key, nil
+}
+
+func formatScopes(scopes []authz.Scope) string {
+	s := make([]string, len(scopes))
+	for i, scope := range scopes {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, " ")
+}
+
+func parseScopes(s string) []authz.Scope {
+	var scopes []authz.Scope
+	for _, scope := range strings.Fields(s) {
+		scopes = append(scopes, authz.Scope(scope))
+	}
+	return scopes
+}
+
+// tokenWantsEntry reports whether any of the scopes need the data key of the entry called name.
+func tokenWantsEntry(scopes []authz.Scope, name string) bool {
+	return authz.CheckScopes(scopes, authz.Read, name) == nil
+}
+
+// CreateAccessToken makes a token for the user with scopes, ttl 0 means it doesn't expire. the token is returned
+// once, we only keep its hash.
+func (db *DB) CreateAccessToken(ctx context.Context, userid int64, name, key2 string, scopes []authz.Scope, ttl time.Duration) (*AccessToken, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrAccessTokenScopes
+	}
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// step 1: verify key2 (users from before key pairs get one now, the vault keys need it)
+	k, err := getUserKeys(ctx, tx, userid)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return nil, "", err
+	}
+	if err := ensureKeyPair(ctx, tx, k, key2_decoded); err != nil {
+		return nil, "", err
+	}
+
+	// step 2: make the token and its key pair
+	raw := make([]byte, 32)
+	rand.Read(raw)
+	token := AccessTokenPrefix + base64.RawURLEncoding.EncodeToString(raw)
+	tokenKey, err := accessTokenKey(token)
+	if err != nil {
+		return nil, "", err
+	}
+	pub, priv, nonce, err := newKeyPair(tokenKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	t := &AccessToken{UserID: userid, Name: name, Scopes: scopes}
+	var expiresAt sql.NullString
+	seconds := sql.NullInt64{Int64: int64(ttl / time.Second), Valid: ttl > 0} // NULL doesn't expire
+	stmt := `INSERT INTO access_tokens (user_id, name, token_hash, scopes, public_key, private_key, private_key_nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP + $8 * INTERVAL '1 second')
+		RETURNING id, expires_at, created_at;`
+	err = tx.QueryRowContext(ctx, stmt, userid, name, hashAccessToken(token), formatScopes(scopes), pub, priv, nonce, seconds).
+		Scan(&t.ID, &expiresAt, &t.CreatedAt)
+	if err != nil {
+		return nil, "", fmt.Errorf("inserting access token: %w", err)
+	}
+	t.ExpiresAt = expiresAt.String
+
+	// step 3: seal the data keys of the entries in scope to the token
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM passwords WHERE user_id = $1;`, userid)
+	if err != nil {
+		return nil, "", fmt.Errorf("querying passwords: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var n string
+		if err := rows.Scan(&n); err != nil {
+			rows.Close()
+			return nil, "", fmt.Errorf("scanning password row: %w", err)
+		}
+		if tokenWantsEntry(scopes, n) {
+			names = append(names, n)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, "", fmt.Errorf("iterating passwords: %w", err)
+	}
+	for _, n := range names {
+		e, err := getEntry(ctx, tx, userid, n, true)
+		if err != nil {
+			return nil, "", err
+		}
+		legacy := e.dataKey == nil
+		wrapKey, err := entryWrapKey(ctx, tx, k, e, key2_decoded, "")
+		if errors.Is(err, ErrVaultCodeRequired) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		dataKey, err := e.giveDataKey(k.key1, wrapKey)
+		if err != nil {
+			return nil, "", err
+		}
+		if legacy {
+			if err := e.update(ctx, tx); err != nil {
+				return nil, "", err
+			}
+		}
+		if err := sealToToken(ctx, tx, t.ID, pub, e.id, dataKey); err != nil {
+			return nil, "", err
+		}
+		t.Entries++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("commit tx: %w", err)
+	}
+	return t, token, nil
+}
+
+func sealToToken(ctx context.Context, q querier, tokenID int64, publicKey []byte, passwordID int64, dataKey []byte) error {
+	sealed, err := utils.Seal(publicKey, dataKey)
+	if err != nil {
+		return fmt.Errorf("sealing data key: %w", err)
+	}
+	stmt := `INSERT INTO access_token_keys (token_id, password_id, data_key) VALUES ($1, $2, $3)
+		ON CONFLICT (token_id, password_id) DO UPDATE SET data_key = EXCLUDED.data_key;`
+	if _, err := q.ExecContext(ctx, stmt, tokenID, passwordID, sealed); err != nil {
+		return fmt.Errorf("inserting access token key: %w", err)
+	}
+	return nil
+}
+
+// sealToTokens gives the user's tokens whose scopes cover a new entry its data key.
+func sealToTokens(ctx context.Context, q querier, userid int64, e *entry, name string, dataKey []byte) error {
+	stmt := `SELECT id, scopes, public_key FROM access_tokens WHERE user_id = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP);`
+	rows, err := q.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return fmt.Errorf("querying access tokens: %w", err)
+	}
+	type token struct {
+		id        int64
+		publicKey []byte
+	}
+	var tokens []token
+	for rows.Next() {
+		var t token
+		var scopes string
+		if err := rows.Scan(&t.id, &scopes, &t.publicKey); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning access token row: %w", err)
+		}
+		if tokenWantsEntry(parseScopes(scopes), name) {
+			tokens = append(tokens, t)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("iterating access tokens: %w", err)
+	}
+	for _, t := range tokens {
+		if err := sealToToken(ctx, q, t.id, t.publicKey, e.id, dataKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseAccessToken gets the token and records that it was used. ErrAccessTokenInvalid if it doesn't exist or has
+// expired.
+func (db *DB) UseAccessToken(ctx context.Context, token string) (*AccessToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	t := &AccessToken{token: token}
+	var scopes string
+	var expiresAt, lastUsed sql.NullString
+	stmt := `UPDATE access_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE token_hash = $1 AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+		RETURNING id, user_id, name, scopes, expires_at, last_used_at, created_at, private_key, private_key_nonce;`
+	err := db.sql.QueryRowContext(ctx, stmt, hashAccessToken(token)).
+		Scan(&t.ID, &t.UserID, &t.Name, &scopes, &expiresAt, &lastUsed, &t.CreatedAt, &t.privateKey, &t.privateKeyNonce)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAccessTokenInvalid
+		}
+		return nil, fmt.Errorf("updating access token: %w", err)
+	}
+	t.Scopes = parseScopes(scopes)
+	t.ExpiresAt, t.LastUsedAt = expiresAt.String, lastUsed.String
+	return t, nil
+}
+
+// ListAccessTokens lists the user's tokens, expired ones too.
+func (db *DB) ListAccessTokens(ctx context.Context, userid int64) ([]AccessToken, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT id, name, scopes, expires_at, last_used_at, created_at FROM access_tokens WHERE user_id = $1 ORDER BY created_at;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying access tokens: %w", err)
+	}
+	defer rows.Close()
+	var tokens []AccessToken
+	for rows.Next() {
+		t := AccessToken{UserID: userid}
+		var scopes string
+		var expiresAt, lastUsed sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &scopes, &expiresAt, &lastUsed, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scanning access token row: %w", err)
+		}
+		t.Scopes = parseScopes(scopes)
+		t.ExpiresAt, t.LastUsedAt = expiresAt.String, lastUsed.String
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeAccessToken deletes one of the user's tokens (and its keys).
+func (db *DB) RevokeAccessToken(ctx context.Context, userid, id int64) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	res, err := db.sql.ExecContext(ctx, `DELETE FROM access_tokens WHERE id = $1 AND user_id = $2;`, id, userid)
+	if err != nil {
+		return fmt.Errorf("deleting access token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w: id %d", ErrAccessTokenNotFound, id)
+	}
+	return nil
+}
+
+// tokenDataKey opens the data key of e that was sealed to t.
+func tokenDataKey(ctx context.Context, q querier, t *AccessToken, e *entry) ([]byte, error) {
+	var sealed []byte
+	stmt := `SELECT data_key FROM access_token_keys WHERE token_id = $1 AND password_id = $2;`
+	if err := q.QueryRowContext(ctx, stmt, t.ID, e.id).Scan(&sealed); err != nil {
+		if err == sql.ErrNoRows {
+			// in scope but in a vault with its own code, or saved before the token without matching it
+			return nil, fmt.Errorf("%w: the access token has no key for password %d", ErrPasswordNotFound, e.id)
+		}
+		return nil, fmt.Errorf("querying access token key: %w", err)
+	}
+	tokenKey, err := accessTokenKey(t.token)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := utils.Decrypt(tokenKey, t.privateKeyNonce, t.privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting access token private key: %w", err)
+	}
+	dataKey, err := utils.Unseal(priv, sealed)
+	if err != nil {
+		return nil, fmt.Errorf("opening access token data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// RetrievePasswordWithToken is RetrievePassword for an access token (from UseAccessToken), the token is the key
+// instead of key2. the scopes are checked by the caller.
+func (db *DB) RetrievePasswordWithToken(ctx context.Context, t *AccessToken, name string) ([]byte, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	k, err := getUserKeys(ctx, db.sql, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	e, err := getEntry(ctx, db.sql, t.UserID, name, false)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := tokenDataKey(ctx, db.sql, t, e)
+	if err != nil {
+		return nil, err
+	}
+	return e.open(k.key1, dataKey)
+}
+
+// UpdatePasswordWithToken is UpdatePassword for an access token. the data key doesn't change, so the owner's
+// copy and every share stay valid.
+func (db *DB) UpdatePasswordWithToken(ctx context.Context, t *AccessToken, name, value string) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	k, err := getUserKeys(ctx, tx, t.UserID)
+	if err != nil {
+		return err
+	}
+	e, err := getEntry(ctx, tx, t.UserID, name, true)
+	if err != nil {
+		return err
+	}
+	dataKey, err := tokenDataKey(ctx, tx, t, e)
+	if err != nil {
+		return err
+	}
+	if err := e.seal(k.key1, dataKey, []byte(value)); err != nil {
+		return err
+	}
+	if err := e.update(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tiredkangaroo/keylock/totp"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// two-factor: a user can add a totp authenticator to their account. once it's enabled, logging in needs a code
+// from it (or one of their recovery codes) on top of the master password. the totp secret is encrypted with
+// enc_key, it has to be readable by the server without the user's key2 since it's checked before we have one.
+// recovery codes are only stored hashed and each one works once. enrolling stores a secret that isn't enabled
+// until the user proves they can make codes with it (EnableTwoFactor), so a half finished enrollment can't lock
+// anyone out.
+
+const RecoveryCodeCount = 10
+
+var (
+	ErrTwoFactorRequired    = errors.New("two-factor code required")
+	ErrInvalidTwoFactor     = errors.New("incorrect two-factor code")
+	ErrTwoFactorEnabled     = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled  = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotEnrolled = errors.New("start two-factor enrollment first")
+)
+
+// SecondFactor is what the user gave us on top of their master password, one of them is enough.
+type SecondFactor struct {
+	TOTP         string
+	RecoveryCode string
+	Passkey      bool // the server already verified a passkey assertion of this user (see passkeys.go)
+}
+
+func (f SecondFactor) empty() bool {
+	return f.TOTP == "" && f.RecoveryCode == "" && !f.Passkey
+}
+
+// TwoFactorStatus is what the user sees about their own two-factor setup.
+type TwoFactorStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// BeginTwoFactor gives the user a new (not yet enabled) totp secret, replacing any unfinished enrollment.
+func (db *DB) BeginTwoFactor(ctx context.Context, userid int64) ([]byte, error) {
+	secret := totp.NewSecret()
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	encrypted, err := utils.Encrypt(enc_key, nonce, secret)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting totp secret: %w", err)
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	stmt := `UPDATE users SET totp_secret = $1, totp_secret_nonce = $2, totp_last_counter = 0 WHERE id = $3 AND NOT totp_enabled;`
+	res, err := db.sql.ExecContext(ctx, stmt, encrypted, nonce, userid)
+	if err != nil {
+		return nil, fmt.Errorf("storing totp secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrTwoFactorEnabled
+	}
+	return secret, nil
+}
+
+// EnableTwoFactor enables the secret from BeginTwoFactor once code (from the authenticator) matches it and returns
+// the user's recovery codes. they're only shown now.
+func (db *DB) EnableTwoFactor(ctx context.Context, userid int64, code string) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	t, err := getTOTP(ctx, tx, userid)
+	if err != nil {
+		return nil, err
+	}
+	if t.enabled {
+		return nil, ErrTwoFactorEnabled
+	}
+	if t.secret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+	counter, ok := totp.Verify(t.secret, code, time.Now(), t.lastCounter)
+	if !ok {
+		return nil, ErrInvalidTwoFactor
+	}
+	stmt := `UPDATE users SET totp_enabled = TRUE, totp_last_counter = $1 WHERE id = $2;`
+	if _, err := tx.ExecContext(ctx, stmt, counter, userid); err != nil {
+		return nil, fmt.Errorf("enabling two-factor: %w", err)
+	}
+	codes, err := newRecoveryCodes(ctx, tx, userid)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor removes the user's authenticator and recovery codes, it needs a code from either.
+func (db *DB) DisableTwoFactor(ctx context.Context, userid int64, f SecondFactor) error {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	enabled, err := checkSecondFactor(ctx, tx, userid, f)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+	stmt := `UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_secret_nonce = NULL, totp_last_counter = 0 WHERE id = $1;`
+	if _, err := tx.ExecContext(ctx, stmt, userid); err != nil {
+		return fmt.Errorf("disabling two-factor: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userid); err != nil {
+		return fmt.Errorf("deleting recovery codes: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes (used or not) with new ones, it needs a code.
+func (db *DB) RegenerateRecoveryCodes(ctx context.Context, userid int64, f SecondFactor) ([]string, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	enabled, err := checkSecondFactor(ctx, tx, userid, f)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	codes, err := newRecoveryCodes(ctx, tx, userid)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit tx: %w", err)
+	}
+	return codes, nil
+}
+
+// GetTwoFactorStatus tells the user whether they have two-factor enabled and how many recovery codes they have left.
+func (db *DB) GetTwoFactorStatus(ctx context.Context, userid int64) (*TwoFactorStatus, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	stmt := `SELECT u.totp_enabled, COUNT(r.id) FROM users u LEFT JOIN recovery_codes r ON r.user_id = u.id AND r.used_at IS NULL
+		WHERE u.id = $1 GROUP BY u.id;`
+	var status TwoFactorStatus
+	if err := db.sql.QueryRowContext(ctx, stmt, userid).Scan(&status.Enabled, &status.RecoveryCodesLeft); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: id %d", ErrUserNotFound, userid)
+		}
+		return nil, fmt.Errorf("querying two-factor status: %w", err)
+	}
+	return &status, nil
+}
+
+// VerifySecondFactor checks the second factor of a user whose master password is already verified. users without
+// two-factor pass with enabled == false, users with it need f (ErrTwoFactorRequired if it's empty). a totp code
+// can't be used again and a recovery code is used up.
+func (db *DB) VerifySecondFactor(ctx context.Context, userid int64, f SecondFactor) (enabled bool, err error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	tx, err := db.sql.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	enabled, err = checkSecondFactor(ctx, tx, userid, f)
+	if err != nil {
+		return enabled, err
+	}
+	if err := tx.Commit(); err != nil {
+		return enabled, fmt.Errorf("commit tx: %w", err)
+	}
+	return enabled, nil
+}
+
+type userTOTP struct {
+	enabled     bool
+	secret      []byte // nil if the user never enrolled
+	lastCounter int64
+}
+
+// getTOTP locks the user's row (tx only) so two logins can't both use the same code.
+func getTOTP(ctx context.Context, tx *sql.Tx, userid int64) (*userTOTP, error) {
+	stmt := `SELECT totp_enabled, totp_secret, totp_secret_nonce, totp_last_counter FROM users WHERE id = $1 FOR UPDATE;`
+	var t userTOTP
+	var encrypted, nonce []byte
+	if err := tx.QueryRowContext(ctx, stmt, userid).Scan(&t.enabled, &encrypted, &nonce, &t.lastCounter); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("%w: id %d", ErrUserNotFound, userid)
+		}
+		return nil, fmt.Errorf("querying totp: %w", err)
+	}
+	if encrypted != nil {
+		secret, err := utils.Decrypt(enc_key, nonce, encrypted)
+		if err != nil {
+			return nil, fmt.Errorf("decrypting totp secret: %w", err)
+		}
+		t.secret = secret
+	}
+	return &t, nil
+}
+
+// checkSecondFactor is VerifySecondFactor in a tx.
+func checkSecondFactor(ctx context.Context, tx *sql.Tx, userid int64, f SecondFactor) (bool, error) {
+	t, err := getTOTP(ctx, tx, userid)
+	if err != nil {
+		return false, err
+	}
+	if !t.enabled {
+		return false, nil
+	}
+	if f.empty() {
+		return true, ErrTwoFactorRequired
+	}
+	if f.Passkey {
+		return true, nil
+	}
+
+	if f.TOTP != "" {
+		counter, ok := totp.Verify(t.secret, f.TOTP, time.Now(), t.lastCounter)
+		if !ok {
+			return true, ErrInvalidTwoFactor
+		}
+		stmt := `UPDATE users SET totp_last_counter = $1 WHERE id = $2;`
+		if _, err := tx.ExecContext(ctx, stmt, counter, userid); err != nil {
+			return true, fmt.Errorf("updating totp counter: %w", err)
+		}
+		return true, nil
+	}
+
+	stmt := `UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;`
+	res, err := tx.ExecContext(ctx, stmt, userid, hashRecoveryCode(f.RecoveryCode))
+	if err != nil {
+		return true, fmt.Errorf("using recovery code: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return true, ErrInvalidTwoFactor
+	}
+	return true, nil
+}
+
+// recovery codes are 16 base32 characters (80 bits) written as xxxx-xxxx-xxxx-xxxx. that's too many to guess
+// even from the hash, so a plain sha256 is enough.
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// newRecoveryCodes replaces the user's recovery codes.
+func newRecoveryCodes(ctx context.Context, tx *sql.Tx, userid int64) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1;`, userid); err != nil {
+		return nil, fmt.Errorf("deleting recovery codes: %w", err)
+	}
+	codes := make([]string, RecoveryCodeCount)
+	raw := make([]byte, 10)
+	for i := range codes {
+		rand.Read(raw)
+		code := recoveryEncoding.EncodeToString(raw)
+		codes[i] = code[:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:]
+		stmt := `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2);`
+		if _, err := tx.ExecContext(ctx, stmt, userid, hashRecoveryCode(codes[i])); err != nil {
+			return nil, fmt.Errorf("inserting recovery code: %w", err)
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode ignores case, spaces and dashes.
+func hashRecoveryCode(code string) []byte {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	sum := sha256.Sum256([]byte(code))
+	return sum[:]
+}
@@ -0,0 +1,246 @@
+package database
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/passcode"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+// vaults: a user's passwords live in the default vault unless they make more (work, finance, ...). every vault
+// other than the default one has its own random vault key, and the data keys of its passwords are encrypted
+// with the vault key instead of key2. the vault key is encrypted with a key derived from the user's private key
+// (which needs key2) and, if the vault has a code of its own, that code. so a vault with its own code can't be
+// opened with key2 alone, and knowing its code doesn't open anything else.
+// the private key survives rekeyUser, so changing key2 doesn't touch vaults.
+//
+// password names are still unique per user, a vault groups them and decides what unlocks them.
+
+const DefaultVault = "default"
+
+var (
+	ErrVaultNotFound     = errors.New("vault not found")
+	ErrVaultExists       = errors.New("a vault with that name already exists")
+	ErrVaultCodeRequired = errors.New("this vault has its own code")
+)
+
+type Vault struct {
+	ID         int64            `json:"id"` // 0 for the default vault
+	Name       string           `json:"name"`
+	CodeFormat *passcode.Format `json:"code_format,omitempty"` // nil if it doesn't have its own code
+	CreatedAt  string           `json:"created_at"`
+}
+
+// vaultRow is a vault with its (encrypted) key.
+type vaultRow struct {
+	Vault
+	key, keyNonce, salt []byte
+}
+
+// CreateVault creates a vault for the user. with a format the vault gets its own code, which is returned
+// (we don't keep it, like the code).
+func (db *DB) CreateVault(ctx context.Context, userid int64, name, key2 string, format *passcode.Format) (*Vault, string, error) {
+	if name == DefaultVault {
+		return nil, "", fmt.Errorf("%w: %s", ErrVaultExists, name)
+	}
+	key2_decoded, err := decodeKey2(key2)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	// step 1: verify key2 and open the private key (users from before key pairs get one now)
+	k, err := getUserKeys(ctx, db.sql, userid)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := k.verify(key2_decoded); err != nil {
+		return nil, "", err
+	}
+	if err := ensureKeyPair(ctx, db.sql, k, key2_decoded); err != nil {
+		return nil, "", err
+	}
+	priv, err := k.openPrivateKey(key2_decoded)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// step 2: the code, if it has one
+	var code string
+	var codeFormat sql.NullString
+	if format != nil {
+		if code, err = passcode.Generate(*format); err != nil {
+			return nil, "", err
+		}
+		f, err := format.Marshal()
+		if err != nil {
+			return nil, "", err
+		}
+		codeFormat = sql.NullString{String: f, Valid: true}
+	}
+
+	// step 3: a new vault key, encrypted with the key from the private key + code
+	randoms := make([]byte, 32+12+16) // 32 for the vault key, 12 for its nonce, 16 for the salt
+	rand.Read(randoms)
+	salt := randoms[44:]
+	kek, err := vaultKEK(priv, code, salt)
+	if err != nil {
+		return nil, "", err
+	}
+	vaultKey, err := utils.Encrypt(kek, randoms[32:44], randoms[:32])
+	if err != nil {
+		return nil, "", fmt.Errorf("encrypting vault key: %w", err)
+	}
+
+	v := &Vault{Name: name, CodeFormat: format}
+	stmt := `INSERT INTO vaults (user_id, name, vault_key, vault_key_nonce, salt, code_format) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at;`
+	if err := db.sql.QueryRowContext(ctx, stmt, userid, name, vaultKey, randoms[32:44], salt, codeFormat).Scan(&v.ID, &v.CreatedAt); err != nil {
+		if isUniqueViolation(err) {
+			return nil, "", fmt.Errorf("%w: %s", ErrVaultExists, name)
+		}
+		return nil, "", fmt.Errorf("inserting vault: %w", err)
+	}
+	return v, code, nil
+}
+
+// ListVaults lists the user's vaults, the default vault first.
+func (db *DB) ListVaults(ctx context.Context, userid int64) ([]Vault, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
+	var created string
+	if err := db.sql.QueryRowContext(ctx, `SELECT created_at FROM users WHERE id = $1;`, userid).Scan(&created); err != nil {
+		return nil, fmt.Errorf("querying user: %w", err)
+	}
+	vaults := []Vault{{Name: DefaultVault, CreatedAt: created}}
+
+	stmt := `SELECT id, name, vault_key, vault_key_nonce, salt, code_format, created_at FROM vaults WHERE user_id = $1 ORDER BY name;`
+	rows, err := db.sql.QueryContext(ctx, stmt, userid)
+	if err != nil {
+		return nil, fmt.Errorf("querying vaults: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := scanVault(rows)
+		if err != nil {
+			return nil, err
+		}
+		vaults = append(vaults, v.Vault)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating vaults: %w", err)
+	}
+	return vaults, nil
+}
+
+// getVault gets the vault called name, nil for the default vault.
+func getVault(ctx context.Context, q querier, userid int64, name string) (*vaultRow, error) {
+	if name == "" || name == DefaultVault {
+		return nil, nil
+	}
+	stmt := `SELECT id, name, vault_key, vault_key_nonce, salt, code_format, created_at FROM vaults WHERE user_id = $1 AND name = $2;`
+	v, err := scanVault(q.QueryRowContext(ctx, stmt, userid, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrVaultNotFound, name)
+	}
+	return v, err
+}
+
+func getVaultByID(ctx context.Context, q querier, id int64) (*vaultRow, error) {
+	stmt := `SELECT id, name, vault_key, vault_key_nonce, salt, code_format, created_at FROM vaults WHERE id = $1;`
+	v, err := scanVault(q.QueryRowContext(ctx, stmt, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: id %d", ErrVaultNotFound, id)
+	}
+	return v, err
+}
+
+// expects id, name, vault_key, vault_key_nonce, salt, code_format, created_at
+func scanVault(row interface{ Scan(...any) error }) (*vaultRow, error) {
+	v := &vaultRow{}
+	var codeFormat sql.NullString
+	if err := row.Scan(&v.ID, &v.Name, &v.key, &v.keyNonce, &v.salt, &codeFormat, &v.CreatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("scanning vault row: %w", err)
+	}
+	if codeFormat.Valid {
+		f, err := passcode.Parse(codeFormat.String)
+		if err != nil {
+			return nil, fmt.Errorf("vault code format: %w", err)
+		}
+		v.CodeFormat = &f
+	}
+	return v, nil
+}
+
+// open decrypts the vault key with the user's private key and the vault's code ("" if it doesn't have one).
+// a wrong code is ErrInvalidCode.
+func (v *vaultRow) open(priv []byte, code string) ([]byte, error) {
+	if v.CodeFormat != nil {
+		if code == "" {
+			return nil, fmt.Errorf("%w: %s", ErrVaultCodeRequired, v.Name)
+		}
+		var err error
+		if code, err = passcode.Normalize(code, *v.CodeFormat); err != nil {
+			return nil, ErrInvalidCode
+		}
+	} else {
+		code = ""
+	}
+	kek, err := vaultKEK(priv, code, v.salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := utils.Decrypt(kek, v.keyNonce, v.key)
+	if err != nil {
+		if v.CodeFormat != nil {
+			return nil, ErrInvalidCode
+		}
+		return nil, fmt.Errorf("decrypting vault key: %w", err)
+	}
+	return key, nil
+}
+
+// vaultKEK derives the key a vault key is encrypted with.
+func vaultKEK(priv []byte, code string, salt []byte) ([]byte, error) {
+	kek, err := hkdf.Key(sha256.New, append(priv[:len(priv):len(priv)], code...), salt, "keylock-vault", 32)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf vault key: %w", err)
+	}
+	return kek, nil
+}
+
+// wrapKey returns what the owner's copy of a data key in the vault is encrypted with: key2 for the default vault
+// (v == nil), the vault key otherwise. k is the owner and key2 must be verified already.
+func (v *vaultRow) wrapKey(k *userKeys, key2 []byte, code string) ([]byte, error) {
+	if v == nil {
+		return key2, nil
+	}
+	priv, err := k.openPrivateKey(key2)
+	if err != nil {
+		return nil, err
+	}
+	return v.open(priv, code)
+}
+
+// entryWrapKey is wrapKey for the vault e is in.
+func entryWrapKey(ctx context.Context, q querier, owner *userKeys, e *entry, key2 []byte, code string) ([]byte, error) {
+	if !e.vaultID.Valid {
+		return key2, nil
+	}
+	v, err := getVaultByID(ctx, q, e.vaultID.Int64)
+	if err != nil {
+		return nil, err
+	}
+	return v.wrapKey(owner, key2, code)
+}
@@ -0,0 +1,204 @@
+package kdf
+
+import (
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+// the master password stays on the client. clients (the cli with this package, the web with the wasm build in
+// web/wasm) run the kdf themselves and send the server two things, both hkdf of the kdf output with their own info
+// (so one doesn't say anything about the other):
+//
+// - key2, split like it always was (see passcode.Split). the server checks it against users.key2_verifier and uses
+//   it for the onion layer like before.
+// - the login key. this is what logging in checks (against users.login_verifier), so logging in doesn't need key2
+//   and knowing key2 (session code + code) isn't enough to log in.
+//
+// the server only stores the salt, the params and verifiers of the two.
+//
+// accounts from before (Params.Version 0) have key2 split off of the kdf output itself, so key2 is the kdf output
+// give or take the code's reduction (see passcode.Unsplit) and there's no login key that key2 doesn't give away.
+// their one way in is the master password: the client sends it once, the server checks it against key2_verifier
+// like it used to and the account is rekeyed to the current version (see database.LoginUser).
+
+const LoginKeySize = 32
+
+const (
+	key2Info     = "keylock key2 v1"
+	loginKeyInfo = "keylock login key v1"
+)
+
+var ErrMalformedCredentials = errors.New("malformed credentials")
+
+// Keys is everything a client derives from the master password.
+type Keys struct {
+	Key2        []byte `json:"-"`
+	SessionCode string `json:"session_code"` // hex, kept by the client
+	Code        string `json:"code"`         // remembered by the user
+	LoginKey    []byte `json:"-"`
+}
+
+// Credentials is what the server gets for a new account, or an account being rekeyed.
+type Credentials struct {
+	Key2       string          `json:"key2"` // hex
+	LoginKey   []byte          `json:"login_key"`
+	Salt       []byte          `json:"salt"`
+	KDF        Params          `json:"kdf"`
+	CodeFormat passcode.Format `json:"code_format"`
+	CodeOffset []byte          `json:"code_offset,omitempty"` // set by a rekey that keeps the code, see passcode.Offset
+}
+
+// Account is what the server tells a client about an account before it logs in (/api/accounts/prelogin).
+type Account struct {
+	KDF        Params          `json:"kdf"`
+	Salt       []byte          `json:"salt"`
+	CodeFormat passcode.Format `json:"code_format"`
+	CodeOffset []byte          `json:"code_offset,omitempty"`
+	CurrentKDF Params          `json:"current_kdf"` // what new accounts use and old ones are rekeyed to
+	// the account is from before the login key was its own (KDF.Version 0). the client sends the master password
+	// and rekeys the account to get one (see Login).
+	Migrate bool `json:"migrate"`
+}
+
+// Login is what a client logs in with. if the account needs rekeying, Rekey has the new credentials and Rekeyed
+// the keys that go with them, which the client keeps if the server says it rekeyed (Keys otherwise). a rekey changes
+// the session code, the code stays the same unless the format changes.
+type Login struct {
+	LoginKey       []byte       `json:"login_key"`
+	Key2           string       `json:"key2,omitempty"`            // only when rekeying, the old key2
+	MasterPassword string       `json:"master_password,omitempty"` // only when migrating
+	Rekey          *Credentials `json:"rekey,omitempty"`
+	Keys           Keys         `json:"keys"`
+	Rekeyed        *Keys        `json:"rekeyed,omitempty"`
+}
+
+// Keys derives the client's keys from the master password and the account's salt, code format and code offset.
+func (p Params) Keys(password string, salt []byte, format passcode.Format, codeOffset []byte) (Keys, error) {
+	raw, err := p.Derive(password, salt, passcode.Key2Size)
+	if err != nil {
+		return Keys{}, fmt.Errorf("kdf key: %w", err)
+	}
+	key2Raw := raw
+	if p.Version > 0 {
+		if key2Raw, err = hkdf.Key(sha256.New, raw, salt, key2Info, passcode.Key2Size); err != nil {
+			return Keys{}, fmt.Errorf("hkdf key2: %w", err)
+		}
+	}
+	key2, _, _, err := passcode.Split(key2Raw, format)
+	if err != nil {
+		return Keys{}, fmt.Errorf("split key2: %w", err)
+	}
+	key2, sessionCode, code, err := passcode.Shift(key2, codeOffset, format)
+	if err != nil {
+		return Keys{}, fmt.Errorf("shift code: %w", err)
+	}
+	loginKey, err := loginKeyOf(raw, salt)
+	if err != nil {
+		return Keys{}, err
+	}
+	return Keys{Key2: key2, SessionCode: sessionCode, Code: code, LoginKey: loginKey}, nil
+}
+
+func loginKeyOf(raw, salt []byte) ([]byte, error) {
+	loginKey, err := hkdf.Key(sha256.New, raw, salt, loginKeyInfo, LoginKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf login key: %w", err)
+	}
+	return loginKey, nil
+}
+
+// NewCredentials derives keys with a fresh salt, for a new account.
+func (p Params) NewCredentials(password string, format passcode.Format) (Credentials, Keys, error) {
+	return p.rekey(password, format, nil)
+}
+
+// rekey derives keys with a fresh salt. the code of keep (optional, the key2 of the account being rekeyed, in
+// format) stays the same, with an offset from the code the kdf gives.
+func (p Params) rekey(password string, format passcode.Format, keep []byte) (Credentials, Keys, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+	keys, err := p.Keys(password, salt, format, nil)
+	if err != nil {
+		return Credentials{}, Keys{}, err
+	}
+	var offset []byte
+	if keep != nil {
+		if offset, err = passcode.Offset(keys.Key2, keep, format); err != nil {
+			return Credentials{}, Keys{}, fmt.Errorf("code offset: %w", err)
+		}
+		if keys.Key2, keys.SessionCode, keys.Code, err = passcode.Shift(keys.Key2, offset, format); err != nil {
+			return Credentials{}, Keys{}, fmt.Errorf("shift code: %w", err)
+		}
+	}
+	return Credentials{
+		Key2:       hex.EncodeToString(keys.Key2),
+		LoginKey:   keys.LoginKey,
+		Salt:       salt,
+		KDF:        p,
+		CodeFormat: format,
+		CodeOffset: offset,
+	}, keys, nil
+}
+
+// Login derives what the client logs in to the account with. the account is rekeyed when its kdf params aren't the
+// current ones or format (optional) is different from its code format.
+func (a Account) Login(password string, format *passcode.Format) (Login, error) {
+	keys, err := a.KDF.Keys(password, a.Salt, a.CodeFormat, a.CodeOffset)
+	if err != nil {
+		return Login{}, err
+	}
+	l := Login{LoginKey: keys.LoginKey, Keys: keys}
+	newFormat := a.CodeFormat
+	if format != nil {
+		newFormat = *format
+	}
+	// migrating accounts get a login key with a rekey, see database.LoginUser
+	if a.KDF != a.CurrentKDF || newFormat != a.CodeFormat || a.Migrate {
+		// the code the user remembers only changes with its format
+		var keep []byte
+		if newFormat == a.CodeFormat {
+			keep = keys.Key2
+		}
+		creds, rekeyed, err := a.CurrentKDF.rekey(password, newFormat, keep)
+		if err != nil {
+			return Login{}, err
+		}
+		l.Rekey, l.Rekeyed = &creds, &rekeyed
+	}
+	switch {
+	case a.Migrate:
+		l.MasterPassword = password
+	case l.Rekey != nil:
+		l.Key2 = hex.EncodeToString(keys.Key2)
+	}
+	return l, nil
+}
+
+// Validate checks the shape of the credentials, not whether they're right.
+func (c Credentials) Validate() error {
+	if key2, err := hex.DecodeString(c.Key2); err != nil || len(key2) != passcode.Key2Size {
+		return fmt.Errorf("%w: key2 must be %d bytes of hex", ErrMalformedCredentials, passcode.Key2Size)
+	}
+	if len(c.LoginKey) != LoginKeySize {
+		return fmt.Errorf("%w: login_key must be %d bytes", ErrMalformedCredentials, LoginKeySize)
+	}
+	if len(c.Salt) < 16 {
+		return fmt.Errorf("%w: salt must be at least 16 bytes", ErrMalformedCredentials)
+	}
+	if err := c.KDF.Validate(); err != nil {
+		return err
+	}
+	if err := c.CodeFormat.Validate(); err != nil {
+		return err
+	}
+	if c.CodeOffset != nil && len(c.CodeOffset) != c.CodeFormat.Bytes() {
+		return fmt.Errorf("%w: code_offset must be %d bytes", ErrMalformedCredentials, c.CodeFormat.Bytes())
+	}
+	return nil
+}
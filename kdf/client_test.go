@@ -0,0 +1,114 @@
+package kdf
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tiredkangaroo/keylock/passcode"
+)
+
+var testParams = Params{Algorithm: PBKDF2SHA256, Version: KeysVersion, Iterations: 10}
+
+// loginKeysOf is what someone who knows key2 (session code + code) and the salt (prelogin hands it out) can work out
+// the login key to be, if it was derived from key2's kdf output.
+func loginKeysOf(t *testing.T, key2, salt []byte, f passcode.Format) [][]byte {
+	t.Helper()
+	raws, err := passcode.Unsplit(key2, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys [][]byte
+	for _, raw := range raws {
+		k, err := loginKeyOf(raw, salt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func TestLoginKeyFromKey2(t *testing.T) {
+	formats := []passcode.Format{
+		passcode.Legacy(),
+		{Kind: passcode.Digits, Length: 6},
+		{Kind: passcode.Digits, Length: 10},
+		{Kind: passcode.Alphanumeric, Length: 8},
+		{Kind: passcode.Alphanumeric, Length: 16},
+	}
+	for _, f := range formats {
+		t.Run(f.String(), func(t *testing.T) {
+			creds, keys, err := testParams.NewCredentials("correct horse battery staple", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := creds.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range loginKeysOf(t, keys.Key2, creds.Salt, f) {
+				if bytes.Equal(k, keys.LoginKey) {
+					t.Fatal("the login key can be worked out from key2")
+				}
+			}
+
+			// accounts from before the login key was its own can be, which is why they migrate with the master password
+			legacy := testParams
+			legacy.Version = 0
+			old, err := legacy.Keys("correct horse battery staple", creds.Salt, f, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, k := range loginKeysOf(t, old.Key2, creds.Salt, f) {
+				found = found || bytes.Equal(k, old.LoginKey)
+			}
+			if !found {
+				t.Fatal("the version 0 login key isn't one of key2's")
+			}
+		})
+	}
+}
+
+func TestRekeyKeepsCode(t *testing.T) {
+	formats := []passcode.Format{
+		passcode.Legacy(),
+		{Kind: passcode.Digits, Length: 8},
+		{Kind: passcode.Alphanumeric, Length: 12},
+	}
+	for _, f := range formats {
+		t.Run(f.String(), func(t *testing.T) {
+			old := testParams
+			old.Version = 0
+			creds, keys, err := old.NewCredentials("correct horse battery staple", f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := testParams
+			current.Iterations *= 2
+			a := Account{KDF: old, Salt: creds.Salt, CodeFormat: f, CurrentKDF: current, Migrate: true}
+			l, err := a.Login("correct horse battery staple", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.Rekey == nil {
+				t.Fatal("no rekey for an account on old params")
+			}
+			if l.Rekeyed.Code != keys.Code {
+				t.Fatalf("the code changed with the rekey: %s, was %s", l.Rekeyed.Code, keys.Code)
+			}
+			if l.Rekeyed.SessionCode == keys.SessionCode {
+				t.Fatal("the session code is the same after the rekey")
+			}
+
+			// and the next login derives the rekeyed keys
+			a = Account{KDF: current, Salt: l.Rekey.Salt, CodeFormat: f, CodeOffset: l.Rekey.CodeOffset, CurrentKDF: current}
+			next, err := a.Login("correct horse battery staple", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next.Rekey != nil || !bytes.Equal(next.Keys.Key2, l.Rekeyed.Key2) || next.Keys.Code != keys.Code {
+				t.Fatalf("after the rekey: got %+v, want %+v", next.Keys, *l.Rekeyed)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package kdf
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/tiredkangaroo/keylock/config"
+	"golang.org/x/crypto/argon2"
+)
+
+// a kdf turns a master password + salt into key2 (on the client, see client.go).
+// the algorithm and its parameters are stored per user (users.key2_kdf) so we can raise the cost
+// later without breaking anyone. when a user logs in and their stored params don't match Current(),
+// the client derives new keys with a fresh salt and the server rekeys them.
+//
+// Version is how key2 and the login key come out of the kdf output (see client.go). accounts from before the login
+// key was its own hkdf output are version 0, everything new is KeysVersion, so they're upgraded like any other
+// change in the params.
+
+type Algorithm string
+
+const (
+	PBKDF2SHA256 Algorithm = "pbkdf2-sha256"
+	Argon2id     Algorithm = "argon2id"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown kdf algorithm")
+	ErrInvalidParams    = errors.New("invalid kdf parameters")
+)
+
+// KeysVersion is the Version of Current().
+const KeysVersion = 1
+
+type Params struct {
+	Algorithm Algorithm `json:"algorithm"`
+	Version   int       `json:"version,omitempty"`
+
+	// pbkdf2-sha256
+	Iterations int `json:"iterations,omitempty"`
+
+	// argon2id
+	Memory      uint32 `json:"memory,omitempty"` // in KiB
+	Time        uint32 `json:"time,omitempty"`   // number of passes
+	Parallelism uint8  `json:"parallelism,omitempty"`
+}
+
+// Legacy returns the parameters every account was created with before kdfs were stored per user.
+func Legacy() Params {
+	return Params{
+		Algorithm:  PBKDF2SHA256,
+		Iterations: 1e6,
+	}
+}
+
+// Current returns the parameters new accounts (and upgraded accounts) should use.
+func Current() Params {
+	c := config.DefaultConfig.KDF
+	switch Algorithm(c.Algorithm) {
+	case PBKDF2SHA256:
+		return Params{
+			Algorithm:  PBKDF2SHA256,
+			Version:    KeysVersion,
+			Iterations: c.PBKDF2.Iterations,
+		}
+	default: // argon2id is the default
+		return Params{
+			Algorithm:   Argon2id,
+			Version:     KeysVersion,
+			Memory:      c.Argon2id.Memory,
+			Time:        c.Argon2id.Time,
+			Parallelism: c.Argon2id.Parallelism,
+		}
+	}
+}
+
+func (p Params) Validate() error {
+	if p.Version < 0 || p.Version > KeysVersion {
+		return fmt.Errorf("%w: unknown version %d", ErrInvalidParams, p.Version)
+	}
+	switch p.Algorithm {
+	case PBKDF2SHA256:
+		if p.Iterations <= 0 {
+			return fmt.Errorf("%w: pbkdf2 iterations must be positive", ErrInvalidParams)
+		}
+	case Argon2id:
+		if p.Memory == 0 || p.Time == 0 || p.Parallelism == 0 {
+			return fmt.Errorf("%w: argon2id memory, time and parallelism must be positive", ErrInvalidParams)
+		}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownAlgorithm, p.Algorithm)
+	}
+	return nil
+}
+
+// Derive derives a keyLen byte key from the password and salt.
+func (p Params) Derive(password string, salt []byte, keyLen int) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	switch p.Algorithm {
+	case PBKDF2SHA256:
+		return pbkdf2.Key(sha256.New, password, salt, p.Iterations, keyLen)
+	case Argon2id:
+		return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Parallelism, uint32(keyLen)), nil
+	}
+	return nil, ErrUnknownAlgorithm // unreachable, Validate catches it
+}
+
+// Marshal encodes the params for storage in the database.
+func (p Params) Marshal() (string, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("marshal kdf params: %w", err)
+	}
+	return string(data), nil
+}
+
+// Parse decodes params stored with Marshal.
+func Parse(s string) (Params, error) {
+	var p Params
+	if err := json.Unmarshal([]byte(s), &p); err != nil {
+		return Params{}, fmt.Errorf("unmarshal kdf params: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return Params{}, err
+	}
+	return p, nil
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 
 	"github.com/tiredkangaroo/keylock/cache"
 	"github.com/tiredkangaroo/keylock/config"
 	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/ratelimit"
 	"github.com/tiredkangaroo/keylock/server"
 	"github.com/tiredkangaroo/keylock/vault"
 )
@@ -20,7 +22,13 @@ func main() {
 	cache.Init()    // relies on vault and config
 	database.Init() // relies on config
 
-	db, err := database.Database()
+	// relies on config (and cache for redis)
+	if err := ratelimit.Init(); err != nil {
+		slog.Error("setting up rate limiting failed (fatal)", "error", err)
+		return
+	}
+
+	db, err := database.Database(context.Background())
 	if err != nil {
 		slog.Error("connecting to database failed (fatal)", "error", err)
 		return
@@ -0,0 +1,245 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+// the 5-digit code only has 65,536 possibilities, so anything that checks it (or the master password)
+// goes through a Counter. every failure is counted against each subject (a user, a session, an ip...),
+// the next attempt has to wait an exponentially growing backoff and after MaxFailures the subject is locked.
+//
+// an attempt is counted as a failure before it's checked (Attempt), all in one redis script, and taken back when it
+// wasn't one (Release). checking first and counting after the fact would let parallel guesses all get past the
+// check before the first failure landed. the price is that two requests with the right code at the same moment
+// can get a backoff.
+//
+// cache keys:
+// lockout:<name>:failures:<subject> - number of failures in the current window
+// lockout:<name>:next:<subject>     - exists until the next attempt is allowed
+// lockout:<name>:locked:<subject>   - exists while locked
+
+var (
+	ErrLocked          = errors.New("too many failed attempts, locked")
+	ErrTooManyAttempts = errors.New("too many failed attempts, try again later")
+)
+
+// Error is returned by Check. RetryAfter is zero when the lock only goes away with Reset.
+type Error struct {
+	Err        error // ErrLocked or ErrTooManyAttempts
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s (retry after %s)", e.Err.Error(), e.RetryAfter.Round(time.Second))
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+type Counter struct {
+	Name        string
+	MaxFailures int
+	Window      time.Duration // failures older than this are forgotten
+	LockFor     time.Duration // 0 means locked until Reset
+	BackoffBase time.Duration // backoff after the first failure, doubled after every failure after
+	BackoffMax  time.Duration
+}
+
+// Code is the counter for the code (key2). a lock only goes away when the user logs in with their master password.
+func Code() Counter {
+	c := config.DefaultConfig.Lockout
+	return Counter{
+		Name:        "code",
+		MaxFailures: c.CodeMaxFailures,
+		Window:      time.Duration(c.Window) * time.Second,
+		BackoffBase: time.Duration(c.BackoffBase) * time.Millisecond,
+		BackoffMax:  time.Duration(c.BackoffMax) * time.Millisecond,
+	}
+}
+
+// Login is the counter for the master password.
+func Login() Counter {
+	c := config.DefaultConfig.Lockout
+	return Counter{
+		Name:        "login",
+		MaxFailures: c.LoginMaxFailures,
+		Window:      time.Duration(c.Window) * time.Second,
+		LockFor:     time.Duration(c.LoginLockDuration) * time.Second,
+		BackoffBase: time.Duration(c.BackoffBase) * time.Millisecond,
+		BackoffMax:  time.Duration(c.BackoffMax) * time.Millisecond,
+	}
+}
+
+func User(id int64) string {
+	return fmt.Sprintf("user:%d", id)
+}
+func Name(name string) string {
+	return "name:" + name
+}
+func Session(id string) string {
+	return "session:" + id
+}
+func IP(ip string) string {
+	return "ip:" + ip
+}
+
+func (c Counter) key(kind, subject string) string {
+	return fmt.Sprintf("lockout:%s:%s:%s", c.Name, kind, subject)
+}
+
+// Check returns an *Error if any of the subjects is locked or still waiting out a backoff.
+func (c Counter) Check(ctx context.Context, subjects ...string) error {
+	for _, subject := range subjects {
+		ttl, ok, err := cache.TTL(ctx, c.key("locked", subject))
+		if err != nil {
+			return fmt.Errorf("checking lock: %w", err)
+		}
+		if ok {
+			return &Error{Err: ErrLocked, RetryAfter: max(ttl, 0)}
+		}
+
+		ttl, ok, err = cache.TTL(ctx, c.key("next", subject))
+		if err != nil {
+			return fmt.Errorf("checking backoff: %w", err)
+		}
+		if ok && ttl > 0 {
+			return &Error{Err: ErrTooManyAttempts, RetryAfter: ttl}
+		}
+	}
+	return nil
+}
+
+// attemptScript is Check and counting a failure (with its backoff) in one go. KEYS are failures, next and locked of
+// every subject, ARGV the window, MaxFailures, BackoffBase and BackoffMax (ms). it returns {0, 0} when the attempt
+// is counted, {1, ttl} when a subject is locked and {2, ttl} when one has to wait (ttl in ms, -1 for no expiry).
+var attemptScript = redis.NewScript(`
+local max = tonumber(ARGV[2])
+for i = 1, #KEYS, 3 do
+	local locked = redis.call('PTTL', KEYS[i+2])
+	if locked ~= -2 then
+		return {1, locked}
+	end
+	local next = redis.call('PTTL', KEYS[i+1])
+	if next > 0 then
+		return {2, next}
+	end
+	-- attempts still being checked use up what's left
+	if max > 0 and tonumber(redis.call('GET', KEYS[i]) or '0') >= max then
+		return {2, 1000}
+	end
+end
+local base, cap = tonumber(ARGV[3]), tonumber(ARGV[4])
+for i = 1, #KEYS, 3 do
+	local n = redis.call('INCR', KEYS[i])
+	if n == 1 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[1])
+	end
+	if base > 0 then
+		local backoff = base * 2 ^ (n - 1)
+		if cap > 0 and backoff > cap then
+			backoff = cap
+		end
+		redis.call('SET', KEYS[i+1], '1', 'PX', math.floor(backoff))
+	end
+end
+return {0, 0}
+`)
+
+// releaseScript takes an attempt back. KEYS are failures and next of every subject.
+var releaseScript = redis.NewScript(`
+for i = 1, #KEYS, 2 do
+	if tonumber(redis.call('GET', KEYS[i]) or '0') > 0 then
+		redis.call('DECR', KEYS[i])
+	end
+	redis.call('DEL', KEYS[i+1])
+end
+return 0
+`)
+
+// Attempt returns an *Error like Check does, or counts a failure against every subject. the caller then checks
+// the code (or password) and calls Fail if it was wrong and Release otherwise.
+func (c Counter) Attempt(ctx context.Context, subjects ...string) error {
+	keys := make([]string, 0, len(subjects)*3)
+	for _, subject := range subjects {
+		keys = append(keys, c.key("failures", subject), c.key("next", subject), c.key("locked", subject))
+	}
+	res, err := cache.RunScript(ctx, attemptScript, keys,
+		c.Window.Milliseconds(), c.MaxFailures, c.BackoffBase.Milliseconds(), c.BackoffMax.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("counting attempt: %w", err)
+	}
+	vals, ok := res.([]any)
+	if !ok || len(vals) != 2 {
+		return fmt.Errorf("counting attempt: unexpected result %v", res)
+	}
+	kind, _ := vals[0].(int64)
+	ttl, _ := vals[1].(int64)
+	switch kind {
+	case 1:
+		return &Error{Err: ErrLocked, RetryAfter: max(time.Duration(ttl)*time.Millisecond, 0)}
+	case 2:
+		return &Error{Err: ErrTooManyAttempts, RetryAfter: time.Duration(ttl) * time.Millisecond}
+	}
+	return nil
+}
+
+// Fail is for an attempt that was a failure (it's counted already), locked is true if that locked any of the
+// subjects.
+func (c Counter) Fail(ctx context.Context, subjects ...string) (locked bool, err error) {
+	if c.MaxFailures <= 0 {
+		return false, nil
+	}
+	for _, subject := range subjects {
+		v, err := cache.Get(ctx, c.key("failures", subject))
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return locked, fmt.Errorf("getting failures: %w", err)
+		}
+		if n, _ := strconv.ParseInt(v, 10, 64); n < int64(c.MaxFailures) {
+			continue
+		}
+		if err := cache.SetWithExpiration(ctx, c.key("locked", subject), "1", c.LockFor); err != nil {
+			return locked, fmt.Errorf("locking: %w", err)
+		}
+		locked = true
+	}
+	return locked, nil
+}
+
+// Release takes back an attempt that wasn't a failure (the code was right, or it never got checked).
+func (c Counter) Release(ctx context.Context, subjects ...string) error {
+	keys := make([]string, 0, len(subjects)*2)
+	for _, subject := range subjects {
+		keys = append(keys, c.key("failures", subject), c.key("next", subject))
+	}
+	if _, err := cache.RunScript(ctx, releaseScript, keys); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("releasing attempt: %w", err)
+	}
+	return nil
+}
+
+// Reset forgets all failures and locks for the subjects.
+func (c Counter) Reset(ctx context.Context, subjects ...string) error {
+	keys := make([]string, 0, len(subjects)*3)
+	for _, subject := range subjects {
+		keys = append(keys, c.key("failures", subject), c.key("next", subject), c.key("locked", subject))
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return cache.Del(ctx, keys...)
+}
@@ -0,0 +1,110 @@
+package lockout
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/cache"
+)
+
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	cache.UseClient(client)
+	return mr
+}
+
+// parallel guesses all start before any of them is known to be wrong
+func parallelAttempts(c Counter, n int, subjects ...string) int64 {
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if c.Attempt(context.Background(), subjects...) == nil {
+				allowed.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return allowed.Load()
+}
+
+func TestParallelAttemptsHitBackoff(t *testing.T) {
+	useMiniredis(t)
+	c := Counter{Name: "test", MaxFailures: 10, Window: time.Hour, BackoffBase: time.Second, BackoffMax: time.Minute}
+
+	if allowed := parallelAttempts(c, 50, User(1)); allowed != 1 {
+		t.Fatalf("%d parallel attempts got past the backoff, want 1", allowed)
+	}
+	err := c.Attempt(context.Background(), User(1))
+	var lerr *Error
+	if !errors.As(err, &lerr) || !errors.Is(err, ErrTooManyAttempts) || lerr.RetryAfter <= 0 {
+		t.Fatalf("attempt during the backoff: got %v, want ErrTooManyAttempts with a retry after", err)
+	}
+}
+
+func TestParallelAttemptsCantPassMaxFailures(t *testing.T) {
+	useMiniredis(t)
+	// no backoff, only the limit stands between parallel guesses and the code
+	c := Counter{Name: "test", MaxFailures: 5, Window: time.Hour}
+
+	if allowed := parallelAttempts(c, 100, User(1), Session("s")); allowed != 5 {
+		t.Fatalf("%d parallel attempts allowed, want 5", allowed)
+	}
+	locked, err := c.Fail(context.Background(), User(1), Session("s"))
+	if err != nil || !locked {
+		t.Fatalf("failing the 5th attempt: locked %t, err %v", locked, err)
+	}
+	if err := c.Attempt(context.Background(), User(1)); !errors.Is(err, ErrLocked) {
+		t.Fatalf("attempt after the lock: got %v, want ErrLocked", err)
+	}
+}
+
+func TestReleaseTakesAttemptBack(t *testing.T) {
+	mr := useMiniredis(t)
+	ctx := context.Background()
+	c := Counter{Name: "test", MaxFailures: 3, Window: time.Hour, BackoffBase: time.Second}
+
+	// a right code doesn't count towards the limit or leave a backoff behind
+	for i := range 10 {
+		if err := c.Attempt(ctx, User(1)); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+		if err := c.Release(ctx, User(1)); err != nil {
+			t.Fatalf("release %d: %v", i, err)
+		}
+	}
+
+	// two wrong ones do, with a backoff after each
+	for i := range 2 {
+		if err := c.Attempt(ctx, User(1)); err != nil {
+			t.Fatalf("wrong attempt %d: %v", i, err)
+		}
+		if locked, err := c.Fail(ctx, User(1)); err != nil || locked {
+			t.Fatalf("wrong attempt %d: locked %t, err %v", i, locked, err)
+		}
+		if err := c.Attempt(ctx, User(1)); !errors.Is(err, ErrTooManyAttempts) {
+			t.Fatalf("attempt in the backoff: got %v, want ErrTooManyAttempts", err)
+		}
+		mr.FastForward(time.Duration(1<<i) * time.Second)
+	}
+	if err := c.Attempt(ctx, User(1)); err != nil {
+		t.Fatalf("attempt after the backoff: %v", err)
+	}
+	if locked, err := c.Fail(ctx, User(1)); err != nil || !locked {
+		t.Fatalf("third wrong attempt: locked %t, err %v", locked, err)
+	}
+}
@@ -0,0 +1,267 @@
+package passcode
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// key2 is 32 bytes. the first 32-n bytes are the session code (kept by the client for the session) and the last
+// n bytes are the code (remembered by the user). a Format decides n and how the code is written down:
+//
+// - digits, length 5: the original format. n = 2 and the code is the uint16 as 5 digits.
+// - digits, length 6-10: a pin. n is the fewest bytes that fit 10^length.
+// - alphanumeric, length 6-16: a case insensitive passcode (0-9a-z). n is the fewest bytes that fit 36^length.
+//
+// the code bytes from the kdf are reduced mod base^length so every code maps to exactly one key2, which means
+// key2 is session code bytes + the code's value as an n byte big endian number. the client can put that together
+// without knowing anything else.
+//
+// a rekey (new kdf params, new salt) keeps the code the user remembers: the new key2 gets the old code's value and
+// the account stores the offset (see Offset and Shift) from the code the kdf gives to that one. the offset is
+// public, it says nothing about the code without the kdf output.
+
+type Kind string
+
+const (
+	Digits       Kind = "digits"
+	Alphanumeric Kind = "alphanumeric"
+)
+
+const Key2Size = 32
+
+const alphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+var (
+	ErrInvalidFormat = errors.New("invalid code format")
+	ErrInvalidCode   = errors.New("invalid code")
+)
+
+type Format struct {
+	Kind   Kind `json:"kind"`
+	Length int  `json:"length"`
+}
+
+// Legacy is the format every account had before formats were stored per user.
+func Legacy() Format {
+	return Format{Kind: Digits, Length: 5}
+}
+
+func (f Format) Validate() error {
+	switch f.Kind {
+	case Digits:
+		if f.Length < 5 || f.Length > 10 {
+			return fmt.Errorf("%w: pins must be 5 to 10 digits", ErrInvalidFormat)
+		}
+	case Alphanumeric:
+		if f.Length < 6 || f.Length > 16 {
+			return fmt.Errorf("%w: passcodes must be 6 to 16 characters", ErrInvalidFormat)
+		}
+	default:
+		return fmt.Errorf("%w: unknown kind %q", ErrInvalidFormat, f.Kind)
+	}
+	return nil
+}
+
+// String describes the format for prompts, e.g. "5-digit code" or "8-character passcode".
+func (f Format) String() string {
+	if f.Kind == Alphanumeric {
+		return fmt.Sprintf("%d-character passcode", f.Length)
+	}
+	return fmt.Sprintf("%d-digit code", f.Length)
+}
+
+func (f Format) base() int {
+	if f.Kind == Alphanumeric {
+		return 36
+	}
+	return 10
+}
+
+// Bytes is the number of key2 bytes the code takes up.
+func (f Format) Bytes() int {
+	if f == Legacy() {
+		return 2 // always was a uint16
+	}
+	space := f.space()
+	n := 1
+	for new(big.Int).Lsh(big.NewInt(1), uint(8*n)).Cmp(space) < 0 {
+		n++
+	}
+	return n
+}
+
+// space is the number of distinct codes.
+func (f Format) space() *big.Int {
+	if f == Legacy() {
+		return big.NewInt(1 << 16)
+	}
+	return new(big.Int).Exp(big.NewInt(int64(f.base())), big.NewInt(int64(f.Length)), nil)
+}
+
+// Split turns raw kdf output into key2, the session code (hex) and the code.
+func Split(raw []byte, f Format) (key2 []byte, sessionCode string, code string, err error) {
+	if err = f.Validate(); err != nil {
+		return
+	}
+	if len(raw) != Key2Size {
+		err = fmt.Errorf("key must be %d bytes", Key2Size)
+		return
+	}
+	n := f.Bytes()
+	value := new(big.Int).SetBytes(raw[Key2Size-n:])
+	value.Mod(value, f.space())
+
+	key2 = make([]byte, Key2Size)
+	copy(key2, raw[:Key2Size-n])
+	value.FillBytes(key2[Key2Size-n:])
+
+	sessionCode = hex.EncodeToString(key2[:Key2Size-n])
+	code = f.encode(value)
+	return
+}
+
+// Unsplit returns every raw kdf output Split turns into key2. the code bytes were reduced mod the code space, so
+// there's one for each value below 2^(8n) the code's value is congruent to (fewer than 256).
+func Unsplit(key2 []byte, f Format) ([][]byte, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if len(key2) != Key2Size {
+		return nil, fmt.Errorf("key must be %d bytes", Key2Size)
+	}
+	n := f.Bytes()
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(8*n))
+	var raws [][]byte
+	for v := new(big.Int).SetBytes(key2[Key2Size-n:]); v.Cmp(limit) < 0; v.Add(v, f.space()) {
+		raw := make([]byte, Key2Size)
+		copy(raw, key2[:Key2Size-n])
+		v.FillBytes(raw[Key2Size-n:])
+		raws = append(raws, raw)
+	}
+	return raws, nil
+}
+
+// Offset returns what Shift has to add to the code of key2 to get the code of target, both key2s in format f.
+func Offset(key2, target []byte, f Format) ([]byte, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if len(key2) != Key2Size || len(target) != Key2Size {
+		return nil, fmt.Errorf("key must be %d bytes", Key2Size)
+	}
+	n := f.Bytes()
+	offset := new(big.Int).SetBytes(target[Key2Size-n:])
+	offset.Sub(offset, new(big.Int).SetBytes(key2[Key2Size-n:]))
+	offset.Mod(offset, f.space())
+	return offset.FillBytes(make([]byte, n)), nil
+}
+
+// Shift adds offset (from Offset) to the code of key2, it returns the new key2, its session code (hex) and code like
+// Split. a nil offset leaves key2 as it is.
+func Shift(key2, offset []byte, f Format) ([]byte, string, string, error) {
+	if err := f.Validate(); err != nil {
+		return nil, "", "", err
+	}
+	if len(key2) != Key2Size {
+		return nil, "", "", fmt.Errorf("key must be %d bytes", Key2Size)
+	}
+	n := f.Bytes()
+	if offset != nil && len(offset) != n {
+		return nil, "", "", fmt.Errorf("%w: the code offset of a %s is %d bytes", ErrInvalidCode, f, n)
+	}
+	value := new(big.Int).SetBytes(key2[Key2Size-n:])
+	value.Add(value, new(big.Int).SetBytes(offset))
+	value.Mod(value, f.space())
+
+	shifted := make([]byte, Key2Size)
+	copy(shifted, key2[:Key2Size-n])
+	value.FillBytes(shifted[Key2Size-n:])
+	return shifted, hex.EncodeToString(shifted[:Key2Size-n]), f.encode(value), nil
+}
+
+// Key2 puts the session code (hex) and the code back together into key2 (hex).
+func Key2(sessionCode, code string, f Format) (string, error) {
+	if err := f.Validate(); err != nil {
+		return "", err
+	}
+	n := f.Bytes()
+	if len(sessionCode) != 2*(Key2Size-n) {
+		return "", fmt.Errorf("session code doesn't match a %s", f)
+	}
+	value, err := f.decode(code)
+	if err != nil {
+		return "", err
+	}
+	codeBytes := make([]byte, n)
+	value.FillBytes(codeBytes)
+	return sessionCode + hex.EncodeToString(codeBytes), nil
+}
+
+// Generate makes a random code in the format, for codes that aren't split off of key2 (e.g. a vault's own code).
+func Generate(f Format) (string, error) {
+	if err := f.Validate(); err != nil {
+		return "", err
+	}
+	value, err := rand.Int(rand.Reader, f.space())
+	if err != nil {
+		return "", fmt.Errorf("generating code: %w", err)
+	}
+	return f.encode(value), nil
+}
+
+// Normalize checks that code is a code in the format and returns it the way Generate would have written it.
+func Normalize(code string, f Format) (string, error) {
+	value, err := f.decode(code)
+	if err != nil {
+		return "", err
+	}
+	return f.encode(value), nil
+}
+
+func (f Format) encode(value *big.Int) string {
+	s := value.Text(f.base())
+	return strings.Repeat("0", max(f.Length-len(s), 0)) + s
+}
+
+func (f Format) decode(code string) (*big.Int, error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if len(code) != f.Length {
+		return nil, fmt.Errorf("%w: must be a %s", ErrInvalidCode, f)
+	}
+	for _, ch := range code {
+		if i := strings.IndexRune(alphabet, ch); i < 0 || i >= f.base() {
+			return nil, fmt.Errorf("%w: must be a %s", ErrInvalidCode, f)
+		}
+	}
+	value, ok := new(big.Int).SetString(code, f.base())
+	if !ok || value.Cmp(f.space()) >= 0 {
+		return nil, fmt.Errorf("%w: must be a %s", ErrInvalidCode, f)
+	}
+	return value, nil
+}
+
+// Marshal encodes the format for storage in the database.
+func (f Format) Marshal() (string, error) {
+	data, err := json.Marshal(f)
+	if err != nil {
+		return "", fmt.Errorf("marshal code format: %w", err)
+	}
+	return string(data), nil
+}
+
+// Parse decodes a format stored with Marshal.
+func Parse(s string) (Format, error) {
+	var f Format
+	if err := json.Unmarshal([]byte(s), &f); err != nil {
+		return Format{}, fmt.Errorf("unmarshal code format: %w", err)
+	}
+	if err := f.Validate(); err != nil {
+		return Format{}, err
+	}
+	return f, nil
+}
@@ -0,0 +1,30 @@
+package passcode
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUnsplit(t *testing.T) {
+	f := Format{Kind: Digits, Length: 6}
+	raw := bytes.Repeat([]byte{0xab}, Key2Size)
+	key2, _, _, err := Split(raw, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raws, err := Unsplit(key2, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, r := range raws {
+		k, _, _, err := Split(r, f)
+		if err != nil || !bytes.Equal(k, key2) {
+			t.Fatalf("%x doesn't split into key2", r)
+		}
+		found = found || bytes.Equal(r, raw)
+	}
+	if !found {
+		t.Fatal("the raw output isn't one of key2's")
+	}
+}
@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+// how fast a client can hit a route (see rate_limit in config). each window is a sliding window counter: the count
+// of the current fixed window plus the previous one's, weighted by how much of it is still inside the sliding
+// window. it's two numbers per subject instead of a log of every request, and close enough.
+//
+// the counts are kept in redis so every server sees the same ones, or in memory with store = "memory" (one server).
+// if redis fails the memory counts are used until it's back, a redis outage shouldn't lift or block everything.
+//
+// cache keys:
+// ratelimit:<key>:<window start> - requests in the fixed window starting at window start (unix seconds)
+
+const (
+	StoreRedis  = "redis"
+	StoreMemory = "memory"
+)
+
+// Limit is at most Requests per Window.
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result is what a Hit left of the limit. Reset is when the current window is over.
+type Result struct {
+	Limit     Limit
+	Remaining int
+	Reset     time.Duration
+	Allowed   bool
+}
+
+type store interface {
+	// counts returns the count of the previous window and the current one, after counting this request.
+	counts(ctx context.Context, key string, window time.Duration, start time.Time) (prev, cur int64, err error)
+}
+
+var (
+	memory        = &memoryStore{windows: make(map[string]*memoryWindow)}
+	current store = memory
+)
+
+// Init picks the store from config, the cache has to be set up first for redis.
+func Init() error {
+	switch config.DefaultConfig.RateLimit.Store {
+	case StoreRedis, "":
+		current = redisStore{}
+	case StoreMemory:
+		current = memory
+	default:
+		return fmt.Errorf("unknown rate limit store %q", config.DefaultConfig.RateLimit.Store)
+	}
+	return nil
+}
+
+// Hit counts a request against key and says whether it's within l.
+func Hit(ctx context.Context, key string, l Limit) (Result, error) {
+	if l.Requests <= 0 || l.Window <= 0 {
+		return Result{Limit: l, Allowed: true}, fmt.Errorf("invalid limit %d per %s", l.Requests, l.Window)
+	}
+	now := time.Now()
+	start := now.Truncate(l.Window)
+
+	prev, cur, err := current.counts(ctx, key, l.Window, start)
+	if err != nil && current != memory {
+		slog.Warn("rate limit store failed, counting in memory", "key", key, "err", err)
+		prev, cur, err = memory.counts(ctx, key, l.Window, start)
+	}
+	if err != nil {
+		return Result{Limit: l, Allowed: true}, err
+	}
+
+	elapsed := now.Sub(start)
+	weight := float64(l.Window-elapsed) / float64(l.Window)
+	count := int(math.Ceil(float64(prev)*weight)) + int(cur)
+	return Result{
+		Limit:     l,
+		Remaining: max(l.Requests-count, 0),
+		Reset:     l.Window - elapsed,
+		Allowed:   count <= l.Requests,
+	}, nil
+}
+
+type redisStore struct{}
+
+func (redisStore) counts(ctx context.Context, key string, window time.Duration, start time.Time) (int64, int64, error) {
+	// the current window is needed for the next one too
+	cur, err := cache.Incr(ctx, windowKey(key, start), 2*window)
+	if err != nil {
+		return 0, 0, fmt.Errorf("counting request: %w", err)
+	}
+	v, err := cache.Get(ctx, windowKey(key, start.Add(-window)))
+	if errors.Is(err, redis.Nil) {
+		return 0, cur, nil
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting previous window: %w", err)
+	}
+	prev, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("previous window: %w", err)
+	}
+	return prev, cur, nil
+}
+
+func windowKey(key string, start time.Time) string {
+	return fmt.Sprintf("ratelimit:%s:%d", key, start.Unix())
+}
+
+type memoryWindow struct {
+	start     time.Time
+	window    time.Duration
+	prev, cur int64
+}
+
+type memoryStore struct {
+	mu      sync.Mutex
+	windows map[string]*memoryWindow
+	swept   time.Time
+}
+
+func (m *memoryStore) counts(_ context.Context, key string, window time.Duration, start time.Time) (int64, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(time.Now())
+
+	w, ok := m.windows[key]
+	switch {
+	case !ok || w.window != window || start.Sub(w.start) > window:
+		w = &memoryWindow{start: start, window: window}
+		m.windows[key] = w
+	case start.Sub(w.start) == window:
+		w.start, w.prev, w.cur = start, w.cur, 0
+	}
+	w.cur++
+	return w.prev, w.cur, nil
+}
+
+// sweep forgets the windows that can't be the previous one anymore, at most once a minute.
+func (m *memoryStore) sweep(now time.Time) {
+	if now.Sub(m.swept) < time.Minute {
+		return
+	}
+	m.swept = now
+	for key, w := range m.windows {
+		if now.Sub(w.start) > 2*w.window {
+			delete(m.windows, key)
+		}
+	}
+}
@@ -32,4 +32,13 @@ fi
 
 vault kv put keylock/encryption enc_key="$ENC_KEY"
 
+# only needed with mode = "signed" in [sessions]
+if [ -n "$SESSION_SIGNING_KEY" ]; then
+  if ! echo "$SESSION_SIGNING_KEY" | grep -Eq '^[a-fA-F0-9]{64}$'; then
+    echo "SESSION_SIGNING_KEY must be exactly 64 hex characters"
+    exit 1
+  fi
+  vault kv put keylock/sessions signing_key="$SESSION_SIGNING_KEY"
+fi
+
 echo "Secrets written to Vault."
@@ -1,26 +1,49 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
+	"slices"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/kdf"
 )
 
+// credentialsErr makes bad credentials from a client (see kdf.Credentials) a 400.
+func credentialsErr(err error) error {
+	if errors.Is(err, kdf.ErrMalformedCredentials) || errors.Is(err, database.ErrOutdatedKDF) || errors.Is(err, database.ErrMigrationRekey) {
+		return api.Validation(err)
+	}
+	return err
+}
+
+func APIPrelogin(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.PreloginRequest) (*api.PreloginResponse, error) {
+		account, err := s.db.Prelogin(c.UserContext(), req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		return &api.PreloginResponse{Body: account}, nil
+	})
+}
+
 func APINewAccount(s *Server) fiber.Handler {
 	return api.Handler(func(c *fiber.Ctx, req *api.NewAccountRequest) (*api.NewAccountResponse, error) {
-		id, sessionCode, code, err := s.db.SaveUser(req.Body.Name, req.Body.MasterPassword)
+		id, err := s.db.SaveUser(c.UserContext(), req.Body.Name, req.Body.Credentials)
 		if err != nil {
-			if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			if errors.Is(err, database.ErrUserExists) {
 				slog.Warn("user already exists", "name", req.Body.Name)
-				return nil, fmt.Errorf("user already exists with name \"%s\"", req.Body.Name)
+				return nil, api.Conflict(err)
 			}
-			return nil, err
+			return nil, credentialsErr(err)
 		}
 
-		sessionID, err := newSessionForUser(id)
+		// a new account can't have two-factor or a session policy of its own yet
+		sessionID, refresh, err := newSessionForUser(c, &database.User{ID: id}, false, req.Body.Device)
 		if err != nil {
 			return nil, fmt.Errorf("session: %w", err)
 		}
@@ -29,11 +52,64 @@ func APINewAccount(s *Server) fiber.Handler {
 		return &api.NewAccountResponse{
 			Cookies: api.NewAccountResponseCookies{
 				Session: sessionID,
+				Refresh: refresh,
 			},
 			Body: api.NewAccountResponseBody{
+				UserID:     id,
+				CodeFormat: req.Body.CodeFormat,
+			},
+		}, nil
+	})
+}
+
+func APILogin(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.LoginRequest) (*api.LoginResponse, error) {
+		var id int64
+		var upgraded, passkey bool
+		err := s.guardLogin(c, req.Body.Name, func() (int64, error) {
+			var err error
+			factor := database.SecondFactor{TOTP: req.Body.TOTP, RecoveryCode: req.Body.RecoveryCode}
+			if req.Body.Passkey != nil {
+				if err := s.passkeyFactor(c, req.Body.Name, req.Body.Passkey); err != nil {
+					return 0, err
+				}
+				factor.Passkey, passkey = true, true
+			}
+			id, upgraded, err = s.db.LoginUser(c.UserContext(), req.Body.Name, req.Body.LoginKey, req.Body.Key2, req.Body.MasterPassword, req.Body.Rekey, factor)
+			return id, err
+		})
+		if err != nil {
+			return nil, twoFactorErr(credentialsErr(err))
+		}
+
+		user, err := s.db.GetUserByID(c.UserContext(), id)
+		if err != nil {
+			return nil, err
+		}
+
+		if user.TwoFactor && req.Body.TOTP == "" && req.Body.RecoveryCode != "" {
+			s.db.Audit(c.UserContext(), id, database.AuditRecoveryCodeUsed, c.IP(), "")
+		}
+
+		// LoginUser doesn't let users with two-factor in without it, and a passkey on top of the master password is
+		// two factors whether or not they have it
+		twoFactor := user.TwoFactor || passkey
+		sessionID, refresh, err := newSessionForUser(c, user, twoFactor, req.Body.Device)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+
+		slog.Info("user logged in", "name", req.Body.Name, "id", id, "rekeyed", upgraded, "two_factor", twoFactor)
+		return &api.LoginResponse{
+			Cookies: api.LoginResponseCookies{
+				Session: sessionID,
+				Refresh: refresh,
+			},
+			Body: api.LoginResponseBody{
 				UserID:      id,
-				SessionCode: sessionCode,
-				Code:        code,
+				CodeFormat:  user.CodeFormat,
+				CodeChanged: upgraded,
+				TwoFactor:   twoFactor,
 			},
 		}, nil
 	})
@@ -41,23 +117,50 @@ func APINewAccount(s *Server) fiber.Handler {
 
 func APINewPassword(s *Server) fiber.Handler {
 	return api.Handler(func(c *fiber.Ctx, req *api.NewPasswordRequest) (*api.NewPasswordResponse, error) {
-		user := getUser(c)
-
-		err := s.db.SavePassword(user.ID, req.Body.Name, req.Body.Key2, req.Body.Value)
+		p, err := s.authorize(c, authz.Write, 0, req.Body.Name)
 		if err != nil {
-			fmt.Println(err.Error())
 			return nil, err
 		}
-		slog.Info("saved password", "name", req.Body.Name, "user_id", user.ID)
+		if p.Token != 0 {
+			// the owner's copy of the data key needs key2
+			return nil, api.Forbidden(errTokenNewPassword)
+		}
+
+		err = s.guardCode(c, func() error {
+			return s.db.SavePassword(c.UserContext(), p.UserID, req.Body.Vault, req.Body.Name, req.Body.Key2, req.Body.VaultCode, req.Body.Value)
+		})
+		if err != nil {
+			return nil, vaultErr(err)
+		}
+		slog.Info("saved password", "name", req.Body.Name, "user_id", p.UserID, "vault", req.Body.Vault)
 		return &api.NewPasswordResponse{}, nil
 	})
 }
 
 func APIRetrievePassword(s *Server) fiber.Handler {
 	return api.Handler(func(c *fiber.Ctx, req *api.RetrievePasswordRequest) (*api.RetrievePasswordResponse, error) {
-		val, err := s.db.RetrievePassword(req.Body.UserID, req.Body.Name, req.Body.Key2)
+		p, err := s.authorize(c, authz.Read, req.Body.UserID, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		ownerID := req.Body.UserID
+		if ownerID == 0 {
+			ownerID = p.UserID
+		}
+
+		var val []byte
+		if t := accessToken(c); t != nil {
+			// the token is the key, there's no code to guess
+			val, err = s.db.RetrievePasswordWithToken(c.UserContext(), t, req.Body.Name)
+		} else {
+			err = s.guardCode(c, func() (err error) {
+				val, err = s.db.RetrievePassword(c.UserContext(), p.UserID, ownerID, req.Body.Name, req.Body.Key2, req.Body.VaultCode)
+				return
+			})
+		}
 		if err != nil {
-			return nil, fmt.Errorf("password not found or incorrect code: %w", err)
+			return nil, vaultErr(err)
 		}
 		return &api.RetrievePasswordResponse{
 			Body: api.RetrievePasswordResponseBody{
@@ -67,14 +170,44 @@ func APIRetrievePassword(s *Server) fiber.Handler {
 	})
 }
 
+func APIDeletePassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DeletePasswordRequest) (*api.DeletePasswordResponse, error) {
+		p, err := s.authorize(c, authz.Delete, 0, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.db.DeletePassword(c.UserContext(), p.UserID, req.Body.Name); err != nil {
+			if errors.Is(err, database.ErrPasswordNotFound) {
+				return nil, api.NotFound(database.ErrPasswordNotFound)
+			}
+			return nil, err
+		}
+		slog.Info("deleted password", "name", req.Body.Name, "user_id", p.UserID)
+		return &api.DeletePasswordResponse{}, nil
+	})
+}
+
 func APIListPasswords(s *Server) fiber.Handler {
 	return api.Handler(func(c *fiber.Ctx, req *api.ListPasswordsRequest) (*api.ListPasswordsResponse, error) {
-		user := getUser(c)
-		passwords, err := s.db.ListPasswords(user.ID)
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if p.Token != 0 && !authz.CanList(p.Scopes) {
+			return nil, api.Forbidden(errTokenList)
+		}
+		passwords, err := s.db.ListPasswords(c.UserContext(), p.UserID, req.Vault)
 		if err != nil {
 			return nil, fmt.Errorf("list passwords: %w", err)
 		}
-		slog.Info("listing passwords", "user_id", user.ID, "count", len(passwords))
+		if p.Token != 0 {
+			// tokens only ever see their user's own entries
+			passwords = slices.DeleteFunc(passwords, func(pwd database.Password) bool {
+				return pwd.UserID != p.UserID
+			})
+		}
+		slog.Info("listing passwords", "user_id", p.UserID, "count", len(passwords))
 		return &api.ListPasswordsResponse{
 			Body: api.ListPasswordsResponseBody{
 				Passwords: passwords,
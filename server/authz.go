@@ -0,0 +1,79 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// principal resolves who's acting from the session (SessionMiddleware puts the user in c.Locals("user")).
+// nothing in a request body decides who the principal is. if the server requires two-factor, sessions that
+// didn't pass it aren't a principal for anything (see twofactor.go for what they can still do).
+//
+// an access token (only on the routes that allow them, see server.go) is a principal limited to its scopes.
+func principal(c *fiber.Ctx) (authz.Principal, error) {
+	user, ok := c.Locals("user").(*database.User)
+	if !ok || user == nil {
+		return authz.Principal{}, api.Unauthorized(authz.ErrUnauthenticated)
+	}
+	if t := accessToken(c); t != nil {
+		return authz.Principal{UserID: user.ID, Token: t.ID, Scopes: t.Scopes}, nil
+	}
+	if config.DefaultConfig.TwoFactor.Required && !sessionTwoFactor(c) {
+		return authz.Principal{}, api.Forbidden(errTwoFactorPolicy)
+	}
+	return authz.Principal{UserID: user.ID}, nil
+}
+
+// authorize is the access check every handler that touches a password entry goes through. it resolves the
+// principal from the session and checks that they may do action on the entry called name in ownerID's vault
+// (ownerID 0 means the principal's own vault). denied attempts are audited.
+func (s *Server) authorize(c *fiber.Ctx, action authz.Action, ownerID int64, name string) (authz.Principal, error) {
+	p, err := principal(c)
+	if err != nil {
+		return p, err
+	}
+	if ownerID == 0 {
+		ownerID = p.UserID
+	}
+	if p.Token != 0 {
+		return p, s.authorizeToken(c, p, action, ownerID, name)
+	}
+
+	perm, err := s.db.PasswordPermission(c.UserContext(), p.UserID, ownerID, name)
+	if err != nil {
+		return p, fmt.Errorf("permission: %w", err)
+	}
+	if err := authz.Check(perm, action); err != nil {
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessDenied, c.IP(), fmt.Sprintf("%s %q owned by user %d", action, name, ownerID))
+		if errors.Is(err, authz.ErrNotFound) {
+			return p, api.NotFound(database.ErrPasswordNotFound)
+		}
+		return p, api.Forbidden(err)
+	}
+	return p, nil
+}
+
+// authorizeToken is authorize for an access token: only its user's own entries, and only what its scopes allow.
+func (s *Server) authorizeToken(c *fiber.Ctx, p authz.Principal, action authz.Action, ownerID int64, name string) error {
+	if ownerID != p.UserID {
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessDenied, c.IP(), fmt.Sprintf("access token %d: %s %q owned by user %d", p.Token, action, name, ownerID))
+		return api.NotFound(database.ErrPasswordNotFound)
+	}
+	if err := authz.CheckScopes(p.Scopes, action, name); err != nil {
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessDenied, c.IP(), fmt.Sprintf("access token %d: %s %q", p.Token, action, name))
+		return api.Forbidden(err)
+	}
+	return nil
+}
+
+// accessToken is the access token the request came with, nil for a session.
+func accessToken(c *fiber.Ctx) *database.AccessToken {
+	t, _ := c.Locals("access_token").(*database.AccessToken)
+	return t
+}
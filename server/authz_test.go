@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/server/middlewares"
+)
+
+func passwordsApp(s *Server) *fiber.App {
+	app := fiber.New()
+	session := middlewares.SessionMiddleware(s.db, true)
+	app.Post("/api/passwords/retrieve", session, APIRetrievePassword(s))
+	app.Post("/api/passwords/update", session, APIUpdatePassword(s))
+	app.Post("/api/passwords/delete", session, APIDeletePassword(s))
+	app.Post("/api/orgs/passwords/new", session, APINewOrgPassword(s))
+	app.Post("/api/orgs/passwords/retrieve", session, APIRetrieveOrgPassword(s))
+	return app
+}
+
+// alice has an entry called "bank", bob is someone else
+func aliceAndBob(t *testing.T) (*Server, *fiber.App, testUser, testUser) {
+	t.Helper()
+	s := testServer(t)
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+	if err := s.db.SavePassword(context.Background(), alice.ID, "", "bank", alice.key2, "", "alice's pin"); err != nil {
+		t.Fatalf("save password: %v", err)
+	}
+	return s, passwordsApp(s), alice, bob
+}
+
+func wantStatus(t *testing.T, what string, status int, body []byte, want int) {
+	t.Helper()
+	if status != want {
+		t.Fatalf("%s: status %d (%s), want %d", what, status, body, want)
+	}
+}
+
+// aliceStillHas checks that alice's entry wasn't touched
+func aliceStillHas(t *testing.T, app *fiber.App, alice testUser, value string) {
+	t.Helper()
+	status, body := call(t, app, alice, http.MethodPost, "/api/passwords/retrieve", map[string]any{"name": "bank", "key2": alice.key2})
+	wantStatus(t, "alice retrieving her entry", status, body, http.StatusOK)
+	var resp api.RetrievePasswordResponseBody
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Value != value {
+		t.Fatalf("alice's entry is %q, want %q", resp.Value, value)
+	}
+}
+
+func TestOtherUsersEntryIsNotFound(t *testing.T) {
+	_, app, alice, bob := aliceAndBob(t)
+
+	status, body := call(t, app, bob, http.MethodPost, "/api/passwords/retrieve", map[string]any{"user_id": alice.ID, "name": "bank", "key2": bob.key2})
+	wantStatus(t, "bob retrieving alice's entry", status, body, http.StatusNotFound)
+	status, body = call(t, app, bob, http.MethodPost, "/api/passwords/update", map[string]any{"user_id": alice.ID, "name": "bank", "key2": bob.key2, "value": "bob's pin"})
+	wantStatus(t, "bob updating alice's entry", status, body, http.StatusNotFound)
+
+	// the same as an entry that doesn't exist, so bob can't tell what alice has
+	status, body = call(t, app, bob, http.MethodPost, "/api/passwords/retrieve", map[string]any{"user_id": alice.ID, "name": "nothing", "key2": bob.key2})
+	wantStatus(t, "bob retrieving an entry alice doesn't have", status, body, http.StatusNotFound)
+
+	aliceStillHas(t, app, alice, "alice's pin")
+}
+
+func TestReadOnlyShareCantUpdateOrDelete(t *testing.T) {
+	s, app, alice, bob := aliceAndBob(t)
+	if err := s.db.SharePassword(context.Background(), alice.ID, "bank", alice.key2, "", "bob", authz.ReadOnly); err != nil {
+		t.Fatalf("share: %v", err)
+	}
+
+	status, body := call(t, app, bob, http.MethodPost, "/api/passwords/retrieve", map[string]any{"user_id": alice.ID, "name": "bank", "key2": bob.key2})
+	wantStatus(t, "bob reading the shared entry", status, body, http.StatusOK)
+
+	status, body = call(t, app, bob, http.MethodPost, "/api/passwords/update", map[string]any{"user_id": alice.ID, "name": "bank", "key2": bob.key2, "value": "bob's pin"})
+	wantStatus(t, "bob updating a read-only share", status, body, http.StatusForbidden)
+
+	// delete has no owner, it's only ever the principal's own entry
+	status, body = call(t, app, bob, http.MethodPost, "/api/passwords/delete", map[string]any{"user_id": alice.ID, "name": "bank"})
+	wantStatus(t, "bob deleting a read-only share", status, body, http.StatusNotFound)
+
+	aliceStillHas(t, app, alice, "alice's pin")
+}
+
+func TestBodyUserIDDoesntChangePrincipal(t *testing.T) {
+	_, app, alice, bob := aliceAndBob(t)
+
+	// even with alice's code, bob is bob: the session decides who's asking, user_id only says whose entry it is
+	status, body := call(t, app, bob, http.MethodPost, "/api/passwords/retrieve", map[string]any{"user_id": alice.ID, "name": "bank", "key2": alice.key2})
+	wantStatus(t, "bob retrieving alice's entry with her code", status, body, http.StatusNotFound)
+	status, body = call(t, app, bob, http.MethodPost, "/api/passwords/update", map[string]any{"user_id": alice.ID, "name": "bank", "key2": alice.key2, "value": "bob's pin"})
+	wantStatus(t, "bob updating alice's entry with her code", status, body, http.StatusNotFound)
+
+	// and without a session there's no principal at all
+	status, body = call(t, app, testUser{}, http.MethodPost, "/api/passwords/retrieve", map[string]any{"user_id": alice.ID, "name": "bank", "key2": alice.key2})
+	wantStatus(t, "retrieving without a session", status, body, http.StatusUnauthorized)
+
+	aliceStillHas(t, app, alice, "alice's pin")
+}
+
+func TestRemovedOrgMemberLosesAccess(t *testing.T) {
+	s, app, alice, bob := aliceAndBob(t)
+	ctx := context.Background()
+	org, err := s.db.CreateOrganization(ctx, alice.ID, "acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.CreateCollection(ctx, org.ID, "infra"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.AddOrgMember(ctx, org.ID, alice.ID, alice.key2, "bob", authz.RoleMember); err != nil {
+		t.Fatalf("add bob: %v", err)
+	}
+	if err := s.db.SaveCollectionPassword(ctx, org.ID, "infra", alice.ID, "db", alice.key2, "root"); err != nil {
+		t.Fatal(err)
+	}
+
+	retrieve := map[string]any{"org": "acme", "collection": "infra", "name": "db", "key2": bob.key2}
+	status, body := call(t, app, bob, http.MethodPost, "/api/orgs/passwords/retrieve", retrieve)
+	wantStatus(t, "bob reading as a member", status, body, http.StatusOK)
+
+	if err := s.db.RemoveOrgMember(ctx, org.ID, alice.ID, alice.key2, bob.ID); err != nil {
+		t.Fatalf("remove bob: %v", err)
+	}
+
+	status, body = call(t, app, bob, http.MethodPost, "/api/orgs/passwords/retrieve", retrieve)
+	wantStatus(t, "bob reading after he was removed", status, body, http.StatusNotFound)
+	status, body = call(t, app, bob, http.MethodPost, "/api/orgs/passwords/new",
+		map[string]any{"org": "acme", "collection": "infra", "name": "backdoor", "key2": bob.key2, "value": "x"})
+	wantStatus(t, "bob adding an entry after he was removed", status, body, http.StatusNotFound)
+}
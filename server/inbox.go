@@ -0,0 +1,153 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/server/middlewares"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+const maxInboxLabel = 200
+
+var errTooManyDrops = errors.New("too many secrets dropped into this inbox, try again later")
+
+func APIOpenInbox(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.OpenInboxRequest) (*api.OpenInboxResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		token, err := s.db.OpenInbox(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, inboxErr(err)
+		}
+		slog.Info("opened inbox", "user_id", p.UserID)
+		return &api.OpenInboxResponse{
+			Body: api.OpenInboxResponseBody{
+				Token: token,
+			},
+		}, nil
+	})
+}
+
+func APICloseInbox(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.CloseInboxRequest) (*api.CloseInboxResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.CloseInbox(c.UserContext(), p.UserID); err != nil {
+			return nil, err
+		}
+		slog.Info("closed inbox", "user_id", p.UserID)
+		return &api.CloseInboxResponse{}, nil
+	})
+}
+
+func APIListInbox(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListInboxRequest) (*api.ListInboxResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		items, err := s.db.ListInbox(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListInboxResponse{
+			Body: api.ListInboxResponseBody{
+				Items: items,
+			},
+		}, nil
+	})
+}
+
+func APIFileInboxItem(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.FileInboxItemRequest) (*api.FileInboxItemResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		err = s.guardCode(c, func() error {
+			return s.db.FileInboxItem(c.UserContext(), p.UserID, req.Body.ID, req.Body.Key2, req.Body.Vault, req.Body.VaultCode, req.Body.Name)
+		})
+		if err != nil {
+			return nil, inboxErr(err)
+		}
+		slog.Info("filed inbox item", "id", req.Body.ID, "name", req.Body.Name, "vault", req.Body.Vault, "user_id", p.UserID)
+		return &api.FileInboxItemResponse{}, nil
+	})
+}
+
+func APIDiscardInboxItem(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DiscardInboxItemRequest) (*api.DiscardInboxItemResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.DiscardInboxItem(c.UserContext(), p.UserID, req.Body.ID); err != nil {
+			return nil, inboxErr(err)
+		}
+		slog.Info("discarded inbox item", "id", req.Body.ID, "user_id", p.UserID)
+		return &api.DiscardInboxItemResponse{}, nil
+	})
+}
+
+func APIGetInbox(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.GetInboxRequest) (*api.GetInboxResponse, error) {
+		inbox, err := s.db.GetInbox(c.UserContext(), req.Body.Token)
+		if err != nil {
+			return nil, inboxErr(err)
+		}
+		return &api.GetInboxResponse{
+			Body: api.GetInboxResponseBody{
+				Inbox: *inbox,
+			},
+		}, nil
+	})
+}
+
+func APIDropInboxItem(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DropInboxItemRequest) (*api.DropInboxItemResponse, error) {
+		limits := config.DefaultConfig.Inbox
+		switch {
+		case len(req.Body.Sealed) > limits.MaxSize:
+			return nil, api.TooLarge(fmt.Errorf("a secret can be at most %d bytes", limits.MaxSize))
+		case len(req.Body.Label) > maxInboxLabel:
+			return nil, api.Validation(fmt.Errorf("the label can be at most %d characters", maxInboxLabel))
+		}
+		// the ip limit is middlewares.RateLimit's, the user limit of the route counts drops into this inbox. tokens
+		// without an inbox aren't counted so made up ones don't fill the store.
+		if _, err := s.db.GetInbox(c.UserContext(), req.Body.Token); err != nil {
+			return nil, inboxErr(err)
+		}
+		if !middlewares.Limit(c, "inbox:"+req.Body.Token) {
+			return nil, api.RateLimited(errTooManyDrops, 0)
+		}
+
+		if err := s.db.DropInboxItem(c.UserContext(), req.Body.Token, req.Body.Label, req.Body.Sealed, limits.MaxPending); err != nil {
+			return nil, inboxErr(err)
+		}
+		slog.Info("dropped inbox item", "ip", c.IP(), "label", req.Body.Label)
+		return &api.DropInboxItemResponse{}, nil
+	})
+}
+
+// inboxErr is vaultErr plus the inbox errors.
+func inboxErr(err error) error {
+	switch {
+	case errors.Is(err, database.ErrInboxNotFound), errors.Is(err, database.ErrInboxItemNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, database.ErrInboxFull):
+		return api.Conflict(err)
+	case errors.Is(err, utils.ErrSealedTooShort):
+		return api.Validation(err)
+	}
+	return vaultErr(err)
+}
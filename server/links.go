@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+func APINewLink(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewLinkRequest) (*api.NewLinkResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		limits := config.DefaultConfig.Links
+		switch {
+		case req.Body.MaxViews > limits.MaxViews:
+			return nil, api.Validation(fmt.Errorf("a link can be opened at most %d times", limits.MaxViews))
+		case req.Body.ExpiresIn > limits.MaxTTL:
+			return nil, api.Validation(fmt.Errorf("a link can live at most %s", time.Duration(limits.MaxTTL)*time.Second))
+		case len(req.Body.Ciphertext) > limits.MaxSize:
+			return nil, api.TooLarge(fmt.Errorf("a link can hold at most %d bytes", limits.MaxSize))
+		}
+
+		link, err := s.db.CreateLink(c.UserContext(), p.UserID, req.Body.Ciphertext, req.Body.Nonce, req.Body.Verifier, req.Body.Passphrase,
+			req.Body.MaxViews, time.Duration(req.Body.ExpiresIn)*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		// the id is as good as the ciphertext, don't log it
+		slog.Info("created one-time link", "user_id", p.UserID, "views", link.ViewsLeft, "expires_at", link.ExpiresAt)
+		return &api.NewLinkResponse{
+			Body: api.NewLinkResponseBody{
+				ID:        link.ID,
+				ExpiresAt: link.ExpiresAt,
+			},
+		}, nil
+	})
+}
+
+func APIOpenLink(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.OpenLinkRequest) (*api.OpenLinkResponse, error) {
+		link, err := s.db.OpenLink(c.UserContext(), req.Body.ID, req.Body.Verifier)
+		if err != nil {
+			switch {
+			case errors.Is(err, database.ErrLinkNotFound):
+				return nil, api.NotFound(err)
+			case errors.Is(err, database.ErrLinkKey):
+				return nil, api.InvalidCredentials(err)
+			}
+			return nil, err
+		}
+		slog.Info("opened one-time link", "ip", c.IP(), "views_left", link.ViewsLeft)
+		return &api.OpenLinkResponse{
+			Body: api.OpenLinkResponseBody{
+				Link: *link,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/lockout"
+	"github.com/tiredkangaroo/keylock/sessions"
+)
+
+var errCodeLocked = errors.New("too many incorrect codes, log in with your master password to unlock")
+
+// guardCode runs fn (anything that checks a key2 or a two-factor code) under the code lockout. a wrong code counts against both the
+// user and the session. once either is locked the session is deleted and the user has to log in with their
+// master password again (see guardLogin) before any code is accepted. the attempt is counted before fn runs (see
+// lockout.Counter.Attempt) and taken back if the code was right.
+func (s *Server) guardCode(c *fiber.Ctx, fn func() error) error {
+	ctx := c.UserContext()
+	user := getUser(c)
+	session := getSessionID(c)
+	counter := lockout.Code()
+	subjects := []string{lockout.User(user.ID), lockout.Session(sessions.StableID(session))}
+
+	if err := counter.Attempt(ctx, subjects...); err != nil {
+		if errors.Is(err, lockout.ErrLocked) {
+			return api.Locked(errCodeLocked, 0)
+		}
+		return lockoutErr(err)
+	}
+
+	err := fn()
+	switch {
+	case errors.Is(err, database.ErrInvalidCode), errors.Is(err, database.ErrInvalidTwoFactor):
+		s.db.Audit(ctx, user.ID, database.AuditCodeFailed, c.IP(), "")
+		locked, lerr := counter.Fail(ctx, subjects...)
+		if lerr != nil {
+			return fmt.Errorf("lockout: %w", lerr)
+		}
+		if locked {
+			s.db.Audit(ctx, user.ID, database.AuditCodeLocked, c.IP(), "")
+			if err := sessions.RevokeID(ctx, user.ID, session); err != nil {
+				slog.Error("deleting locked session", "user_id", user.ID, "err", err)
+			}
+			return api.Locked(errCodeLocked, 0)
+		}
+		return api.InvalidCredentials(err)
+	case err == nil:
+		if err := counter.Reset(ctx, lockout.Session(sessions.StableID(session))); err != nil {
+			slog.Error("resetting session code failures", "user_id", user.ID, "err", err)
+		}
+		if err := counter.Release(ctx, lockout.User(user.ID)); err != nil {
+			slog.Error("releasing code attempt", "user_id", user.ID, "err", err)
+		}
+	default:
+		// not a wrong code (not found, no access...)
+		if err := counter.Release(ctx, subjects...); err != nil {
+			slog.Error("releasing code attempt", "user_id", user.ID, "err", err)
+		}
+	}
+	return err
+}
+
+// guardLogin runs fn (anything that checks a master password) under the login lockout, counted against the
+// account name and the ip. fn returns the id of the user on success, which unlocks their code.
+func (s *Server) guardLogin(c *fiber.Ctx, name string, fn func() (int64, error)) error {
+	ctx := c.UserContext()
+	id, err := s.guardSignIn(c, name, fn)
+	if err != nil {
+		return err
+	}
+	// the master password is what unlocks a locked code
+	if err := lockout.Code().Check(ctx, lockout.User(id)); err != nil {
+		s.db.Audit(ctx, id, database.AuditCodeUnlocked, c.IP(), "")
+	}
+	if err := lockout.Code().Reset(ctx, lockout.User(id)); err != nil {
+		slog.Error("resetting code failures", "user_id", id, "err", err)
+	}
+	return nil
+}
+
+// guardSignIn is guardLogin without unlocking the code, for sign ins that don't involve the master password
+// (single sign-on).
+func (s *Server) guardSignIn(c *fiber.Ctx, name string, fn func() (int64, error)) (int64, error) {
+	ctx := c.UserContext()
+	counter := lockout.Login()
+	subjects := []string{lockout.Name(name), lockout.IP(c.IP())}
+
+	if err := counter.Attempt(ctx, subjects...); err != nil {
+		return 0, lockoutErr(err)
+	}
+
+	id, err := fn()
+	// a wrong two-factor code counts like a wrong master password, otherwise codes could be guessed for free
+	if errors.Is(err, database.ErrInvalidCredentials) || errors.Is(err, database.ErrInvalidTwoFactor) {
+		event := database.AuditLoginFailed
+		if errors.Is(err, database.ErrInvalidTwoFactor) {
+			event = database.AuditTwoFactorFailed
+		}
+		s.db.Audit(ctx, 0, event, c.IP(), "name: "+name)
+		locked, lerr := counter.Fail(ctx, subjects...)
+		if lerr != nil {
+			return 0, fmt.Errorf("lockout: %w", lerr)
+		}
+		if locked {
+			s.db.Audit(ctx, 0, database.AuditLoginLocked, c.IP(), "name: "+name)
+		}
+		return 0, api.InvalidCredentials(err)
+	}
+	if err != nil {
+		// not a wrong password (two-factor code required...)
+		if rerr := counter.Release(ctx, subjects...); rerr != nil {
+			slog.Error("releasing login attempt", "name", name, "err", rerr)
+		}
+		return 0, err
+	}
+
+	s.db.Audit(ctx, id, database.AuditLogin, c.IP(), "")
+	if err := counter.Reset(ctx, lockout.Name(name)); err != nil {
+		slog.Error("resetting login failures", "user_id", id, "err", err)
+	}
+	if err := counter.Release(ctx, lockout.IP(c.IP())); err != nil {
+		slog.Error("releasing login attempt", "user_id", id, "err", err)
+	}
+	return id, nil
+}
+
+// lockoutErr turns a *lockout.Error into a 423 (locked) or 429 (backoff) with Retry-After.
+func lockoutErr(err error) error {
+	var lerr *lockout.Error
+	if !errors.As(err, &lerr) {
+		return err
+	}
+	if errors.Is(lerr, lockout.ErrLocked) {
+		return api.Locked(lerr, lerr.RetryAfter)
+	}
+	return api.RateLimited(lerr, lerr.RetryAfter)
+}
@@ -0,0 +1,67 @@
+package middlewares
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+// how often we check if the client is still there
+const disconnectPollInterval = 250 * time.Millisecond
+
+// ContextMiddleware gives every request a context (c.UserContext()) that handlers pass down to the database,
+// cache and vault. it's canceled when the request times out (request_timeout in config) or when the server shuts
+// down, so a slow postgres or redis can't pile up goroutines forever. routes that can take a while also stop when
+// the client goes away, see WatchDisconnect.
+func ContextMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// fasthttp's ctx is done on shutdown
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if t := config.DefaultConfig.RequestTimeout; t > 0 {
+			ctx, cancel = context.WithTimeout(c.Context(), time.Duration(t)*time.Second)
+		} else {
+			ctx, cancel = context.WithCancel(c.Context())
+		}
+		defer cancel()
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+// WatchDisconnect cancels the request's context when the client disconnects. fasthttp doesn't tell handlers when
+// a client goes away, so a goroutine peeks at the socket every disconnectPollInterval for as long as the request
+// runs. that's why it's only on the routes slow enough for it to matter (see server.go). it can only tell on unix
+// and for plain tcp connections (not a tls.Conn), otherwise only the request timeout applies. behind a reverse
+// proxy it watches the proxy's connection, which most proxies close when their client goes away.
+func WatchDisconnect() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithCancel(c.UserContext())
+		defer cancel()
+
+		go watchDisconnect(ctx, c.Context().Conn(), cancel)
+
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
+
+func watchDisconnect(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {
+	ticker := time.NewTicker(disconnectPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if connClosed(conn) {
+				cancel()
+				return
+			}
+		}
+	}
+}
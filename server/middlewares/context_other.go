@@ -0,0 +1,10 @@
+//go:build !unix
+
+package middlewares
+
+import "net"
+
+// connClosed can't peek at sockets here, so only the request timeout applies.
+func connClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package middlewares
+
+import (
+	"net"
+	"syscall"
+)
+
+// connClosed peeks at the socket without consuming anything. a read of 0 bytes means the client closed the
+// connection (it could also mean they only closed their write side, but http clients don't do that mid request).
+func connClosed(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false // e.g. tls, we can't tell
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	closed := false
+	buf := make([]byte, 1)
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, rerr := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+		switch {
+		case rerr == syscall.EAGAIN || rerr == syscall.EWOULDBLOCK: // nothing to read, still connected
+		case rerr != nil: // connection reset etc.
+			closed = true
+		case n == 0:
+			closed = true
+		}
+		return true // never wait for the socket to become readable
+	})
+	return err == nil && closed
+}
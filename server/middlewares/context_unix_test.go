@@ -0,0 +1,46 @@
+//go:build unix
+
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestWatchDisconnect(t *testing.T) {
+	canceled := make(chan struct{})
+	app := fiber.New()
+	app.Use(ContextMiddleware())
+	app.Get("/slow", WatchDisconnect(), func(c *fiber.Ctx) error {
+		select {
+		case <-c.UserContext().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+		}
+		return nil
+	})
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+
+	conn, err := net.Dial("tcp4", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(conn, "GET /slow HTTP/1.1\r\nHost: keylock\r\n\r\n")
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case <-canceled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request's context wasn't canceled after the client went away")
+	}
+}
@@ -0,0 +1,86 @@
+package middlewares
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/ratelimit"
+)
+
+var errTooManyRequests = errors.New("too many requests, try again later")
+
+// RateLimit limits how fast one ip can hit the routes in rate_limit.routes (config). the per user limits are
+// checked by SessionMiddleware, it's the one that knows the user.
+func RateLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		route := routeKey(c)
+		l, ok := config.DefaultConfig.RateLimit.Routes[route]
+		if !ok || l.IP <= 0 {
+			return c.Next()
+		}
+		if !allow(c, route+":ip:"+c.IP(), l.IP, l.Window) {
+			return tooManyRequests(c)
+		}
+		return c.Next()
+	}
+}
+
+// limitUser is RateLimit for the user's limit of the route, false if they're over it.
+func limitUser(c *fiber.Ctx, userid int64) bool {
+	return Limit(c, "user:"+strconv.FormatInt(userid, 10))
+}
+
+// Limit counts the request against the route's user limit for subject, for handlers that limit something other
+// than the session's user (the inbox a secret is dropped into). false if subject is over it, the Retry-After
+// header is set.
+func Limit(c *fiber.Ctx, subject string) bool {
+	route := routeKey(c)
+	l, ok := config.DefaultConfig.RateLimit.Routes[route]
+	if !ok || l.User <= 0 {
+		return true
+	}
+	return allow(c, route+":"+subject, l.User, l.Window)
+}
+
+// routeKey is the path the way fiber matches it (not case sensitive, a trailing slash doesn't matter), so
+// "/API/accounts/new/" can't get around the limit of "/api/accounts/new".
+func routeKey(c *fiber.Ctx) string {
+	path := strings.ToLower(c.Path())
+	if len(path) > 1 {
+		path = strings.TrimSuffix(path, "/")
+	}
+	return path
+}
+
+// allow counts the request against key and sets the RateLimit-* headers. with an ip and a user limit the headers
+// are the one with the fewest requests left. if the limit can't be checked the request goes through.
+func allow(c *fiber.Ctx, key string, requests int, window int64) bool {
+	r, err := ratelimit.Hit(c.UserContext(), key, ratelimit.Limit{Requests: requests, Window: time.Duration(window) * time.Second})
+	if err != nil {
+		slog.Error("rate limit", "key", key, "err", err)
+		return true
+	}
+	if left, err := strconv.Atoi(string(c.Response().Header.Peek("RateLimit-Remaining"))); err != nil || r.Remaining < left {
+		reset := strconv.Itoa(int(math.Ceil(r.Reset.Seconds())))
+		c.Set("RateLimit-Limit", strconv.Itoa(requests))
+		c.Set("RateLimit-Remaining", strconv.Itoa(r.Remaining))
+		c.Set("RateLimit-Reset", reset)
+		c.Set("RateLimit-Policy", strconv.Itoa(requests)+";w="+strconv.FormatInt(window, 10))
+		if !r.Allowed {
+			c.Set(fiber.HeaderRetryAfter, reset)
+		}
+	}
+	return r.Allowed
+}
+
+// allow already set Retry-After
+func tooManyRequests(c *fiber.Ctx) error {
+	return api.SendError(c, api.RateLimited(errTooManyRequests, 0))
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+func TestLimitPerSubject(t *testing.T) {
+	routes := config.DefaultConfig.RateLimit.Routes
+	t.Cleanup(func() { config.DefaultConfig.RateLimit.Routes = routes })
+	config.DefaultConfig.RateLimit.Routes = map[string]config.RouteLimit{
+		"/api/inbox/drop": {IP: 7, User: 3, Window: 60 * 60},
+	}
+
+	app := fiber.New()
+	app.Post("/api/inbox/drop", RateLimit(), func(c *fiber.Ctx) error {
+		if !Limit(c, "inbox:"+c.Query("inbox")) {
+			return api.SendError(c, api.RateLimited(errTooManyRequests, 0))
+		}
+		return c.SendStatus(http.StatusOK)
+	})
+	drop := func(inbox string) *http.Response {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest(http.MethodPost, "/api/inbox/drop?inbox="+inbox, nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for i := range 3 {
+		if resp := drop("a"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("drop %d into a: status %d", i, resp.StatusCode)
+		}
+	}
+	resp := drop("a")
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Fatalf("4th drop into a: status %d, Retry-After %q", resp.StatusCode, resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	// another inbox has its own count, the ip's still goes up
+	for i := range 3 {
+		if resp := drop("b"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("drop %d into b: status %d", i, resp.StatusCode)
+		}
+	}
+	if resp := drop("c"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("8th drop from the ip: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,90 @@
+package middlewares
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+// contentSecurityPolicy keeps pages to our own scripts: the views have no inline scripts or handlers (they're all
+// in web/assets/js), so an injected one doesn't run. and whatever does run can only talk to us, no fetches, images,
+// forms or frames anywhere else, so nothing can send the session code (see web/assets/js/sessioncode.js) off.
+// 'wasm-unsafe-eval' is for keylock.wasm, data: images are the qr codes and the favicon.
+var contentSecurityPolicy = strings.Join([]string{
+	"default-src 'self'",
+	"script-src 'self' 'wasm-unsafe-eval'",
+	"style-src 'self'",
+	"img-src 'self' data:",
+	"connect-src 'self'",
+	"object-src 'none'",
+	"base-uri 'none'",
+	"form-action 'self'",
+	"frame-ancestors 'none'",
+}, "; ")
+
+// two years, browsers only take it over https
+const strictTransportSecurity = "max-age=63072000; includeSubDomains"
+
+// SecurityHeaders sets the headers every response gets. handlers can still override them (the link and pair pages
+// send no referrer at all).
+func SecurityHeaders() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentSecurityPolicy, contentSecurityPolicy)
+		c.Set(fiber.HeaderStrictTransportSecurity, strictTransportSecurity)
+		c.Set(fiber.HeaderXFrameOptions, "DENY") // frame-ancestors for browsers that don't know it
+		c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+		c.Set(fiber.HeaderReferrerPolicy, "same-origin")
+		c.Set("Cross-Origin-Opener-Policy", "same-origin")
+		return c.Next()
+	}
+}
+
+// CSRF refuses state changing requests a browser sends for another site. the session cookie is SameSite=Strict
+// already, this is for browsers that don't do SameSite and for same site (but other origin) pages.
+//
+// browsers say where a request comes from (Origin, and Sec-Fetch-Site on newer ones), so it's checked against our
+// own host (with its port, or x-forwarded-host behind a proxy) and the origins the web client is served from
+// (webauthn.origins in config). a request with neither header isn't from a browser (the cli, curl) and doesn't
+// carry a browser's cookies, so it's let through. that's also why this isn't a double submit token, the cli would
+// have to fetch one first.
+func CSRF() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		if origin := c.Get(fiber.HeaderOrigin); origin != "" {
+			if !allowedOrigin(c, origin) {
+				return csrfFailed(c, "origin", origin)
+			}
+			return c.Next()
+		}
+		switch site := c.Get("Sec-Fetch-Site"); site {
+		case "", "same-origin", "none": // none is the user typing the url or using a bookmark
+			return c.Next()
+		default:
+			return csrfFailed(c, "sec-fetch-site", site)
+		}
+	}
+}
+
+func allowedOrigin(c *fiber.Ctx, origin string) bool {
+	if slices.Contains(config.DefaultConfig.WebAuthn.Origins, origin) {
+		return true
+	}
+	u, err := url.Parse(origin) // "null" (sandboxed frames, some redirects) has no host
+	return err == nil && u.Host != "" && u.Host == c.Hostname()
+}
+
+var errCrossSite = errors.New("cross site request refused")
+
+func csrfFailed(c *fiber.Ctx, header, value string) error {
+	slog.Warn("cross site request refused", "path", c.Path(), header, value)
+	return api.SendError(c, api.Forbidden(errCrossSite))
+}
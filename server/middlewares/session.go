@@ -1,49 +1,105 @@
 package middlewares
 
 import (
+	"errors"
 	"log/slog"
-	"strconv"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/api"
 	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
 )
 
-func SessionMiddleware(db *database.DB) fiber.Handler {
+var errTokenRoute = errors.New("access tokens can only list, read and change passwords")
+
+// SessionMiddleware puts the user of the session in c.Locals("user"). with allowTokens an access token can stand in
+// for a session, only routes whose handlers check its scopes should allow them.
+func SessionMiddleware(db *database.DB, allowTokens bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// NOTE: we should look over login required stuff
 		// we have two ways for session tokens: cookie "session_token" or Authorization header
 		// the cookie takes precedence over the header
-		session_token := c.Cookies("session", c.Get("Authorization"))
+		// an access token (database/tokens.go) comes as "Authorization: Bearer klpat_..." instead of a session
+		header := c.Get("Authorization")
+		if bearer, ok := strings.CutPrefix(header, "Bearer "); ok {
+			if strings.HasPrefix(bearer, database.AccessTokenPrefix) && c.Cookies("session") == "" {
+				if !allowTokens {
+					return api.SendError(c, api.Forbidden(errTokenRoute))
+				}
+				return accessToken(c, db, bearer)
+			}
+			header = bearer
+		}
+		session_token := c.Cookies("session", header)
 		if session_token == "" {
 			slog.Error("no session token found in cookie or Authorization header")
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "unauthorized",
-			})
+			return unauthorized(c)
 		}
-		userid_raw, err := cache.HGet("user-session", session_token)
-		if err != nil {
-			slog.Error("get session token from redis", "err", err)
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "unauthorized",
-			})
+		// a revoked session is gone from redis (or on the revocation list), so this is all it takes to stop it
+		userid, twoFactor, err := sessions.Get(c.UserContext(), session_token)
+		if errors.Is(err, sessions.ErrNotFound) && sessions.Signed() && c.Cookies("refresh") != "" {
+			// signed session tokens don't last long, the browser doesn't have to ask for a new one itself
+			session_token, err = refresh(c, session_token)
+			if err == nil {
+				userid, twoFactor, err = sessions.Get(c.UserContext(), session_token)
+			}
 		}
-		userid, err := strconv.ParseInt(userid_raw, 10, 64)
 		if err != nil {
-			slog.Error("invalid session token, userid stored in redis is not an integer", "err", err) // shouldn't happen but i handle my erros :)
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "unauthorized",
-			})
+			slog.Error("get session", "err", err)
+			return unauthorized(c)
 		}
-		user, err := db.GetUserByID(userid)
+		user, err := db.GetUserByID(c.UserContext(), userid)
 		if err != nil {
 			slog.Error("get user by id from database", "userid", userid, "err", err)
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-				"error": "unauthorized",
-			})
+			return unauthorized(c)
+		}
+		if !limitUser(c, user.ID) {
+			return tooManyRequests(c)
 		}
 		c.Locals("user", user)
+		c.Locals("session", session_token)
+		c.Locals("two_factor", twoFactor)
+		sessions.Touch(c.UserContext(), userid, session_token, c.IP())
 
 		return c.Next()
 	}
 }
+
+// accessToken is SessionMiddleware for an access token. the user is the token's, c.Locals("access_token") is the
+// token (handlers check its scopes, see server/authz.go) and there is no session.
+func accessToken(c *fiber.Ctx, db *database.DB, token string) error {
+	t, err := db.UseAccessToken(c.UserContext(), token)
+	if err != nil {
+		if !errors.Is(err, database.ErrAccessTokenInvalid) {
+			slog.Error("use access token", "err", err)
+		}
+		return unauthorized(c)
+	}
+	user, err := db.GetUserByID(c.UserContext(), t.UserID)
+	if err != nil {
+		slog.Error("get user by id from database", "userid", t.UserID, "err", err)
+		return unauthorized(c)
+	}
+	if !limitUser(c, user.ID) {
+		return tooManyRequests(c)
+	}
+	c.Locals("user", user)
+	c.Locals("session", "")
+	c.Locals("access_token", t)
+	return c.Next()
+}
+
+// refresh gets an expired signed session a new token with the refresh cookie and sets both cookies.
+func refresh(c *fiber.Ctx, session string) (string, error) {
+	session, refresh, err := sessions.Refresh(c.UserContext(), session, c.Cookies("refresh"))
+	if err != nil {
+		return "", err
+	}
+	api.SetSessionCookies(c, session, refresh)
+	return session, nil
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return api.SendError(c, api.Unauthorized(api.ErrUnauthorized))
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// orgMember resolves the principal and their role in the organization called name. organizations the principal
+// isn't a member of don't exist as far as they're concerned. organizations that require two-factor refuse
+// sessions that didn't pass it.
+func (s *Server) orgMember(c *fiber.Ctx, name string) (authz.Principal, *database.Organization, error) {
+	p, err := principal(c)
+	if err != nil {
+		return p, nil, err
+	}
+	org, err := s.db.GetOrganization(c.UserContext(), p.UserID, name)
+	if err != nil {
+		return p, nil, orgErr(err)
+	}
+	if org.RequireTwoFactor && !sessionTwoFactor(c) {
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessDenied, c.IP(), fmt.Sprintf("organization %q requires two-factor", org.Name))
+		return p, nil, api.Forbidden(fmt.Errorf("organization %q requires two-factor authentication, enable it and log in again", org.Name))
+	}
+	return p, org, nil
+}
+
+// orgDenied audits and returns a 403 for something the principal's role doesn't allow.
+func (s *Server) orgDenied(c *fiber.Ctx, p authz.Principal, org *database.Organization, what string) error {
+	s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessDenied, c.IP(), fmt.Sprintf("%s in organization %q as %s", what, org.Name, org.Role))
+	return api.Forbidden(fmt.Errorf("%s: %s can't %s", authz.ErrForbidden, org.Role, what))
+}
+
+// orgEntry is authorize for the passwords in an organization's collections, the role decides.
+func (s *Server) orgEntry(c *fiber.Ctx, action authz.Action, orgName, collection, name string) (authz.Principal, *database.Organization, error) {
+	p, org, err := s.orgMember(c, orgName)
+	if err != nil {
+		return p, nil, err
+	}
+	if err := authz.Check(org.Role.Permission(), action); err != nil {
+		return p, nil, s.orgDenied(c, p, org, fmt.Sprintf("%s %q in %q", action, name, collection))
+	}
+	return p, org, nil
+}
+
+func APINewOrganization(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewOrganizationRequest) (*api.NewOrganizationResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		org, err := s.db.CreateOrganization(c.UserContext(), p.UserID, req.Body.Name)
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		slog.Info("created organization", "name", org.Name, "id", org.ID, "user_id", p.UserID)
+		return &api.NewOrganizationResponse{
+			Body: api.NewOrganizationResponseBody{
+				Organization: *org,
+			},
+		}, nil
+	})
+}
+
+func APIListOrganizations(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListOrganizationsRequest) (*api.ListOrganizationsResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		orgs, err := s.db.ListOrganizations(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListOrganizationsResponse{
+			Body: api.ListOrganizationsResponseBody{
+				Organizations: orgs,
+			},
+		}, nil
+	})
+}
+
+func APIListOrgMembers(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListOrgMembersRequest) (*api.ListOrgMembersResponse, error) {
+		_, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		members, err := s.db.OrgMembers(c.UserContext(), org.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListOrgMembersResponse{
+			Body: api.ListOrgMembersResponseBody{
+				Members: members,
+			},
+		}, nil
+	})
+}
+
+func APIInviteOrgMember(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.InviteOrgMemberRequest) (*api.InviteOrgMemberResponse, error) {
+		p, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		role, err := authz.ParseRole(req.Body.Role)
+		if err != nil {
+			return nil, api.Validation(err)
+		}
+		if !org.Role.CanManage(role) {
+			return nil, s.orgDenied(c, p, org, fmt.Sprintf("invite a %s", role))
+		}
+
+		err = s.guardCode(c, func() error {
+			return s.db.AddOrgMember(c.UserContext(), org.ID, p.UserID, req.Body.Key2, req.Body.Name, role)
+		})
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditOrgInvite, c.IP(), fmt.Sprintf("%s as %s in organization %q", req.Body.Name, role, org.Name))
+		return &api.InviteOrgMemberResponse{}, nil
+	})
+}
+
+func APIRemoveOrgMember(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RemoveOrgMemberRequest) (*api.RemoveOrgMemberResponse, error) {
+		p, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		member, err := s.db.GetOrgMember(c.UserContext(), org.ID, req.Body.Name)
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		// anyone can leave
+		if member.UserID != p.UserID && !org.Role.CanManage(member.Role) {
+			return nil, s.orgDenied(c, p, org, fmt.Sprintf("remove a %s", member.Role))
+		}
+
+		err = s.guardCode(c, func() error {
+			return s.db.RemoveOrgMember(c.UserContext(), org.ID, p.UserID, req.Body.Key2, member.UserID)
+		})
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditOrgRemove, c.IP(), fmt.Sprintf("%s from organization %q", member.Name, org.Name))
+		return &api.RemoveOrgMemberResponse{}, nil
+	})
+}
+
+func APIChangeOrgRole(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ChangeOrgRoleRequest) (*api.ChangeOrgRoleResponse, error) {
+		p, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		role, err := authz.ParseRole(req.Body.Role)
+		if err != nil {
+			return nil, api.Validation(err)
+		}
+		member, err := s.db.GetOrgMember(c.UserContext(), org.ID, req.Body.Name)
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		if !org.Role.CanManage(member.Role) || !org.Role.CanManage(role) {
+			return nil, s.orgDenied(c, p, org, fmt.Sprintf("make a %s a %s", member.Role, role))
+		}
+
+		if err := s.db.SetOrgRole(c.UserContext(), org.ID, member.UserID, role); err != nil {
+			return nil, orgErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditOrgRole, c.IP(), fmt.Sprintf("%s from %s to %s in organization %q", member.Name, member.Role, role, org.Name))
+		return &api.ChangeOrgRoleResponse{}, nil
+	})
+}
+
+func APINewCollection(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewCollectionRequest) (*api.NewCollectionResponse, error) {
+		p, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		if !org.Role.IsAdmin() {
+			return nil, s.orgDenied(c, p, org, "create a collection")
+		}
+		collection, err := s.db.CreateCollection(c.UserContext(), org.ID, req.Body.Name)
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		slog.Info("created collection", "name", collection.Name, "org", org.Name, "user_id", p.UserID)
+		return &api.NewCollectionResponse{
+			Body: api.NewCollectionResponseBody{
+				Collection: *collection,
+			},
+		}, nil
+	})
+}
+
+func APIListCollections(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListCollectionsRequest) (*api.ListCollectionsResponse, error) {
+		_, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		collections, err := s.db.ListCollections(c.UserContext(), org.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListCollectionsResponse{
+			Body: api.ListCollectionsResponseBody{
+				Collections: collections,
+			},
+		}, nil
+	})
+}
+
+func APINewOrgPassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewOrgPasswordRequest) (*api.NewOrgPasswordResponse, error) {
+		p, org, err := s.orgEntry(c, authz.Write, req.Body.Org, req.Body.Collection, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		err = s.guardCode(c, func() error {
+			return s.db.SaveCollectionPassword(c.UserContext(), org.ID, req.Body.Collection, p.UserID, req.Body.Name, req.Body.Key2, req.Body.Value)
+		})
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		slog.Info("saved organization password", "name", req.Body.Name, "collection", req.Body.Collection, "org", org.Name, "user_id", p.UserID)
+		return &api.NewOrgPasswordResponse{}, nil
+	})
+}
+
+func APIRetrieveOrgPassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RetrieveOrgPasswordRequest) (*api.RetrieveOrgPasswordResponse, error) {
+		p, org, err := s.orgEntry(c, authz.Read, req.Body.Org, req.Body.Collection, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		var val []byte
+		err = s.guardCode(c, func() (err error) {
+			val, err = s.db.RetrieveCollectionPassword(c.UserContext(), org.ID, req.Body.Collection, p.UserID, req.Body.Name, req.Body.Key2)
+			return
+		})
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		return &api.RetrieveOrgPasswordResponse{
+			Body: api.RetrieveOrgPasswordResponseBody{
+				Value: string(val),
+			},
+		}, nil
+	})
+}
+
+func APIDeleteOrgPassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DeleteOrgPasswordRequest) (*api.DeleteOrgPasswordResponse, error) {
+		p, org, err := s.orgEntry(c, authz.Delete, req.Body.Org, req.Body.Collection, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.DeleteCollectionPassword(c.UserContext(), org.ID, req.Body.Collection, req.Body.Name); err != nil {
+			return nil, orgErr(err)
+		}
+		slog.Info("deleted organization password", "name", req.Body.Name, "collection", req.Body.Collection, "org", org.Name, "user_id", p.UserID)
+		return &api.DeleteOrgPasswordResponse{}, nil
+	})
+}
+
+func APIListOrgPasswords(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListOrgPasswordsRequest) (*api.ListOrgPasswordsResponse, error) {
+		_, org, err := s.orgEntry(c, authz.Read, req.Body.Org, req.Body.Collection, "*")
+		if err != nil {
+			return nil, err
+		}
+		passwords, err := s.db.ListCollectionPasswords(c.UserContext(), org.ID, req.Body.Collection)
+		if err != nil {
+			return nil, orgErr(err)
+		}
+		return &api.ListOrgPasswordsResponse{
+			Body: api.ListOrgPasswordsResponseBody{
+				Passwords: passwords,
+			},
+		}, nil
+	})
+}
+
+// orgErr gives the database errors about organizations their status codes.
+func orgErr(err error) error {
+	switch {
+	case errors.Is(err, database.ErrOrgNotFound), errors.Is(err, database.ErrMemberNotFound),
+		errors.Is(err, database.ErrCollectionNotFound), errors.Is(err, database.ErrUserNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, database.ErrOrgExists), errors.Is(err, database.ErrCollectionExists),
+		errors.Is(err, database.ErrAlreadyMember), errors.Is(err, database.ErrLastOwner):
+		return api.Conflict(err)
+	}
+	return shareErr(err)
+}
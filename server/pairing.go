@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/skip2/go-qrcode"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
+)
+
+// device pairing gets a new device a session and the session code without typing the master password. the
+// logged in device starts a pairing with a p-256 public key and shows the code (or a qr code of the /pair link).
+// the new device joins with the code and its own public key, and both derive the same key with ecdh. each shows a
+// number derived from it too: the user checks they match (so the server didn't swap the keys) and approves on the
+// logged in device, which seals the session code with that key. the new device claims a fresh session and the
+// sealed session code, which the server can't open.
+//
+// a pairing is kept in redis under "pairing:<code>" until it's claimed or pairingTTL is up. only one device can
+// join (see pairingJoins) and only that device can claim it.
+
+const pairingTTL = 5 * time.Minute
+
+// no 0/O or 1/I, 32 letters is 5 bits each
+const pairingAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	errPairingNotFound  = errors.New("pairing not found or expired, start again on your other device")
+	errPairingNotJoined = errors.New("no device has joined this pairing yet")
+	errPairingKey       = errors.New("public key must be an uncompressed p-256 point")
+)
+
+type pairing struct {
+	UserID    int64     `json:"user_id"`
+	Offer     []byte    `json:"offer"`            // the logged in device's public key
+	Answer    []byte    `json:"answer,omitempty"` // the new device's
+	Device    string    `json:"device,omitempty"`
+	Claim     []byte    `json:"claim,omitempty"` // sha256 of what the new device claims with
+	Approved  bool      `json:"approved"`
+	TwoFactor bool      `json:"two_factor"` // whether the session that approved passed two-factor
+	Sealed    []byte    `json:"sealed,omitempty"`
+	Nonce     []byte    `json:"nonce,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func newPairingCode() string {
+	raw := make([]byte, 8)
+	rand.Read(raw)
+	code := make([]byte, len(raw))
+	for i, b := range raw {
+		code[i] = pairingAlphabet[b%byte(len(pairingAlphabet))]
+	}
+	return string(code)
+}
+
+// normalizePairingCode takes a code however it was typed ("abcd-efgh", "ABCD EFGH").
+func normalizePairingCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToUpper(code))
+}
+
+func pairingKey(code string) string {
+	return "pairing:" + code
+}
+
+// pairingJoins counts the devices that tried to join a pairing, only the first one gets to.
+func pairingJoins(code string) string {
+	return "pairing:" + code + ":joins"
+}
+
+func savePairing(ctx context.Context, code string, p *pairing) error {
+	ttl := time.Until(p.ExpiresAt)
+	if ttl <= 0 {
+		return errPairingNotFound
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("marshal pairing: %w", err)
+	}
+	if err := cache.SetWithExpiration(ctx, pairingKey(code), string(data), ttl); err != nil {
+		return fmt.Errorf("saving pairing: %w", err)
+	}
+	return nil
+}
+
+func parsePairing(data string) (*pairing, error) {
+	var p pairing
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return nil, fmt.Errorf("unmarshal pairing: %w", err)
+	}
+	return &p, nil
+}
+
+func getPairing(ctx context.Context, code string) (*pairing, error) {
+	data, err := cache.Get(ctx, pairingKey(code))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errPairingNotFound
+		}
+		return nil, fmt.Errorf("getting pairing: %w", err)
+	}
+	return parsePairing(data)
+}
+
+// ownPairing is the user's pairing called code, the logged in device's side of it.
+func ownPairing(c *fiber.Ctx, code string) (*pairing, error) {
+	p, err := getPairing(c.UserContext(), code)
+	if err != nil {
+		return nil, pairingErr(err)
+	}
+	if p.UserID != getUser(c).ID {
+		return nil, pairingErr(errPairingNotFound)
+	}
+	return p, nil
+}
+
+func checkPairingKey(key []byte) error {
+	if _, err := ecdh.P256().NewPublicKey(key); err != nil {
+		return errPairingKey
+	}
+	return nil
+}
+
+func pairingErr(err error) error {
+	switch {
+	case errors.Is(err, errPairingNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, errPairingNotJoined):
+		return api.Conflict(err)
+	case errors.Is(err, errPairingKey):
+		return api.Validation(err)
+	}
+	return err
+}
+
+func APINewPairing(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewPairingRequest) (*api.NewPairingResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkPairingKey(req.Body.PublicKey); err != nil {
+			return nil, pairingErr(err)
+		}
+		code := newPairingCode()
+		pr := &pairing{
+			UserID:    p.UserID,
+			Offer:     req.Body.PublicKey,
+			ExpiresAt: time.Now().Add(pairingTTL).UTC(),
+		}
+		if err := savePairing(c.UserContext(), code, pr); err != nil {
+			return nil, err
+		}
+		// the code goes in the fragment so it stays out of logs
+		qr, err := qrcode.Encode(c.BaseURL()+"/pair#"+code, qrcode.Medium, 256)
+		if err != nil {
+			return nil, fmt.Errorf("qr code: %w", err)
+		}
+		return &api.NewPairingResponse{
+			Body: api.NewPairingResponseBody{
+				Code:      code[:4] + "-" + code[4:],
+				QRCode:    qr,
+				ExpiresAt: pr.ExpiresAt,
+			},
+		}, nil
+	})
+}
+
+func APIPairingStatus(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.PairingStatusRequest) (*api.PairingStatusResponse, error) {
+		p, err := ownPairing(c, normalizePairingCode(req.Body.Code))
+		if err != nil {
+			return nil, err
+		}
+		return &api.PairingStatusResponse{
+			Body: api.PairingStatusResponseBody{
+				Joined:    p.Answer != nil,
+				PublicKey: p.Answer,
+				Device:    p.Device,
+			},
+		}, nil
+	})
+}
+
+func APIApprovePairing(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ApprovePairingRequest) (*api.ApprovePairingResponse, error) {
+		if _, err := principal(c); err != nil {
+			return nil, err
+		}
+		code := normalizePairingCode(req.Body.Code)
+		p, err := ownPairing(c, code)
+		if err != nil {
+			return nil, err
+		}
+		if p.Answer == nil {
+			return nil, pairingErr(errPairingNotJoined)
+		}
+		if p.Approved {
+			return &api.ApprovePairingResponse{}, nil
+		}
+		p.Approved = true
+		p.TwoFactor = sessionTwoFactor(c)
+		p.Sealed, p.Nonce = req.Body.Sealed, req.Body.Nonce
+		if err := savePairing(c.UserContext(), code, p); err != nil {
+			return nil, pairingErr(err)
+		}
+		return &api.ApprovePairingResponse{}, nil
+	})
+}
+
+func APIJoinPairing(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.JoinPairingRequest) (*api.JoinPairingResponse, error) {
+		ctx := c.UserContext()
+		code := normalizePairingCode(req.Body.Code)
+		if err := checkPairingKey(req.Body.PublicKey); err != nil {
+			return nil, pairingErr(err)
+		}
+		joins, err := cache.Incr(ctx, pairingJoins(code), pairingTTL)
+		if err != nil {
+			return nil, fmt.Errorf("counting pairing joins: %w", err)
+		}
+		if joins != 1 {
+			return nil, pairingErr(errPairingNotFound)
+		}
+		p, err := getPairing(ctx, code)
+		if err != nil {
+			return nil, pairingErr(err)
+		}
+
+		claim := randomToken()
+		sum := sha256.Sum256([]byte(claim))
+		p.Answer = req.Body.PublicKey
+		p.Claim = sum[:]
+		p.Device = req.Body.Device
+		if p.Device == "" {
+			p.Device = sessions.DeviceName(c.Get(fiber.HeaderUserAgent))
+		}
+		if err := savePairing(ctx, code, p); err != nil {
+			return nil, pairingErr(err)
+		}
+		return &api.JoinPairingResponse{
+			Body: api.JoinPairingResponseBody{
+				PublicKey: p.Offer,
+				Claim:     claim,
+			},
+		}, nil
+	})
+}
+
+func APIClaimPairing(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ClaimPairingRequest) (*api.ClaimPairingResponse, error) {
+		ctx := c.UserContext()
+		code := normalizePairingCode(req.Body.Code)
+		p, err := getPairing(ctx, code)
+		if err != nil {
+			return nil, pairingErr(err)
+		}
+		sum := sha256.Sum256([]byte(req.Body.Claim))
+		if p.Claim == nil || subtle.ConstantTimeCompare(sum[:], p.Claim) != 1 {
+			return nil, pairingErr(errPairingNotFound)
+		}
+		if !p.Approved {
+			return &api.ClaimPairingResponse{}, nil
+		}
+		// GetDel so it can't be claimed twice
+		data, err := cache.GetDel(ctx, pairingKey(code))
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return nil, pairingErr(errPairingNotFound)
+			}
+			return nil, fmt.Errorf("getting pairing: %w", err)
+		}
+		if p, err = parsePairing(data); err != nil {
+			return nil, err
+		}
+
+		user, err := s.db.GetUserByID(ctx, p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		// made here so the session has the new device's ip and user agent
+		sessionID, refresh, err := newSessionForUser(c, user, p.TwoFactor, p.Device)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		s.db.Audit(ctx, user.ID, database.AuditDevicePaired, c.IP(), p.Device)
+		slog.Info("device paired", "user_id", user.ID, "device", p.Device)
+		return &api.ClaimPairingResponse{
+			Cookies: api.ClaimPairingResponseCookies{
+				Session: sessionID,
+				Refresh: refresh,
+			},
+			Body: api.ClaimPairingResponseBody{
+				Approved:   true,
+				UserID:     user.ID,
+				Sealed:     p.Sealed,
+				Nonce:      p.Nonce,
+				CodeFormat: user.CodeFormat,
+			},
+		}, nil
+	})
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+// webauthn ceremonies (see database/passkeys.go for what passkeys can do). beginning one stores the library's
+// session data in redis under "webauthn:<ceremony>" until it times out, finishing one takes it out again so a
+// ceremony can only be finished once.
+
+var (
+	errCeremonyNotFound = errors.New("passkey ceremony not found or expired, try again")
+	errPasskeyRejected  = errors.New("passkey rejected")
+	errNotUserVerified  = errors.New("the passkey didn't verify you (pin or biometrics), log in with your master password")
+)
+
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+type ceremony struct {
+	Kind    string               `json:"kind"`
+	UserID  int64                `json:"user_id"`
+	Session webauthn.SessionData `json:"session"`
+}
+
+func newWebAuthn() (*webauthn.WebAuthn, error) {
+	cfg := config.DefaultConfig.WebAuthn
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: time.Duration(cfg.Timeout) * time.Second}
+	return webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPName,
+		RPOrigins:     cfg.Origins,
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        timeout,
+			Registration: timeout,
+		},
+	})
+}
+
+// passkeyUser is a user as the webauthn library sees them.
+type passkeyUser struct {
+	user  *database.User
+	creds []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(u.user.ID))
+}
+func (u *passkeyUser) WebAuthnName() string                       { return u.user.Name }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.user.Name }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.creds }
+
+func (s *Server) passkeyUser(ctx context.Context, user *database.User) (*passkeyUser, error) {
+	stored, err := s.db.PasskeyCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	u := &passkeyUser{user: user}
+	for _, sc := range stored {
+		var cred webauthn.Credential
+		if err := json.Unmarshal(sc.Credential, &cred); err != nil {
+			return nil, fmt.Errorf("passkey credential: %w", err)
+		}
+		cred.Authenticator.SignCount = sc.SignCount
+		u.creds = append(u.creds, cred)
+	}
+	return u, nil
+}
+
+func startCeremony(ctx context.Context, kind string, userID int64, session *webauthn.SessionData) (string, error) {
+	id := randomToken()
+	data, err := json.Marshal(ceremony{Kind: kind, UserID: userID, Session: *session})
+	if err != nil {
+		return "", fmt.Errorf("marshal ceremony: %w", err)
+	}
+	ttl := time.Duration(config.DefaultConfig.WebAuthn.Timeout) * time.Second
+	if err := cache.SetWithExpiration(ctx, "webauthn:"+id, string(data), ttl); err != nil {
+		return "", fmt.Errorf("saving ceremony: %w", err)
+	}
+	return id, nil
+}
+
+func finishCeremony(ctx context.Context, kind, id string) (*ceremony, error) {
+	data, err := cache.GetDel(ctx, "webauthn:"+id)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, errCeremonyNotFound
+		}
+		return nil, fmt.Errorf("getting ceremony: %w", err)
+	}
+	var cer ceremony
+	if err := json.Unmarshal([]byte(data), &cer); err != nil {
+		return nil, fmt.Errorf("unmarshal ceremony: %w", err)
+	}
+	if cer.Kind != kind {
+		return nil, errCeremonyNotFound
+	}
+	return &cer, nil
+}
+
+// finishPasskeyLogin verifies an assertion for a login ceremony and returns who it's for, the credential it was
+// made with and the passkey's encrypted session code (nil if it doesn't have one). the signature counter has to
+// go up (see database.UsePasskey).
+func (s *Server) finishPasskeyLogin(ctx context.Context, assertion *api.PasskeyAssertion) (*database.User, *webauthn.Credential, []byte, error) {
+	cer, err := finishCeremony(ctx, ceremonyLogin, assertion.Ceremony)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	user, err := s.db.GetUserByID(ctx, cer.UserID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	pu, err := s.passkeyUser(ctx, user)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(assertion.Credential)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errPasskeyRejected, err)
+	}
+	cred, err := s.webauthn.ValidateLogin(pu, cer.Session, parsed)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("%w: %v", errPasskeyRejected, err)
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, nil, nil, database.ErrPasskeyCloned
+	}
+	sessionCode, err := s.db.UsePasskey(ctx, user.ID, cred.ID, cred.Authenticator.SignCount)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return user, cred, sessionCode, nil
+}
+
+// passkeyFactor checks an assertion used as the second factor of a master password login for name. anything
+// wrong with it is database.ErrInvalidTwoFactor, so guardLogin counts it like a wrong code.
+func (s *Server) passkeyFactor(c *fiber.Ctx, name string, assertion *api.PasskeyAssertion) error {
+	user, _, _, err := s.finishPasskeyLogin(c.UserContext(), assertion)
+	switch {
+	case errors.Is(err, errCeremonyNotFound), errors.Is(err, errPasskeyRejected), errors.Is(err, database.ErrPasskeyCloned),
+		errors.Is(err, database.ErrPasskeyNotFound):
+		return fmt.Errorf("%w: %v", database.ErrInvalidTwoFactor, err)
+	case err != nil:
+		return err
+	case user.Name != name:
+		return fmt.Errorf("%w: passkey is for another user", database.ErrInvalidTwoFactor)
+	}
+	return nil
+}
+
+func APIBeginPasskeyRegistration(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.BeginPasskeyRegistrationRequest) (*api.BeginPasskeyRegistrationResponse, error) {
+		// getUser, not principal, so a passkey can satisfy a two-factor policy like an authenticator app (see twofactor.go)
+		user := getUser(c)
+		pu, err := s.passkeyUser(c.UserContext(), user)
+		if err != nil {
+			return nil, err
+		}
+		creation, session, err := s.webauthn.BeginRegistration(pu,
+			webauthn.WithExclusions(webauthn.Credentials(pu.creds).CredentialDescriptors()),
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("begin registration: %w", err)
+		}
+		id, err := startCeremony(c.UserContext(), ceremonyRegister, user.ID, session)
+		if err != nil {
+			return nil, err
+		}
+		options, err := json.Marshal(creation)
+		if err != nil {
+			return nil, fmt.Errorf("marshal options: %w", err)
+		}
+		return &api.BeginPasskeyRegistrationResponse{
+			Body: api.BeginPasskeyRegistrationResponseBody{
+				Ceremony: id,
+				Options:  options,
+			},
+		}, nil
+	})
+}
+
+func APIFinishPasskeyRegistration(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.FinishPasskeyRegistrationRequest) (*api.FinishPasskeyRegistrationResponse, error) {
+		user := getUser(c)
+		cer, err := finishCeremony(c.UserContext(), ceremonyRegister, req.Body.Ceremony)
+		if err != nil {
+			return nil, passkeyErr(err)
+		}
+		if cer.UserID != user.ID {
+			return nil, passkeyErr(errCeremonyNotFound)
+		}
+		pu, err := s.passkeyUser(c.UserContext(), user)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := protocol.ParseCredentialCreationResponseBytes(req.Body.Credential)
+		if err != nil {
+			return nil, passkeyErr(fmt.Errorf("%w: %v", errPasskeyRejected, err))
+		}
+		cred, err := s.webauthn.CreateCredential(pu, cer.Session, parsed)
+		if err != nil {
+			return nil, passkeyErr(fmt.Errorf("%w: %v", errPasskeyRejected, err))
+		}
+		record, err := json.Marshal(cred)
+		if err != nil {
+			return nil, fmt.Errorf("marshal credential: %w", err)
+		}
+
+		passkey, err := s.db.AddPasskey(c.UserContext(), user.ID, cred.ID, req.Body.Name, record, cred.Authenticator.SignCount, req.Body.SessionCode)
+		if err != nil {
+			return nil, passkeyErr(err)
+		}
+		s.db.Audit(c.UserContext(), user.ID, database.AuditPasskeyAdded, c.IP(), fmt.Sprintf("%q (passwordless: %t)", passkey.Name, passkey.Passwordless))
+		return &api.FinishPasskeyRegistrationResponse{
+			Body: api.FinishPasskeyRegistrationResponseBody{
+				Passkey: *passkey,
+			},
+		}, nil
+	})
+}
+
+func APIListPasskeys(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListPasskeysRequest) (*api.ListPasskeysResponse, error) {
+		user := getUser(c)
+		passkeys, err := s.db.ListPasskeys(c.UserContext(), user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListPasskeysResponse{
+			Body: api.ListPasskeysResponseBody{
+				Passkeys: passkeys,
+			},
+		}, nil
+	})
+}
+
+func APIDeletePasskey(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DeletePasskeyRequest) (*api.DeletePasskeyResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.DeletePasskey(c.UserContext(), p.UserID, req.Body.ID); err != nil {
+			return nil, passkeyErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditPasskeyRemoved, c.IP(), fmt.Sprintf("id %d", req.Body.ID))
+		return &api.DeletePasskeyResponse{}, nil
+	})
+}
+
+func APIBeginPasskeyLogin(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.BeginPasskeyLoginRequest) (*api.BeginPasskeyLoginResponse, error) {
+		// unknown names and users without passkeys look the same
+		user, err := s.db.GetUserByName(c.UserContext(), req.Body.Name)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, passkeyErr(database.ErrPasskeyNotFound)
+		}
+		if err != nil {
+			return nil, err
+		}
+		pu, err := s.passkeyUser(c.UserContext(), user)
+		if err != nil {
+			return nil, err
+		}
+		if len(pu.creds) == 0 {
+			return nil, passkeyErr(database.ErrPasskeyNotFound)
+		}
+		assertion, session, err := s.webauthn.BeginLogin(pu)
+		if err != nil {
+			return nil, fmt.Errorf("begin login: %w", err)
+		}
+		id, err := startCeremony(c.UserContext(), ceremonyLogin, user.ID, session)
+		if err != nil {
+			return nil, err
+		}
+		options, err := json.Marshal(assertion)
+		if err != nil {
+			return nil, fmt.Errorf("marshal options: %w", err)
+		}
+		return &api.BeginPasskeyLoginResponse{
+			Body: api.BeginPasskeyLoginResponseBody{
+				Ceremony: id,
+				Options:  options,
+			},
+		}, nil
+	})
+}
+
+// APIPasskeyLogin logs in with a passkey instead of the master password. the passkey has to verify the user
+// (which also counts as two-factor) and be able to give back the session code.
+func APIPasskeyLogin(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.PasskeyLoginRequest) (*api.PasskeyLoginResponse, error) {
+		user, cred, sessionCode, err := s.finishPasskeyLogin(c.UserContext(), &req.Body)
+		if err != nil {
+			if errors.Is(err, errPasskeyRejected) || errors.Is(err, database.ErrPasskeyCloned) {
+				s.db.Audit(c.UserContext(), 0, database.AuditPasskeyFailed, c.IP(), err.Error())
+			}
+			return nil, passkeyErr(err)
+		}
+		if !cred.Flags.UserVerified {
+			return nil, passkeyErr(errNotUserVerified)
+		}
+		if sessionCode == nil {
+			return nil, passkeyErr(database.ErrPasskeyNoSessionCode)
+		}
+
+		sessionID, refresh, err := newSessionForUser(c, user, true, "")
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		s.db.Audit(c.UserContext(), user.ID, database.AuditPasskeyLogin, c.IP(), "")
+		slog.Info("user logged in with a passkey", "name", user.Name, "id", user.ID)
+		return &api.PasskeyLoginResponse{
+			Cookies: api.PasskeyLoginResponseCookies{
+				Session: sessionID,
+				Refresh: refresh,
+			},
+			Body: api.PasskeyLoginResponseBody{
+				UserID:      user.ID,
+				SessionCode: sessionCode,
+				CodeFormat:  user.CodeFormat,
+				TwoFactor:   true,
+			},
+		}, nil
+	})
+}
+
+func passkeyErr(err error) error {
+	switch {
+	case errors.Is(err, errCeremonyNotFound), errors.Is(err, database.ErrPasskeyNoSessionCode), errors.Is(err, errNotUserVerified):
+		return api.Validation(err)
+	case errors.Is(err, errPasskeyRejected), errors.Is(err, database.ErrPasskeyCloned):
+		return api.InvalidCredentials(err)
+	case errors.Is(err, database.ErrPasskeyNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, database.ErrPasskeyExists):
+		return api.Conflict(err)
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/server/middlewares"
+)
+
+// softAuthenticator is a passkey in memory: an es256 key with "none" attestation, that always verifies the user.
+type softAuthenticator struct {
+	key       *ecdsa.PrivateKey
+	id        []byte
+	origin    string // what the browser would put in the client data
+	signCount uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, id: id, origin: config.DefaultConfig.WebAuthn.Origins[0]}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(typ string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]string{"type": typ, "challenge": b64(challenge), "origin": a.origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(t *testing.T, rpID string, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified
+	if attested {
+		flags |= protocol.FlagAttestedCredentialData
+	}
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if !attested {
+		return data
+	}
+
+	pub, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  1, // p-256
+		XCoord: a.key.X.FillBytes(make([]byte, 32)),
+		YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, make([]byte, 16)...) // no aaguid
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	return append(data, pub...)
+}
+
+// register is navigator.credentials.create with the options from /api/passkeys/register/begin.
+func (a *softAuthenticator) register(t *testing.T, options []byte) json.RawMessage {
+	t.Helper()
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(options, &creation); err != nil {
+		t.Fatal(err)
+	}
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, creation.Response.RelyingParty.ID, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", creation.Response.Challenge)),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return cred
+}
+
+// assert is navigator.credentials.get with the options from /api/passkeys/login/begin, the counter goes up first
+// like on a real authenticator.
+func (a *softAuthenticator) assert(t *testing.T, options []byte, userHandle []byte) json.RawMessage {
+	t.Helper()
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		t.Fatal(err)
+	}
+	a.signCount++
+	authData := a.authData(t, assertion.Response.RelyingPartyID, false)
+	clientData := a.clientData("webauthn.get", assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(bytes.Clone(authData), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, _ := json.Marshal(map[string]any{
+		"id":    b64(a.id),
+		"rawId": b64(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(sig),
+			"userHandle":        b64(userHandle),
+		},
+	})
+	return cred
+}
+
+func passkeysApp(s *Server) *fiber.App {
+	app := fiber.New()
+	session := middlewares.SessionMiddleware(s.db, false)
+	app.Post("/api/passkeys/register/begin", session, APIBeginPasskeyRegistration(s))
+	app.Post("/api/passkeys/register/finish", session, APIFinishPasskeyRegistration(s))
+	app.Post("/api/passkeys/login/begin", APIBeginPasskeyLogin(s))
+	app.Post("/api/passkeys/login/finish", APIPasskeyLogin(s))
+	return app
+}
+
+// passkeyServer is testServer with webauthn (from the default config: localhost, http://localhost:8755)
+func passkeyServer(t *testing.T) (*Server, *fiber.App) {
+	t.Helper()
+	s := testServer(t)
+	var err error
+	if s.webauthn, err = newWebAuthn(); err != nil {
+		t.Fatal(err)
+	}
+	return s, passkeysApp(s)
+}
+
+var testSessionCode = []byte("encrypted session code")
+
+func registerPasskey(t *testing.T, app *fiber.App, u testUser, a *softAuthenticator) (int, []byte) {
+	t.Helper()
+	status, body := call(t, app, u, http.MethodPost, "/api/passkeys/register/begin", nil)
+	wantStatus(t, "beginning registration", status, body, http.StatusOK)
+	var begin api.BeginPasskeyRegistrationResponseBody
+	if err := json.Unmarshal(body, &begin); err != nil {
+		t.Fatal(err)
+	}
+	return call(t, app, u, http.MethodPost, "/api/passkeys/register/finish", map[string]any{
+		"ceremony":     begin.Ceremony,
+		"name":         "soft",
+		"credential":   a.register(t, begin.Options),
+		"session_code": testSessionCode,
+	})
+}
+
+// beginPasskeyLogin returns the ceremony and its options
+func beginPasskeyLogin(t *testing.T, app *fiber.App, name string) (string, []byte) {
+	t.Helper()
+	status, body := call(t, app, testUser{}, http.MethodPost, "/api/passkeys/login/begin", map[string]any{"name": name})
+	wantStatus(t, "beginning login", status, body, http.StatusOK)
+	var begin api.BeginPasskeyLoginResponseBody
+	if err := json.Unmarshal(body, &begin); err != nil {
+		t.Fatal(err)
+	}
+	return begin.Ceremony, begin.Options
+}
+
+func finishPasskeyLogin(t *testing.T, app *fiber.App, ceremony string, credential json.RawMessage) (int, []byte) {
+	t.Helper()
+	return call(t, app, testUser{}, http.MethodPost, "/api/passkeys/login/finish", map[string]any{"ceremony": ceremony, "credential": credential})
+}
+
+func userHandle(u testUser) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(u.ID))
+}
+
+func TestPasskeyRegisterAndLogin(t *testing.T) {
+	s, app := passkeyServer(t)
+	alice := newTestUser(t, s, "alice")
+	a := newSoftAuthenticator(t)
+
+	status, body := registerPasskey(t, app, alice, a)
+	wantStatus(t, "finishing registration", status, body, http.StatusOK)
+	var reg api.FinishPasskeyRegistrationResponseBody
+	if err := json.Unmarshal(body, &reg); err != nil {
+		t.Fatal(err)
+	}
+	if !reg.Passkey.Passwordless {
+		t.Fatal("a passkey registered with a session code isn't passwordless")
+	}
+
+	for i := range 2 {
+		ceremony, options := beginPasskeyLogin(t, app, "alice")
+		status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, options, userHandle(alice)))
+		wantStatus(t, "logging in with the passkey", status, body, http.StatusOK)
+		var login api.PasskeyLoginResponseBody
+		if err := json.Unmarshal(body, &login); err != nil {
+			t.Fatal(err)
+		}
+		if login.UserID != alice.ID || !bytes.Equal(login.SessionCode, testSessionCode) || !login.TwoFactor {
+			t.Fatalf("login %d: got %+v", i, login)
+		}
+	}
+}
+
+func TestPasskeySignCountRegression(t *testing.T) {
+	s, app := passkeyServer(t)
+	alice := newTestUser(t, s, "alice")
+	a := newSoftAuthenticator(t)
+	status, body := registerPasskey(t, app, alice, a)
+	wantStatus(t, "finishing registration", status, body, http.StatusOK)
+
+	a.signCount = 10
+	ceremony, options := beginPasskeyLogin(t, app, "alice")
+	status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, options, userHandle(alice)))
+	wantStatus(t, "logging in at 11", status, body, http.StatusOK)
+
+	// a copy of the key that signs with an older counter
+	a.signCount = 5
+	ceremony, options = beginPasskeyLogin(t, app, "alice")
+	status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, options, userHandle(alice)))
+	wantStatus(t, "logging in at 6 after 11", status, body, http.StatusUnauthorized)
+
+	// and one with the same counter
+	a.signCount = 10
+	ceremony, options = beginPasskeyLogin(t, app, "alice")
+	status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, options, userHandle(alice)))
+	wantStatus(t, "logging in at 11 again", status, body, http.StatusUnauthorized)
+}
+
+func TestPasskeyWrongOriginOrChallenge(t *testing.T) {
+	s, app := passkeyServer(t)
+	alice := newTestUser(t, s, "alice")
+	a := newSoftAuthenticator(t)
+
+	a.origin = "https://keylock.evil.example"
+	status, body := registerPasskey(t, app, alice, a)
+	wantStatus(t, "registering from another origin", status, body, http.StatusUnauthorized)
+	a.origin = config.DefaultConfig.WebAuthn.Origins[0]
+	status, body = registerPasskey(t, app, alice, a)
+	wantStatus(t, "finishing registration", status, body, http.StatusOK)
+
+	a.origin = "https://keylock.evil.example"
+	ceremony, options := beginPasskeyLogin(t, app, "alice")
+	status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, options, userHandle(alice)))
+	wantStatus(t, "logging in from another origin", status, body, http.StatusUnauthorized)
+	a.origin = config.DefaultConfig.WebAuthn.Origins[0]
+
+	// an assertion for one ceremony doesn't finish another
+	_, otherOptions := beginPasskeyLogin(t, app, "alice")
+	ceremony, _ = beginPasskeyLogin(t, app, "alice")
+	status, body = finishPasskeyLogin(t, app, ceremony, a.assert(t, otherOptions, userHandle(alice)))
+	wantStatus(t, "logging in with another ceremony's challenge", status, body, http.StatusUnauthorized)
+
+	// and a ceremony only finishes once, even with a good assertion
+	ceremony, options = beginPasskeyLogin(t, app, "alice")
+	assertion := a.assert(t, options, userHandle(alice))
+	status, body = finishPasskeyLogin(t, app, ceremony, assertion)
+	wantStatus(t, "logging in", status, body, http.StatusOK)
+	status, body = finishPasskeyLogin(t, app, ceremony, assertion)
+	wantStatus(t, "finishing the same ceremony again", status, body, http.StatusBadRequest)
+}
@@ -1,21 +1,29 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"net"
+	"sync"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/gofiber/fiber/v2"
 	"github.com/tiredkangaroo/keylock/config"
 	"github.com/tiredkangaroo/keylock/database"
 	"github.com/tiredkangaroo/keylock/server/middlewares"
+	"github.com/tiredkangaroo/keylock/sessions"
 	"github.com/tiredkangaroo/keylock/web"
 )
 
 // NOTE: maybe retrive should be a GET
 
 type Server struct {
-	db *database.DB
+	db       *database.DB
+	webauthn *webauthn.WebAuthn
+
+	ssoMu     sync.Mutex
+	ssoClient *ssoClient // nil until the provider is discovered, see sso.go
 }
 
 func (s *Server) Init(db *database.DB) {
@@ -29,20 +37,107 @@ func (s *Server) Start() error {
 	}
 	slog.Info("listening on addr", "addr", listener.Addr().String())
 
+	s.webauthn, err = newWebAuthn()
+	if err != nil {
+		return fmt.Errorf("webauthn config: %w", err)
+	}
+
+	switch config.DefaultConfig.Sessions.Mode {
+	case sessions.ModeRedis:
+	case sessions.ModeSigned:
+		if err := sessions.InitSigned(context.Background(), revocationStore{s.db}); err != nil {
+			return fmt.Errorf("signed sessions: %w", err)
+		}
+	default:
+		return fmt.Errorf("unknown session mode %q", config.DefaultConfig.Sessions.Mode)
+	}
+
 	app := fiber.New(fiber.Config{
 		EnablePrintRoutes: true,
 	})
 
-	sessionMiddleware := middlewares.SessionMiddleware(s.db)
+	app.Use(middlewares.ContextMiddleware())
+	app.Use(middlewares.SecurityHeaders())
+	app.Use(middlewares.CSRF())
+
+	sessionMiddleware := middlewares.SessionMiddleware(s.db, false)
+	// access tokens only get as far as the password endpoints, which check their scopes (see authorize)
+	tokenMiddleware := middlewares.SessionMiddleware(s.db, true)
+	// slow routes stop when the client goes away: logins that migrate or rekey an account (the kdf for migrating
+	// accounts, re-encrypting every password) and the lists that can get long
+	watch := middlewares.WatchDisconnect()
+
+	app.Get("/sso/login", SSOLogin(s))
+	app.Get("/sso/link", sessionMiddleware, SSOLink(s))
+	app.Get("/sso/callback", SSOCallback(s))
 
 	webGroup := app.Group("")
 	web.SetGroup(s.db, sessionMiddleware, webGroup)
 
-	api := app.Group("/api")
+	api := app.Group("/api", middlewares.RateLimit())
+	api.Post("/accounts/prelogin", APIPrelogin(s))
 	api.Post("/accounts/new", APINewAccount(s))
-	api.Post("/passwords/new", sessionMiddleware, APINewPassword(s))
-	api.Post("/passwords/retrieve", sessionMiddleware, APIRetrievePassword(s))
-	api.Get("/passwords/list", sessionMiddleware, APIListPasswords(s))
+	api.Post("/accounts/login", watch, APILogin(s))
+	api.Post("/passwords/new", tokenMiddleware, APINewPassword(s))
+	api.Post("/passwords/retrieve", tokenMiddleware, APIRetrievePassword(s))
+	api.Post("/passwords/delete", tokenMiddleware, APIDeletePassword(s))
+	api.Post("/passwords/update", tokenMiddleware, APIUpdatePassword(s))
+	api.Post("/passwords/share", sessionMiddleware, APISharePassword(s))
+	api.Post("/passwords/unshare", sessionMiddleware, APIUnsharePassword(s))
+	api.Post("/passwords/shares", watch, sessionMiddleware, APIListShares(s))
+	api.Post("/orgs/new", sessionMiddleware, APINewOrganization(s))
+	api.Get("/orgs/list", sessionMiddleware, APIListOrganizations(s))
+	api.Post("/orgs/members", sessionMiddleware, APIListOrgMembers(s))
+	api.Post("/orgs/invite", sessionMiddleware, APIInviteOrgMember(s))
+	api.Post("/orgs/remove", sessionMiddleware, APIRemoveOrgMember(s))
+	api.Post("/orgs/role", sessionMiddleware, APIChangeOrgRole(s))
+	api.Post("/orgs/collections/new", sessionMiddleware, APINewCollection(s))
+	api.Post("/orgs/collections/list", sessionMiddleware, APIListCollections(s))
+	api.Post("/orgs/passwords/new", sessionMiddleware, APINewOrgPassword(s))
+	api.Post("/orgs/passwords/retrieve", sessionMiddleware, APIRetrieveOrgPassword(s))
+	api.Post("/orgs/passwords/delete", sessionMiddleware, APIDeleteOrgPassword(s))
+	api.Post("/orgs/passwords/list", watch, sessionMiddleware, APIListOrgPasswords(s))
+	api.Get("/passwords/list", watch, tokenMiddleware, APIListPasswords(s))
+	api.Post("/vaults/new", sessionMiddleware, APINewVault(s))
+	api.Get("/vaults/list", sessionMiddleware, APIListVaults(s))
+	api.Post("/links/new", sessionMiddleware, APINewLink(s))
+	api.Post("/links/open", APIOpenLink(s))
+	api.Post("/inbox/open", sessionMiddleware, APIOpenInbox(s))
+	api.Post("/inbox/close", sessionMiddleware, APICloseInbox(s))
+	api.Get("/inbox/list", watch, sessionMiddleware, APIListInbox(s))
+	api.Post("/inbox/file", sessionMiddleware, APIFileInboxItem(s))
+	api.Post("/inbox/discard", sessionMiddleware, APIDiscardInboxItem(s))
+	api.Post("/inbox/info", APIGetInbox(s))
+	api.Post("/inbox/drop", APIDropInboxItem(s))
+	api.Get("/2fa/status", sessionMiddleware, APITwoFactorStatus(s))
+	api.Post("/2fa/enroll", sessionMiddleware, APIEnrollTwoFactor(s))
+	api.Post("/2fa/confirm", sessionMiddleware, APIConfirmTwoFactor(s))
+	api.Post("/2fa/disable", sessionMiddleware, APIDisableTwoFactor(s))
+	api.Post("/2fa/recovery-codes", sessionMiddleware, APINewRecoveryCodes(s))
+	api.Post("/orgs/two-factor", sessionMiddleware, APISetOrgTwoFactor(s))
+	api.Post("/passkeys/register/begin", sessionMiddleware, APIBeginPasskeyRegistration(s))
+	api.Post("/passkeys/register/finish", sessionMiddleware, APIFinishPasskeyRegistration(s))
+	api.Get("/passkeys/list", sessionMiddleware, APIListPasskeys(s))
+	api.Post("/passkeys/delete", sessionMiddleware, APIDeletePasskey(s))
+	api.Post("/passkeys/login/begin", APIBeginPasskeyLogin(s))
+	api.Post("/passkeys/login/finish", APIPasskeyLogin(s))
+	api.Post("/sso/login", APISSOLogin(s))
+	api.Get("/sso/identities", sessionMiddleware, APIListIdentities(s))
+	api.Post("/sso/unlink", sessionMiddleware, APIUnlinkIdentity(s))
+	api.Get("/sessions", sessionMiddleware, APIListSessions(s))
+	api.Post("/sessions/revoke", sessionMiddleware, APIRevokeSession(s))
+	api.Post("/sessions/revoke-all", sessionMiddleware, APIRevokeAllSessions(s))
+	api.Get("/session", sessionMiddleware, APISession(s))
+	api.Post("/session/refresh", APIRefreshSession(s))
+	api.Post("/session/policy", sessionMiddleware, APISetSessionPolicy(s))
+	api.Post("/tokens/new", sessionMiddleware, APICreateAccessToken(s))
+	api.Get("/tokens", sessionMiddleware, APIListAccessTokens(s))
+	api.Post("/tokens/revoke", sessionMiddleware, APIRevokeAccessToken(s))
+	api.Post("/pairing/new", sessionMiddleware, APINewPairing(s))
+	api.Post("/pairing/status", sessionMiddleware, APIPairingStatus(s))
+	api.Post("/pairing/approve", sessionMiddleware, APIApprovePairing(s))
+	api.Post("/pairing/join", APIJoinPairing(s))
+	api.Post("/pairing/claim", APIClaimPairing(s))
 
 	return app.Listener(listener)
 }
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
+)
+
+func APIListSessions(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListSessionsRequest) (*api.ListSessionsResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		list, err := sessions.List(c.UserContext(), p.UserID, getSessionID(c))
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListSessionsResponse{
+			Body: api.ListSessionsResponseBody{
+				Sessions: list,
+			},
+		}, nil
+	})
+}
+
+func APIRevokeSession(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RevokeSessionRequest) (*api.RevokeSessionResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := sessions.Revoke(c.UserContext(), p.UserID, req.Body.ID); err != nil {
+			if errors.Is(err, sessions.ErrNotFound) {
+				return nil, api.NotFound(err)
+			}
+			return nil, err
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditSessionRevoked, c.IP(), "session "+req.Body.ID)
+		return &api.RevokeSessionResponse{}, nil
+	})
+}
+
+func APIRevokeAllSessions(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RevokeAllSessionsRequest) (*api.RevokeAllSessionsResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		n, err := sessions.RevokeAll(c.UserContext(), p.UserID, getSessionID(c))
+		if err != nil {
+			return nil, err
+		}
+		detail := fmt.Sprintf("%d sessions", n)
+		if n < 0 {
+			detail = "all sessions" // signed sessions, there's no count
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditSessionsRevokedAll, c.IP(), detail)
+		return &api.RevokeAllSessionsResponse{
+			Body: api.RevokeAllSessionsResponseBody{
+				Revoked: n,
+			},
+		}, nil
+	})
+}
+
+func sessionPolicy(p sessions.Policy) api.SessionPolicy {
+	return api.SessionPolicy{
+		IdleTimeout: int64(p.Idle.Seconds()),
+		MaxLifetime: int64(p.Max.Seconds()),
+	}
+}
+
+// APISession tells the client about its own session. it doesn't need a principal, a session that still has to
+// pass two-factor should know when it expires too.
+func APISession(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.SessionRequest) (*api.SessionResponse, error) {
+		user := getUser(c)
+		info, err := sessions.Info(c.UserContext(), user.ID, getSessionID(c))
+		if err != nil {
+			if errors.Is(err, sessions.ErrNotFound) {
+				// expired between the middleware and here
+				return nil, api.Unauthorized(err)
+			}
+			return nil, err
+		}
+		info.TwoFactor = sessionTwoFactor(c)
+		return &api.SessionResponse{
+			Body: api.SessionResponseBody{
+				UserID:  user.ID,
+				Session: *info,
+				Policy:  sessionPolicy(sessions.PolicyFor(user.SessionIdleTimeout, user.SessionMaxLifetime)),
+				UserPolicy: api.SessionPolicy{
+					IdleTimeout: user.SessionIdleTimeout,
+					MaxLifetime: user.SessionMaxLifetime,
+				},
+			},
+		}, nil
+	})
+}
+
+func APISetSessionPolicy(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.SetSessionPolicyRequest) (*api.SetSessionPolicyResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.SetSessionPolicy(c.UserContext(), p.UserID, req.Body.IdleTimeout, req.Body.MaxLifetime); err != nil {
+			if errors.Is(err, database.ErrInvalidSessionPolicy) {
+				return nil, api.Validation(err)
+			}
+			return nil, err
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditSessionPolicyChanged, c.IP(),
+			fmt.Sprintf("idle timeout %ds, max lifetime %ds", req.Body.IdleTimeout, req.Body.MaxLifetime))
+		return &api.SetSessionPolicyResponse{
+			Body: api.SetSessionPolicyResponseBody{
+				Policy: sessionPolicy(sessions.PolicyFor(req.Body.IdleTimeout, req.Body.MaxLifetime)),
+			},
+		}, nil
+	})
+}
+
+// APIRefreshSession gets a signed session a new token with its refresh token. there's no session middleware, the
+// session token has probably expired.
+func APIRefreshSession(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RefreshSessionRequest) (*api.RefreshSessionResponse, error) {
+		session, refresh, err := sessions.Refresh(c.UserContext(), req.Cookies.Session, req.Cookies.Refresh)
+		if err != nil {
+			switch {
+			case errors.Is(err, sessions.ErrNotSigned):
+				return nil, api.Validation(err)
+			case errors.Is(err, sessions.ErrNotFound):
+				return nil, api.Unauthorized(api.ErrUnauthorized)
+			}
+			return nil, err
+		}
+		return &api.RefreshSessionResponse{
+			Cookies: api.RefreshSessionResponseCookies{
+				Session: session,
+				Refresh: refresh,
+			},
+		}, nil
+	})
+}
+
+// revocationStore keeps the signed session revocation list in postgres.
+type revocationStore struct {
+	db *database.DB
+}
+
+func (r revocationStore) SaveRevocation(ctx context.Context, rev sessions.Revocation) error {
+	return r.db.SaveRevokedSession(ctx, database.RevokedSession{
+		Key:       rev.Key,
+		Before:    rev.Before,
+		Except:    rev.Except,
+		ExpiresAt: rev.Until,
+	})
+}
+
+func (r revocationStore) LoadRevocations(ctx context.Context) ([]sessions.Revocation, error) {
+	rows, err := r.db.RevokedSessions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]sessions.Revocation, len(rows))
+	for i, row := range rows {
+		list[i] = sessions.Revocation{
+			Key:    row.Key,
+			Before: row.Before,
+			Except: row.Except,
+			Until:  row.ExpiresAt,
+		}
+	}
+	return list, nil
+}
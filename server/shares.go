@@ -0,0 +1,106 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+func APIUpdatePassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.UpdatePasswordRequest) (*api.UpdatePasswordResponse, error) {
+		p, err := s.authorize(c, authz.Write, req.Body.UserID, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		ownerID := req.Body.UserID
+		if ownerID == 0 {
+			ownerID = p.UserID
+		}
+
+		if t := accessToken(c); t != nil {
+			err = s.db.UpdatePasswordWithToken(c.UserContext(), t, req.Body.Name, req.Body.Value)
+		} else {
+			err = s.guardCode(c, func() error {
+				return s.db.UpdatePassword(c.UserContext(), p.UserID, ownerID, req.Body.Name, req.Body.Key2, req.Body.VaultCode, req.Body.Value)
+			})
+		}
+		if err != nil {
+			return nil, vaultErr(err)
+		}
+		slog.Info("updated password", "name", req.Body.Name, "owner_id", ownerID, "user_id", p.UserID)
+		return &api.UpdatePasswordResponse{}, nil
+	})
+}
+
+func APISharePassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.SharePasswordRequest) (*api.SharePasswordResponse, error) {
+		p, err := s.authorize(c, authz.Share, 0, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		perm, err := authz.ParsePermission(req.Body.Permission)
+		if err != nil {
+			return nil, api.Validation(err)
+		}
+
+		err = s.guardCode(c, func() error {
+			return s.db.SharePassword(c.UserContext(), p.UserID, req.Body.Name, req.Body.Key2, req.Body.VaultCode, req.Body.Recipient, perm)
+		})
+		if err != nil {
+			return nil, vaultErr(err)
+		}
+		slog.Info("shared password", "name", req.Body.Name, "user_id", p.UserID, "recipient", req.Body.Recipient, "permission", perm)
+		return &api.SharePasswordResponse{}, nil
+	})
+}
+
+func APIUnsharePassword(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.UnsharePasswordRequest) (*api.UnsharePasswordResponse, error) {
+		p, err := s.authorize(c, authz.Share, 0, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.UnsharePassword(c.UserContext(), p.UserID, req.Body.Name, req.Body.Recipient); err != nil {
+			return nil, vaultErr(err)
+		}
+		slog.Info("unshared password", "name", req.Body.Name, "user_id", p.UserID, "recipient", req.Body.Recipient)
+		return &api.UnsharePasswordResponse{}, nil
+	})
+}
+
+func APIListShares(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListSharesRequest) (*api.ListSharesResponse, error) {
+		p, err := s.authorize(c, authz.Share, 0, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		shares, err := s.db.PasswordShares(c.UserContext(), p.UserID, req.Body.Name)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListSharesResponse{
+			Body: api.ListSharesResponseBody{
+				Shares: shares,
+			},
+		}, nil
+	})
+}
+
+// shareErr gives the database errors about passwords and shares their status codes.
+func shareErr(err error) error {
+	switch {
+	case errors.Is(err, database.ErrPasswordNotFound):
+		return api.NotFound(database.ErrPasswordNotFound)
+	case errors.Is(err, database.ErrUserNotFound), errors.Is(err, database.ErrShareNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, database.ErrShareWithSelf):
+		return api.Validation(err)
+	case errors.Is(err, database.ErrNoKeyPair):
+		return api.Conflict(err)
+	}
+	return err
+}
@@ -0,0 +1,361 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+	"golang.org/x/oauth2"
+)
+
+// single sign-on (see database/sso.go). /sso/login (or /sso/link for a logged in user) sends the browser to the
+// provider with a state, nonce and pkce challenge kept in redis under "sso:state:<state>" and a cookie that ties
+// them to this browser. /sso/callback exchanges the code, checks the id token and either links the identity or
+// hands the login page a short lived token ("sso:login:<token>"), which /api/sso/login turns into a session once
+// any two-factor code checks out.
+
+const (
+	ssoStateTTL = 10 * time.Minute
+	ssoLoginTTL = 5 * time.Minute
+)
+
+var (
+	errSSODisabled     = errors.New("single sign-on isn't set up on this server")
+	errSSOState        = errors.New("single sign-on expired or was started in another browser, try again")
+	errSSODomain       = errors.New("your single sign-on account's email domain isn't allowed on this server")
+	errSSOEmail        = errors.New("your single sign-on provider didn't give a verified email")
+	errSSOLoginExpired = errors.New("single sign-on expired, try again")
+)
+
+type ssoClient struct {
+	oauth    oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// ssoState is what a started sign in remembers until the callback. LinkUserID is set when a logged in user is
+// linking their provider account.
+type ssoState struct {
+	Verifier   string `json:"verifier"`
+	Nonce      string `json:"nonce"`
+	LinkUserID int64  `json:"link_user_id,omitempty"`
+}
+
+// sso gets the client, discovering the provider the first time. a failed discovery is tried again next time so
+// a provider that's down when keylock starts doesn't disable single sign-on.
+func (s *Server) sso(ctx context.Context) (*ssoClient, error) {
+	cfg := config.DefaultConfig.OIDC
+	if cfg.Issuer == "" {
+		return nil, errSSODisabled
+	}
+	s.ssoMu.Lock()
+	defer s.ssoMu.Unlock()
+	if s.ssoClient != nil {
+		return s.ssoClient, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	s.ssoClient = &ssoClient{
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+	}
+	return s.ssoClient, nil
+}
+
+// startSSO sends the browser to the provider. linkUserID is 0 for a login.
+func (s *Server) startSSO(c *fiber.Ctx, linkUserID int64) error {
+	client, err := s.sso(c.UserContext())
+	if err != nil {
+		return ssoFailed(c, linkUserID != 0, err)
+	}
+	state := randomToken()
+	st := ssoState{Verifier: oauth2.GenerateVerifier(), Nonce: randomToken(), LinkUserID: linkUserID}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("marshal sso state: %w", err)
+	}
+	if err := cache.SetWithExpiration(c.UserContext(), "sso:state:"+state, string(data), ssoStateTTL); err != nil {
+		return fmt.Errorf("saving sso state: %w", err)
+	}
+	// lax, the provider's redirect back is a cross site navigation
+	c.Cookie(&fiber.Cookie{
+		Name:     "sso_state",
+		Value:    state,
+		Path:     "/sso",
+		MaxAge:   int(ssoStateTTL.Seconds()),
+		HTTPOnly: true,
+		Secure:   true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(client.oauth.AuthCodeURL(state, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier)))
+}
+
+// ssoFailed sends the browser back to where it started with the error.
+func ssoFailed(c *fiber.Ctx, link bool, err error) error {
+	page := "/login"
+	if link {
+		page = "/security"
+	}
+	slog.Warn("single sign-on failed", "err", err)
+	msg := err.Error()
+	// don't show the user internal errors
+	var ferr *fiber.Error
+	if !errors.As(err, &ferr) && !isSSOUserError(err) {
+		msg = "single sign-on failed, try again"
+	}
+	return sameSiteRedirect(c, page+"?sso_error="+url.QueryEscape(msg))
+}
+
+// sameSiteRedirect sends the browser to page from a page of ours instead of with a redirect. the provider's redirect
+// back to the callback is a cross site navigation, and a redirect from there still is, so the browser wouldn't send
+// the (strict) session cookie and /security would say unauthorized.
+func sameSiteRedirect(c *fiber.Ctx, page string) error {
+	c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+	return c.SendString(`<!DOCTYPE html><meta http-equiv="refresh" content="0; url=` + html.EscapeString(page) + `">`)
+}
+
+func isSSOUserError(err error) bool {
+	for _, target := range []error{errSSODisabled, errSSOState, errSSODomain, errSSOEmail, database.ErrIdentityNotLinked, database.ErrIdentityLinked} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// SSOLogin starts a single sign-on login.
+func SSOLogin(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return s.startSSO(c, 0)
+	}
+}
+
+// SSOLink starts linking a provider account to the logged in user.
+func SSOLink(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p, err := principal(c)
+		if err != nil {
+			return ssoFailed(c, true, err)
+		}
+		return s.startSSO(c, p.UserID)
+	}
+}
+
+// SSOCallback is where the provider sends the browser back to.
+func SSOCallback(s *Server) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+
+		// step 1: the state has to be one we started, in this browser
+		state := c.Query("state")
+		cookie := c.Cookies("sso_state")
+		c.ClearCookie("sso_state")
+		if state == "" || state != cookie {
+			return ssoFailed(c, false, errSSOState)
+		}
+		data, err := cache.GetDel(ctx, "sso:state:"+state)
+		if errors.Is(err, redis.Nil) {
+			return ssoFailed(c, false, errSSOState)
+		}
+		if err != nil {
+			return fmt.Errorf("getting sso state: %w", err)
+		}
+		var st ssoState
+		if err := json.Unmarshal([]byte(data), &st); err != nil {
+			return fmt.Errorf("unmarshal sso state: %w", err)
+		}
+		link := st.LinkUserID != 0
+		if e := c.Query("error"); e != "" {
+			return ssoFailed(c, link, fmt.Errorf("provider: %s %s", e, c.Query("error_description")))
+		}
+
+		// step 2: exchange the code and check the id token
+		client, err := s.sso(ctx)
+		if err != nil {
+			return ssoFailed(c, link, err)
+		}
+		token, err := client.oauth.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(st.Verifier))
+		if err != nil {
+			return ssoFailed(c, link, fmt.Errorf("exchanging code: %w", err))
+		}
+		rawIDToken, ok := token.Extra("id_token").(string)
+		if !ok {
+			return ssoFailed(c, link, errors.New("no id token in the token response"))
+		}
+		idToken, err := client.verifier.Verify(ctx, rawIDToken)
+		if err != nil {
+			return ssoFailed(c, link, fmt.Errorf("verifying id token: %w", err))
+		}
+		if idToken.Nonce != st.Nonce {
+			return ssoFailed(c, link, errors.New("id token nonce doesn't match"))
+		}
+
+		// step 3: the email has to be verified and in an allowed domain
+		var claims struct {
+			Email         string `json:"email"`
+			EmailVerified any    `json:"email_verified"` // some providers send "true"
+		}
+		if err := idToken.Claims(&claims); err != nil {
+			return ssoFailed(c, link, fmt.Errorf("id token claims: %w", err))
+		}
+		if err := checkSSOEmail(claims.Email, claims.EmailVerified); err != nil {
+			s.db.Audit(ctx, st.LinkUserID, database.AuditSSOFailed, c.IP(), fmt.Sprintf("%s (%s)", err, claims.Email))
+			return ssoFailed(c, link, err)
+		}
+
+		// step 4: link, or hand the login page a token
+		if link {
+			identity, err := s.db.LinkIdentity(ctx, st.LinkUserID, idToken.Issuer, idToken.Subject, claims.Email)
+			if err != nil {
+				return ssoFailed(c, link, err)
+			}
+			s.db.Audit(ctx, st.LinkUserID, database.AuditSSOLinked, c.IP(), identity.Email)
+			return sameSiteRedirect(c, "/security")
+		}
+		user, err := s.db.UseIdentity(ctx, idToken.Issuer, idToken.Subject, claims.Email)
+		if err != nil {
+			if errors.Is(err, database.ErrIdentityNotLinked) {
+				s.db.Audit(ctx, 0, database.AuditSSOFailed, c.IP(), "not linked: "+claims.Email)
+			}
+			return ssoFailed(c, link, err)
+		}
+		loginToken := randomToken()
+		if err := cache.SetWithExpiration(ctx, "sso:login:"+loginToken, strconv.FormatInt(user.ID, 10), ssoLoginTTL); err != nil {
+			return fmt.Errorf("saving sso login: %w", err)
+		}
+		return c.Redirect("/login?sso=" + loginToken)
+	}
+}
+
+func checkSSOEmail(email string, verified any) error {
+	if v, _ := verified.(bool); !v && verified != "true" {
+		return errSSOEmail
+	}
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return errSSOEmail
+	}
+	domains := config.DefaultConfig.OIDC.AllowedDomains
+	if len(domains) == 0 {
+		return nil
+	}
+	domain := strings.ToLower(email[at+1:])
+	if !slices.ContainsFunc(domains, func(d string) bool { return strings.EqualFold(d, domain) }) {
+		return errSSODomain
+	}
+	return nil
+}
+
+// APISSOLogin turns the token from /sso/callback into a session. users with two-factor still need their code,
+// the token stays valid (until it expires) while they get it right.
+func APISSOLogin(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.SSOLoginRequest) (*api.SSOLoginResponse, error) {
+		ctx := c.UserContext()
+		key := "sso:login:" + req.Body.Token
+		value, err := cache.Get(ctx, key)
+		if errors.Is(err, redis.Nil) {
+			return nil, api.Unauthorized(errSSOLoginExpired)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getting sso login: %w", err)
+		}
+		userID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("sso login: %w", err)
+		}
+		user, err := s.db.GetUserByID(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+
+		// no master password here, so this doesn't unlock a locked code
+		_, err = s.guardSignIn(c, user.Name, func() (int64, error) {
+			factor := database.SecondFactor{TOTP: req.Body.TOTP, RecoveryCode: req.Body.RecoveryCode}
+			_, err := s.db.VerifySecondFactor(ctx, user.ID, factor)
+			return user.ID, err
+		})
+		if err != nil {
+			return nil, twoFactorErr(err)
+		}
+		if err := cache.Del(ctx, key); err != nil {
+			return nil, fmt.Errorf("deleting sso login: %w", err)
+		}
+
+		sessionID, refresh, err := newSessionForUser(c, user, user.TwoFactor, "")
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		s.db.Audit(ctx, user.ID, database.AuditSSOLogin, c.IP(), "")
+		slog.Info("user logged in with single sign-on", "name", user.Name, "id", user.ID, "two_factor", user.TwoFactor)
+		return &api.SSOLoginResponse{
+			Cookies: api.SSOLoginResponseCookies{
+				Session: sessionID,
+				Refresh: refresh,
+			},
+			Body: api.SSOLoginResponseBody{
+				UserID:     user.ID,
+				Name:       user.Name,
+				CodeFormat: user.CodeFormat,
+				TwoFactor:  user.TwoFactor,
+			},
+		}, nil
+	})
+}
+
+func APIListIdentities(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListIdentitiesRequest) (*api.ListIdentitiesResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		identities, err := s.db.ListIdentities(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListIdentitiesResponse{
+			Body: api.ListIdentitiesResponseBody{
+				Identities: identities,
+			},
+		}, nil
+	})
+}
+
+func APIUnlinkIdentity(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.UnlinkIdentityRequest) (*api.UnlinkIdentityResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.UnlinkIdentity(c.UserContext(), p.UserID, req.Body.ID); err != nil {
+			if errors.Is(err, database.ErrIdentityNotFound) {
+				return nil, api.NotFound(err)
+			}
+			return nil, err
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditSSOUnlinked, c.IP(), fmt.Sprintf("id %d", req.Body.ID))
+		return &api.UnlinkIdentityResponse{}, nil
+	})
+}
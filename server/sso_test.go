@@ -0,0 +1,422 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"html"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/server/middlewares"
+)
+
+// mockProvider is an openid connect provider: discovery, a jwks with one rsa key and a token endpoint that checks
+// pkce. there's no login page, authorize hands out a code for what the browser was sent to directly.
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	idToken   string
+}
+
+const mockClientID = "keylock-test"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   b64(key.N.Bytes()),
+			"e":   b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	oidc := config.DefaultConfig.OIDC
+	config.DefaultConfig.OIDC.Issuer = p.URL
+	config.DefaultConfig.OIDC.ClientID = mockClientID
+	config.DefaultConfig.OIDC.ClientSecret = "secret"
+	config.DefaultConfig.OIDC.RedirectURL = "http://localhost:8755/sso/callback"
+	config.DefaultConfig.OIDC.AllowedDomains = nil
+	t.Cleanup(func() { config.DefaultConfig.OIDC = oidc })
+	return p
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	grant, ok := p.codes[r.Form.Get("code")]
+	delete(p.codes, r.Form.Get("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || b64(sum[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     grant.idToken,
+	})
+}
+
+func (p *mockProvider) sign(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + b64(sig)
+}
+
+// authorize is the user signing in at the provider after keylock sent their browser to authURL. claims are the id
+// token's on top of the ones every token has. it returns the code the provider redirects back with.
+func (p *mockProvider) authorize(t *testing.T, authURL string, claims map[string]any) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(authURL, p.URL+"/authorize") || q.Get("client_id") != mockClientID {
+		t.Fatalf("sent to %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("no s256 pkce challenge in %s", authURL)
+	}
+
+	now := time.Now()
+	token := map[string]any{
+		"iss":   p.URL,
+		"aud":   mockClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	}
+	for k, v := range claims {
+		token[k] = v
+	}
+	code := randomToken()
+	p.mu.Lock()
+	p.codes[code] = mockGrant{challenge: q.Get("code_challenge"), idToken: p.sign(t, token)}
+	p.mu.Unlock()
+	return code
+}
+
+func ssoApp(s *Server) *fiber.App {
+	app := fiber.New()
+	app.Get("/sso/login", SSOLogin(s))
+	app.Get("/sso/link", middlewares.SessionMiddleware(s.db, false), SSOLink(s))
+	app.Get("/sso/callback", SSOCallback(s))
+	app.Post("/api/sso/login", APISSOLogin(s))
+	return app
+}
+
+// get sends a browser navigation with the cookie (and u's session). it returns the response and where it sends the
+// browser, with a redirect or with sameSiteRedirect's page.
+func get(t *testing.T, app *fiber.App, path string, cookie *http.Cookie, u testUser) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	if u.session != "" {
+		req.Header.Set(fiber.HeaderAuthorization, "Bearer "+u.session)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	if loc := resp.Header.Get(fiber.HeaderLocation); loc != "" {
+		return resp, loc
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, page, ok := strings.Cut(string(body), "url=")
+	if !ok {
+		t.Fatalf("GET %s: status %d, no redirect (%s)", path, resp.StatusCode, body)
+	}
+	page, _, _ = strings.Cut(page, `"`)
+	return resp, html.UnescapeString(page)
+}
+
+// beginSSO goes to path (/sso/login, or /sso/link with u's session) and returns where the browser is sent and the
+// state cookie.
+func beginSSO(t *testing.T, app *fiber.App, path string, u testUser) (string, *http.Cookie) {
+	t.Helper()
+	resp, authURL := get(t, app, path, nil, u)
+	for _, c := range resp.Cookies() {
+		if c.Name == "sso_state" {
+			return authURL, &http.Cookie{Name: c.Name, Value: c.Value}
+		}
+	}
+	t.Fatalf("GET %s: no state cookie (sent to %s)", path, authURL)
+	return "", nil
+}
+
+func queryOf(t *testing.T, authURL, key string) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Query().Get(key)
+}
+
+func stateOf(t *testing.T, authURL string) string {
+	t.Helper()
+	return queryOf(t, authURL, "state")
+}
+
+// callback is the provider sending the browser back, it returns where keylock sends it next.
+func callback(t *testing.T, app *fiber.App, cookie *http.Cookie, state, code string) string {
+	t.Helper()
+	_, page := get(t, app, "/sso/callback?"+url.Values{"state": {state}, "code": {code}}.Encode(), cookie, testUser{})
+	return page
+}
+
+// ssoSignIn is a whole sign in at the provider with claims, started from path.
+func ssoSignIn(t *testing.T, app *fiber.App, p *mockProvider, path string, u testUser, claims map[string]any) string {
+	t.Helper()
+	authURL, cookie := beginSSO(t, app, path, u)
+	return callback(t, app, cookie, stateOf(t, authURL), p.authorize(t, authURL, claims))
+}
+
+// wantSSOError checks that the browser was sent to page with err (or any error for nil)
+func wantSSOError(t *testing.T, what, got, page string, err error) {
+	t.Helper()
+	u, perr := url.Parse(got)
+	if perr != nil || u.Path != page || u.Query().Get("sso_error") == "" {
+		t.Fatalf("%s: sent to %q, want %s with an error", what, got, page)
+	}
+	if err != nil && u.Query().Get("sso_error") != err.Error() {
+		t.Fatalf("%s: error %q, want %q", what, u.Query().Get("sso_error"), err)
+	}
+}
+
+// ssoServer is a server for what doesn't get as far as the database, the rest uses testServer.
+func ssoServer(t *testing.T) *Server {
+	t.Helper()
+	useMiniredis(t)
+	return &Server{}
+}
+
+func TestCheckSSOEmail(t *testing.T) {
+	domains := config.DefaultConfig.OIDC.AllowedDomains
+	t.Cleanup(func() { config.DefaultConfig.OIDC.AllowedDomains = domains })
+
+	config.DefaultConfig.OIDC.AllowedDomains = nil
+	tests := []struct {
+		email    string
+		verified any
+		want     error
+	}{
+		{"alice@example.com", true, nil},
+		{"alice@example.com", "true", nil},
+		{"alice@example.com", false, errSSOEmail},
+		{"alice@example.com", "false", errSSOEmail},
+		{"alice@example.com", nil, errSSOEmail},
+		{"alice", true, errSSOEmail},
+	}
+	for _, tt := range tests {
+		if err := checkSSOEmail(tt.email, tt.verified); err != tt.want {
+			t.Errorf("checkSSOEmail(%q, %v) = %v, want %v", tt.email, tt.verified, err, tt.want)
+		}
+	}
+
+	config.DefaultConfig.OIDC.AllowedDomains = []string{"example.com"}
+	tests = []struct {
+		email    string
+		verified any
+		want     error
+	}{
+		{"alice@example.com", true, nil},
+		{"Alice@EXAMPLE.com", true, nil},
+		{"alice@example.com.evil.example", true, errSSODomain},
+		{"alice@evil.example", true, errSSODomain},
+		{"alice@sub.example.com", true, errSSODomain},
+		{"alice@evil.example", false, errSSOEmail},
+	}
+	for _, tt := range tests {
+		if err := checkSSOEmail(tt.email, tt.verified); err != tt.want {
+			t.Errorf("allowed domains: checkSSOEmail(%q, %v) = %v, want %v", tt.email, tt.verified, err, tt.want)
+		}
+	}
+}
+
+func TestSSOStateMismatch(t *testing.T) {
+	p := newMockProvider(t)
+	app := ssoApp(ssoServer(t))
+
+	authURL, _ := beginSSO(t, app, "/sso/login", testUser{})
+	state := stateOf(t, authURL)
+	code := p.authorize(t, authURL, nil)
+
+	// the callback in a browser that didn't start it, or with another sign in's state
+	got := callback(t, app, nil, state, code)
+	wantSSOError(t, "callback without the cookie", got, "/login", errSSOState)
+	otherURL, otherCookie := beginSSO(t, app, "/sso/login", testUser{})
+	got = callback(t, app, otherCookie, state, code)
+	wantSSOError(t, "callback with another sign in's cookie", got, "/login", errSSOState)
+	got = callback(t, app, &http.Cookie{Name: "sso_state", Value: "made-up"}, "made-up", code)
+	wantSSOError(t, "callback with a state we didn't start", got, "/login", errSSOState)
+
+	// a state only comes back once: the first callback fails at the provider (a code for another sign in's
+	// challenge), the second can't have another go with a good code
+	got = callback(t, app, otherCookie, stateOf(t, otherURL), code)
+	wantSSOError(t, "callback with a code for another challenge", got, "/login", nil)
+	got = callback(t, app, otherCookie, stateOf(t, otherURL), p.authorize(t, otherURL, nil))
+	wantSSOError(t, "callback with a used state", got, "/login", errSSOState)
+}
+
+func TestSSOPKCE(t *testing.T) {
+	p := newMockProvider(t)
+	app := ssoApp(ssoServer(t))
+
+	// every sign in has its own verifier
+	authURL, cookie := beginSSO(t, app, "/sso/login", testUser{})
+	otherURL, _ := beginSSO(t, app, "/sso/login", testUser{})
+	if queryOf(t, authURL, "code_challenge") == queryOf(t, otherURL, "code_challenge") {
+		t.Fatal("two sign ins have the same pkce challenge")
+	}
+
+	// someone who intercepted a code for their own sign in can't redeem it in the victim's: the provider wants the
+	// verifier of the sign in the code was issued to
+	got := callback(t, app, cookie, stateOf(t, authURL), p.authorize(t, otherURL, nil))
+	wantSSOError(t, "code for another challenge", got, "/login", nil)
+}
+
+func TestSSOEmailChecks(t *testing.T) {
+	s := testServer(t)
+	p := newMockProvider(t)
+	app := ssoApp(s)
+	alice := newTestUser(t, s, "alice")
+	got := ssoSignIn(t, app, p, "/sso/link", alice, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+	if got != "/security" {
+		t.Fatalf("linking: sent to %s", got)
+	}
+
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": false})
+	wantSSOError(t, "unverified email", got, "/login", errSSOEmail)
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, map[string]any{"sub": "alice", "email": "alice@example.com"})
+	wantSSOError(t, "no email_verified", got, "/login", errSSOEmail)
+
+	config.DefaultConfig.OIDC.AllowedDomains = []string{"keylock.example"}
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, map[string]any{"sub": "alice", "email": "alice@example.com", "email_verified": true})
+	wantSSOError(t, "domain that isn't allowed", got, "/login", errSSODomain)
+	got = ssoSignIn(t, app, p, "/sso/link", alice, map[string]any{"sub": "alice2", "email": "alice@example.com", "email_verified": true})
+	wantSSOError(t, "linking with a domain that isn't allowed", got, "/security", errSSODomain)
+
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, map[string]any{"sub": "alice", "email": "alice@KEYLOCK.example", "email_verified": "true"})
+	if !strings.HasPrefix(got, "/login?sso=") {
+		t.Fatalf("allowed domain: sent to %s", got)
+	}
+}
+
+func TestSSOLinkAndLogin(t *testing.T) {
+	s := testServer(t)
+	p := newMockProvider(t)
+	app := ssoApp(s)
+	alice := newTestUser(t, s, "alice")
+	bob := newTestUser(t, s, "bob")
+	claims := func(sub, email string) map[string]any {
+		return map[string]any{"sub": sub, "email": email, "email_verified": true}
+	}
+
+	got := ssoSignIn(t, app, p, "/sso/login", testUser{}, claims("alice-sub", "alice@example.com"))
+	wantSSOError(t, "logging in before linking", got, "/login", database.ErrIdentityNotLinked)
+
+	got = ssoSignIn(t, app, p, "/sso/link", alice, claims("alice-sub", "alice@example.com"))
+	if got != "/security" {
+		t.Fatalf("linking: sent to %s", got)
+	}
+	// one provider account is one keylock account
+	got = ssoSignIn(t, app, p, "/sso/link", bob, claims("alice-sub", "alice@example.com"))
+	wantSSOError(t, "bob linking alice's provider account", got, "/security", database.ErrIdentityLinked)
+
+	// the subject decides who it is, not the email
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, claims("mallory-sub", "alice@example.com"))
+	wantSSOError(t, "another subject with alice's email", got, "/login", database.ErrIdentityNotLinked)
+
+	got = ssoSignIn(t, app, p, "/sso/login", testUser{}, claims("alice-sub", "alice@new.example.com"))
+	token, ok := strings.CutPrefix(got, "/login?sso=")
+	if !ok {
+		t.Fatalf("logging in: sent to %s", got)
+	}
+	status, body := call(t, app, testUser{}, http.MethodPost, "/api/sso/login", map[string]any{"token": token})
+	wantStatus(t, "turning the token into a session", status, body, http.StatusOK)
+	var login api.SSOLoginResponseBody
+	if err := json.Unmarshal(body, &login); err != nil {
+		t.Fatal(err)
+	}
+	if login.UserID != alice.ID || login.Name != "alice" {
+		t.Fatalf("logged in as %+v, want alice", login)
+	}
+	status, body = call(t, app, testUser{}, http.MethodPost, "/api/sso/login", map[string]any{"token": token})
+	wantStatus(t, "using the token again", status, body, http.StatusUnauthorized)
+
+	// the email is kept up to date from the provider
+	identities, err := s.db.ListIdentities(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 1 || identities[0].Issuer != p.URL || identities[0].Email != "alice@new.example.com" {
+		t.Fatalf("alice's identities: %+v", identities)
+	}
+}
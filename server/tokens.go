@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/authz"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+var (
+	errTokenNewPassword = errors.New("access tokens can't save new passwords, only change the ones in their scopes")
+	errTokenList        = errors.New("this access token doesn't have the list scope")
+)
+
+func APICreateAccessToken(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.CreateAccessTokenRequest) (*api.CreateAccessTokenResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		scopes := make([]authz.Scope, 0, len(req.Body.Scopes))
+		for _, raw := range req.Body.Scopes {
+			scope, err := authz.ParseScope(raw)
+			if err != nil {
+				return nil, api.Validation(err)
+			}
+			scopes = append(scopes, scope)
+		}
+
+		var t *database.AccessToken
+		var token string
+		err = s.guardCode(c, func() (err error) {
+			t, token, err = s.db.CreateAccessToken(c.UserContext(), p.UserID, req.Body.Name, req.Body.Key2, scopes, time.Duration(req.Body.ExpiresIn)*time.Second)
+			return
+		})
+		if err != nil {
+			if errors.Is(err, database.ErrAccessTokenScopes) {
+				return nil, api.Validation(err)
+			}
+			return nil, vaultErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessTokenCreated, c.IP(), fmt.Sprintf("token %d (%s)", t.ID, t.Name))
+		slog.Info("created access token", "user_id", p.UserID, "id", t.ID, "entries", t.Entries)
+		return &api.CreateAccessTokenResponse{
+			Body: api.CreateAccessTokenResponseBody{
+				Token:       token,
+				AccessToken: *t,
+			},
+		}, nil
+	})
+}
+
+func APIListAccessTokens(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListAccessTokensRequest) (*api.ListAccessTokensResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		tokens, err := s.db.ListAccessTokens(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListAccessTokensResponse{
+			Body: api.ListAccessTokensResponseBody{
+				Tokens: tokens,
+			},
+		}, nil
+	})
+}
+
+func APIRevokeAccessToken(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.RevokeAccessTokenRequest) (*api.RevokeAccessTokenResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.db.RevokeAccessToken(c.UserContext(), p.UserID, req.Body.ID); err != nil {
+			if errors.Is(err, database.ErrAccessTokenNotFound) {
+				return nil, api.NotFound(err)
+			}
+			return nil, err
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditAccessTokenRevoked, c.IP(), fmt.Sprintf("token %d", req.Body.ID))
+		return &api.RevokeAccessTokenResponse{}, nil
+	})
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skip2/go-qrcode"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
+	"github.com/tiredkangaroo/keylock/totp"
+)
+
+// the two-factor endpoints use getUser instead of principal, so a session that hasn't passed two-factor on a
+// server that requires it can still enroll.
+
+var errTwoFactorPolicy = errors.New("two-factor authentication is required, enable it and log in again")
+
+func APITwoFactorStatus(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.TwoFactorStatusRequest) (*api.TwoFactorStatusResponse, error) {
+		user := getUser(c)
+		status, err := s.db.GetTwoFactorStatus(c.UserContext(), user.ID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.TwoFactorStatusResponse{
+			Body: api.TwoFactorStatusResponseBody{
+				Status:    *status,
+				Required:  config.DefaultConfig.TwoFactor.Required,
+				Satisfied: sessionTwoFactor(c),
+			},
+		}, nil
+	})
+}
+
+func APIEnrollTwoFactor(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.EnrollTwoFactorRequest) (*api.EnrollTwoFactorResponse, error) {
+		user := getUser(c)
+		secret, err := s.db.BeginTwoFactor(c.UserContext(), user.ID)
+		if err != nil {
+			return nil, twoFactorErr(err)
+		}
+		uri := totp.URI(config.DefaultConfig.TwoFactor.Issuer, user.Name, secret)
+		qr, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			return nil, fmt.Errorf("qr code: %w", err)
+		}
+		slog.Info("two-factor enrollment started", "user_id", user.ID)
+		return &api.EnrollTwoFactorResponse{
+			Body: api.EnrollTwoFactorResponseBody{
+				Secret: totp.Encode(secret),
+				URI:    uri,
+				QRCode: qr,
+			},
+		}, nil
+	})
+}
+
+func APIConfirmTwoFactor(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ConfirmTwoFactorRequest) (*api.ConfirmTwoFactorResponse, error) {
+		user := getUser(c)
+		codes, err := s.db.EnableTwoFactor(c.UserContext(), user.ID, req.Body.Code)
+		if err != nil {
+			return nil, twoFactorErr(err)
+		}
+		s.db.Audit(c.UserContext(), user.ID, database.AuditTwoFactorEnabled, c.IP(), "")
+
+		// they just made a code, so this session passed two-factor too
+		var cookies api.ConfirmTwoFactorResponseCookies
+		sessionID, err := sessions.SetTwoFactor(c.UserContext(), user.ID, getSessionID(c))
+		if err != nil {
+			slog.Error("marking session as two-factor", "user_id", user.ID, "err", err)
+		} else if sessionID != getSessionID(c) {
+			cookies.Session = sessionID
+		}
+		return &api.ConfirmTwoFactorResponse{
+			Cookies: cookies,
+			Body: api.ConfirmTwoFactorResponseBody{
+				RecoveryCodes: codes,
+			},
+		}, nil
+	})
+}
+
+func APIDisableTwoFactor(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.DisableTwoFactorRequest) (*api.DisableTwoFactorResponse, error) {
+		user := getUser(c)
+		if config.DefaultConfig.TwoFactor.Required {
+			return nil, api.Forbidden(errTwoFactorPolicy)
+		}
+		err := s.guardCode(c, func() error {
+			return s.db.DisableTwoFactor(c.UserContext(), user.ID, database.SecondFactor{TOTP: req.Body.TOTP, RecoveryCode: req.Body.RecoveryCode})
+		})
+		if err != nil {
+			return nil, twoFactorErr(err)
+		}
+		s.db.Audit(c.UserContext(), user.ID, database.AuditTwoFactorDisabled, c.IP(), "")
+		return &api.DisableTwoFactorResponse{}, nil
+	})
+}
+
+func APINewRecoveryCodes(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewRecoveryCodesRequest) (*api.NewRecoveryCodesResponse, error) {
+		user := getUser(c)
+		var codes []string
+		err := s.guardCode(c, func() (err error) {
+			codes, err = s.db.RegenerateRecoveryCodes(c.UserContext(), user.ID, database.SecondFactor{TOTP: req.Body.TOTP, RecoveryCode: req.Body.RecoveryCode})
+			return
+		})
+		if err != nil {
+			return nil, twoFactorErr(err)
+		}
+		s.db.Audit(c.UserContext(), user.ID, database.AuditRecoveryCodesNew, c.IP(), "")
+		return &api.NewRecoveryCodesResponse{
+			Body: api.NewRecoveryCodesResponseBody{
+				RecoveryCodes: codes,
+			},
+		}, nil
+	})
+}
+
+func APISetOrgTwoFactor(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.SetOrgTwoFactorRequest) (*api.SetOrgTwoFactorResponse, error) {
+		p, org, err := s.orgMember(c, req.Body.Org)
+		if err != nil {
+			return nil, err
+		}
+		if !org.Role.IsAdmin() {
+			return nil, s.orgDenied(c, p, org, "change the two-factor policy")
+		}
+		// otherwise they'd lock themselves out
+		if req.Body.Required && !sessionTwoFactor(c) {
+			return nil, api.Forbidden(errors.New("enable two-factor and log in with it before requiring it"))
+		}
+		if err := s.db.SetOrgTwoFactor(c.UserContext(), org.ID, req.Body.Required); err != nil {
+			return nil, orgErr(err)
+		}
+		s.db.Audit(c.UserContext(), p.UserID, database.AuditOrgTwoFactor, c.IP(), fmt.Sprintf("required=%t in organization %q", req.Body.Required, org.Name))
+		return &api.SetOrgTwoFactorResponse{}, nil
+	})
+}
+
+func twoFactorErr(err error) error {
+	switch {
+	case errors.Is(err, database.ErrTwoFactorRequired):
+		return api.TwoFactorRequired(err)
+	case errors.Is(err, database.ErrInvalidTwoFactor):
+		return api.InvalidCredentials(err)
+	case errors.Is(err, database.ErrTwoFactorEnabled), errors.Is(err, database.ErrTwoFactorNotEnabled),
+		errors.Is(err, database.ErrTwoFactorNotEnrolled):
+		return api.Conflict(err)
+	}
+	return err
+}
@@ -3,30 +3,41 @@ package server
 import (
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
-	"strconv"
-	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/tiredkangaroo/keylock/cache"
 	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
 )
 
-var sessionExpiration time.Duration = time.Hour * 24 * 7
-
-func newSessionForUser(userID int64) (string, error) {
-	sessionIDRaw := make([]byte, 20)
-	rand.Read(sessionIDRaw)
-	sessionID := hex.EncodeToString(sessionIDRaw)
-
-	err := cache.HSetWithExpiration("user-session", sessionID, strconv.Itoa(int(userID)), sessionExpiration)
-	if err != nil {
-		return "", fmt.Errorf("redis save error: %w", err)
-	}
+// newSessionForUser is the only place sessions are made. twoFactor says whether the user passed two-factor to get it,
+// device is what the client calls itself (empty to guess from the user agent). the session lasts as long as the
+// server's session policy, made stricter by the user's own. refresh is only for signed sessions.
+func newSessionForUser(c *fiber.Ctx, user *database.User, twoFactor bool, device string) (id, refresh string, err error) {
+	return sessions.New(c.UserContext(), user.ID, twoFactor, sessions.Meta{
+		IP:        c.IP(),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		Device:    device,
+	}, sessions.PolicyFor(user.SessionIdleTimeout, user.SessionMaxLifetime))
+}
 
-	return sessionID, nil
+// randomToken makes an id for something kept in redis (ceremonies, single sign-on state).
+func randomToken() string {
+	raw := make([]byte, 20)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
 }
 
 func getUser(c *fiber.Ctx) *database.User {
 	return c.Locals("user").(*database.User)
 }
+
+func getSessionID(c *fiber.Ctx) string {
+	return c.Locals("session").(string)
+}
+
+// sessionTwoFactor reports whether the session passed two-factor.
+func sessionTwoFactor(c *fiber.Ctx) bool {
+	twoFactor, _ := c.Locals("two_factor").(bool)
+	return twoFactor
+}
+
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tiredkangaroo/keylock/api"
+	"github.com/tiredkangaroo/keylock/database"
+)
+
+func APINewVault(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.NewVaultRequest) (*api.NewVaultResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		if req.Body.CodeFormat != nil {
+			if err := req.Body.CodeFormat.Validate(); err != nil {
+				return nil, api.Validation(err)
+			}
+		}
+
+		var vault *database.Vault
+		var code string
+		err = s.guardCode(c, func() (err error) {
+			vault, code, err = s.db.CreateVault(c.UserContext(), p.UserID, req.Body.Name, req.Body.Key2, req.Body.CodeFormat)
+			return
+		})
+		if err != nil {
+			return nil, vaultErr(err)
+		}
+		slog.Info("created vault", "name", vault.Name, "id", vault.ID, "user_id", p.UserID, "own_code", code != "")
+		return &api.NewVaultResponse{
+			Body: api.NewVaultResponseBody{
+				Vault: *vault,
+				Code:  code,
+			},
+		}, nil
+	})
+}
+
+func APIListVaults(s *Server) fiber.Handler {
+	return api.Handler(func(c *fiber.Ctx, req *api.ListVaultsRequest) (*api.ListVaultsResponse, error) {
+		p, err := principal(c)
+		if err != nil {
+			return nil, err
+		}
+		vaults, err := s.db.ListVaults(c.UserContext(), p.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &api.ListVaultsResponse{
+			Body: api.ListVaultsResponseBody{
+				Vaults: vaults,
+			},
+		}, nil
+	})
+}
+
+// vaultErr is shareErr plus the vault errors.
+func vaultErr(err error) error {
+	switch {
+	case errors.Is(err, database.ErrVaultNotFound):
+		return api.NotFound(err)
+	case errors.Is(err, database.ErrVaultExists), errors.Is(err, database.ErrPasswordExists):
+		return api.Conflict(err)
+	case errors.Is(err, database.ErrVaultCodeRequired):
+		return api.InvalidCredentials(err)
+	}
+	return shareErr(err)
+}
@@ -0,0 +1,453 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tiredkangaroo/keylock/cache"
+	"github.com/tiredkangaroo/keylock/config"
+)
+
+// a session ends when it hasn't been used for its idle timeout, or at the latest its max
+// lifetime after it was made (see Policy). SessionMiddleware calls Touch, which pushes the expiration back.
+//
+// sessions live in redis (unless they're signed, see signed.go). the session id is the cookie (or Authorization header), it never leaves redis
+// otherwise: the devices page and the api name a session by its handle, the start of sha256(session id).
+//
+// cache keys:
+// user-session (hash)       - session id -> user id, with ":2fa" after it if the session passed two-factor. this is
+//                             all SessionMiddleware needs, deleting the field revokes the session
+// sessions:<user id> (hash) - handle -> json record (when, where from, what device), same ttl as the session
+
+// sessions from before the two-factor flag are just the user id, which reads as no two-factor
+const twoFactorSuffix = ":2fa"
+
+// how often last seen is written (and the expiration pushed back), so every request isn't a write
+const touchInterval = time.Minute
+
+var (
+	ErrNotFound = errors.New("session not found")
+)
+
+// Policy is how long sessions last. Idle 0 means no idle timeout.
+type Policy struct {
+	Idle time.Duration
+	Max  time.Duration
+}
+
+// PolicyFor is the server's policy (sessions in config) made stricter by a user's own, idle and max are in seconds
+// and 0 means the server's.
+func PolicyFor(idle, max int64) Policy {
+	cfg := config.DefaultConfig.Sessions
+	p := Policy{
+		Idle: time.Duration(cfg.IdleTimeout) * time.Second,
+		Max:  time.Duration(cfg.MaxLifetime) * time.Second,
+	}
+	if p.Max <= 0 {
+		p.Max = 7 * 24 * time.Hour
+	}
+	if d := time.Duration(idle) * time.Second; d > 0 && (p.Idle == 0 || d < p.Idle) {
+		p.Idle = d
+	}
+	if d := time.Duration(max) * time.Second; d > 0 && d < p.Max {
+		p.Max = d
+	}
+	return p
+}
+
+// Session is a session as the user sees it.
+type Session struct {
+	Handle      string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at"`             // when it ends unless it's used before then
+	EndsAt      time.Time `json:"ends_at"`                // when it ends however much it's used
+	IdleTimeout int64     `json:"idle_timeout,omitempty"` // in seconds
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+	Device      string    `json:"device"`
+	TwoFactor   bool      `json:"two_factor"`
+	Current     bool      `json:"current,omitempty"` // the session asking
+}
+
+// record is what's stored, the session id is only kept so the session can be revoked by its handle.
+type record struct {
+	Session
+	ID string `json:"session_id"`
+}
+
+// ttl is how long the session has left after it was last seen.
+func (r *record) ttl(now time.Time) time.Duration {
+	end := r.EndsAt
+	if r.IdleTimeout > 0 {
+		if idle := r.LastSeenAt.Add(time.Duration(r.IdleTimeout) * time.Second); idle.Before(end) {
+			end = idle
+		}
+	}
+	return end.Sub(now)
+}
+
+// Meta is where a session is made from.
+type Meta struct {
+	IP        string
+	UserAgent string
+	Device    string // empty to guess from the user agent
+}
+
+func value(userID int64, twoFactor bool) string {
+	v := strconv.FormatInt(userID, 10)
+	if twoFactor {
+		v += twoFactorSuffix
+	}
+	return v
+}
+
+func parseValue(v string) (int64, bool, error) {
+	raw, twoFactor := strings.CutSuffix(v, twoFactorSuffix)
+	userid, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("userid stored in redis is not an integer: %w", err)
+	}
+	return userid, twoFactor, nil
+}
+
+func recordsKey(userID int64) string {
+	return fmt.Sprintf("sessions:%d", userID)
+}
+
+// Handle is how a session is named outside of redis.
+func Handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// New makes a session for userID that lasts as long as policy says. twoFactor says whether the user passed
+// two-factor to get it. refresh is only for signed sessions (see signed.go), empty otherwise.
+func New(ctx context.Context, userID int64, twoFactor bool, meta Meta, policy Policy) (id, refresh string, err error) {
+	if Signed() {
+		return newSigned(userID, twoFactor, meta, policy)
+	}
+	return newRedis(ctx, userID, twoFactor, meta, policy)
+}
+
+func newRedis(ctx context.Context, userID int64, twoFactor bool, meta Meta, policy Policy) (string, string, error) {
+	raw := make([]byte, 20)
+	rand.Read(raw)
+	id := hex.EncodeToString(raw)
+
+	device := meta.Device
+	if device == "" {
+		device = DeviceName(meta.UserAgent)
+	}
+	now := time.Now().UTC()
+	rec := record{
+		Session: Session{
+			Handle:      Handle(id),
+			CreatedAt:   now,
+			LastSeenAt:  now,
+			EndsAt:      now.Add(policy.Max),
+			IdleTimeout: int64(policy.Idle.Seconds()),
+			IP:          meta.IP,
+			UserAgent:   meta.UserAgent,
+			Device:      device,
+			TwoFactor:   twoFactor,
+		},
+		ID: id,
+	}
+	ttl := rec.ttl(now)
+
+	if err := cache.HSetWithExpiration(ctx, "user-session", id, value(userID, twoFactor), ttl); err != nil {
+		return "", "", fmt.Errorf("redis save error: %w", err)
+	}
+	if err := saveRecord(ctx, userID, &rec, ttl); err != nil {
+		// a session nobody can see or revoke shouldn't exist
+		cache.HDel(context.WithoutCancel(ctx), "user-session", id)
+		return "", "", err
+	}
+	return id, "", nil
+}
+
+// saveRecord saves rec with ttl, or changes it without touching its expiration if ttl is 0.
+func saveRecord(ctx context.Context, userID int64, rec *record, ttl time.Duration) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+	if ttl == 0 {
+		err = cache.HSetKeepTTL(ctx, recordsKey(userID), rec.Handle, string(data))
+	} else {
+		err = cache.HSetWithExpiration(ctx, recordsKey(userID), rec.Handle, string(data), ttl)
+	}
+	if err != nil {
+		return fmt.Errorf("saving session: %w", err)
+	}
+	return nil
+}
+
+func getRecord(ctx context.Context, userID int64, handle string) (*record, error) {
+	data, err := cache.HGet(ctx, recordsKey(userID), handle)
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("getting session: %w", err)
+	}
+	return parseRecord(data)
+}
+
+func parseRecord(data string) (*record, error) {
+	var rec record
+	if err := json.Unmarshal([]byte(data), &rec); err != nil {
+		return nil, fmt.Errorf("unmarshal session: %w", err)
+	}
+	rec.ExpiresAt = rec.LastSeenAt.Add(rec.ttl(rec.LastSeenAt))
+	return &rec, nil
+}
+
+// Get gets the user the session id belongs to, and whether it passed two-factor. ErrNotFound if it doesn't exist
+// (expired or revoked).
+func Get(ctx context.Context, id string) (userID int64, twoFactor bool, err error) {
+	if Signed() {
+		return getSigned(id)
+	}
+	raw, err := cache.HGet(ctx, "user-session", id)
+	if errors.Is(err, redis.Nil) {
+		return 0, false, ErrNotFound
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("get session token from redis: %w", err)
+	}
+	return parseValue(raw)
+}
+
+// Touch records that the session was just used from ip and pushes its idle timeout back. it only writes once
+// every touchInterval (or half the idle timeout if that's shorter).
+func Touch(ctx context.Context, userID int64, id, ip string) {
+	if Signed() {
+		return // refreshing is what keeps a signed session alive
+	}
+	rec, err := getRecord(ctx, userID, Handle(id))
+	if err != nil {
+		// sessions from before records don't have one, they keep the expiration they were made with
+		if !errors.Is(err, ErrNotFound) {
+			slog.Error("touching session", "user_id", userID, "err", err)
+		}
+		return
+	}
+	interval := touchInterval
+	if idle := time.Duration(rec.IdleTimeout) * time.Second / 2; idle > 0 && idle < interval {
+		interval = idle
+	}
+	now := time.Now().UTC()
+	if now.Sub(rec.LastSeenAt) < interval && rec.IP == ip {
+		return
+	}
+	rec.LastSeenAt = now
+	rec.IP = ip
+	ttl := rec.ttl(now)
+	if ttl <= 0 {
+		return // about to expire by itself
+	}
+	if err := saveRecord(ctx, userID, rec, ttl); err != nil {
+		slog.Error("touching session", "user_id", userID, "err", err)
+		return
+	}
+	if err := cache.HExpire(ctx, "user-session", ttl, id); err != nil {
+		slog.Error("touching session", "user_id", userID, "err", err)
+	}
+}
+
+// Info gets the session id of userID as the user sees it, mostly for when it expires.
+func Info(ctx context.Context, userID int64, id string) (*Session, error) {
+	if Signed() {
+		return infoSigned(id)
+	}
+	rec, err := getRecord(ctx, userID, Handle(id))
+	if errors.Is(err, ErrNotFound) {
+		// sessions from before records only have their expiration in redis
+		ttl, ok, err := cache.HTTL(ctx, "user-session", id)
+		if err != nil {
+			return nil, fmt.Errorf("getting session ttl: %w", err)
+		}
+		if !ok {
+			return nil, ErrNotFound
+		}
+		end := time.Now().UTC().Add(ttl)
+		return &Session{Handle: Handle(id), ExpiresAt: end, EndsAt: end, Current: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rec.Current = true
+	return &rec.Session, nil
+}
+
+// SetTwoFactor marks the session as having passed two-factor. it returns the session id to use from now on, which
+// is only different for signed sessions (their claims can't change, so they get a new token).
+func SetTwoFactor(ctx context.Context, userID int64, id string) (string, error) {
+	if Signed() {
+		return setTwoFactorSigned(id)
+	}
+	if err := cache.HSetKeepTTL(ctx, "user-session", id, value(userID, true)); err != nil {
+		return "", err
+	}
+	rec, err := getRecord(ctx, userID, Handle(id))
+	if errors.Is(err, ErrNotFound) {
+		return id, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	rec.TwoFactor = true
+	return id, saveRecord(ctx, userID, rec, 0)
+}
+
+// List lists the user's sessions, newest first. current is the session id asking (may be empty). signed sessions
+// aren't stored anywhere, so that's only the current one.
+func List(ctx context.Context, userID int64, current string) ([]Session, error) {
+	if Signed() {
+		if current == "" {
+			return nil, nil
+		}
+		info, err := infoSigned(current)
+		if err != nil {
+			return nil, err
+		}
+		return []Session{*info}, nil
+	}
+	all, err := cache.HGetAll(ctx, recordsKey(userID))
+	if err != nil {
+		return nil, fmt.Errorf("listing sessions: %w", err)
+	}
+	currentHandle := ""
+	if current != "" {
+		currentHandle = Handle(current)
+	}
+	list := make([]Session, 0, len(all))
+	for _, data := range all {
+		rec, err := parseRecord(data)
+		if err != nil {
+			return nil, err
+		}
+		rec.Current = rec.Handle == currentHandle
+		list = append(list, rec.Session)
+	}
+	slices.SortFunc(list, func(a, b Session) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return list, nil
+}
+
+// Revoke ends one of the user's sessions by its handle. it's gone for the next request.
+func Revoke(ctx context.Context, userID int64, handle string) error {
+	if Signed() {
+		return revokeSigned(ctx, handle)
+	}
+	rec, err := getRecord(ctx, userID, handle)
+	if err != nil {
+		return err
+	}
+	if err := cache.HDel(ctx, "user-session", rec.ID); err != nil {
+		return fmt.Errorf("revoking session: %w", err)
+	}
+	if err := cache.HDel(ctx, recordsKey(userID), handle); err != nil {
+		return fmt.Errorf("deleting session: %w", err)
+	}
+	return nil
+}
+
+// RevokeID is Revoke by session id.
+func RevokeID(ctx context.Context, userID int64, id string) error {
+	if Signed() {
+		return revokeIDSigned(ctx, id)
+	}
+	if err := cache.HDel(ctx, "user-session", id); err != nil {
+		return fmt.Errorf("revoking session: %w", err)
+	}
+	if err := cache.HDel(ctx, recordsKey(userID), Handle(id)); err != nil {
+		return fmt.Errorf("deleting session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAll ends every session of the user except the session id keep (empty to end them all) and returns how
+// many it ended (-1 for signed sessions, there's no telling).
+func RevokeAll(ctx context.Context, userID int64, keep string) (int, error) {
+	if Signed() {
+		return -1, revokeAllSigned(ctx, userID, keep)
+	}
+	all, err := cache.HGetAll(ctx, recordsKey(userID))
+	if err != nil {
+		return 0, fmt.Errorf("listing sessions: %w", err)
+	}
+	var ids, handles []string
+	for handle, data := range all {
+		rec, err := parseRecord(data)
+		if err != nil {
+			return 0, err
+		}
+		if keep != "" && rec.ID == keep {
+			continue
+		}
+		ids = append(ids, rec.ID)
+		handles = append(handles, handle)
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := cache.HDel(ctx, "user-session", ids...); err != nil {
+		return 0, fmt.Errorf("revoking sessions: %w", err)
+	}
+	if err := cache.HDel(ctx, recordsKey(userID), handles...); err != nil {
+		return 0, fmt.Errorf("deleting sessions: %w", err)
+	}
+	return len(ids), nil
+}
+
+// DeviceName guesses something like "Firefox on Linux" from a user agent.
+func DeviceName(userAgent string) string {
+	browser := ""
+	for _, b := range []struct{ token, name string }{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"}, {"Chrome/", "Chrome"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	os := ""
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"}, {"Windows", "Windows"}, {"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, o.token) {
+			os = o.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && os != "":
+		return browser + " on " + os
+	case browser != "":
+		return browser
+	case os != "":
+		return os
+	case userAgent != "":
+		if len(userAgent) > 64 {
+			return userAgent[:64]
+		}
+		return userAgent
+	}
+	return "unknown device"
+}
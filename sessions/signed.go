@@ -0,0 +1,387 @@
+package sessions
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tiredkangaroo/keylock/config"
+	"github.com/tiredkangaroo/keylock/vault"
+)
+
+// signed sessions (mode = "signed" in [sessions]) don't touch redis (the server still needs it, e.g. for the lockout,
+// see lockout.Counter). the session id is a jwt signed with ed25519
+// (the key is in vault) carrying the user, whether the session passed two-factor and when it expires. it only lasts
+// token_lifetime, a refresh token (also a jwt, one use) gets a new one and a new refresh token. the refresh token
+// lasts the idle timeout, so using it is what keeps a session alive, and neither outlives the max lifetime.
+//
+// nothing about a signed session is stored, so revoking one goes on the revocation list: a short list (in postgres,
+// see RevocationStore) of what's been revoked, kept until whatever it matches would have expired anyway. every
+// server keeps a copy in memory and reloads it every revocationSync.
+
+const (
+	ModeRedis  = "redis"
+	ModeSigned = "signed"
+
+	revocationSync = 30 * time.Second
+	// a refresh token still works this long after it was used, so requests that race to refresh don't log the
+	// user out
+	refreshGrace = 10 * time.Second
+)
+
+var ErrNotSigned = errors.New("sessions on this server aren't signed tokens, there's nothing to refresh")
+
+// every token has this header, anything else (another alg) is rejected before looking at the signature
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"JWT"}`))
+
+type claims struct {
+	Subject   string `json:"sub"` // the user id
+	SessionID string `json:"sid"` // the same for every token of a session
+	Type      string `json:"typ"` // "session" or "refresh"
+	TwoFactor bool   `json:"2fa,omitempty"`
+	Device    string `json:"dev,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	Expires   int64  `json:"exp"`
+	Start     int64  `json:"start"`          // when the session was made
+	End       int64  `json:"end"`            // its max lifetime
+	Idle      int64  `json:"idle,omitempty"` // its idle timeout in seconds
+	ID        string `json:"jti,omitempty"`  // refresh tokens, to use each once
+}
+
+// Revocation is an entry of the revocation list. Key is "session:<handle>" for a session, "user:<id>" for every
+// session of a user made before Before (except the one whose handle is Except), or "refresh:<jti>" for a used
+// refresh token (which stops working at Before). it's forgotten at Until, when everything it matches has expired.
+type Revocation struct {
+	Key    string
+	Before time.Time
+	Except string
+	Until  time.Time
+}
+
+// RevocationStore keeps the revocation list where every server can see it.
+type RevocationStore interface {
+	SaveRevocation(ctx context.Context, r Revocation) error
+	LoadRevocations(ctx context.Context) ([]Revocation, error) // only the ones that aren't past Until
+}
+
+var signed struct {
+	key   ed25519.PrivateKey
+	store RevocationStore
+
+	mu      sync.RWMutex
+	revoked map[string]Revocation
+}
+
+// Signed reports whether sessions are signed tokens.
+func Signed() bool {
+	return config.DefaultConfig.Sessions.Mode == ModeSigned
+}
+
+// InitSigned gets the signing key from vault and loads the revocation list, which is reloaded until ctx is done.
+// only for signed sessions.
+func InitSigned(ctx context.Context, store RevocationStore) error {
+	seed, err := hex.DecodeString(vault.GetSessionSigningKey(ctx))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return fmt.Errorf("session signing key in vault must be %d hex encoded bytes", ed25519.SeedSize)
+	}
+	signed.key = ed25519.NewKeyFromSeed(seed)
+	signed.store = store
+	if err := loadRevocations(ctx); err != nil {
+		return err
+	}
+	go func() {
+		ticker := time.NewTicker(revocationSync)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := loadRevocations(ctx); err != nil {
+					slog.Error("loading session revocations", "err", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func loadRevocations(ctx context.Context) error {
+	list, err := signed.store.LoadRevocations(ctx)
+	if err != nil {
+		return fmt.Errorf("loading revocations: %w", err)
+	}
+	revoked := make(map[string]Revocation, len(list))
+	for _, r := range list {
+		revoked[r.Key] = r
+	}
+	signed.mu.Lock()
+	signed.revoked = revoked
+	signed.mu.Unlock()
+	return nil
+}
+
+func revoke(ctx context.Context, r Revocation) error {
+	if err := signed.store.SaveRevocation(ctx, r); err != nil {
+		return fmt.Errorf("saving revocation: %w", err)
+	}
+	// this server knows right away, the others on their next load
+	signed.mu.Lock()
+	signed.revoked[r.Key] = r
+	signed.mu.Unlock()
+	return nil
+}
+
+// revokedAt reports whether c is revoked at now.
+func (c *claims) revokedAt(now time.Time) bool {
+	signed.mu.RLock()
+	defer signed.mu.RUnlock()
+	handle := Handle(c.SessionID)
+	if _, ok := signed.revoked["session:"+handle]; ok {
+		return true
+	}
+	if r, ok := signed.revoked["user:"+c.Subject]; ok && c.Start < r.Before.Unix() && r.Except != handle {
+		return true
+	}
+	if c.Type == "refresh" {
+		if r, ok := signed.revoked["refresh:"+c.ID]; ok && now.After(r.Before) {
+			return true
+		}
+	}
+	return false
+}
+
+// revocationTTL is how long a session revocation has to be kept: no session lasts longer than the server's max
+// lifetime.
+func revocationTTL() time.Duration {
+	return PolicyFor(0, 0).Max
+}
+
+func sign(c *claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("marshal claims: %w", err)
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(signed.key, []byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// parse verifies token and returns its claims. expired tokens are ErrNotFound unless checkExpiry is false.
+func parse(token, typ string, checkExpiry bool) (*claims, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != jwtHeader {
+		return nil, ErrNotFound
+	}
+	payload, sig, ok := strings.Cut(rest, ".")
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rawSig, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(signed.key.Public().(ed25519.PublicKey), []byte(header+"."+payload), rawSig) {
+		return nil, ErrNotFound
+	}
+	rawPayload, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	var c claims
+	if err := json.Unmarshal(rawPayload, &c); err != nil || c.Type != typ {
+		return nil, ErrNotFound
+	}
+	if checkExpiry && time.Now().Unix() >= c.Expires {
+		return nil, ErrNotFound
+	}
+	return &c, nil
+}
+
+func (c *claims) userID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("user id in session token is not an integer: %w", err)
+	}
+	return id, nil
+}
+
+// issue signs a session token and a refresh token for the session c describes, from now.
+func issue(c claims, now time.Time) (session, refresh string, err error) {
+	end := time.Unix(c.End, 0)
+	if !now.Before(end) {
+		return "", "", ErrNotFound
+	}
+	c.IssuedAt = now.Unix()
+
+	c.Type = "session"
+	c.ID = ""
+	c.Expires = earliest(now.Add(time.Duration(config.DefaultConfig.Sessions.TokenLifetime)*time.Second), end).Unix()
+	if session, err = sign(&c); err != nil {
+		return "", "", err
+	}
+
+	c.Type = "refresh"
+	c.ID = randomID()
+	c.Expires = c.End
+	if c.Idle > 0 {
+		c.Expires = earliest(now.Add(time.Duration(c.Idle)*time.Second), end).Unix()
+	}
+	c.TwoFactor = false // comes from the session token when it's used, see Refresh
+	if refresh, err = sign(&c); err != nil {
+		return "", "", err
+	}
+	return session, refresh, nil
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func randomID() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
+}
+
+func newSigned(userID int64, twoFactor bool, meta Meta, policy Policy) (string, string, error) {
+	device := meta.Device
+	if device == "" {
+		device = DeviceName(meta.UserAgent)
+	}
+	now := time.Now().UTC()
+	return issue(claims{
+		Subject:   strconv.FormatInt(userID, 10),
+		SessionID: randomID(),
+		TwoFactor: twoFactor,
+		Device:    device,
+		Start:     now.Unix(),
+		End:       now.Add(policy.Max).Unix(),
+		Idle:      int64(policy.Idle.Seconds()),
+	}, now)
+}
+
+func getSigned(id string) (int64, bool, error) {
+	c, err := parse(id, "session", true)
+	if err != nil {
+		return 0, false, err
+	}
+	if c.revokedAt(time.Now()) {
+		return 0, false, ErrNotFound
+	}
+	userID, err := c.userID()
+	return userID, c.TwoFactor, err
+}
+
+// Refresh uses a signed session's refresh token to get a new session token and refresh token. session is the
+// session token it came with (it may have expired), two-factor carries over from it. ErrNotFound if the refresh
+// token is expired, used or revoked.
+func Refresh(ctx context.Context, session, refresh string) (string, string, error) {
+	if !Signed() {
+		return "", "", ErrNotSigned
+	}
+	now := time.Now().UTC()
+	r, err := parse(refresh, "refresh", true)
+	if err != nil {
+		return "", "", err
+	}
+	if r.revokedAt(now) {
+		return "", "", ErrNotFound
+	}
+	if s, err := parse(session, "session", false); err == nil && s.SessionID == r.SessionID {
+		r.TwoFactor = s.TwoFactor
+	}
+	if err := revoke(ctx, Revocation{
+		Key:    "refresh:" + r.ID,
+		Before: now.Add(refreshGrace),
+		Until:  time.Unix(r.Expires, 0),
+	}); err != nil {
+		return "", "", err
+	}
+	return issue(*r, now)
+}
+
+// StableID is what stays the same for the whole session id belongs to, for keeping things about the session
+// elsewhere. that's id, except for a signed session, whose token changes every refresh.
+func StableID(id string) string {
+	if !Signed() {
+		return id
+	}
+	if c, err := parse(id, "session", false); err == nil {
+		return c.SessionID
+	}
+	return id
+}
+
+func setTwoFactorSigned(id string) (string, error) {
+	c, err := parse(id, "session", true)
+	if err != nil {
+		return "", err
+	}
+	c.TwoFactor = true
+	c.IssuedAt = time.Now().Unix()
+	return sign(c)
+}
+
+func infoSigned(id string) (*Session, error) {
+	c, err := parse(id, "session", true)
+	if err != nil {
+		return nil, err
+	}
+	end := time.Unix(c.End, 0).UTC()
+	// the refresh token issued with this one stops working at the idle timeout
+	expires := end
+	if c.Idle > 0 {
+		expires = earliest(time.Unix(c.IssuedAt, 0).UTC().Add(time.Duration(c.Idle)*time.Second), end)
+	}
+	return &Session{
+		Handle:      Handle(c.SessionID),
+		CreatedAt:   time.Unix(c.Start, 0).UTC(),
+		LastSeenAt:  time.Unix(c.IssuedAt, 0).UTC(),
+		ExpiresAt:   expires,
+		EndsAt:      end,
+		IdleTimeout: c.Idle,
+		Device:      c.Device,
+		TwoFactor:   c.TwoFactor,
+		Current:     true,
+	}, nil
+}
+
+func revokeSigned(ctx context.Context, handle string) error {
+	return revoke(ctx, Revocation{
+		Key:   "session:" + handle,
+		Until: time.Now().UTC().Add(revocationTTL()),
+	})
+}
+
+func revokeIDSigned(ctx context.Context, id string) error {
+	c, err := parse(id, "session", false)
+	if err != nil {
+		return err
+	}
+	return revokeSigned(ctx, Handle(c.SessionID))
+}
+
+func revokeAllSigned(ctx context.Context, userID int64, keep string) error {
+	except := ""
+	if c, err := parse(keep, "session", false); err == nil {
+		except = Handle(c.SessionID)
+	}
+	now := time.Now().UTC()
+	return revoke(ctx, Revocation{
+		Key:    "user:" + strconv.FormatInt(userID, 10),
+		Before: now,
+		Except: except,
+		Until:  now.Add(revocationTTL()),
+	})
+}
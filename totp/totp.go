@@ -0,0 +1,88 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// rfc 6238 with the parameters every authenticator app supports: hmac-sha1, 6 digits, 30 second steps.
+// a code is accepted one step early or late so clocks don't have to be perfect. the counter of the last accepted
+// code is kept by the caller and a code is only accepted for a later counter, so a code can't be used twice.
+
+const (
+	Digits     = 6
+	Period     = 30 // in seconds
+	SecretSize = 20 // what rfc 4226 recommends for sha1
+	Skew       = 1  // steps accepted on either side of now
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret generates a random secret.
+func NewSecret() []byte {
+	secret := make([]byte, SecretSize)
+	rand.Read(secret)
+	return secret
+}
+
+// Encode is how the secret is shown to the user (base32, what authenticator apps expect).
+func Encode(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI is the otpauth:// uri authenticator apps scan (as a qr code) to add the account.
+func URI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", Encode(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(Period))
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Counter is the time step t is in.
+func Counter(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// Code is the code for a counter (rfc 4226 hotp).
+func Code(secret []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+// Verify checks code against the steps around now and returns the counter it matched. codes for a counter at or
+// before lastCounter (the last one accepted) are rejected.
+func Verify(secret []byte, code string, now time.Time, lastCounter int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+	current := Counter(now)
+	for counter := current - Skew; counter <= current+Skew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(Code(secret, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
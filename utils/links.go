@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// one-time links: the secret is encrypted with aes-256-gcm under a random link key that only ever travels in the
+// url fragment (browsers don't send it to the server). with a passphrase, the key is pbkdf2-sha256 of the passphrase
+// salted with the link key, so the link alone isn't enough. the /s/<id> page does the same thing with webcrypto,
+// keep the two in sync.
+//
+// the server also gets a verifier of the key (hkdf of it), so opening a link proves the key (and passphrase) before
+// a view is used up: a typo in the passphrase doesn't burn a one-view link, and someone with only the id gets
+// nothing, not even the ciphertext to guess passphrases against.
+
+const LinkPassphraseIterations = 600_000
+
+// NewLinkKey returns a random link key and how it's written in the url fragment.
+func NewLinkKey() (key []byte, fragment string) {
+	key = make([]byte, 32)
+	rand.Read(key)
+	return key, base64.RawURLEncoding.EncodeToString(key)
+}
+
+// LinkCipherKey is the key the secret is encrypted with.
+func LinkCipherKey(linkKey []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return linkKey, nil
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, linkKey, LinkPassphraseIterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("pbkdf2 link key: %w", err)
+	}
+	return key, nil
+}
+
+// LinkVerifier is what the server checks before it hands out the ciphertext, cipherKey is from LinkCipherKey.
+func LinkVerifier(cipherKey []byte) ([]byte, error) {
+	v, err := hkdf.Key(sha256.New, cipherKey, nil, "keylock link verifier v1", 32)
+	if err != nil {
+		return nil, fmt.Errorf("hkdf link verifier: %w", err)
+	}
+	return v, nil
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// sealed boxes let anyone encrypt something that only the holder of an x25519 private key can open:
+// a fresh ephemeral key pair, ecdh with the recipient's public key, the shared secret through hkdf and then
+// aes-256-gcm. the output is ephemeral public key (32 bytes) || nonce (12 bytes) || ciphertext.
+// this is easy to do with webcrypto too (X25519 + HKDF + AES-GCM).
+
+const sealInfo = "keylock-seal"
+
+var ErrSealedTooShort = errors.New("sealed box too short")
+
+// NewKeyPair generates an x25519 key pair.
+func NewKeyPair() (publicKey, privateKey []byte, err error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return priv.PublicKey().Bytes(), priv.Bytes(), nil
+}
+
+// Seal encrypts plaintext to the x25519 public key.
+func Seal(publicKey, plaintext []byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("public key: %w", err)
+	}
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+	shared, err := eph.ECDH(pub)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+	key, err := sealKey(shared, eph.PublicKey().Bytes(), pub.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	ciphertext, err := Encrypt(key, nonce, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, 32+12+len(ciphertext))
+	out = append(out, eph.PublicKey().Bytes()...)
+	out = append(out, nonce...)
+	return append(out, ciphertext...), nil
+}
+
+// Unseal opens a box made with Seal using the x25519 private key.
+func Unseal(privateKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < 32+12 {
+		return nil, ErrSealedTooShort
+	}
+	priv, err := ecdh.X25519().NewPrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("private key: %w", err)
+	}
+	eph, err := ecdh.X25519().NewPublicKey(sealed[:32])
+	if err != nil {
+		return nil, fmt.Errorf("ephemeral key: %w", err)
+	}
+	shared, err := priv.ECDH(eph)
+	if err != nil {
+		return nil, fmt.Errorf("ecdh: %w", err)
+	}
+	key, err := sealKey(shared, sealed[:32], priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return Decrypt(key, sealed[32:44], sealed[44:])
+}
+
+// sealKey derives the aes key from the shared secret, bound to both public keys.
+func sealKey(shared, ephemeralPublic, recipientPublic []byte) ([]byte, error) {
+	info := make([]byte, 0, len(sealInfo)+64)
+	info = append(info, sealInfo...)
+	info = append(info, ephemeralPublic...)
+	info = append(info, recipientPublic...)
+	return hkdf.Key(sha256.New, shared, nil, string(info), 32)
+}
@@ -16,6 +16,7 @@ import (
 // keylock/psql - username
 // keylock/psql - password
 // keylock/encryption - key (enc_key) as hex encoded string 32 bytes/64 chars
+// keylock/sessions - signing_key, ed25519 seed for signed sessions as hex encoded string 32 bytes/64 chars
 
 var (
 	ErrWrongType      = errors.New("wrong type for key in vault")
@@ -49,9 +50,20 @@ func Init() {
 	client.SetToken(token)
 }
 
-func getSecretField[T any](path, key, subkey string) (T, error) {
+// withTimeout applies the per request deadline from config on top of ctx.
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if t := config.DefaultConfig.Vault.Timeout; t > 0 {
+		return context.WithTimeout(ctx, time.Duration(t)*time.Second)
+	}
+	return context.WithCancel(ctx)
+}
+
+func getSecretField[T any](ctx context.Context, path, key, subkey string) (T, error) {
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+
 	var zero T // zero value of type T
-	secret, err := v.client.KVv2(path).Get(context.Background(), key)
+	secret, err := v.client.KVv2(path).Get(ctx, key)
 	if err != nil {
 		return zero, err
 	}
@@ -67,8 +79,8 @@ func getSecretField[T any](path, key, subkey string) (T, error) {
 	return vt, nil
 }
 
-func mustGetSecretField[T any](path, key, subkey string) T {
-	v, err := getSecretField[T](path, key, subkey)
+func mustGetSecretField[T any](ctx context.Context, path, key, subkey string) T {
+	v, err := getSecretField[T](ctx, path, key, subkey)
 	if err != nil {
 		slog.Warn("must failed (using zero value)", "err", err.Error(), "path", path, "key", key, "subkey", subkey)
 		var zero T
@@ -77,18 +89,21 @@ func mustGetSecretField[T any](path, key, subkey string) T {
 	return v
 }
 
-func GetRedisUsername() string {
-	return mustGetSecretField[string]("keylock", "redis", "username")
+func GetRedisUsername(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "redis", "username")
+}
+func GetRedisPassword(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "redis", "password")
 }
-func GetRedisPassword() string {
-	return mustGetSecretField[string]("keylock", "redis", "password")
+func GetPostgresUsername(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "psql", "username")
 }
-func GetPostgresUsername() string {
-	return mustGetSecretField[string]("keylock", "psql", "username")
+func GetPostgresPassword(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "psql", "password")
 }
-func GetPostgresPassword() string {
-	return mustGetSecretField[string]("keylock", "psql", "password")
+func GetEncryptionKey(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "encryption", "key")
 }
-func GetEncryptionKey() string {
-	return mustGetSecretField[string]("keylock", "encryption", "key")
+func GetSessionSigningKey(ctx context.Context) string {
+	return mustGetSecretField[string](ctx, "keylock", "sessions", "signing_key")
 }
@@ -0,0 +1,96 @@
+// the devices page (web/views/devices.templ): sessions, their timeouts and pairing a new device. needs pairing.js.
+
+let pairing = null; // { code, keys, secrets, cancelled }
+async function startPairing() {
+    const sessionCode = await getSessionCode();
+    if (!sessionCode) {
+        devicesMessage("This browser can't unlock your passwords, so it can't pair another one. Log in with your master password first.");
+        return;
+    }
+    try {
+        const keys = await pairingKeyPair();
+        const offer = await pairingPost("/api/pairing/new", { public_key: keys.publicKey });
+        pairing = { code: offer.code, keys, cancelled: false };
+        document.getElementById("pair-qr").src = "data:image/png;base64," + offer.qr_code;
+        document.getElementById("pair-code").textContent = offer.code;
+        document.getElementById("pair-start").classList.add("hidden");
+        document.getElementById("pair-offer").classList.remove("hidden");
+
+        const current = pairing;
+        const joined = await pairingPoll(async () => {
+            if (current.cancelled) {
+                throw new Error("Pairing cancelled.");
+            }
+            const data = await pairingPost("/api/pairing/status", { code: current.code });
+            return data.joined ? data : null;
+        });
+        current.secrets = await pairingSecrets(keys.privateKey, joined.public_key);
+        document.getElementById("pair-device").textContent = joined.device;
+        document.getElementById("pair-check").textContent = current.secrets.check;
+        document.getElementById("pair-offer").classList.add("hidden");
+        document.getElementById("pair-joined").classList.remove("hidden");
+    } catch (error) {
+        devicesMessage(error.message);
+    }
+}
+async function approvePairing() {
+    try {
+        const sealed = await pairingSeal(pairing.secrets.key, await getSessionCode());
+        await pairingPost("/api/pairing/approve", { code: pairing.code, ...sealed });
+        window.location.reload();
+    } catch (error) {
+        devicesMessage(error.message);
+    }
+}
+// the server forgets the pairing when it expires
+function cancelPairing() {
+    if (pairing) {
+        pairing.cancelled = true;
+    }
+    window.location.reload();
+}
+async function devicesPost(path, body) {
+    const response = await fetch(path, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body || {}),
+    });
+    const data = await response.json();
+    if (data.error) {
+        throw new Error(data.error);
+    }
+    return data;
+}
+function devicesMessage(message) {
+    const el = document.getElementById("devices-message");
+    el.innerText = message;
+    el.classList.remove("hidden");
+}
+function revokeSession(id) {
+    devicesPost("/api/sessions/revoke", { id }).then(() => {
+        window.location.reload();
+    }).catch(err => devicesMessage(err.message));
+}
+function setSessionPolicy(event) {
+    event.preventDefault();
+    devicesPost("/api/session/policy", {
+        idle_timeout: Number(document.getElementById("idle-timeout").value) * 60,
+        max_lifetime: Number(document.getElementById("max-lifetime").value) * 3600,
+    }).then(() => {
+        window.location.reload();
+    }).catch(err => devicesMessage(err.message));
+}
+function revokeAllSessions() {
+    devicesPost("/api/sessions/revoke-all").then(() => {
+        window.location.reload();
+    }).catch(err => devicesMessage(err.message));
+}
+
+document.getElementById("pair-start").addEventListener("click", startPairing);
+document.getElementById("pair-approve").addEventListener("click", approvePairing);
+document.getElementById("pair-cancel").addEventListener("click", cancelPairing);
+document.getElementById("session-policy").addEventListener("submit", setSessionPolicy);
+document.getElementById("revoke-all-sessions")?.addEventListener("click", revokeAllSessions);
+for (const button of document.querySelectorAll("[data-revoke-session]")) {
+    button.addEventListener("click", () => revokeSession(button.dataset.revokeSession));
+}
@@ -0,0 +1,169 @@
+// the password cards on the home page (web/views/home.templ). each card has its password's user id, id, name and
+// vault (if it has its own code) as data attributes and its buttons a data-password-action, so there's nothing
+// inline for the csp to allow. needs keylock.js.
+
+// codes of vaults with their own code, by vault name. only kept in memory, so they're gone on reload.
+const vaultCodes = {};
+
+function codeFormat() {
+    return JSON.parse(document.getElementById("code-format").textContent);
+}
+function describeCodeFormat(format) {
+    if (format.kind === "alphanumeric") {
+        return `${format.length}-character passcode`;
+    }
+    return `${format.length}-digit code`;
+}
+
+function hideShowButton(id) {
+    document.getElementById(`show-password-button-${id}`).hidden = true;
+}
+function hidePromptCode(id) {
+    document.getElementById(`prompt-code-${id}`).classList.add("hidden");
+    document.getElementById(`prompt-vault-code-${id}`)?.classList.add("hidden");
+}
+function hidePasswordValue(id) {
+    document.getElementById(`password-value-container-${id}`).classList.add("hidden");
+    document.getElementById(`password-value-${id}`).innerText = ""; // clear the password value
+}
+function hideMessage(id) {
+    const messageElement = document.getElementById(`prompt-code-message-${id}`);
+    messageElement.classList.add("hidden");
+}
+
+function showMessage(id, message) {
+    const messageElement = document.getElementById(`prompt-code-message-${id}`);
+    messageElement.innerText = message;
+    messageElement.classList.remove("hidden");
+}
+function viewShowButton(id) {
+    hideMessage(id); // hide any previous messages
+    hidePasswordValue(id); // hide the password value if it was shown
+    hidePromptCode(id); // hide the prompt code if it was shown
+    document.getElementById(`show-password-button-${id}`).hidden = false;
+}
+function viewPassword(id, password) {
+    hideMessage(id); // hide any previous messages
+    hidePromptCode(id); // hide the prompt code if it was shown
+    hideShowButton(id); // hide the show button if it was shown
+    // set the password value
+    document.getElementById(`password-value-${id}`).innerText = password;
+    // unhide the password value container
+    document.getElementById(`password-value-container-${id}`).classList.remove("hidden");
+}
+function viewPromptCode(id) {
+    hideMessage(id); // hide any previous messages
+    hidePasswordValue(id); // hide the password value if it was shown
+    hideShowButton(id); // hide the show button if it was shown
+    document.getElementById(`prompt-code-${id}`).classList.remove("hidden");
+}
+
+function viewPromptVaultCode(id) {
+    hidePasswordValue(id); // hide the password value if it was shown
+    hideShowButton(id); // hide the show button if it was shown
+    document.getElementById(`prompt-vault-code-${id}`).classList.remove("hidden");
+}
+
+// vault is the name of the password's vault if it has its own code, "" otherwise.
+async function retrievePassword(user_id, id, name, vault) {
+    hideMessage(id); // hide any previous messages
+
+    const code = await getUnlockCode();
+    if (!code) {
+        getCode(id);
+        return;
+    }
+    if (vault && !vaultCodes[vault]) {
+        viewPromptVaultCode(id);
+        return;
+    }
+    let key2;
+    try {
+        key2 = await keylockKey2(code, codeFormat());
+    } catch {
+        clearUnlockCode(); // the session code or the format changed, ask again
+        getCode(id);
+        return;
+    }
+
+    fetch(`/api/passwords/retrieve`, {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+            user_id: user_id,
+            name: name,
+            key2: key2,
+            vault_code: vault ? vaultCodes[vault] : undefined,
+        }),
+    }).then(async (response) => {
+        const data = await response.json();
+        if (response.ok) {
+            const password = data.value;
+            viewPassword(id, password); // show the password
+        } else {
+            if (vault && response.status === 401) {
+                delete vaultCodes[vault]; // probably the wrong vault code, ask again next time
+            }
+            showMessage(id, data.error || "An error occurred while retrieving the password.");
+        }
+    }).catch((error) => {
+        console.error("Error retrieving password:", error);
+        showMessage(id, "An error occurred while retrieving the password.");
+    });
+}
+function getCode(id) {
+    viewPromptCode(id); // show the prompt code
+}
+function setVaultCode(input, user_id, id, name, vault) {
+    const code = input.value.trim();
+    if (!code) {
+        return;
+    }
+    vaultCodes[vault] = code;
+    input.value = "";
+    hidePromptCode(id);
+    retrievePassword(user_id, id, name, vault);
+}
+async function setCodeValue(input, user_id, id, name, vault) {
+    const format = codeFormat();
+    const code = input.value.trim();
+    if (code.length !== format.length) {
+        return;
+    }
+    try {
+        await keylockKey2(code, format); // just checks it
+    } catch {
+        showMessage(id, `Please enter the right ${describeCodeFormat(format)}.`);
+        return;
+    }
+    await setUnlockCode(code);
+    hidePromptCode(id); // hide the prompt code
+    retrievePassword(user_id, id, name, vault);
+}
+
+document.addEventListener("click", (event) => {
+    const button = event.target.closest("[data-password-action]");
+    if (!button) {
+        return;
+    }
+    const card = button.closest("[data-password-id]");
+    const id = Number(card.dataset.passwordId);
+    const userID = Number(card.dataset.userId);
+    const { name, vault } = card.dataset;
+    switch (button.dataset.passwordAction) {
+        case "show":
+            retrievePassword(userID, id, name, vault);
+            break;
+        case "hide":
+            viewShowButton(id);
+            break;
+        case "code":
+            setCodeValue(document.getElementById(`code-input-${id}`), userID, id, name, vault);
+            break;
+        case "vault-code":
+            setVaultCode(document.getElementById(`vault-code-input-${id}`), userID, id, name, vault);
+            break;
+    }
+});
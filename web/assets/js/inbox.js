@@ -0,0 +1,87 @@
+// the inbox on the home page (web/views/inbox.templ). like the password cards, each item has its id and the vault
+// it's filed into as data attributes and its buttons a data-inbox-action. needs keylock.js and home.js.
+
+function inboxMessage(id, message) {
+    const el = document.getElementById(`inbox-message-${id}`);
+    el.innerText = message;
+    el.classList.remove("hidden");
+}
+async function fileInboxItem(id, vault) {
+    const name = document.getElementById(`inbox-name-${id}`).value.trim();
+    if (!name) {
+        inboxMessage(id, "Enter a name to save it as.");
+        return;
+    }
+    let key2;
+    try {
+        key2 = await keylockKey2((await getUnlockCode()) || "", codeFormat());
+    } catch {
+        const input = document.getElementById(`inbox-code-${id}`);
+        if (input.classList.contains("hidden") || !input.value) {
+            clearUnlockCode();
+            input.classList.remove("hidden");
+            inboxMessage(id, `Enter your ${describeCodeFormat(codeFormat())}.`);
+            return;
+        }
+        try {
+            key2 = await keylockKey2(input.value.trim(), codeFormat());
+        } catch {
+            inboxMessage(id, `Please enter the right ${describeCodeFormat(codeFormat())}.`);
+            return;
+        }
+        await setUnlockCode(input.value.trim());
+    }
+    const vaultCode = document.getElementById(`inbox-vault-code-${id}`);
+    fetch("/api/inbox/file", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+            id: id,
+            key2: key2,
+            vault: vault,
+            vault_code: vaultCode ? vaultCode.value : undefined,
+            name: name,
+        }),
+    }).then(async (response) => {
+        if (response.ok) {
+            window.location.reload();
+            return;
+        }
+        const data = await response.json();
+        inboxMessage(id, data.error || "An error occurred while filing the secret.");
+    }).catch(() => inboxMessage(id, "An error occurred while filing the secret."));
+}
+function discardInboxItem(id) {
+    if (!confirm("Discard this secret? It can't be recovered.")) {
+        return;
+    }
+    fetch("/api/inbox/discard", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ id: id }),
+    }).then(async (response) => {
+        if (response.ok) {
+            window.location.reload();
+            return;
+        }
+        const data = await response.json();
+        inboxMessage(id, data.error || "An error occurred while discarding the secret.");
+    }).catch(() => inboxMessage(id, "An error occurred while discarding the secret."));
+}
+
+document.addEventListener("click", (event) => {
+    const button = event.target.closest("[data-inbox-action]");
+    if (!button) {
+        return;
+    }
+    const item = button.closest("[data-inbox-id]");
+    const id = Number(item.dataset.inboxId);
+    switch (button.dataset.inboxAction) {
+        case "file":
+            fileInboxItem(id, item.dataset.vault);
+            break;
+        case "discard":
+            discardInboxItem(id);
+            break;
+    }
+});
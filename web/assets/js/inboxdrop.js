@@ -0,0 +1,93 @@
+// the public page of an inbox (web/views/inbox.templ). the secret is sealed to the owner's public key here, same as
+// utils.Seal (x25519, hkdf-sha256, aes-256-gcm), so the server never sees it.
+
+(() => {
+    const token = JSON.parse(document.getElementById("inbox-token").textContent);
+    let publicKey;
+
+    function showMessage(message) {
+        const el = document.getElementById("inbox-message");
+        el.innerText = message;
+        el.classList.remove("hidden");
+    }
+    function fromBase64(s) {
+        return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+    }
+    function toBase64(bytes) {
+        return btoa(String.fromCharCode(...bytes));
+    }
+    function concat(...parts) {
+        const out = new Uint8Array(parts.reduce((n, p) => n + p.length, 0));
+        let i = 0;
+        for (const p of parts) {
+            out.set(p, i);
+            i += p.length;
+        }
+        return out;
+    }
+    // same as utils.Seal: ephemeral public key || nonce || ciphertext
+    async function seal(recipient, plaintext) {
+        const eph = await crypto.subtle.generateKey({ name: "X25519" }, true, ["deriveBits"]);
+        const ephPublic = new Uint8Array(await crypto.subtle.exportKey("raw", eph.publicKey));
+        const pub = await crypto.subtle.importKey("raw", recipient, { name: "X25519" }, false, []);
+        const shared = await crypto.subtle.deriveBits({ name: "X25519", public: pub }, eph.privateKey, 256);
+        const hkdf = await crypto.subtle.importKey("raw", shared, "HKDF", false, ["deriveKey"]);
+        const info = concat(new TextEncoder().encode("keylock-seal"), ephPublic, recipient);
+        const key = await crypto.subtle.deriveKey(
+            { name: "HKDF", hash: "SHA-256", salt: new Uint8Array(), info: info },
+            hkdf,
+            { name: "AES-GCM", length: 256 },
+            false,
+            ["encrypt"],
+        );
+        const nonce = crypto.getRandomValues(new Uint8Array(12));
+        const ciphertext = new Uint8Array(await crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, key, plaintext));
+        return concat(ephPublic, nonce, ciphertext);
+    }
+
+    fetch("/api/inbox/info", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ token: token }),
+    }).then(async (response) => {
+        const data = await response.json();
+        if (!response.ok) {
+            showMessage(data.error || "An error occurred while opening this inbox.");
+            document.getElementById("inbox-form").classList.add("hidden");
+            return;
+        }
+        publicKey = fromBase64(data.inbox.public_key);
+        document.getElementById("inbox-title").innerText = `send a secret to ${data.inbox.owner_name}`;
+    });
+
+    document.getElementById("inbox-form").addEventListener("submit", async (event) => {
+        event.preventDefault();
+        if (!publicKey) {
+            return;
+        }
+        let sealed;
+        try {
+            sealed = await seal(publicKey, new TextEncoder().encode(document.getElementById("inbox-secret").value));
+        } catch {
+            showMessage("Your browser can't encrypt this (it needs X25519 support), try a newer one.");
+            return;
+        }
+        const response = await fetch("/api/inbox/drop", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({
+                token: token,
+                label: document.getElementById("inbox-label").value,
+                sealed: toBase64(sealed),
+            }),
+        });
+        if (!response.ok) {
+            const data = await response.json();
+            showMessage(data.error || "An error occurred while sending the secret.");
+            return;
+        }
+        document.getElementById("inbox-message").classList.add("hidden");
+        document.getElementById("inbox-form").classList.add("hidden");
+        document.getElementById("inbox-done").classList.remove("hidden");
+    });
+})();
@@ -0,0 +1,54 @@
+// the web's crypto. the work is done by the wasm build of the go code (web/wasm, the same code the server and the
+// cli run), this loads it and wraps it. needs wasm_exec.js (go's wasm glue) loaded first,
+// and sessioncode.js (the layout loads it).
+
+const keylockReady = (async () => {
+    const go = new Go();
+    const { instance } = await WebAssembly.instantiateStreaming(fetch("/assets/js/keylock.wasm"), go.importObject);
+    go.run(instance); // doesn't return until the page goes away
+})();
+
+// keylockCall calls keylock.<name> with args, the wasm side takes and returns json.
+async function keylockCall(name, args) {
+    await keylockReady;
+    const result = JSON.parse(keylock[name](JSON.stringify(args)));
+    if (result.error) {
+        throw new Error(result.error);
+    }
+    return result;
+}
+
+// keylockPrelogin gets the kdf params, salt and code format of the account called name.
+async function keylockPrelogin(name) {
+    const response = await fetch("/api/accounts/prelogin", {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ name }),
+    });
+    const data = await response.json();
+    if (data.error) {
+        throw new Error(data.error);
+    }
+    return data;
+}
+
+// keylockNewAccount derives the credentials for a new account, resolves to { credentials, keys }. the master
+// password never leaves the browser.
+async function keylockNewAccount(name, masterPassword, format) {
+    const account = await keylockPrelogin(name);
+    return keylockCall("newAccount", { account, password: masterPassword, format });
+}
+
+// keylockLogin derives what to log in to the account called name with (the login body without the name) and the
+// keys to keep: keys, or rekeyed if the server says the code changed.
+async function keylockLogin(name, masterPassword) {
+    const account = await keylockPrelogin(name);
+    return keylockCall("login", { account, password: masterPassword });
+}
+
+// keylockKey2 puts key2 (hex, what the api wants) together from this browser's session code and the code the user
+// typed. it throws if the code isn't a code in format.
+async function keylockKey2(code, format) {
+    const sessionCode = (await getSessionCode()) || "";
+    return (await keylockCall("key2", { session_code: sessionCode, code, format })).key2;
+}
@@ -0,0 +1,77 @@
+// the public page of a one-time link (web/views/link.templ). the key is in the fragment, see utils/links.go for the
+// crypto.
+
+(() => {
+    const id = JSON.parse(document.getElementById("link-id").textContent);
+
+    function showMessage(message) {
+        const el = document.getElementById("link-message");
+        el.innerText = message;
+        el.classList.remove("hidden");
+    }
+    function fromBase64(s, url) {
+        if (url) {
+            s = s.replace(/-/g, "+").replace(/_/g, "/");
+        }
+        return Uint8Array.from(atob(s), (c) => c.charCodeAt(0));
+    }
+    function toBase64(bytes) {
+        return btoa(String.fromCharCode(...new Uint8Array(bytes)));
+    }
+    // same as utils.LinkCipherKey, the raw key
+    async function cipherKey(linkKey, passphrase) {
+        if (!passphrase) {
+            return linkKey;
+        }
+        const base = await crypto.subtle.importKey("raw", new TextEncoder().encode(passphrase), "PBKDF2", false, ["deriveBits"]);
+        return crypto.subtle.deriveBits({ name: "PBKDF2", hash: "SHA-256", salt: linkKey, iterations: 600000 }, base, 256);
+    }
+    // same as utils.LinkVerifier
+    async function verifier(key) {
+        const base = await crypto.subtle.importKey("raw", key, "HKDF", false, ["deriveBits"]);
+        return crypto.subtle.deriveBits(
+            { name: "HKDF", hash: "SHA-256", salt: new Uint8Array(), info: new TextEncoder().encode("keylock link verifier v1") },
+            base,
+            256,
+        );
+    }
+    async function decrypt(link, key) {
+        const aesKey = await crypto.subtle.importKey("raw", key, "AES-GCM", false, ["decrypt"]);
+        const plaintext = await crypto.subtle.decrypt({ name: "AES-GCM", iv: fromBase64(link.nonce) }, aesKey, fromBase64(link.ciphertext));
+        return new TextDecoder().decode(plaintext);
+    }
+
+    document.getElementById("link-reveal-button").addEventListener("click", async () => {
+        if (window.location.hash.length <= 1) {
+            showMessage("This link is missing its key, make sure you copied all of it.");
+            return;
+        }
+        const key = await cipherKey(fromBase64(window.location.hash.slice(1), true), document.getElementById("link-passphrase-input").value);
+        // the server checks the verifier before it uses up a view, so a wrong passphrase can just be tried again
+        const response = await fetch("/api/links/open", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ id: id, verifier: toBase64(await verifier(key)) }),
+        });
+        const data = await response.json();
+        if (!response.ok) {
+            showMessage(data.error || "An error occurred while opening the link.");
+            return;
+        }
+        const link = data.link;
+        let secret;
+        try {
+            secret = await decrypt(link, key);
+        } catch {
+            showMessage("This link is broken, make sure you copied all of it.");
+            return;
+        }
+        document.getElementById("link-message").classList.add("hidden");
+        document.getElementById("link-reveal").classList.add("hidden");
+        document.getElementById("link-secret-value").innerText = secret;
+        document.getElementById("link-secret-views").innerText = link.views_left > 0
+            ? `This link can be opened ${link.views_left} more time(s).`
+            : "This link has been deleted, copy the secret now.";
+        document.getElementById("link-secret").classList.remove("hidden");
+    });
+})();
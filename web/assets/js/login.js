@@ -0,0 +1,150 @@
+// the login page (web/views/login.templ): master password, passkey or finishing a single sign-on. the master
+// password stays here, see keylock.js. needs keylock.js and passkeys.js.
+
+function setError(message) {
+    const messageElement = document.getElementById("login-message");
+    messageElement.textContent = message;
+    messageElement.classList.remove("hidden");
+}
+function showCodeModal(code) {
+    document.getElementById("code-modal-code").textContent = code;
+    const modal = document.getElementById("code-modal")
+    modal.removeAttribute("hidden");
+    modal.showModal();
+}
+// a 6 digit code is from the app, anything else is a recovery code
+function secondFactor() {
+    const code = document.getElementById("two_factor").value.replace(/\s/g, "");
+    if (!code) {
+        return {};
+    }
+    return /^\d{6}$/.test(code) ? { totp: code } : { recovery_code: code };
+}
+async function loginWithPasskey(event, username) {
+    event.preventDefault();
+    if (!username) {
+        setError("Enter your username first.");
+        return;
+    }
+    try {
+        const data = await passkeyLogin(username);
+        await setSessionCode(data.session_code);
+        window.location.replace("/home");
+    } catch (error) {
+        setError(error.message);
+    }
+}
+// set while finishing a single sign-on (see server/sso.go), the log in button sends the two-factor code for it
+let ssoToken = new URLSearchParams(window.location.search).get("sso");
+async function ssoLogin() {
+    await fetch("/api/sso/login", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+        },
+        body: JSON.stringify({ token: ssoToken, ...secondFactor() }),
+    })
+    .then(response => response.json())
+    .then(async data => {
+        if (data.code === "two_factor_required") {
+            const input = document.getElementById("two_factor");
+            input.classList.remove("hidden");
+            input.focus();
+            setError("Enter the code from your authenticator app.");
+            return;
+        }
+        if (data.error) {
+            ssoToken = null;
+            setError(data.error);
+            return;
+        }
+        ssoToken = null;
+        // single sign-on doesn't give us key2, only a master password login does
+        if (await getSessionCode()) {
+            window.location.replace("/home");
+            return;
+        }
+        document.getElementById("name").value = data.name;
+        setError("You're logged in as " + data.name + ", but this browser can't unlock your passwords yet. Log in with your master password once to set it up.");
+    })
+    .catch(error => {
+        console.error("Error during single sign-on:", error);
+        setError("An unexpected error occurred. " + error.message);
+    });
+}
+const ssoError = new URLSearchParams(window.location.search).get("sso_error");
+if (ssoError) {
+    setError(ssoError);
+} else if (ssoToken) {
+    ssoLogin();
+}
+async function login(event, username, masterPassword, usePasskey) {
+    event.preventDefault();
+    if (ssoToken) {
+        await ssoLogin();
+        return;
+    }
+    if (!username || !masterPassword) {
+        setError("Fields cannot be empty.");
+        return;
+    }
+    // the master password stays here, the server gets what's derived from it (except once, for accounts from
+    // before login keys, see kdf/client.go)
+    let derived;
+    try {
+        derived = await keylockLogin(username, masterPassword);
+    } catch (error) {
+        setError(error.message);
+        return;
+    }
+    let factor = secondFactor();
+    if (usePasskey) {
+        try {
+            factor = { passkey: (await passkeyAssertion(username)).assertion };
+        } catch (error) {
+            setError(error.message);
+            return;
+        }
+    }
+    await fetch("/api/accounts/login", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+            name: username,
+            login_key: derived.login_key,
+            key2: derived.key2,
+            rekey: derived.rekey,
+            master_password: derived.master_password, // only for accounts from before login keys
+            ...factor,
+        }),
+    })
+    .then(response => response.json())
+    .then(async data => {
+        if (data.code === "two_factor_required") {
+            const input = document.getElementById("two_factor");
+            input.classList.remove("hidden");
+            input.focus();
+            document.getElementById("passkey_factor").classList.remove("hidden");
+            setError("Enter the code from your authenticator app, or use a passkey.");
+        } else if (!data.error) {
+            const keys = data.code_changed ? derived.rekeyed : derived.keys;
+            await setSessionCode(keys.session_code); // forgets the unlock code too, the session code changes with a rekey
+            showCodeModal(keys.code);
+        } else {
+            setError(data.error || "An error occurred during login.");
+        }
+    })
+    .catch(error => {
+        console.error("Error during login:", error);
+        setError("An unexpected error occurred. " + error.message);
+    });
+}
+
+const loginName = () => document.getElementById("name").value;
+const loginPassword = () => document.getElementById("master_password").value;
+document.getElementById("login-button").addEventListener("click", (event) => login(event, loginName(), loginPassword()));
+document.getElementById("passkey-login-button").addEventListener("click", (event) => loginWithPasskey(event, loginName()));
+document.getElementById("passkey_factor").addEventListener("click", (event) => login(event, loginName(), loginPassword(), true));
+document.getElementById("code-modal-close").addEventListener("click", () => window.location.replace("/"));
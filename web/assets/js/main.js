@@ -0,0 +1,25 @@
+// the landing page (web/views/main.templ), it only sends the browser on to /home or /access.
+
+const redirectHome = () => window.location.replace("/home");
+const redirectPromptLoginSignup = () => window.location.replace("/access");
+// the server knows when the session really expires (/api/session). asking counts as using it, so the idle
+// timeout was just pushed back: go home if the session's max lifetime is more than 10 minutes away and this
+// browser has the session code
+async function situationalRedirect() {
+    if (!(await getSessionCode())) {
+        redirectPromptLoginSignup();
+        return;
+    }
+    try {
+        const response = await fetch("/api/session");
+        const data = await response.json();
+        if (response.ok && new Date(data.session.ends_at) - new Date() > 600000) {
+            redirectHome();
+            return;
+        }
+    } catch (error) {
+        console.error("Error checking session:", error);
+    }
+    redirectPromptLoginSignup();
+}
+setTimeout(situationalRedirect, 1000);
@@ -0,0 +1,40 @@
+// the pair page (web/views/pair.templ), the new device's side of pairing. needs pairing.js.
+
+function pairMessage(message) {
+    const el = document.getElementById("pair-message");
+    el.textContent = message;
+    el.classList.remove("hidden");
+}
+async function joinPairing(code) {
+    code = code.trim();
+    if (!code) {
+        pairMessage("Enter the code from your other device.");
+        return;
+    }
+    try {
+        const keys = await pairingKeyPair();
+        const joined = await pairingPost("/api/pairing/join", { code, public_key: keys.publicKey });
+        const secrets = await pairingSecrets(keys.privateKey, joined.public_key);
+        document.getElementById("pair-check").textContent = secrets.check;
+        document.getElementById("pair-form").classList.add("hidden");
+        document.getElementById("pair-waiting").classList.remove("hidden");
+
+        const claimed = await pairingPoll(async () => {
+            const data = await pairingPost("/api/pairing/claim", { code, claim: joined.claim });
+            return data.approved ? data : null;
+        });
+        // the cookies are set, all that's left is the session code
+        await setSessionCode(await pairingOpen(secrets.key, claimed.sealed, claimed.nonce));
+        window.location.replace("/home");
+    } catch (error) {
+        pairMessage(error.message);
+    }
+}
+if (window.location.hash.length > 1) {
+    const code = decodeURIComponent(window.location.hash.slice(1));
+    history.replaceState(null, "", window.location.pathname);
+    document.getElementById("pair-code").value = code;
+    joinPairing(code);
+}
+
+document.getElementById("pair-button").addEventListener("click", () => joinPairing(document.getElementById("pair-code").value));
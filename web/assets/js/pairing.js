@@ -0,0 +1,83 @@
+// device pairing for the devices page (the logged in device) and the pair page (the new one), the server side is
+// server/pairing.go.
+//
+// both devices make a p-256 key pair and the server passes the public keys along. ecdh gives both the same secret,
+// hkdf turns it into an aes-gcm key for the session code and a 6 digit number the user compares on both screens.
+// if the server swapped the keys the numbers won't match. the cli does the same (cli_client/pairing.go).
+
+const pairingKeyInfo = new TextEncoder().encode("keylock pairing key v1");
+const pairingCheckInfo = new TextEncoder().encode("keylock pairing check v1");
+
+// []byte fields in the api are standard base64
+function pairingB64(buf) {
+    return btoa(String.fromCharCode(...new Uint8Array(buf)));
+}
+
+function pairingBuf(s) {
+    return Uint8Array.from(atob(s), c => c.charCodeAt(0));
+}
+
+// some responses have no body
+async function pairingPost(path, body) {
+    const response = await fetch(path, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body || {}),
+    });
+    const text = await response.text();
+    const data = text ? JSON.parse(text) : {};
+    if (!response.ok || data.error) {
+        throw new Error(data.error || response.statusText);
+    }
+    return data;
+}
+
+// pairingKeyPair makes this device's key pair, publicKey is base64 for the api.
+async function pairingKeyPair() {
+    const pair = await crypto.subtle.generateKey({ name: "ECDH", namedCurve: "P-256" }, false, ["deriveBits"]);
+    const publicKey = pairingB64(await crypto.subtle.exportKey("raw", pair.publicKey));
+    return { privateKey: pair.privateKey, publicKey };
+}
+
+// pairingSecrets derives the session code key and the number to compare from our private key and the other
+// device's public key (base64).
+async function pairingSecrets(privateKey, peerPublicKey) {
+    const peer = await crypto.subtle.importKey("raw", pairingBuf(peerPublicKey), { name: "ECDH", namedCurve: "P-256" }, false, []);
+    const shared = await crypto.subtle.deriveBits({ name: "ECDH", public: peer }, privateKey, 256);
+    const hkdf = await crypto.subtle.importKey("raw", shared, "HKDF", false, ["deriveKey", "deriveBits"]);
+    const params = info => ({ name: "HKDF", hash: "SHA-256", salt: new Uint8Array(), info });
+    const key = await crypto.subtle.deriveKey(params(pairingKeyInfo), hkdf, { name: "AES-GCM", length: 256 }, false, ["encrypt", "decrypt"]);
+    const check = new DataView(await crypto.subtle.deriveBits(params(pairingCheckInfo), hkdf, 32)).getUint32(0);
+    const digits = String(check % 1000000).padStart(6, "0");
+    return { key, check: digits.slice(0, 3) + " " + digits.slice(3) };
+}
+
+async function pairingSeal(key, text) {
+    const nonce = crypto.getRandomValues(new Uint8Array(12));
+    const sealed = await crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, key, new TextEncoder().encode(text));
+    return { sealed: pairingB64(sealed), nonce: pairingB64(nonce) };
+}
+
+async function pairingOpen(key, sealed, nonce) {
+    const text = await crypto.subtle.decrypt({ name: "AES-GCM", iv: pairingBuf(nonce) }, key, pairingBuf(sealed));
+    return new TextDecoder().decode(text);
+}
+
+// pairingPoll calls fn every 2 seconds until it returns something (which it resolves to) or throws.
+function pairingPoll(fn) {
+    return new Promise((resolve, reject) => {
+        const tick = async () => {
+            try {
+                const result = await fn();
+                if (result) {
+                    resolve(result);
+                    return;
+                }
+                setTimeout(tick, 2000);
+            } catch (error) {
+                reject(error);
+            }
+        };
+        tick();
+    });
+}
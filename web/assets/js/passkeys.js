@@ -0,0 +1,163 @@
+// webauthn for the login, signup and security pages (the server side is server/passkeys.go).
+//
+// a passkey can log in without the master password only if it can give back the session code. we ask the
+// authenticator for a prf output (the webauthn prf extension) with a fixed salt, derive an aes-gcm key from it
+// and encrypt the session code with that. the server keeps the blob but can't open it.
+
+const passkeyPRFSalt = new TextEncoder().encode("keylock passkey session code v1");
+
+function b64urlToBuf(s) {
+    s = s.replace(/-/g, "+").replace(/_/g, "/");
+    const bin = atob(s + "=".repeat((4 - s.length % 4) % 4));
+    return Uint8Array.from(bin, c => c.charCodeAt(0)).buffer;
+}
+
+function bufToB64url(buf) {
+    return bufToB64(buf).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+// []byte fields in the api are standard base64
+function bufToB64(buf) {
+    return btoa(String.fromCharCode(...new Uint8Array(buf)));
+}
+
+function b64ToBuf(s) {
+    return Uint8Array.from(atob(s), c => c.charCodeAt(0)).buffer;
+}
+
+async function passkeyPost(path, body) {
+    const response = await fetch(path, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body || {}),
+    });
+    const data = await response.json();
+    if (data.error) {
+        throw new Error(data.error);
+    }
+    return data;
+}
+
+function passkeyPRFExtension() {
+    return { prf: { eval: { first: passkeyPRFSalt } } };
+}
+
+// the server sends the options as json, the browser wants buffers
+function creationOptions(options) {
+    const pk = options.publicKey;
+    pk.challenge = b64urlToBuf(pk.challenge);
+    pk.user.id = b64urlToBuf(pk.user.id);
+    pk.excludeCredentials = (pk.excludeCredentials || []).map(c => ({ ...c, id: b64urlToBuf(c.id) }));
+    pk.extensions = { ...(pk.extensions || {}), ...passkeyPRFExtension() };
+    return options;
+}
+
+function requestOptions(options) {
+    const pk = options.publicKey;
+    pk.challenge = b64urlToBuf(pk.challenge);
+    pk.allowCredentials = (pk.allowCredentials || []).map(c => ({ ...c, id: b64urlToBuf(c.id) }));
+    pk.extensions = { ...(pk.extensions || {}), ...passkeyPRFExtension() };
+    return options;
+}
+
+function encodeCredential(cred) {
+    const response = {};
+    for (const key of ["clientDataJSON", "attestationObject", "authenticatorData", "signature", "userHandle"]) {
+        if (cred.response[key]) {
+            response[key] = bufToB64url(cred.response[key]);
+        }
+    }
+    if (cred.response.getTransports) {
+        response.transports = cred.response.getTransports();
+    }
+    return {
+        id: cred.id,
+        rawId: bufToB64url(cred.rawId),
+        type: cred.type,
+        authenticatorAttachment: cred.authenticatorAttachment,
+        response: response,
+    };
+}
+
+function prfOutput(cred) {
+    const prf = cred.getClientExtensionResults().prf;
+    return prf && prf.results ? prf.results.first : null;
+}
+
+async function sessionCodeKey(prf, usage) {
+    const material = await crypto.subtle.importKey("raw", prf, "HKDF", false, ["deriveKey"]);
+    return crypto.subtle.deriveKey(
+        { name: "HKDF", hash: "SHA-256", salt: new Uint8Array(), info: new TextEncoder().encode("keylock session code") },
+        material,
+        { name: "AES-GCM", length: 256 },
+        false,
+        [usage],
+    );
+}
+
+// sealSessionCode returns the session code encrypted with the prf output as nonce || ciphertext, in base64
+async function sealSessionCode(prf, sessionCode) {
+    const key = await sessionCodeKey(prf, "encrypt");
+    const iv = crypto.getRandomValues(new Uint8Array(12));
+    const ct = await crypto.subtle.encrypt({ name: "AES-GCM", iv }, key, new TextEncoder().encode(sessionCode));
+    const blob = new Uint8Array(iv.length + ct.byteLength);
+    blob.set(iv);
+    blob.set(new Uint8Array(ct), iv.length);
+    return bufToB64(blob);
+}
+
+async function openSessionCode(prf, sealed) {
+    const blob = new Uint8Array(b64ToBuf(sealed));
+    const key = await sessionCodeKey(prf, "decrypt");
+    const pt = await crypto.subtle.decrypt({ name: "AES-GCM", iv: blob.slice(0, 12) }, key, blob.slice(12));
+    return new TextDecoder().decode(pt);
+}
+
+// registerPasskey adds a passkey to the logged in user. if the authenticator can do prf and this browser has the
+// session code, the passkey can log in without the master password too.
+async function registerPasskey(name) {
+    const begin = await passkeyPost("/api/passkeys/register/begin");
+    const cred = await navigator.credentials.create(creationOptions(begin.options));
+
+    // some authenticators only evaluate prf on an assertion, not when the credential is made
+    let prf = prfOutput(cred);
+    const prfEnabled = cred.getClientExtensionResults().prf?.enabled;
+    if (!prf && prfEnabled) {
+        const assertion = await navigator.credentials.get({
+            publicKey: {
+                challenge: crypto.getRandomValues(new Uint8Array(32)),
+                allowCredentials: [{ type: "public-key", id: cred.rawId }],
+                extensions: passkeyPRFExtension(),
+            },
+        });
+        prf = prfOutput(assertion);
+    }
+
+    const body = { ceremony: begin.ceremony, name: name, credential: encodeCredential(cred) };
+    const sessionCode = await getSessionCode();
+    if (prf && sessionCode) {
+        body.session_code = await sealSessionCode(prf, sessionCode);
+    }
+    return passkeyPost("/api/passkeys/register/finish", body);
+}
+
+// passkeyAssertion asks for one of name's passkeys. it returns what the login endpoints take as "passkey", and
+// the prf output to open the session code with.
+async function passkeyAssertion(name) {
+    const begin = await passkeyPost("/api/passkeys/login/begin", { name: name });
+    const cred = await navigator.credentials.get(requestOptions(begin.options));
+    return {
+        assertion: { ceremony: begin.ceremony, credential: encodeCredential(cred) },
+        prf: prfOutput(cred),
+    };
+}
+
+// passkeyLogin logs name in without their master password and resolves to the session code it unlocked.
+async function passkeyLogin(name) {
+    const { assertion, prf } = await passkeyAssertion(name);
+    const data = await passkeyPost("/api/passkeys/login/finish", assertion);
+    if (!prf) {
+        throw new Error("this browser didn't let the passkey unlock your passwords, log in with your master password");
+    }
+    return { ...data, session_code: await openSessionCode(prf, data.session_code) };
+}
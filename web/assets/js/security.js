@@ -0,0 +1,63 @@
+// the security page (web/views/security.templ), two-factor for now (see database/twofactor.go).
+
+function tfMessage(message) {
+    const el = document.getElementById("tf-message");
+    el.innerText = message;
+    el.classList.remove("hidden");
+}
+async function tfPost(path, body) {
+    const response = await fetch(path, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify(body || {}),
+    });
+    const data = await response.json();
+    if (data.error) {
+        throw new Error(data.error);
+    }
+    return data;
+}
+function tfFactor() {
+    const code = document.getElementById("tf-code").value.replace(/\s/g, "");
+    return /^\d{6}$/.test(code) ? { totp: code } : { recovery_code: code };
+}
+function showRecoveryCodes(codes) {
+    const list = document.getElementById("tf-recovery-codes");
+    list.replaceChildren(...codes.map(code => {
+        const li = document.createElement("li");
+        li.innerText = code;
+        return li;
+    }));
+    document.getElementById("tf-recovery").classList.remove("hidden");
+}
+function enrollTwoFactor() {
+    tfPost("/api/2fa/enroll").then(data => {
+        document.getElementById("tf-qr").src = "data:image/png;base64," + data.qr_code;
+        document.getElementById("tf-secret").innerText = data.secret;
+        document.getElementById("tf-setup").classList.remove("hidden");
+        document.getElementById("tf-enroll").classList.add("hidden");
+    }).catch(err => tfMessage(err.message));
+}
+function confirmTwoFactor() {
+    const code = document.getElementById("tf-confirm-code").value.replace(/\s/g, "");
+    tfPost("/api/2fa/confirm", { code }).then(data => {
+        document.getElementById("tf-setup").classList.add("hidden");
+        showRecoveryCodes(data.recovery_codes);
+    }).catch(err => tfMessage(err.message));
+}
+function newRecoveryCodes() {
+    tfPost("/api/2fa/recovery-codes", tfFactor()).then(data => {
+        showRecoveryCodes(data.recovery_codes);
+    }).catch(err => tfMessage(err.message));
+}
+function disableTwoFactor() {
+    tfPost("/api/2fa/disable", tfFactor()).then(() => {
+        window.location.reload();
+    }).catch(err => tfMessage(err.message));
+}
+
+// which buttons there are depends on whether two-factor is on
+document.getElementById("tf-enroll")?.addEventListener("click", enrollTwoFactor);
+document.getElementById("tf-confirm")?.addEventListener("click", confirmTwoFactor);
+document.getElementById("tf-new-recovery-codes")?.addEventListener("click", newRecoveryCodes);
+document.getElementById("tf-disable")?.addEventListener("click", disableTwoFactor);
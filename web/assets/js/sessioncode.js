@@ -0,0 +1,149 @@
+// where the browser keeps its half of key2. the session code (see passcode.Split) is encrypted with an aes-gcm key
+// that can't be exported and both live in indexeddb, so anything that can read the browser's storage only gets
+// ciphertext. the unlock code the user types is kept in sessionStorage encrypted the same way, and forgotten after
+// unlockCodeIdle without any activity. a script running in the page could still use the key, the csp (see
+// server/middlewares/security.go) is what stops it sending anything anywhere.
+
+const unlockCodeIdle = 5 * 60 * 1000;
+
+const sessionCodeDBName = "keylock";
+const sessionCodeStoreName = "keys";
+
+function sessionCodeRequest(request) {
+    return new Promise((resolve, reject) => {
+        request.onsuccess = () => resolve(request.result);
+        request.onerror = () => reject(request.error);
+    });
+}
+
+let sessionCodeDBPromise = null;
+function sessionCodeDB() {
+    if (!sessionCodeDBPromise) {
+        const request = indexedDB.open(sessionCodeDBName, 1);
+        request.onupgradeneeded = () => request.result.createObjectStore(sessionCodeStoreName);
+        sessionCodeDBPromise = sessionCodeRequest(request);
+    }
+    return sessionCodeDBPromise;
+}
+
+async function sessionCodeGet(key) {
+    const db = await sessionCodeDB();
+    return sessionCodeRequest(db.transaction(sessionCodeStoreName).objectStore(sessionCodeStoreName).get(key));
+}
+
+async function sessionCodePut(key, value) {
+    const db = await sessionCodeDB();
+    return sessionCodeRequest(db.transaction(sessionCodeStoreName, "readwrite").objectStore(sessionCodeStoreName).put(value, key));
+}
+
+async function sessionCodeDelete(key) {
+    const db = await sessionCodeDB();
+    return sessionCodeRequest(db.transaction(sessionCodeStoreName, "readwrite").objectStore(sessionCodeStoreName).delete(key));
+}
+
+// sessionCodeKey is this browser's wrapping key, made the first time it's needed. indexeddb keeps the CryptoKey
+// itself, it's never exported.
+async function sessionCodeKey() {
+    let key = await sessionCodeGet("wrapping_key");
+    if (!key) {
+        key = await crypto.subtle.generateKey({ name: "AES-GCM", length: 256 }, false, ["encrypt", "decrypt"]);
+        await sessionCodePut("wrapping_key", key);
+    }
+    return key;
+}
+
+async function sessionCodeSeal(text) {
+    const iv = crypto.getRandomValues(new Uint8Array(12));
+    const data = await crypto.subtle.encrypt({ name: "AES-GCM", iv }, await sessionCodeKey(), new TextEncoder().encode(text));
+    return { iv, data: new Uint8Array(data) };
+}
+
+async function sessionCodeOpen(sealed) {
+    const text = await crypto.subtle.decrypt({ name: "AES-GCM", iv: sealed.iv }, await sessionCodeKey(), sealed.data);
+    return new TextDecoder().decode(text);
+}
+
+async function setSessionCode(sessionCode) {
+    await sessionCodePut("session_code", await sessionCodeSeal(sessionCode));
+    localStorage.removeItem("session_code");
+    localStorage.removeItem("session_code_expiry");
+    clearUnlockCode(); // it goes with the old session code
+}
+
+// getSessionCode resolves to the session code, or null if this browser doesn't have one. browsers from before
+// this kept it in localStorage, it's moved the first time it's asked for.
+async function getSessionCode() {
+    const legacy = localStorage.getItem("session_code");
+    if (legacy) {
+        await sessionCodePut("session_code", await sessionCodeSeal(legacy));
+        localStorage.removeItem("session_code");
+        localStorage.removeItem("session_code_expiry");
+    }
+    const sealed = await sessionCodeGet("session_code");
+    if (!sealed) {
+        return null;
+    }
+    try {
+        return await sessionCodeOpen(sealed);
+    } catch {
+        return null; // the wrapping key is gone (cleared site data), the session code is no use anymore
+    }
+}
+
+async function clearSessionCode() {
+    await sessionCodeDelete("session_code");
+    clearUnlockCode();
+}
+
+function sessionCodeB64(bytes) {
+    return btoa(String.fromCharCode(...bytes));
+}
+
+function sessionCodeBuf(s) {
+    return Uint8Array.from(atob(s), c => c.charCodeAt(0));
+}
+
+async function setUnlockCode(code) {
+    const sealed = await sessionCodeSeal(code);
+    sessionStorage.setItem("code", JSON.stringify({ iv: sessionCodeB64(sealed.iv), data: sessionCodeB64(sealed.data) }));
+    sessionStorage.setItem("code_activity", Date.now());
+}
+
+// getUnlockCode resolves to the unlock code the user typed last, or null if they have to type it (again).
+async function getUnlockCode() {
+    expireUnlockCode();
+    const stored = sessionStorage.getItem("code");
+    if (!stored) {
+        return null;
+    }
+    try {
+        const sealed = JSON.parse(stored);
+        return await sessionCodeOpen({ iv: sessionCodeBuf(sealed.iv), data: sessionCodeBuf(sealed.data) });
+    } catch {
+        clearUnlockCode(); // from before it was encrypted, or the wrapping key changed
+        return null;
+    }
+}
+
+function clearUnlockCode() {
+    sessionStorage.removeItem("code");
+    sessionStorage.removeItem("code_activity");
+}
+
+function expireUnlockCode() {
+    const last = Number(sessionStorage.getItem("code_activity"));
+    if (sessionStorage.getItem("code") && (!last || Date.now() - last > unlockCodeIdle)) {
+        clearUnlockCode();
+    }
+}
+
+// any activity on the page keeps the unlock code around, checked every so often (and when the page is opened)
+for (const event of ["pointerdown", "keydown", "scroll", "touchstart"]) {
+    window.addEventListener(event, () => {
+        if (sessionStorage.getItem("code")) {
+            sessionStorage.setItem("code_activity", Date.now());
+        }
+    }, { passive: true, capture: true });
+}
+setInterval(expireUnlockCode, 15 * 1000);
+expireUnlockCode();
@@ -0,0 +1,89 @@
+// the signup page (web/views/signup.templ). the master password stays here, see keylock.js. needs keylock.js and
+// passkeys.js.
+
+let newCode = "";
+function setError(message) {
+    const messageElement = document.getElementById("signup-message");
+    messageElement.textContent = message;
+    messageElement.classList.remove("hidden");
+}
+function showCodeModal(code) {
+    document.getElementById("code-modal-code").textContent = code;
+    const modal = document.getElementById("code-modal")
+    modal.removeAttribute("hidden");
+    modal.showModal();
+}
+function offerPasskey(code) {
+    newCode = code;
+    if (!window.PublicKeyCredential) {
+        showCodeModal(code);
+        return;
+    }
+    document.getElementById("signup-message").classList.add("hidden");
+    document.getElementById("signup-passkey").classList.remove("hidden");
+}
+function addPasskey() {
+    const name = document.getElementById("passkey_name").value.trim() || "passkey";
+    registerPasskey(name).then(() => {
+        showCodeModal(newCode);
+    }).catch(error => setError(error.message));
+}
+function defaultCodeLength(kind) {
+    document.getElementById("code_length").value = kind === "alphanumeric" ? 8 : 5;
+}
+async function signup(event, username, masterPassword, codeKind, codeLength) {
+    console.log(event);
+    event.preventDefault();
+    if (!username || !masterPassword) {
+        setError("Fields cannot be empty.");
+        return;
+    }
+    const length = parseInt(codeLength);
+    if (isNaN(length)) {
+        setError("Code length must be a number.");
+        return;
+    }
+    // the master password stays here, the server gets what's derived from it
+    let derived;
+    try {
+        derived = await keylockNewAccount(username, masterPassword, { kind: codeKind, length: length });
+    } catch (error) {
+        setError(error.message);
+        return;
+    }
+    await fetch("/api/accounts/new", {
+        method: "POST",
+        headers: {
+            "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+            name: username,
+            ...derived.credentials,
+        }),
+    })
+    .then(response => response.json())
+    .then(async data => {
+        if (!data.error) {
+            await setSessionCode(derived.keys.session_code);
+            offerPasskey(derived.keys.code);
+        } else {
+            setError(data.error || "An error occurred during signup.");
+        }
+    })
+    .catch(error => {
+        console.error("Error during signup:", error);
+        setError("An unexpected error occurred. " + error.message);
+    });
+}
+
+document.getElementById("code_kind").addEventListener("change", (event) => defaultCodeLength(event.target.value));
+document.getElementById("signup-button").addEventListener("click", (event) => signup(
+    event,
+    document.getElementById("name").value,
+    document.getElementById("master_password").value,
+    document.getElementById("code_kind").value,
+    document.getElementById("code_length").value,
+));
+document.getElementById("passkey-add").addEventListener("click", addPasskey);
+document.getElementById("passkey-skip").addEventListener("click", () => showCodeModal(newCode));
+document.getElementById("code-modal-close").addEventListener("click", () => window.location.replace("/"));
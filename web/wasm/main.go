@@ -0,0 +1,137 @@
+//go:build js && wasm
+
+// the web's crypto: key derivation (see kdf/client.go), putting key2 together from the session code and the code,
+// and the aes-gcm/hkdf helpers from utils. built to web/assets/js/keylock.wasm with `make wasm` and loaded by
+// web/assets/js/keylock.js. it's the same code the server and the cli run, so nothing is written twice (and the
+// master password stays in the browser too, but for migrating old accounts, see kdf/client.go).
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"syscall/js"
+
+	"github.com/tiredkangaroo/keylock/kdf"
+	"github.com/tiredkangaroo/keylock/passcode"
+	"github.com/tiredkangaroo/keylock/utils"
+)
+
+var (
+	errArgs  = errors.New("expected one argument (json)")
+	errNonce = errors.New("nonce must be 12 bytes")
+)
+
+// everything goes in and out as json strings, errors come back as {"error": "..."}. []byte is base64 like in
+// the api.
+func result(v any, err error) any {
+	if err != nil {
+		v = map[string]string{"error": err.Error()}
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return string(data)
+}
+
+// export makes fn keylock.<name>, it takes its arguments as one json object.
+func export[A any](keylock js.Value, name string, fn func(A) (any, error)) {
+	keylock.Set(name, js.FuncOf(func(this js.Value, args []js.Value) any {
+		if len(args) != 1 || args[0].Type() != js.TypeString {
+			return result(nil, errArgs)
+		}
+		var a A
+		if err := json.Unmarshal([]byte(args[0].String()), &a); err != nil {
+			return result(nil, err)
+		}
+		return result(fn(a))
+	}))
+}
+
+type newAccountArgs struct {
+	Account  kdf.Account     `json:"account"` // from /api/accounts/prelogin
+	Password string          `json:"password"`
+	Format   passcode.Format `json:"format"`
+}
+
+type newAccountResult struct {
+	Credentials kdf.Credentials `json:"credentials"`
+	Keys        kdf.Keys        `json:"keys"`
+}
+
+type loginArgs struct {
+	Account  kdf.Account `json:"account"`
+	Password string      `json:"password"`
+}
+
+type key2Args struct {
+	SessionCode string          `json:"session_code"`
+	Code        string          `json:"code"`
+	Format      passcode.Format `json:"format"`
+}
+
+type splitArgs struct {
+	Raw    []byte          `json:"raw"`
+	Format passcode.Format `json:"format"`
+}
+
+type cryptArgs struct {
+	Key   []byte `json:"key"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+type keyFromKeysArgs struct {
+	Key1 []byte `json:"key1"`
+	Key2 []byte `json:"key2"`
+}
+
+func main() {
+	keylock := js.Global().Get("Object").New()
+
+	// newAccount derives the keys of a new account with the current kdf params, see kdf.Params.NewCredentials
+	export(keylock, "newAccount", func(a newAccountArgs) (any, error) {
+		creds, keys, err := a.Account.CurrentKDF.NewCredentials(a.Password, a.Format)
+		return newAccountResult{creds, keys}, err
+	})
+	// login derives what to log in with, see kdf.Account.Login
+	export(keylock, "login", func(a loginArgs) (any, error) {
+		return a.Account.Login(a.Password, nil)
+	})
+	// key2 is passcode.Key2, {key2} in hex
+	export(keylock, "key2", func(a key2Args) (any, error) {
+		key2, err := passcode.Key2(a.SessionCode, a.Code, a.Format)
+		return map[string]string{"key2": key2}, err
+	})
+	// split is passcode.Split, {key2 (hex), session_code, code}
+	export(keylock, "split", func(a splitArgs) (any, error) {
+		key2, sessionCode, code, err := passcode.Split(a.Raw, a.Format)
+		return map[string]string{"key2": hex.EncodeToString(key2), "session_code": sessionCode, "code": code}, err
+	})
+	// encrypt and decrypt are utils.Encrypt and utils.Decrypt, {data}
+	export(keylock, "encrypt", func(a cryptArgs) (any, error) {
+		if len(a.Nonce) != 12 {
+			return nil, errNonce // gcm would panic, and take the whole wasm instance with it
+		}
+		data, err := utils.Encrypt(a.Key, a.Nonce, a.Data)
+		return map[string][]byte{"data": data}, err
+	})
+	export(keylock, "decrypt", func(a cryptArgs) (any, error) {
+		if len(a.Nonce) != 12 {
+			return nil, errNonce
+		}
+		data, err := utils.Decrypt(a.Key, a.Nonce, a.Data)
+		return map[string][]byte{"data": data}, err
+	})
+	// keyFromKeys is utils.KeyFromKeys, {key}
+	export(keylock, "keyFromKeys", func(a keyFromKeysArgs) (any, error) {
+		if len(a.Key1) == 0 || len(a.Key2) == 0 {
+			return nil, errors.New("key1 and key2 are required")
+		}
+		return map[string][]byte{"key": utils.KeyFromKeys(a.Key1, a.Key2)}, nil
+	})
+
+	js.Global().Set("keylock", keylock)
+	select {} // the functions only work while main is running
+}
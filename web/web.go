@@ -1,14 +1,15 @@
 package web
 
 import (
-	"context"
 	"net/http"
 
 	"github.com/a-h/templ"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
+	"github.com/tiredkangaroo/keylock/config"
 	"github.com/tiredkangaroo/keylock/database"
+	"github.com/tiredkangaroo/keylock/sessions"
 	"github.com/tiredkangaroo/keylock/web/assets"
 	"github.com/tiredkangaroo/keylock/web/views"
 )
@@ -19,15 +20,86 @@ func SetGroup(db *database.DB, sessionMiddleware fiber.Handler, router fiber.Rou
 	}))
 	router.Get("/", adaptor.HTTPHandler(templ.Handler(views.Main())))
 	router.Get("/access", sessionMiddleware, adaptor.HTTPHandler(templ.Handler(views.Access())))
-	router.Get("/login", adaptor.HTTPHandler(templ.Handler(views.Login())))
+	router.Get("/login", func(c *fiber.Ctx) error {
+		sso := ""
+		if config.DefaultConfig.OIDC.Issuer != "" {
+			sso = config.DefaultConfig.OIDC.Name
+		}
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		return views.Login(sso).Render(c.UserContext(), c.Response().BodyWriter())
+	})
 	router.Get("/signup", adaptor.HTTPHandler(templ.Handler(views.Signup())))
+	router.Get("/pair", func(c *fiber.Ctx) error {
+		// the pairing code is in the fragment, same as secret links
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		return views.Pair().Render(c.UserContext(), c.Response().BodyWriter())
+	})
+	router.Get("/s/:id", func(c *fiber.Ctx) error {
+		// the key is in the fragment, but don't let anything keep the page around or leak where it was
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		return views.SecretLink(c.Params("id")).Render(c.UserContext(), c.Response().BodyWriter())
+	})
+	router.Get("/inbox/:token", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		return views.InboxDrop(c.Params("token")).Render(c.UserContext(), c.Response().BodyWriter())
+	})
+	router.Get("/security", sessionMiddleware, func(c *fiber.Ctx) error {
+		user := c.Locals("user").(*database.User)
+		status, err := db.GetTwoFactorStatus(c.UserContext(), user.ID)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("error fetching two-factor status: " + err.Error())
+		}
+		passkeys, err := db.ListPasskeys(c.UserContext(), user.ID)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("error fetching passkeys: " + err.Error())
+		}
+		var identities []database.Identity
+		sso := config.DefaultConfig.OIDC.Issuer != ""
+		if sso {
+			identities, err = db.ListIdentities(c.UserContext(), user.ID)
+			if err != nil {
+				return c.Status(http.StatusBadRequest).SendString("error fetching single sign-on accounts: " + err.Error())
+			}
+		}
+		satisfied, _ := c.Locals("two_factor").(bool)
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		return views.Security(user, status, passkeys, identities, sso, config.DefaultConfig.TwoFactor.Required, satisfied).Render(c.UserContext(), c.Response().BodyWriter())
+	})
+	router.Get("/devices", sessionMiddleware, func(c *fiber.Ctx) error {
+		user := c.Locals("user").(*database.User)
+		list, err := sessions.List(c.UserContext(), user.ID, c.Locals("session").(string))
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("error fetching sessions: " + err.Error())
+		}
+		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
+		policy := sessions.PolicyFor(user.SessionIdleTimeout, user.SessionMaxLifetime)
+		return views.Devices(list, user, policy).Render(c.UserContext(), c.Response().BodyWriter())
+	})
 	router.Get("/home", sessionMiddleware, func(c *fiber.Ctx) error {
 		user := c.Locals("user").(*database.User)
-		pwds, err := db.ListPasswords(user.ID)
+		// sessions without two-factor can't do anything else on a server that requires it
+		if satisfied, _ := c.Locals("two_factor").(bool); config.DefaultConfig.TwoFactor.Required && !satisfied {
+			return c.Redirect("/security")
+		}
+		vault := c.Query("vault") // "" shows every vault
+		pwds, err := db.ListPasswords(c.UserContext(), user.ID, vault)
 		if err != nil {
 			return c.Status(http.StatusBadRequest).SendString("error fetching passwords: " + err.Error())
 		}
+		vaults, err := db.ListVaults(c.UserContext(), user.ID)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("error fetching vaults: " + err.Error())
+		}
+		inbox, err := db.ListInbox(c.UserContext(), user.ID)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).SendString("error fetching inbox: " + err.Error())
+		}
 		c.Set("Content-Type", fiber.MIMETextHTMLCharsetUTF8)
-		return views.Home(user, pwds).Render(context.Background(), c.Response().BodyWriter())
+		return views.Home(user, pwds, vaults, vault, inbox).Render(c.UserContext(), c.Response().BodyWriter())
 	})
 }